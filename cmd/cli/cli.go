@@ -55,7 +55,7 @@ You need to specify the SERVICE_NAME as an argument.`,
 		Args:  cobra.ExactArgs(1),
 	}
 
-	rootCmd.AddCommand(startCmd, eventCmd)
+	rootCmd.AddCommand(startCmd, eventCmd, testCmd, replayCmd)
 
 	err := rootCmd.Execute()
 	if err != nil {
@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/direktiv/direktiv/internal/api"
+	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/pkg/flowtest"
+	"github.com/spf13/cobra"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [test suites or directories]",
+	Short: "Run flow tests offline with mocked actions, services and requests",
+	Long: `The "test" command runs the test suites (*` + flowtest.SuiteSuffix + `) in the given
+files and directories, the current directory by default. Flows are executed in-process,
+actions, services, subflows, fetch requests and events are answered by the mocks of
+the test cases.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		report, err := cmd.Flags().GetString("report")
+		if err != nil {
+			return err
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			return err
+		}
+
+		// the runtime logs the flow execution, it is only of interest when debugging tests.
+		if !verbose {
+			slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		}
+
+		paths, err := flowtest.FindSuites(args...)
+		if err != nil {
+			return err
+		}
+
+		var results []*flowtest.Result
+		for _, p := range paths {
+			s, err := flowtest.LoadSuite(p)
+			if err != nil {
+				return err
+			}
+			res, err := s.Run(cmd.Context())
+			if err != nil {
+				return err
+			}
+			results = append(results, res...)
+		}
+
+		// keep stdout for the report if it is written there.
+		out := os.Stdout
+		if report != "" && output == "" {
+			out = os.Stderr
+		}
+
+		failed := 0
+		for _, res := range results {
+			if res.Passed {
+				fmt.Fprintf(out, "PASS %s: %s (%s)\n", res.Suite, res.Name, res.Duration)
+				continue
+			}
+			failed++
+			fmt.Fprintf(out, "FAIL %s: %s (%s)\n", res.Suite, res.Name, res.Duration)
+			for _, f := range res.Failures {
+				fmt.Fprintf(out, "    %s\n", f)
+			}
+		}
+
+		if report != "" {
+			err = writeReport(report, output, results)
+			if err != nil {
+				return err
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d test cases failed", failed, len(results))
+		}
+		fmt.Fprintf(out, "%d test cases passed\n", len(results))
+
+		return nil
+	},
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay INSTANCE_ID",
+	Args:  cobra.ExactArgs(1),
+	Short: "Replay an instance locally with the results recorded by the engine",
+	Long: `The "replay" command fetches the history of an ended instance and executes it again
+in-process. Actions, services, subflows, fetch requests, variables and events return
+the results recorded by the engine, no external system is called.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			return err
+		}
+		if !verbose {
+			slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		}
+
+		history, err := fetchHistory(prepareCommand(), args[0])
+		if err != nil {
+			return err
+		}
+
+		res, err := flowtest.Replay(cmd.Context(), history)
+		if err != nil {
+			return err
+		}
+
+		for i, fn := range res.Transitions {
+			fmt.Printf("%d. %s\n", i+1, fn)
+		}
+		if res.Error != "" {
+			fmt.Printf("Error: %s\n", res.Error)
+		} else {
+			fmt.Printf("Output:\n%s\n", string(res.Output))
+		}
+		if !res.Passed {
+			return fmt.Errorf("replay did not reproduce the instance: %s", strings.Join(res.Failures, ", "))
+		}
+		fmt.Println("replay reproduced the instance")
+
+		return nil
+	},
+}
+
+func init() {
+	testCmd.Flags().String("report", "", "Write a report of the results, json or junit.")
+	testCmd.Flags().String("output", "", "File to write the report to, stdout by default.")
+	testCmd.Flags().Bool("verbose", false, "Print the logs of the flows.")
+	replayCmd.Flags().Bool("verbose", false, "Print the logs of the flow.")
+}
+
+// fetchHistory returns the history of the instance id.
+func fetchHistory(p profile, id string) ([]*engine.InstanceEvent, error) {
+	uploader, err := newUploader("", p)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/api/v2/namespaces/%s/instances/%s/history", p.Address, p.Namespace, id)
+	resp, err := uploader.sendRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errJSON errorResponse
+		err = json.Unmarshal(b, &errJSON)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%s", errJSON.Error.Message)
+	}
+
+	var body struct {
+		Data []*api.InstanceEvent `json:"data"`
+	}
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*engine.InstanceEvent, len(body.Data))
+	for i, ev := range body.Data {
+		history[i] = &engine.InstanceEvent{
+			State:      engine.StateCode(ev.State),
+			InstanceID: ev.InstanceID,
+			Namespace:  ev.Namespace,
+			Metadata:   ev.Metadata,
+			Script:     ev.Script,
+			Fn:         ev.Fn,
+			Mappings:   ev.Mappings,
+			Input:      ev.Input,
+			Output:     ev.Output,
+			Error:      ev.Error,
+			ErrorCode:  ev.ErrorCode,
+			Records:    ev.Records,
+			EventID:    ev.EventID,
+			Sequence:   ev.Sequence,
+		}
+	}
+
+	return history, nil
+}
+
+func writeReport(format, output string, results []*flowtest.Result) error {
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return flowtest.WriteJSON(w, results)
+	case "junit":
+		return flowtest.WriteJUnit(w, results)
+	default:
+		return fmt.Errorf("unknown report format '%s', must be json or junit", format)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/direktiv/direktiv/internal/core"
 	"github.com/direktiv/direktiv/internal/datastore/datasql"
 	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/internal/events"
 	"github.com/direktiv/direktiv/internal/extensions"
 	"github.com/direktiv/direktiv/internal/sched"
 	"github.com/direktiv/direktiv/internal/version"
@@ -47,6 +48,7 @@ type InitializeArgs struct {
 
 	Engine    *engine.Engine
 	Scheduler *sched.Scheduler
+	Events    *events.Processor
 	DB        *gorm.DB
 }
 
@@ -116,9 +118,11 @@ func New(app InitializeArgs) (*Server, error) {
 		engine: app.Engine,
 	}
 	eventsCtr := eventsController{
-		store:         datasql.NewStore(app.DB),
-		wakeInstance:  nil,
-		startWorkflow: nil,
+		store:     datasql.NewStore(app.DB),
+		processor: app.Events,
+	}
+	locksCtr := &locksController{
+		store: datasql.NewStore(app.DB),
 	}
 
 	mw := &appMiddlewares{
@@ -218,6 +222,9 @@ func New(app InitializeArgs) (*Server, error) {
 			r.Route("/namespaces/{namespace}/metrics", func(r chi.Router) {
 				metricsCtr.mountRouter(r)
 			})
+			r.Route("/namespaces/{namespace}/locks", func(r chi.Router) {
+				locksCtr.mountRouter(r)
+			})
 			r.Route("/namespaces/{namespace}/events/history", func(r chi.Router) {
 				eventsCtr.mountEventHistoryRouter(r)
 			})
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/direktiv/direktiv/internal/core"
 	"github.com/direktiv/direktiv/internal/datastore"
 	"github.com/direktiv/direktiv/internal/engine"
 	"github.com/direktiv/direktiv/internal/secrets"
@@ -47,6 +48,9 @@ func writeError(w http.ResponseWriter, err *Error) {
 	if strings.Contains(err.Code, "not_found") {
 		httpStatus = http.StatusNotFound
 	}
+	if strings.Contains(err.Code, "unsupported_media_type") {
+		httpStatus = http.StatusUnsupportedMediaType
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(httpStatus)
@@ -99,6 +103,20 @@ func writeSecretsError(w http.ResponseWriter, err error) {
 }
 
 func writeEngineError(w http.ResponseWriter, err error) {
+	if errors.Is(err, engine.ErrInvalidInput) {
+		apiErr := &Error{
+			Code:    "request_data_invalid",
+			Message: err.Error(),
+		}
+		var schemaErr *core.SchemaError
+		if errors.As(err, &schemaErr) {
+			apiErr.Message = "input does not match the input schema of the flow"
+			apiErr.Validation = schemaErr.Violations
+		}
+		writeError(w, apiErr)
+
+		return
+	}
 	if errors.Is(err, engine.ErrDataNotFound) {
 		writeError(w, &Error{
 			Code:    "not_found",
@@ -1,33 +1,436 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/events"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type eventsController struct {
-	store         datastore.Store
-	wakeInstance  any
-	startWorkflow any
+	store     datastore.Store
+	processor *events.Processor
 }
 
 func (c *eventsController) mountEventHistoryRouter(r chi.Router) {
-	r.Get("/", c.dummy)          // Retrieve a list of events
-	r.Get("/subscribe", c.dummy) // Retrieve a event updates via sse
-	r.Get("/{eventID}", c.dummy) // Get details of a single event
-	r.Post("/replay/{eventID}", c.dummy)
+	r.Get("/", c.listEvents)         // Retrieve a list of events
+	r.Get("/subscribe", c.subscribe) // Retrieve a event updates via sse
+	r.Get("/{eventID}", c.getEvent)  // Get details of a single event
+	r.Post("/replay/{eventID}", c.replay)
 }
 
 func (c *eventsController) mountEventListenerRouter(r chi.Router) {
-	r.Get("/", c.dummy)                  // Retrieve a list of event-listeners
-	r.Get("/{eventListenerID}", c.dummy) // Get details of a single event-listener
+	r.Get("/", c.listListeners)                // Retrieve a list of event-listeners
+	r.Get("/{eventListenerID}", c.getListener) // Get details of a single event-listener
 }
 
 func (c *eventsController) mountBroadcast(r chi.Router) {
-	r.Post("/", c.dummy)
+	r.Post("/", c.broadcast)
 }
 
-func (c *eventsController) dummy(w http.ResponseWriter, r *http.Request) {
+func (c *eventsController) listEvents(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+
+	starting := time.Now().UTC()
+	if v := r.URL.Query().Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, &Error{
+				Code:    "request_data_invalid",
+				Message: "invalid `before` param",
+			})
+
+			return
+		}
+		starting = t.UTC()
+	}
+
+	params, err := extractEventFilterParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// events received in one batch share the receive time, pages continue after the serial
+	// id of the last event of the previous page.
+	if v := r.URL.Query().Get("beforeId"); v != "" {
+		if _, aErr := strconv.Atoi(v); aErr != nil {
+			writeError(w, &Error{
+				Code:    "request_data_invalid",
+				Message: "invalid `beforeId` param",
+			})
+
+			return
+		}
+		params = append(params, "before_id", v)
+	}
+
+	data, dErr := c.store.EventHistory().GetOld(r.Context(), namespace, starting, params...)
+	if dErr != nil {
+		writeDataStoreError(w, dErr)
+		return
+	}
+
+	meta := map[string]any{
+		"previousPage": nil,
+		"startingFrom": starting.Format(time.RFC3339Nano),
+	}
+	if len(data) > 0 {
+		meta["previousPage"] = strconv.Itoa(data[len(data)-1].SerialID)
+	}
+
+	writeJSONWithMeta(w, data, meta)
+}
+
+// subscribe streams events received in the namespace using Server-Sent Events (SSE). The
+// serial id of an event is its event id, clients resume with the Last-Event-ID header.
+func (c *eventsController) subscribe(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+
+	params, pErr := extractEventFilterParams(r)
+	if pErr != nil {
+		writeError(w, pErr)
+		return
+	}
+
+	cursor := -1
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err == nil {
+			cursor = id
+		}
+	}
+	if cursor < 0 {
+		// without a last event, only events received from now on are streamed.
+		list, err := c.store.EventHistory().GetOld(r.Context(), namespace, time.Now().UTC(), params...)
+		if err != nil {
+			writeDataStoreError(w, err)
+			return
+		}
+		cursor = 0
+		if len(list) > 0 {
+			cursor = list[0].SerialID
+		}
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Accel-Buffering", "no")
+	_ = rc.Flush()
+
+	send := func(ev *datastore.Event) error {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.SerialID, "message", string(b))
+
+		return err
+	}
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		var err error
+		cursor, err = streamEvents(r.Context(), c.store.EventHistory(), namespace, cursor, params, send)
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			slog.Error("error streaming events", slog.Any("error", err))
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// streamEvents sends the events of namespace after the serial id cursor, oldest first, and
+// returns the serial id of the last sent event.
+func streamEvents(ctx context.Context, store datastore.EventHistoryStore, namespace string, cursor int,
+	params []string, send func(ev *datastore.Event) error,
+) (int, error) {
+	for {
+		list, err := store.GetStartingIDUntilTime(ctx, namespace, cursor, time.Now().UTC(), params...)
+		if err != nil {
+			return cursor, err
+		}
+		if len(list) == 0 {
+			return cursor, nil
+		}
+		for _, ev := range list {
+			err = send(ev)
+			if err != nil {
+				return cursor, err
+			}
+			cursor = ev.SerialID
+		}
+	}
+}
+
+func (c *eventsController) getEvent(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	eventID := chi.URLParam(r, "eventID")
+
+	ev, err := c.store.EventHistory().GetByID(r.Context(), namespace, eventID)
+	if err != nil {
+		writeDataStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, ev)
+}
+
+func (c *eventsController) replay(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	eventID := chi.URLParam(r, "eventID")
+
+	ev, err := c.store.EventHistory().GetByID(r.Context(), namespace, eventID)
+	if err != nil {
+		writeDataStoreError(w, err)
+		return
+	}
+
+	err = c.processor.ProcessEvents(r.Context(), namespace, []*datastore.Event{ev})
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	writeOk(w)
+}
+
+type eventListenerEntry struct {
+	ID                     uuid.UUID                      `json:"id"`
+	CreatedAt              time.Time                      `json:"createdAt"`
+	UpdatedAt              time.Time                      `json:"updatedAt"`
+	Namespace              string                         `json:"namespace"`
+	ListeningForEventTypes []string                       `json:"listeningForEventTypes"`
+	TriggerType            string                         `json:"triggerType"`
+	TriggerWorkflow        string                         `json:"triggerWorkflow,omitempty"`
+	TriggerInstance        string                         `json:"triggerInstance,omitempty"`
+	EventContextFilters    []datastore.EventContextFilter `json:"eventContextFilters"`
+}
+
+func convertEventListener(l *datastore.EventListener) *eventListenerEntry {
+	filters := l.EventContextFilters
+	if filters == nil {
+		filters = []datastore.EventContextFilter{}
+	}
+
+	return &eventListenerEntry{
+		ID:                     l.ID,
+		CreatedAt:              l.CreatedAt,
+		UpdatedAt:              l.UpdatedAt,
+		Namespace:              l.Namespace,
+		ListeningForEventTypes: l.ListeningForEventTypes,
+		TriggerType:            l.TriggerType.String(),
+		TriggerWorkflow:        l.TriggerWorkflow,
+		TriggerInstance:        l.TriggerInstance,
+		EventContextFilters:    filters,
+	}
+}
+
+func (c *eventsController) listListeners(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	limit := ParseQueryParam(r, "limit", 0)
+	offset := ParseQueryParam(r, "offset", 0)
+
+	list, total, err := c.store.EventListener().Get(r.Context(), namespace, limit, offset)
+	if err != nil {
+		writeDataStoreError(w, err)
+		return
+	}
+
+	res := make([]*eventListenerEntry, len(list))
+	for i := range list {
+		res[i] = convertEventListener(list[i])
+	}
+
+	writeJSONWithMeta(w, res, map[string]any{
+		"total": total,
+	})
+}
+
+func (c *eventsController) getListener(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	id, err := uuid.Parse(chi.URLParam(r, "eventListenerID"))
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid event listener uuid",
+		})
+
+		return
+	}
+
+	l, err := c.store.EventListener().GetByID(r.Context(), id)
+	if err == nil && l.Namespace != namespace {
+		err = datastore.ErrNotFound
+	}
+	if err != nil {
+		writeDataStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, convertEventListener(l))
+}
+
+// broadcast accepts cloud events in structured, binary and batch content mode. The events
+// are stored in the event history and matched against the namespace listeners.
+func (c *eventsController) broadcast(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+
+	list, apiErr := readCloudEvents(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	now := time.Now().UTC()
+	received := make([]*datastore.Event, len(list))
+	for i := range list {
+		ev := list[i]
+		if ev.ID() == "" {
+			ev.SetID(uuid.NewString())
+		}
+		if ev.Time().IsZero() {
+			ev.SetTime(now)
+		}
+		err := ev.Validate()
+		if err != nil {
+			writeError(w, &Error{
+				Code:    "request_body_bad_cloudevent",
+				Message: err.Error(),
+			})
+
+			return
+		}
+		received[i] = &datastore.Event{
+			Event:      ev,
+			Namespace:  namespace,
+			ReceivedAt: now,
+		}
+	}
+
+	// events received before are skipped, retrying a batch is idempotent.
+	appendErr := c.processor.Broadcast(r.Context(), namespace, received)
+	if appendErr != nil {
+		writeDataStoreError(w, appendErr)
+		return
+	}
+
+	writeOk(w)
+}
+
+func readCloudEvents(r *http.Request) ([]*cloudevents.Event, *Error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	// binary content mode carries the attributes in ce- headers.
+	if r.Header.Get("Ce-Specversion") != "" {
+		ev, err := cehttp.NewEventFromHTTPRequest(r)
+		if err != nil {
+			return nil, &Error{
+				Code:    "request_body_bad_cloudevent",
+				Message: err.Error(),
+			}
+		}
+
+		return []*cloudevents.Event{ev}, nil
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &Error{
+			Code:    "request_invalid_data",
+			Message: "could not read request body",
+		}
+	}
+
+	switch mediaType {
+	case cloudevents.ApplicationCloudEventsBatchJSON:
+		var list []*cloudevents.Event
+		err = json.Unmarshal(b, &list)
+		if err != nil {
+			return nil, &Error{
+				Code:    "request_body_bad_cloudevent",
+				Message: err.Error(),
+			}
+		}
+
+		return list, nil
+	case cloudevents.ApplicationCloudEventsJSON, cloudevents.ApplicationJSON:
+		ev := cloudevents.NewEvent()
+		err = json.Unmarshal(b, &ev)
+		if err != nil {
+			return nil, &Error{
+				Code:    "request_body_bad_cloudevent",
+				Message: err.Error(),
+			}
+		}
+
+		return []*cloudevents.Event{&ev}, nil
+	}
+
+	return nil, &Error{
+		Code:    "request_unsupported_media_type",
+		Message: fmt.Sprintf("unsupported content type '%s'", mediaType),
+	}
+}
+
+// extractEventFilterParams converts the event history query params into store filters.
+func extractEventFilterParams(r *http.Request) ([]string, *Error) {
+	timeParams := map[string]string{
+		"createdBefore":  "created_before",
+		"createdAfter":   "created_after",
+		"receivedBefore": "received_before",
+		"receivedAfter":  "received_after",
+	}
+	textParams := map[string]string{
+		"eventContains": "event_contains",
+		"typeContains":  "type_contains",
+	}
+
+	params := make([]string, 0)
+	for name, key := range timeParams {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, &Error{
+				Code:    "request_data_invalid",
+				Message: fmt.Sprintf("invalid `%s` param", name),
+			}
+		}
+		params = append(params, key, t.UTC().Format(time.RFC3339Nano))
+	}
+	for name, key := range textParams {
+		if v := r.URL.Query().Get(name); v != "" {
+			params = append(params, key, v)
+		}
+	}
+
+	return params, nil
 }
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/datastore"
+)
+
+func TestReadCloudEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		body        string
+		wantIDs     []string
+		wantCode    string
+	}{
+		{
+			name:        "structured",
+			contentType: "application/cloudevents+json",
+			body:        `{"specversion":"1.0","id":"1","type":"t","source":"s"}`,
+			wantIDs:     []string{"1"},
+		},
+		{
+			name:        "structured as json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"specversion":"1.0","id":"1","type":"t","source":"s"}`,
+			wantIDs:     []string{"1"},
+		},
+		{
+			name:        "binary",
+			contentType: "application/json",
+			headers: map[string]string{
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "2",
+				"Ce-Type":        "t",
+				"Ce-Source":      "s",
+			},
+			body:    `{"hello":"world"}`,
+			wantIDs: []string{"2"},
+		},
+		{
+			name:        "batch",
+			contentType: "application/cloudevents-batch+json",
+			body:        `[{"specversion":"1.0","id":"1","type":"t","source":"s"},{"specversion":"1.0","id":"2","type":"t","source":"s"}]`,
+			wantIDs:     []string{"1", "2"},
+		},
+		{
+			name:        "not a cloudevent",
+			contentType: "application/json",
+			body:        `NON-COMPLIANT`,
+			wantCode:    "request_body_bad_cloudevent",
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "application/bad-header",
+			body:        `{}`,
+			wantCode:    "request_unsupported_media_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			list, err := readCloudEvents(r)
+			if tt.wantCode != "" {
+				if err == nil || err.Code != tt.wantCode {
+					t.Fatalf("expected error code %q, got %v", tt.wantCode, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(list) != len(tt.wantIDs) {
+				t.Fatalf("expected %d events, got %d", len(tt.wantIDs), len(list))
+			}
+			for i := range list {
+				if list[i].ID() != tt.wantIDs[i] {
+					t.Errorf("event %d: expected id %q, got %q", i, tt.wantIDs[i], list[i].ID())
+				}
+			}
+		})
+	}
+}
+
+// fakeEventHistory pages events ordered by serial id like the SQL store.
+type fakeEventHistory struct {
+	datastore.EventHistoryStore
+	events []*datastore.Event
+}
+
+func (f *fakeEventHistory) GetStartingIDUntilTime(_ context.Context, _ string, lastID int, _ time.Time, _ ...string) ([]*datastore.Event, error) {
+	var page []*datastore.Event
+	for _, ev := range f.events {
+		if ev.SerialID > lastID && len(page) < 200 {
+			page = append(page, ev)
+		}
+	}
+
+	return page, nil
+}
+
+func TestStreamEvents(t *testing.T) {
+	// a broadcast batch stamps all its events with the same receive time.
+	now := time.Now().UTC()
+	store := &fakeEventHistory{}
+	for i := 1; i <= 450; i++ {
+		ev := cloudevents.NewEvent()
+		ev.SetID(strconv.Itoa(i))
+		store.events = append(store.events, &datastore.Event{Event: &ev, ReceivedAt: now, SerialID: i})
+	}
+
+	var sent []int
+	send := func(ev *datastore.Event) error {
+		sent = append(sent, ev.SerialID)
+		return nil
+	}
+
+	cursor, err := streamEvents(context.Background(), store, "ns", 0, nil, send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cursor != 450 || len(sent) != 450 {
+		t.Fatalf("expected all 450 events, got %d with cursor %d", len(sent), cursor)
+	}
+	for i, id := range sent {
+		if id != i+1 {
+			t.Fatalf("expected event %d at %d, got %d", i+1, i, id)
+		}
+	}
+
+	// clients resuming with the last event id get the rest of the batch.
+	sent = nil
+	cursor, err = streamEvents(context.Background(), store, "ns", 200, nil, send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cursor != 450 || len(sent) != 250 || sent[0] != 201 {
+		t.Fatalf("expected events 201 to 450, got %d from %v", len(sent), sent[:1])
+	}
+}
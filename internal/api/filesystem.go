@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -203,10 +204,7 @@ func (e *fsController) delete(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	e.cache.Notify(r.Context(), cache.CacheNotify{
-		Key:    fmt.Sprintf("%s-%s-%s", namespace, "script", path),
-		Action: cache.CacheUpdate,
-	})
+	e.notifyFileChange(r.Context(), namespace, path)
 
 	writeOk(w)
 }
@@ -289,7 +287,8 @@ func (e *fsController) createFile(w http.ResponseWriter, r *http.Request) {
 
 	// validate flow file. it is stored but we report errors
 	if strings.HasSuffix(req.Name, core.FlowFileExtension) {
-		ci := compiler.NewCompileItem(decodedBytes, req.Name)
+		ci := compiler.NewCompileItem(decodedBytes, req.Name).
+			WithModules(moduleLoader(r.Context(), fStore, namespace))
 		err = ci.TranspileAndValidate()
 		if err != nil {
 			jErr, _ := json.Marshal(err)
@@ -403,10 +402,7 @@ func (e *fsController) updateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	e.cache.Notify(r.Context(), cache.CacheNotify{
-		Key:    fmt.Sprintf("%s-%s-%s", namespace, "script", path),
-		Action: cache.CacheUpdate,
-	})
+	e.notifyFileChange(r.Context(), namespace, path)
 
 	res := struct {
 		*filestore.File
@@ -420,7 +416,8 @@ func (e *fsController) updateFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.Data != "" && strings.HasSuffix(r.URL.Path, core.FlowFileExtension) {
-		ci := compiler.NewCompileItem(decodedBytes, r.URL.Path)
+		ci := compiler.NewCompileItem(decodedBytes, r.URL.Path).
+			WithModules(moduleLoader(r.Context(), fStore, namespace))
 		err = ci.TranspileAndValidate()
 		if err != nil {
 			jErr, _ := json.Marshal(err)
@@ -448,3 +445,25 @@ func (e *fsController) updateFile(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, res)
 }
+
+// notifyFileChange drops the cached file at path. Compiled flows keep the hashes of the
+// modules and schema files they depend on, the flows depending on it compile again.
+func (e *fsController) notifyFileChange(ctx context.Context, namespace, path string) {
+	e.cache.Notify(ctx, cache.CacheNotify{
+		Key:    fmt.Sprintf("%s-%s-%s", namespace, "script", path),
+		Action: cache.CacheUpdate,
+	})
+}
+
+// moduleLoader loads the modules imported by flows from the namespace root, validating a
+// flow reports imports that do not resolve.
+func moduleLoader(ctx context.Context, fStore filestore.FileStore, namespace string) compiler.ModuleLoader {
+	return func(path string) ([]byte, error) {
+		f, err := fStore.ForRoot(namespace).GetFile(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		return fStore.ForFile(f).GetData(ctx)
+	}
+}
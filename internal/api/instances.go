@@ -1,9 +1,13 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -14,6 +18,7 @@ import (
 	"github.com/direktiv/direktiv/internal/compiler"
 	"github.com/direktiv/direktiv/internal/core"
 	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
 	"github.com/direktiv/direktiv/internal/sched"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -42,6 +47,9 @@ type InstanceData struct {
 	TraceID      string         `json:"traceId"`
 	Lineage      []*LineageData `json:"lineage"`
 	Namespace    string         `json:"namespace"`
+	RetryOf      *string        `json:"retryOf"`
+	// PendingSignals are the signals the instance and its subflows wait for.
+	PendingSignals []*PendingSignalData `json:"pendingSignals,omitempty"`
 
 	InputLength    int     `json:"inputLength"`
 	Input          string  `json:"input"`
@@ -51,6 +59,29 @@ type InstanceData struct {
 	Metadata       []byte  `json:"metadata"`
 }
 
+// PendingSignalData is a signal an instance waits for, it is sent to the instance with the id.
+type PendingSignalData struct {
+	InstanceID uuid.UUID  `json:"instanceId"`
+	Name       string     `json:"name"`
+	Deadline   *time.Time `json:"deadline"`
+}
+
+func convertPendingSignals(list []*engine.PendingSignal) []*PendingSignalData {
+	res := make([]*PendingSignalData, 0, len(list))
+	for _, s := range list {
+		d := &PendingSignalData{
+			InstanceID: s.InstanceID,
+			Name:       s.Name,
+		}
+		if !s.Deadline.IsZero() {
+			d.Deadline = &s.Deadline
+		}
+		res = append(res, d)
+	}
+
+	return res
+}
+
 type InstanceEvent struct {
 	State      string            `json:"state"`
 	InstanceID uuid.UUID         `json:"instanceId"`
@@ -60,9 +91,12 @@ type InstanceEvent struct {
 	Fn         string            `json:"fn,omitempty"`
 	Mappings   string            `json:"mappings,omitempty"`
 
-	Input  json.RawMessage `json:"input,omitempty"`
-	Output json.RawMessage `json:"output,omitempty"`
-	Error  string          `json:"error,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ErrorCode string          `json:"errorCode,omitempty"`
+	// Records are the results of the non-deterministic calls leading to the event.
+	Records []*runtime.Record `json:"records,omitempty"`
 
 	CreatedAt time.Time `json:"createdAt"`
 	StartedAt time.Time `json:"startedAt"`
@@ -85,6 +119,8 @@ func convertToInstanceEvent(data *engine.InstanceEvent) *InstanceEvent {
 		Input:      data.Input,
 		Output:     data.Output,
 		Error:      data.Error,
+		ErrorCode:  data.ErrorCode,
+		Records:    data.Records,
 		CreatedAt:  data.CreatedAt,
 		StartedAt:  data.StartedAt,
 		EndedAt:    data.EndedAt,
@@ -122,6 +158,19 @@ func convertInstanceData(data *engine.InstanceEvent) *InstanceData {
 	if data.Error != "" {
 		resp.ErrorMessage = &data.Error
 	}
+	if data.ErrorCode != "" {
+		resp.ErrorCode = &data.ErrorCode
+	}
+	if retryOf, ok := data.Metadata[engine.LabelRetryOf]; ok {
+		resp.RetryOf = &retryOf
+	}
+	for _, l := range data.Lineage() {
+		resp.Lineage = append(resp.Lineage, &LineageData{
+			ID:    l.InstanceID.String(),
+			State: l.State,
+			Step:  l.Step,
+		})
+	}
 
 	return resp
 }
@@ -134,12 +183,15 @@ type instController struct {
 }
 
 func (e *instController) mountRouter(r chi.Router) {
-	r.Get("/{instanceID}/subscribe", e.dummy)
-	r.Get("/{instanceID}/input", e.dummy)
+	r.Get("/{instanceID}/subscribe", e.subscribe)
+	r.Get("/{instanceID}/input", e.input)
 	r.Get("/{instanceID}/history", e.history)
-	r.Get("/{instanceID}/metadata", e.dummy)
+	r.Get("/{instanceID}/metadata", e.metadata)
 	r.Get("/{instanceID}/flow", e.flow)
 	r.Patch("/{instanceID}", e.patch)
+	r.Post("/{instanceID}/retry", e.retry)
+	r.Get("/{instanceID}/signals", e.signals)
+	r.Post("/{instanceID}/signals/{name}", e.signal)
 	r.Get("/", e.list)
 	r.Get("/{instanceID}", e.get)
 
@@ -147,9 +199,6 @@ func (e *instController) mountRouter(r chi.Router) {
 	r.Get("/scheds", e.scheds)
 }
 
-func (e *instController) dummy(w http.ResponseWriter, r *http.Request) {
-}
-
 func (e *instController) patch(w http.ResponseWriter, r *http.Request) {
 	namespace := chi.URLParam(r, "namespace")
 	instanceIDStr := chi.URLParam(r, "instanceID")
@@ -191,6 +240,51 @@ func (e *instController) patch(w http.ResponseWriter, r *http.Request) {
 	writeOk(w)
 }
 
+// retry starts a new instance from a recorded transition of a failed or cancelled
+// instance, from is the state function or "last".
+func (e *instController) retry(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	instanceIDStr := chi.URLParam(r, "instanceID")
+	instanceID, err := uuid.Parse(instanceIDStr)
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid instance uuid",
+		})
+
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = engine.RetryFromLast
+	}
+
+	st, err := e.engine.RetryInstance(r.Context(), namespace, instanceID, from)
+	if errors.Is(err, engine.ErrRetryNotFailed) {
+		writeError(w, &Error{
+			Code:    "request_instance_not_failed",
+			Message: err.Error(),
+		})
+
+		return
+	}
+	if errors.Is(err, engine.ErrRetryStateNotFound) {
+		writeError(w, &Error{
+			Code:    "request_state_invalid",
+			Message: fmt.Sprintf("instance did not transition to state '%s'", from),
+		})
+
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	writeJSON(w, convertInstanceData(st))
+}
+
 func (e *instController) flow(w http.ResponseWriter, r *http.Request) {
 	namespace := chi.URLParam(r, "namespace")
 	instanceIDStr := chi.URLParam(r, "instanceID")
@@ -352,7 +446,83 @@ func (e *instController) get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, convertInstanceData(data))
+	resp := convertInstanceData(data)
+	resp.PendingSignals = convertPendingSignals(e.engine.PendingSignals(r.Context(), data))
+
+	writeJSON(w, resp)
+}
+
+// signals lists the signals the instance and its subflows wait for.
+func (e *instController) signals(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	instanceIDStr := chi.URLParam(r, "instanceID")
+	instanceID, err := uuid.Parse(instanceIDStr)
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid instance uuid",
+		})
+
+		return
+	}
+
+	data, err := e.engine.GetInstanceStatus(r.Context(), namespace, instanceID)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	writeJSON(w, convertPendingSignals(e.engine.PendingSignals(r.Context(), data)))
+}
+
+// signal sends the signal name to the instance waiting for it, the JSON body is the
+// payload waitForSignal returns.
+func (e *instController) signal(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	name := chi.URLParam(r, "name")
+	instanceIDStr := chi.URLParam(r, "instanceID")
+	instanceID, err := uuid.Parse(instanceIDStr)
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid instance uuid",
+		})
+
+		return
+	}
+
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if len(bytes.TrimSpace(payload)) == 0 {
+		payload = []byte("null")
+	}
+	if !json.Valid(payload) {
+		writeError(w, &Error{
+			Code:    "request_body_not_json",
+			Message: "signal payload is not valid json",
+		})
+
+		return
+	}
+
+	err = e.engine.SignalInstance(r.Context(), namespace, instanceID, name, payload)
+	if errors.Is(err, engine.ErrWaitNotFound) {
+		writeError(w, &Error{
+			Code:    "request_signal_not_pending",
+			Message: fmt.Sprintf("instance is not waiting for signal '%s'", name),
+		})
+
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	writeOk(w)
 }
 
 func (e *instController) list(w http.ResponseWriter, r *http.Request) {
@@ -367,6 +537,8 @@ func (e *instController) list(w http.ResponseWriter, r *http.Request) {
 	replacements := map[string]string{
 		"[path]":    "[metadata_" + core.EngineMappingPath + "]",
 		"[invoker]": "[metadata_" + engine.LabelInvokerType + "]",
+		"[parent]":  "[metadata_" + engine.LabelParentInstance + "]",
+		"[retryOf]": "[metadata_" + engine.LabelRetryOf + "]",
 	}
 	fixedQueryValues := make(map[string][]string)
 	for k, v := range queryValues {
@@ -424,6 +596,146 @@ func (e *instController) history(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, out)
 }
 
+// input returns the instance with the input it was started with.
+func (e *instController) input(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	instanceIDStr := chi.URLParam(r, "instanceID")
+	instanceID, err := uuid.Parse(instanceIDStr)
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid instance uuid",
+		})
+
+		return
+	}
+
+	list, err := e.engine.GetInstanceHistory(r.Context(), namespace, instanceID)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	// the first event of the history records the input.
+	resp := convertInstanceData(list[len(list)-1])
+	resp.Input = string(list[0].Input)
+	resp.InputLength = len(list[0].Input)
+
+	writeJSON(w, resp)
+}
+
+// metadata returns the instance with its metadata. Secrets of the flow are not included.
+func (e *instController) metadata(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	instanceIDStr := chi.URLParam(r, "instanceID")
+	instanceID, err := uuid.Parse(instanceIDStr)
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid instance uuid",
+		})
+
+		return
+	}
+
+	list, err := e.engine.GetInstanceHistory(r.Context(), namespace, instanceID)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	last := list[len(list)-1]
+	metadata := maps.Clone(last.Metadata)
+	delete(metadata, core.EngineMappingSecrets)
+
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	resp := convertInstanceData(last)
+	resp.Metadata = b
+	resp.MetadataLength = len(b)
+
+	writeJSON(w, resp)
+}
+
+// subscribe streams the instance using Server-Sent Events (SSE) until it ends. The
+// history sequence of an update is its event id, clients resume with the Last-Event-ID
+// header.
+func (e *instController) subscribe(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+	instanceIDStr := chi.URLParam(r, "instanceID")
+	instanceID, err := uuid.Parse(instanceIDStr)
+	if err != nil {
+		writeError(w, &Error{
+			Code:    "request_id_invalid",
+			Message: "invalid instance uuid",
+		})
+
+		return
+	}
+
+	var cursor uint64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		cursor, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	list, err := e.engine.GetInstanceHistory(r.Context(), namespace, instanceID)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	// the client already received the end of the instance, 204 stops it from reconnecting.
+	last := list[len(list)-1]
+	if last.IsEndStatus() && last.Sequence <= cursor {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ch, err := e.engine.SubscribeInstanceHistory(r.Context(), namespace, instanceID, cursor)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Accel-Buffering", "no")
+	_ = rc.Flush()
+
+	for {
+		var ev *engine.InstanceEvent
+		select {
+		case <-r.Context().Done():
+			return
+		case ev = <-ch:
+		}
+
+		b, err := json.Marshal(convertInstanceData(ev))
+		if err != nil {
+			slog.Error("error streaming instance", slog.Any("error", err))
+			return
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Sequence, "message", string(b))
+		if err != nil {
+			slog.Error("error streaming instance", slog.Any("error", err))
+			return
+		}
+		_ = rc.Flush()
+
+		if ev.IsEndStatus() {
+			return
+		}
+	}
+}
+
 func (e *instController) scheds(writer http.ResponseWriter, r *http.Request) {
 	list, err := e.scheduler.ListRules(r.Context())
 	if err != nil {
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/pkg/lifecycle"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHistoryBus struct {
+	history []*engine.InstanceEvent
+}
+
+var _ engine.DataBus = &fakeHistoryBus{}
+
+func (f *fakeHistoryBus) Start(lc *lifecycle.Manager) error { return nil }
+
+func (f *fakeHistoryBus) PublishInstanceHistoryEvent(ctx context.Context, event *engine.InstanceEvent) error {
+	return nil
+}
+
+func (f *fakeHistoryBus) PublishInstanceQueueEvent(ctx context.Context, event *engine.InstanceEvent) error {
+	return nil
+}
+
+func (f *fakeHistoryBus) PublishInstanceHeartbeat(ctx context.Context, event *engine.InstanceEvent) error {
+	return nil
+}
+
+func (f *fakeHistoryBus) ListInstanceStatuses(ctx context.Context, limit int, offset int, filters filter.Values) ([]*engine.InstanceEvent, int) {
+	if len(f.history) == 0 {
+		return nil, 0
+	}
+
+	return f.history[len(f.history)-1:], 1
+}
+
+func (f *fakeHistoryBus) GetInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID) []*engine.InstanceEvent {
+	return f.history
+}
+
+func (f *fakeHistoryBus) GetInstanceHeartbeat(ctx context.Context, instanceID uuid.UUID) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (f *fakeHistoryBus) SubscribeInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID, afterSequence uint64) (<-chan *engine.InstanceEvent, error) {
+	ch := make(chan *engine.InstanceEvent, len(f.history))
+	for _, ev := range f.history {
+		if ev.Sequence > afterSequence {
+			ch <- ev
+		}
+	}
+
+	return ch, nil
+}
+
+func (f *fakeHistoryBus) DeleteNamespace(ctx context.Context, namespace string) error { return nil }
+
+func (f *fakeHistoryBus) PublishIgniteAction(ctx context.Context, svcID string) error { return nil }
+
+func (f *fakeHistoryBus) PublishInstanceCancel(ctx context.Context, instanceID uuid.UUID) error {
+	return nil
+}
+
+func (f *fakeHistoryBus) SubscribeInstanceCancel(h func(instanceID uuid.UUID)) error { return nil }
+
+func (f *fakeHistoryBus) SubscribeInstanceEnd(h func(st *engine.InstanceEvent)) error { return nil }
+
+func newInstanceTestRouter(t *testing.T) (http.Handler, uuid.UUID) {
+	t.Helper()
+
+	id := uuid.New()
+	metadata := map[string]string{
+		engine.LabelWithScope:       "main",
+		core.EngineMappingPath:      "/wf.wf.ts",
+		core.EngineMappingSecrets:   `{"token":"c2VjcmV0"}`,
+		engine.LabelInvokerType:     "api",
+		core.EngineMappingNamespace: "ns",
+	}
+	ev := func(seq uint64, state engine.StateCode) *engine.InstanceEvent {
+		return &engine.InstanceEvent{
+			State:      state,
+			InstanceID: id,
+			Namespace:  "ns",
+			Metadata:   metadata,
+			Input:      json.RawMessage(`{"a":1}`),
+			Sequence:   seq,
+		}
+	}
+	bus := &fakeHistoryBus{history: []*engine.InstanceEvent{
+		ev(1, engine.StateCodePending),
+		ev(2, engine.StateCodeRunning),
+		ev(3, engine.StateCodeComplete),
+	}}
+	eng, err := engine.NewEngine(bus, nil, nil, nil, nil, &core.Config{EngineWorkers: 1})
+	require.NoError(t, err)
+
+	ctrl := &instController{engine: eng}
+	r := chi.NewRouter()
+	r.Route("/namespaces/{namespace}/instances", ctrl.mountRouter)
+
+	return r, id
+}
+
+func TestInstanceInputAndMetadata(t *testing.T) {
+	r, id := newInstanceTestRouter(t)
+
+	var resp struct {
+		Data InstanceData `json:"data"`
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/namespaces/ns/instances/"+id.String()+"/input", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.JSONEq(t, `{"a":1}`, resp.Data.Input)
+	require.Equal(t, "complete", resp.Data.Status)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/namespaces/ns/instances/"+id.String()+"/metadata", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	var metadata map[string]string
+	require.NoError(t, json.Unmarshal(resp.Data.Metadata, &metadata))
+	require.Equal(t, "/wf.wf.ts", metadata[core.EngineMappingPath])
+	require.NotContains(t, metadata, core.EngineMappingSecrets)
+}
+
+func TestInstanceSubscribe(t *testing.T) {
+	r, id := newInstanceTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/namespaces/ns/instances/"+id.String()+"/subscribe", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	require.NotContains(t, body, "id: 1\n")
+	require.Contains(t, body, "id: 2\n")
+	require.Contains(t, body, "id: 3\n")
+	require.Equal(t, 2, strings.Count(body, "event: message\n"))
+
+	// the client already received the end of the instance.
+	req = httptest.NewRequest(http.MethodGet, "/namespaces/ns/instances/"+id.String()+"/subscribe", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func TestInstanceRetry(t *testing.T) {
+	r, id := newInstanceTestRouter(t)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/namespaces/ns/instances/"+id.String()+"/retry?from=last", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "request_instance_not_failed")
+}
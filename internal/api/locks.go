@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type locksController struct {
+	store datastore.Store
+}
+
+func (c *locksController) mountRouter(r chi.Router) {
+	r.Get("/", c.list)
+}
+
+type lockEntry struct {
+	Key        string    `json:"key"`
+	Holder     string    `json:"holder"`
+	InstanceID uuid.UUID `json:"instanceId"`
+	Limit      int       `json:"limit"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// list returns the locks and semaphore slots held in the namespace.
+func (c *locksController) list(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+
+	leases, err := c.store.Leases().List(r.Context(), namespace)
+	if err != nil {
+		writeDataStoreError(w, err)
+		return
+	}
+
+	res := make([]lockEntry, 0, len(leases))
+	for _, l := range leases {
+		res = append(res, lockEntry{
+			Key:        l.Key,
+			Holder:     l.Holder,
+			InstanceID: l.InstanceID,
+			Limit:      l.Limit,
+			ExpiresAt:  l.ExpiresAt,
+			CreatedAt:  l.CreatedAt,
+		})
+	}
+
+	writeJSON(w, res)
+}
@@ -7,6 +7,7 @@ const (
 	SubjNamespacesChange Subject = "namespace.change"
 	SubjCacheDelete      Subject = "cache.delete"
 	SubjServiceIgnite    Subject = "service.ignite"
+	SubjInstanceCancel   Subject = "instance.cancel"
 )
 
 type Handler func(data []byte)
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"path"
 	"slices"
 	"strings"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/grafana/sobek/ast"
 	"github.com/grafana/sobek/file"
 	"github.com/grafana/sobek/parser"
+	"github.com/grafana/sobek/token"
 	"github.com/sosodev/duration"
 )
 
@@ -62,6 +64,9 @@ type ASTParser struct {
 
 	currentStateNode string
 	stateviews       map[string]*core.StateView
+
+	// imports are the require calls of the modules imported by the script.
+	imports []*ast.CallExpression
 }
 
 func NewASTParser(script, mapping string) (*ASTParser, error) {
@@ -148,12 +153,29 @@ func (ap *ASTParser) Parse() error {
 		}
 	}
 
+	// catch handlers are transitions of the state as well.
+	for name, policy := range ap.FlowConfig.States {
+		view, ok := ap.stateviews[name]
+		if !ok {
+			continue
+		}
+		for _, catch := range policy.Catch {
+			if !slices.Contains(view.Transitions, catch.State) {
+				view.Transitions = append(view.Transitions, catch.State)
+			}
+		}
+	}
+
 	// in the state views we have to set the start node at the end
 	// when everything is parsed
 
 	state, ok := ap.stateviews[ap.FlowConfig.State]
 	if !ok {
-		slog.Error("cannot set start state in state view")
+		// modules without state functions have no start state.
+		if ap.FlowConfig.State != "" {
+			slog.Error("cannot set start state in state view")
+		}
+
 		return nil
 	}
 	state.Start = true
@@ -503,7 +525,16 @@ func (ap *ASTParser) walkExpression(expr ast.Expression, isInsideFunc bool, isSt
 		if identifier, ok := e.Callee.(*ast.Identifier); ok {
 			funcName = identifier.Name.String()
 			// Check if this is an allowed top-level function
-			isAllowedTopLevel = funcName == "getSecrets" || funcName == "generateAction"
+			isAllowedTopLevel = funcName == "getSecrets" || funcName == "generateAction" || funcName == "require"
+
+			// imports are transpiled to require calls
+			if funcName == "require" {
+				if len(e.ArgumentList) == 1 {
+					if _, ok := e.ArgumentList[0].(*ast.StringLiteral); ok {
+						ap.imports = append(ap.imports, e)
+					}
+				}
+			}
 
 			// Check for generateAction and collect it
 			if funcName == "generateAction" {
@@ -566,8 +597,9 @@ func (ap *ASTParser) walkExpression(expr ast.Expression, isInsideFunc bool, isSt
 				}
 			}
 		} else {
-			// For method calls, dot expressions, etc., they are NOT allowed at top level
-			isAllowedTopLevel = false
+			// For method calls, dot expressions, etc., they are NOT allowed at top level,
+			// except the marker of transpiled modules.
+			isAllowedTopLevel = isModuleMarker(e)
 		}
 
 		// Check for invalid function calls outside functions
@@ -671,6 +703,26 @@ func (ap *ASTParser) walkExpression(expr ast.Expression, isInsideFunc bool, isSt
 	}
 }
 
+// isModuleMarker checks if a call is the Object.defineProperty(exports, "__esModule", ...)
+// statement of a script with imports or exports.
+func isModuleMarker(callExpr *ast.CallExpression) bool {
+	dot, ok := callExpr.Callee.(*ast.DotExpression)
+	if !ok || dot.Identifier.Name.String() != "defineProperty" {
+		return false
+	}
+	obj, ok := dot.Left.(*ast.Identifier)
+	if !ok || obj.Name.String() != "Object" || len(callExpr.ArgumentList) < 2 {
+		return false
+	}
+	target, ok := callExpr.ArgumentList[0].(*ast.Identifier)
+	if !ok || target.Name.String() != "exports" {
+		return false
+	}
+	name, ok := callExpr.ArgumentList[1].(*ast.StringLiteral)
+
+	return ok && name.Value.String() == "__esModule"
+}
+
 // isTransitionCall checks if a node is a call to transition or finish.
 func (ap *ASTParser) isTransitionCall(node ast.Node) bool {
 	callExpr, ok := node.(*ast.CallExpression)
@@ -861,6 +913,31 @@ func (ap *ASTParser) buildFlowConfig() (core.FlowConfig, error) {
 					}
 				}
 			}
+
+		case "states":
+			states, err := ap.parseStatePolicies(keyed.Value)
+			if err != nil {
+				return flow, err
+			}
+			flow.States = states
+
+		case "concurrency":
+			concurrency, err := ap.parseConcurrency(keyed.Value)
+			if err != nil {
+				return flow, err
+			}
+			flow.Concurrency = concurrency
+
+		case "input", "output":
+			schema, err := ap.parseSchema(keyed)
+			if err != nil {
+				return flow, err
+			}
+			if keyName == "input" {
+				flow.Input = schema
+			} else {
+				flow.Output = schema
+			}
 		}
 	}
 
@@ -948,6 +1025,381 @@ func (ap *ASTParser) parseEvent(expr ast.Expression) (core.EventConfig, error) {
 	return event, nil
 }
 
+// parseStatePolicies parses the error policies and timeouts of the state functions,
+// keyed by the name of the state function.
+func (ap *ASTParser) parseStatePolicies(expr ast.Expression) (map[string]*core.StatePolicy, error) {
+	objLit, ok := expr.(*ast.ObjectLiteral)
+	if !ok {
+		return nil, ap.newValidationError(expr, "states must be an object")
+	}
+
+	states := make(map[string]*core.StatePolicy)
+	for _, prop := range objLit.Value {
+		keyed, ok := prop.(*ast.PropertyKeyed)
+		if !ok {
+			continue
+		}
+		state, ok := propertyName(keyed)
+		if !ok {
+			continue
+		}
+		if !slices.Contains(ap.allFunctionNames, state) {
+			return nil, ap.newValidationError(keyed, fmt.Sprintf("state function '%s' does not exist", state))
+		}
+
+		policyLit, ok := keyed.Value.(*ast.ObjectLiteral)
+		if !ok {
+			return nil, ap.newValidationError(keyed, fmt.Sprintf("policy of state '%s' must be an object", state))
+		}
+
+		policy := &core.StatePolicy{}
+		for _, policyProp := range policyLit.Value {
+			policyKeyed, ok := policyProp.(*ast.PropertyKeyed)
+			if !ok {
+				continue
+			}
+			name, _ := propertyName(policyKeyed)
+
+			switch name {
+			case "retry":
+				for _, elem := range objectOrList(policyKeyed.Value) {
+					retry, err := ap.parseRetryPolicy(elem)
+					if err != nil {
+						return nil, err
+					}
+					policy.Retry = append(policy.Retry, retry)
+				}
+			case "catch":
+				for _, elem := range objectOrList(policyKeyed.Value) {
+					catch, err := ap.parseCatchPolicy(elem)
+					if err != nil {
+						return nil, err
+					}
+					policy.Catch = append(policy.Catch, catch)
+				}
+			case "timeout":
+				strLit, ok := policyKeyed.Value.(*ast.StringLiteral)
+				if !ok {
+					return nil, ap.newValidationError(policyKeyed, "timeout must be a string")
+				}
+				timeout := strLit.Value.String()
+				if _, err := duration.Parse(timeout); err != nil {
+					return nil, ap.newValidationError(policyKeyed,
+						fmt.Sprintf("invalid timeout pattern '%s', must be ISO8601", timeout))
+				}
+				policy.Timeout = timeout
+			default:
+				return nil, ap.newValidationError(policyKeyed, fmt.Sprintf("unknown state policy '%s'", name))
+			}
+		}
+		states[state] = policy
+	}
+
+	return states, nil
+}
+
+// parseRetryPolicy parses a retry rule of a state function.
+func (ap *ASTParser) parseRetryPolicy(expr ast.Expression) (core.RetryPolicy, error) {
+	retry := core.RetryPolicy{}
+
+	objLit, ok := expr.(*ast.ObjectLiteral)
+	if !ok {
+		return retry, ap.newValidationError(expr, "retry must be an object")
+	}
+
+	for _, prop := range objLit.Value {
+		keyed, ok := prop.(*ast.PropertyKeyed)
+		if !ok {
+			continue
+		}
+		name, _ := propertyName(keyed)
+
+		switch name {
+		case "maxAttempts":
+			numLit, ok := keyed.Value.(*ast.NumberLiteral)
+			if !ok {
+				return retry, ap.newValidationError(keyed, "maxAttempts must be a number")
+			}
+			retry.MaxAttempts = numberValue(numLit)
+		case "backoff":
+			strLit, ok := keyed.Value.(*ast.StringLiteral)
+			if !ok {
+				return retry, ap.newValidationError(keyed, "backoff must be a string")
+			}
+			backoff := strLit.Value.String()
+			if _, err := duration.Parse(backoff); err != nil {
+				return retry, ap.newValidationError(keyed,
+					fmt.Sprintf("invalid backoff pattern '%s', must be ISO8601", backoff))
+			}
+			retry.Backoff = backoff
+		case "codes":
+			codes, err := ap.parseErrorCodes(keyed)
+			if err != nil {
+				return retry, err
+			}
+			retry.Codes = codes
+		}
+	}
+
+	if retry.MaxAttempts < 1 {
+		return retry, ap.newValidationError(expr, "retry requires maxAttempts of at least 1")
+	}
+
+	return retry, nil
+}
+
+// parseCatchPolicy parses a catch handler of a state function.
+func (ap *ASTParser) parseCatchPolicy(expr ast.Expression) (core.CatchPolicy, error) {
+	catch := core.CatchPolicy{}
+
+	objLit, ok := expr.(*ast.ObjectLiteral)
+	if !ok {
+		return catch, ap.newValidationError(expr, "catch must be an object")
+	}
+
+	for _, prop := range objLit.Value {
+		keyed, ok := prop.(*ast.PropertyKeyed)
+		if !ok {
+			continue
+		}
+		name, _ := propertyName(keyed)
+
+		switch name {
+		case "state":
+			strLit, ok := keyed.Value.(*ast.StringLiteral)
+			if !ok {
+				return catch, ap.newValidationError(keyed, "catch state must be a string")
+			}
+			state := strLit.Value.String()
+			if !slices.Contains(ap.allFunctionNames, state) {
+				return catch, ap.newValidationError(keyed, fmt.Sprintf("state function '%s' does not exist", state))
+			}
+			catch.State = state
+		case "codes":
+			codes, err := ap.parseErrorCodes(keyed)
+			if err != nil {
+				return catch, err
+			}
+			catch.Codes = codes
+		}
+	}
+
+	if catch.State == "" {
+		return catch, ap.newValidationError(expr, "catch requires a state")
+	}
+
+	return catch, nil
+}
+
+// parseConcurrency parses the concurrency limit of the flow, either a number or an
+// object with limit and overflow behavior.
+func (ap *ASTParser) parseConcurrency(expr ast.Expression) (*core.ConcurrencyConfig, error) {
+	concurrency := &core.ConcurrencyConfig{
+		Overflow: core.ConcurrencyOverflowQueue,
+	}
+
+	switch v := expr.(type) {
+	case *ast.NumberLiteral:
+		concurrency.Limit = numberValue(v)
+	case *ast.ObjectLiteral:
+		for _, prop := range v.Value {
+			keyed, ok := prop.(*ast.PropertyKeyed)
+			if !ok {
+				continue
+			}
+			name, _ := propertyName(keyed)
+
+			switch name {
+			case "limit":
+				numLit, ok := keyed.Value.(*ast.NumberLiteral)
+				if !ok {
+					return nil, ap.newValidationError(keyed, "limit must be a number")
+				}
+				concurrency.Limit = numberValue(numLit)
+			case "overflow":
+				strLit, ok := keyed.Value.(*ast.StringLiteral)
+				if !ok {
+					return nil, ap.newValidationError(keyed, "overflow must be a string")
+				}
+				overflow := strLit.Value.String()
+				if overflow != core.ConcurrencyOverflowQueue && overflow != core.ConcurrencyOverflowSkip {
+					return nil, ap.newValidationError(keyed,
+						fmt.Sprintf("invalid overflow '%s', must be 'queue' or 'skip'", overflow))
+				}
+				concurrency.Overflow = overflow
+			default:
+				return nil, ap.newValidationError(keyed, fmt.Sprintf("unknown concurrency setting '%s'", name))
+			}
+		}
+	default:
+		return nil, ap.newValidationError(expr, "concurrency must be a number or an object")
+	}
+
+	if concurrency.Limit < 1 {
+		return nil, ap.newValidationError(expr, "concurrency requires a limit of at least 1")
+	}
+
+	return concurrency, nil
+}
+
+// parseSchema parses the JSON schema of the flow input or output, either an object literal
+// or the path of a json file in the namespace.
+func (ap *ASTParser) parseSchema(keyed *ast.PropertyKeyed) (*core.FlowSchema, error) {
+	name, _ := propertyName(keyed)
+
+	switch v := keyed.Value.(type) {
+	case *ast.StringLiteral:
+		file := v.Value.String()
+		if path.Ext(file) != ".json" {
+			return nil, ap.newValidationError(keyed, fmt.Sprintf("%s schema file '%s' must be a json file", name, file))
+		}
+
+		return &core.FlowSchema{File: file}, nil
+	case *ast.ObjectLiteral:
+		value, err := ap.literalValue(v)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, ap.newValidationError(keyed, fmt.Sprintf("invalid %s schema: %s", name, err.Error()))
+		}
+		if _, err := core.ParseSchema(b); err != nil {
+			return nil, ap.newValidationError(keyed, fmt.Sprintf("invalid %s schema: %s", name, err.Error()))
+		}
+
+		return &core.FlowSchema{Schema: b}, nil
+	}
+
+	return nil, ap.newValidationError(keyed, fmt.Sprintf("%s schema must be an object or the path of a json file", name))
+}
+
+// literalValue returns the value of a literal expression as JSON compatible value.
+func (ap *ASTParser) literalValue(expr ast.Expression) (any, error) {
+	switch v := expr.(type) {
+	case *ast.StringLiteral:
+		return v.Value.String(), nil
+	case *ast.NumberLiteral:
+		return v.Value, nil
+	case *ast.BooleanLiteral:
+		return v.Value, nil
+	case *ast.NullLiteral:
+		return nil, nil
+	case *ast.UnaryExpression:
+		if num, ok := v.Operand.(*ast.NumberLiteral); ok && v.Operator == token.MINUS {
+			switch n := num.Value.(type) {
+			case int64:
+				return -n, nil
+			case float64:
+				return -n, nil
+			}
+		}
+	case *ast.ArrayLiteral:
+		list := make([]any, 0, len(v.Value))
+		for _, elem := range v.Value {
+			value, err := ap.literalValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+
+		return list, nil
+	case *ast.ObjectLiteral:
+		obj := make(map[string]any, len(v.Value))
+		for _, prop := range v.Value {
+			keyed, ok := prop.(*ast.PropertyKeyed)
+			if !ok || keyed.Computed {
+				return nil, ap.newValidationError(prop, "only literal properties are supported")
+			}
+			name, ok := propertyName(keyed)
+			if !ok {
+				return nil, ap.newValidationError(prop, "only literal properties are supported")
+			}
+			value, err := ap.literalValue(keyed.Value)
+			if err != nil {
+				return nil, err
+			}
+			obj[name] = value
+		}
+
+		return obj, nil
+	}
+
+	return nil, ap.newValidationError(expr, "only literal values are supported")
+}
+
+// numberValue returns the integer value of a number literal.
+func numberValue(numLit *ast.NumberLiteral) int {
+	switch v := numLit.Value.(type) {
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+
+	return 0
+}
+
+// parseErrorCodes parses a list of error code patterns.
+func (ap *ASTParser) parseErrorCodes(keyed *ast.PropertyKeyed) ([]string, error) {
+	arrLit, ok := keyed.Value.(*ast.ArrayLiteral)
+	if !ok {
+		return nil, ap.newValidationError(keyed, "codes must be an array")
+	}
+
+	codes := make([]string, 0, len(arrLit.Value))
+	for _, elem := range arrLit.Value {
+		strLit, ok := elem.(*ast.StringLiteral)
+		if !ok {
+			return nil, ap.newValidationError(elem, "error codes must be strings")
+		}
+		code := strLit.Value.String()
+		if _, err := path.Match(code, ""); err != nil {
+			return nil, ap.newValidationError(elem, fmt.Sprintf("invalid error code pattern '%s'", code))
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// newValidationError returns a validation error spanning node.
+func (ap *ASTParser) newValidationError(node ast.Node, message string) *ValidationError {
+	start := ap.file.Position(int(node.Idx0()))
+	end := ap.file.Position(int(node.Idx1()))
+
+	return &ValidationError{
+		Message:     message,
+		StartLine:   start.Line,
+		StartColumn: start.Column,
+		EndLine:     end.Line,
+		EndColumn:   end.Column,
+		Severity:    SeverityError,
+	}
+}
+
+// propertyName returns the name of an identifier or string key.
+func propertyName(keyed *ast.PropertyKeyed) (string, bool) {
+	switch k := keyed.Key.(type) {
+	case *ast.Identifier:
+		return k.Name.String(), true
+	case *ast.StringLiteral:
+		return k.Value.String(), true
+	}
+
+	return "", false
+}
+
+// objectOrList returns the elements of an array literal, any other expression as single element.
+func objectOrList(expr ast.Expression) []ast.Expression {
+	if arrLit, ok := expr.(*ast.ArrayLiteral); ok {
+		return arrLit.Value
+	}
+
+	return []ast.Expression{expr}
+}
+
 // extractValue extracts a simple value from an expression.
 func (ap *ASTParser) extractValue(expr ast.Expression) any {
 	switch e := expr.(type) {
@@ -1,9 +1,11 @@
 package compiler_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/direktiv/direktiv/internal/compiler"
+	"github.com/direktiv/direktiv/internal/core"
 	"github.com/stretchr/testify/require"
 )
 
@@ -386,6 +388,140 @@ func TestFlowConfig(t *testing.T) {
 	}
 }
 
+// TestFlowConfigStates tests the retry and catch policies and timeouts of state functions
+func TestFlowConfigStates(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		want        map[string]*core.StatePolicy
+		expectError bool
+	}{
+		{
+			name: "retry and catch",
+			script: `
+			var flow = {
+				states: {
+					stateOne: {
+						retry: { maxAttempts: 3, backoff: "PT10S", codes: ["http.*"] },
+						catch: [{ codes: ["*"], state: "stateFailed" }]
+					}
+				}
+			}
+			function stateOne() { return finish(); }
+			function stateFailed() { return finish(); }`,
+			want: map[string]*core.StatePolicy{
+				"stateOne": {
+					Retry: []core.RetryPolicy{{Codes: []string{"http.*"}, MaxAttempts: 3, Backoff: "PT10S"}},
+					Catch: []core.CatchPolicy{{Codes: []string{"*"}, State: "stateFailed"}},
+				},
+			},
+		},
+		{
+			name: "list of retry rules",
+			script: `
+			var flow = {
+				states: {
+					"stateOne": {
+						retry: [{ maxAttempts: 2, codes: ["a"] }, { maxAttempts: 5 }]
+					}
+				}
+			}
+			function stateOne() { return finish(); }`,
+			want: map[string]*core.StatePolicy{
+				"stateOne": {
+					Retry: []core.RetryPolicy{{Codes: []string{"a"}, MaxAttempts: 2}, {MaxAttempts: 5}},
+				},
+			},
+		},
+		{
+			name: "state timeout",
+			script: `
+			var flow = {
+				states: { stateOne: { timeout: "PT30S", retry: { maxAttempts: 2, codes: ["io.direktiv.error.timeout"] } } }
+			}
+			function stateOne() { return finish(); }`,
+			want: map[string]*core.StatePolicy{
+				"stateOne": {
+					Retry:   []core.RetryPolicy{{Codes: []string{"io.direktiv.error.timeout"}, MaxAttempts: 2}},
+					Timeout: "PT30S",
+				},
+			},
+		},
+		{
+			name: "invalid state timeout",
+			script: `
+			var flow = {
+				states: { stateOne: { timeout: "30s" } }
+			}
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "unknown state",
+			script: `
+			var flow = {
+				states: { stateTwo: { retry: { maxAttempts: 2 } } }
+			}
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "unknown catch state",
+			script: `
+			var flow = {
+				states: { stateOne: { catch: { state: "stateTwo" } } }
+			}
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "invalid backoff",
+			script: `
+			var flow = {
+				states: { stateOne: { retry: { maxAttempts: 2, backoff: "10s" } } }
+			}
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "missing max attempts",
+			script: `
+			var flow = {
+				states: { stateOne: { retry: { backoff: "PT1S" } } }
+			}
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "invalid code pattern",
+			script: `
+			var flow = {
+				states: { stateOne: { retry: { maxAttempts: 2, codes: ["[a"] } } }
+			}
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := compiler.NewASTParser(tt.script, "")
+			require.NoError(t, err)
+
+			err = parser.Parse()
+			require.NoError(t, err)
+
+			if tt.expectError {
+				require.NotEmpty(t, parser.Errors)
+				return
+			}
+
+			require.Empty(t, parser.Errors)
+			require.Equal(t, tt.want, parser.FlowConfig.States)
+		})
+	}
+}
+
 // TestTopLevelFunctionCalls tests that only secrets/getSecrets/generateAction are allowed at top level
 func TestTopLevelFunctionCalls(t *testing.T) {
 	transpiler, _ := compiler.NewTranspiler()
@@ -809,3 +945,141 @@ func TestComplexScenarios(t *testing.T) {
 		})
 	}
 }
+
+// TestFlowConfigConcurrency tests the concurrency limit of flows
+func TestFlowConfigConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		want        *core.ConcurrencyConfig
+		expectError bool
+	}{
+		{
+			name: "limit",
+			script: `
+			var flow = { concurrency: 1 }
+			function stateOne() { return finish(); }`,
+			want: &core.ConcurrencyConfig{Limit: 1, Overflow: core.ConcurrencyOverflowQueue},
+		},
+		{
+			name: "limit and overflow",
+			script: `
+			var flow = { concurrency: { limit: 3, overflow: "skip" } }
+			function stateOne() { return finish(); }`,
+			want: &core.ConcurrencyConfig{Limit: 3, Overflow: core.ConcurrencyOverflowSkip},
+		},
+		{
+			name: "no concurrency",
+			script: `
+			var flow = { type: "default" }
+			function stateOne() { return finish(); }`,
+		},
+		{
+			name: "zero limit",
+			script: `
+			var flow = { concurrency: 0 }
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "invalid overflow",
+			script: `
+			var flow = { concurrency: { limit: 1, overflow: "drop" } }
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := compiler.NewASTParser(tt.script, "")
+			require.NoError(t, err)
+
+			err = parser.Parse()
+			require.NoError(t, err)
+
+			if tt.expectError {
+				require.NotEmpty(t, parser.Errors)
+				return
+			}
+
+			require.Empty(t, parser.Errors)
+			require.Equal(t, tt.want, parser.FlowConfig.Concurrency)
+		})
+	}
+}
+
+// TestFlowConfigSchemas tests the input and output schemas of flows
+func TestFlowConfigSchemas(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		wantInput   *core.FlowSchema
+		wantOutput  *core.FlowSchema
+		expectError bool
+	}{
+		{
+			name: "inline schemas",
+			script: `
+			var flow = {
+				input: { type: "object", required: ["id"], properties: { id: { type: "integer", minimum: -1 } } },
+				output: { "type": "array", items: { type: "string", nullable: true } }
+			}
+			function stateOne() { return finish(); }`,
+			wantInput: &core.FlowSchema{
+				Schema: json.RawMessage(`{"properties":{"id":{"minimum":-1,"type":"integer"}},"required":["id"],"type":"object"}`),
+			},
+			wantOutput: &core.FlowSchema{
+				Schema: json.RawMessage(`{"items":{"nullable":true,"type":"string"},"type":"array"}`),
+			},
+		},
+		{
+			name: "schema file",
+			script: `
+			var flow = { input: "/schemas/order.json" }
+			function stateOne() { return finish(); }`,
+			wantInput: &core.FlowSchema{File: "/schemas/order.json"},
+		},
+		{
+			name: "schema file not json",
+			script: `
+			var flow = { input: "/schemas/order.yaml" }
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "invalid schema",
+			script: `
+			var flow = { output: { type: "unknown" } }
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+		{
+			name: "schema with expressions",
+			script: `
+			var max = 3
+			var flow = { input: { type: "integer", maximum: max } }
+			function stateOne() { return finish(); }`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := compiler.NewASTParser(tt.script, "")
+			require.NoError(t, err)
+
+			err = parser.Parse()
+			require.NoError(t, err)
+
+			if tt.expectError {
+				require.NotEmpty(t, parser.Errors)
+				return
+			}
+
+			require.Empty(t, parser.Errors)
+			require.Equal(t, tt.wantInput, parser.FlowConfig.Input)
+			require.Equal(t, tt.wantOutput, parser.FlowConfig.Output)
+		})
+	}
+}
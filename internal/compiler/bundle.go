@@ -0,0 +1,219 @@
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/grafana/sobek/ast"
+)
+
+// ModuleLoader returns the content of the file at path, e.g. from the namespace filestore.
+type ModuleLoader func(path string) ([]byte, error)
+
+// modulePrelude defines the module registry of a bundled flow on a single line. Modules are
+// executed once on their first require, like CommonJS modules.
+const modulePrelude = `var __direktivModules = (function () { var defs = {}, cache = {}; ` +
+	`function load(p) { if (cache[p]) { return cache[p].exports; } var m = { exports: {} }; cache[p] = m; ` +
+	`defs[p].fn.call(m.exports, m.exports, requireFrom(defs[p].deps), m); return m.exports; } ` +
+	`function requireFrom(deps) { return function (name) { if (!(name in deps)) { throw new Error("cannot find module '" + name + "'"); } return load(deps[name]); }; } ` +
+	`return { define: function (p, deps, fn) { defs[p] = { deps: deps, fn: fn }; }, requireFrom: requireFrom }; })();`
+
+// module is a transpiled file imported by a flow.
+type module struct {
+	path            string
+	script, mapping string
+	// hash is the hash of the content the module was compiled from, see contentHash.
+	hash string
+	// deps maps the imports of the module to the paths of the imported modules.
+	deps map[string]string
+}
+
+// resolveImports loads the modules imported by the flow and its modules. Errors of the
+// modules are reported as validation errors of the flow.
+func (ci *CompileItem) resolveImports(transpiler *Transpiler, pr *ASTParser) ([]*module, map[string]string, error) {
+	var modules []*module
+	loaded := make(map[string]bool)
+
+	var resolve func(from string, parser *ASTParser) (map[string]string, error)
+	resolve = func(from string, parser *ASTParser) (map[string]string, error) {
+		deps := make(map[string]string)
+		for _, call := range parser.imports {
+			spec := call.ArgumentList[0].(*ast.StringLiteral).Value.String()
+			file, data, err := ci.loadImport(from, spec)
+			if err != nil {
+				ci.addModuleError(from, parser.newValidationError(call, err.Error()))
+				continue
+			}
+			deps[spec] = file
+			if loaded[file] {
+				continue
+			}
+			loaded[file] = true
+
+			script, mapping, err := transpiler.Transpile(string(data), file)
+			if err != nil {
+				return nil, fmt.Errorf("transpile module %s: %w", file, err)
+			}
+			mp, err := NewASTParser(script, mapping)
+			if err != nil {
+				return nil, fmt.Errorf("parse module %s: %w", file, err)
+			}
+			err = mp.Parse()
+			if err != nil {
+				return nil, fmt.Errorf("parse module %s: %w", file, err)
+			}
+			for _, vErr := range mp.Errors {
+				ci.addModuleError(file, vErr)
+			}
+			// actions and secrets of modules are part of the flow.
+			pr.Actions = append(pr.Actions, mp.Actions...)
+			pr.allSecretNames = append(pr.allSecretNames, mp.allSecretNames...)
+
+			m := &module{
+				path:    file,
+				script:  script,
+				mapping: mapping,
+				hash:    contentHash(data),
+			}
+			modules = append(modules, m)
+			m.deps, err = resolve(file, mp)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return deps, nil
+	}
+
+	deps, err := resolve(ci.path, pr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return modules, deps, nil
+}
+
+// loadImport loads the module imported as spec by the file at from. Imports are relative,
+// the extension .ts can be omitted.
+func (ci *CompileItem) loadImport(from, spec string) (string, []byte, error) {
+	if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") {
+		return "", nil, fmt.Errorf("cannot import '%s', only relative imports are supported", spec)
+	}
+
+	file := path.Join(path.Dir(from), spec)
+	candidates := []string{file}
+	if ext := path.Ext(file); ext != ".ts" && ext != ".js" {
+		candidates = []string{file + ".ts", file + ".js", path.Join(file, "index.ts")}
+	}
+	for _, candidate := range candidates {
+		data, err := ci.loadModule(candidate)
+		if err == nil {
+			return candidate, data, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("cannot find module '%s'", spec)
+}
+
+// addModuleError adds a validation error of the module at file to the flow.
+func (ci *CompileItem) addModuleError(file string, vErr *ValidationError) {
+	if file != ci.path {
+		vErr.Message = fmt.Sprintf("module %s: %s", file, vErr.Message)
+	}
+	ci.ValidationErrors = append(ci.ValidationErrors, vErr)
+}
+
+// bundle returns the flow script with its modules and the index source map of the bundle,
+// which maps every module to its own source.
+func bundle(name, script, mapping string, modules []*module, deps map[string]string) (string, string, error) {
+	var b strings.Builder
+	lines := 0
+	writeLine := func(line string) {
+		b.WriteString(line)
+		b.WriteString("\n")
+		lines++
+	}
+
+	type offset struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	}
+	type section struct {
+		Offset offset          `json:"offset"`
+		Map    json.RawMessage `json:"map"`
+	}
+	var sections []section
+	writeScript := func(script, mapping string) {
+		sections = append(sections, section{Offset: offset{Line: lines}, Map: json.RawMessage(mapping)})
+		for _, line := range strings.Split(stripSourceMappingURL(script), "\n") {
+			writeLine(line)
+		}
+	}
+
+	writeLine(modulePrelude)
+	for _, m := range modules {
+		mDeps, err := json.Marshal(m.deps)
+		if err != nil {
+			return "", "", err
+		}
+		path, err := json.Marshal(m.path)
+		if err != nil {
+			return "", "", err
+		}
+		mapping, err := moduleSourceMap(m)
+		if err != nil {
+			return "", "", err
+		}
+		writeLine(fmt.Sprintf("__direktivModules.define(%s, %s, function (exports, require, module) {", path, mDeps))
+		writeScript(m.script, mapping)
+		writeLine("});")
+	}
+
+	flowDeps, err := json.Marshal(deps)
+	if err != nil {
+		return "", "", err
+	}
+	writeLine(fmt.Sprintf("var exports = {}, require = __direktivModules.requireFrom(%s);", flowDeps))
+	writeScript(script, mapping)
+	fmt.Fprintf(&b, "//# sourceMappingURL=%s.map", name)
+
+	indexMap, err := json.Marshal(map[string]any{
+		"version":  3,
+		"file":     name,
+		"sections": sections,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return b.String(), string(indexMap), nil
+}
+
+// moduleSourceMap returns the source map of m with the module path as source, so errors
+// in the module point to its file in the namespace.
+func moduleSourceMap(m *module) (string, error) {
+	var sm map[string]any
+	err := json.Unmarshal([]byte(m.mapping), &sm)
+	if err != nil {
+		return "", fmt.Errorf("parse source map of module %s: %w", m.path, err)
+	}
+	sm["sources"] = []string{m.path}
+	delete(sm, "sourceRoot")
+
+	b, err := json.Marshal(sm)
+
+	return string(b), err
+}
+
+// stripSourceMappingURL removes the trailing source map comment of a transpiled script.
+func stripSourceMappingURL(script string) string {
+	script = strings.TrimRight(script, "\n")
+	idx := strings.LastIndex(script, "\n//# sourceMappingURL=")
+	if idx >= 0 {
+		return script[:idx]
+	}
+
+	return script
+}
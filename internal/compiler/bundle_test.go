@@ -0,0 +1,122 @@
+package compiler_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/compiler"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// the modules are written as transpiled CommonJS, imports are require calls.
+var testModules = map[string]string{
+	"/lib/math.ts": `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+exports.double = double;
+exports.offset = offset;
+var base_1 = require("../base");
+function double(n) { return n * 2; }
+function offset() { return base_1.BASE; }`,
+	"/base.ts": `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+exports.BASE = 1;
+exports.token = token;
+exports.fail = fail;
+function token() {
+	var t = getSecret("token");
+	return t;
+}
+function fail() {
+	throw new Error("failed in base");
+}`,
+}
+
+func loadTestModule(path string) ([]byte, error) {
+	src, ok := testModules[path]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", path)
+	}
+
+	return []byte(src), nil
+}
+
+func TestCompileImports(t *testing.T) {
+	flow := `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+var math_1 = require("./lib/math");
+var base_1 = require("./base.ts");
+function stateOne(input) {
+	return transition(stateTwo, (0, math_1.double)(input.n) + (0, math_1.offset)());
+}
+function stateTwo(n) {
+	if (n > 10) {
+		(0, base_1.fail)();
+	}
+	return finish(n);
+}`
+
+	ci := compiler.NewCompileItem([]byte(flow), "/flow.wf.ts").WithModules(loadTestModule)
+	require.NoError(t, ci.TranspileAndValidate())
+	require.Empty(t, ci.ValidationErrors)
+
+	config := ci.Config()
+	require.Len(t, config.Dependencies, 2)
+	require.Contains(t, config.Dependencies, "/lib/math.ts")
+	require.Contains(t, config.Dependencies, "/base.ts")
+	require.Equal(t, []string{"token"}, config.Config.Secrets)
+
+	exec := func(fn, input string) (string, error) {
+		var output string
+		var onFinish runtime.OnFinishHook = func(b []byte) error {
+			output = string(b)
+			return nil
+		}
+		var onTransition runtime.OnTransitionHook = func(b []byte, next string) error {
+			output = string(b)
+			return nil
+		}
+		err := runtime.ExecScript(context.Background(), &runtime.Script{
+			InstID:   uuid.New(),
+			Text:     config.Script,
+			Mappings: config.Mapping,
+			Fn:       fn,
+			Input:    input,
+		}, onFinish, onTransition)
+
+		return output, err
+	}
+
+	output, err := exec("stateOne", `{"n": 2}`)
+	require.NoError(t, err)
+	require.Equal(t, "5", output)
+
+	// errors in modules point to the module file.
+	_, err = exec("stateTwo", `11`)
+	require.ErrorContains(t, err, "failed in base")
+	require.ErrorContains(t, err, "base.ts:11")
+}
+
+func TestCompileImportErrors(t *testing.T) {
+	flow := `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+var missing_1 = require("./missing");
+var lodash_1 = require("lodash");
+function stateOne() {
+	return finish((0, missing_1.value)() + (0, lodash_1.value)());
+}`
+
+	ci := compiler.NewCompileItem([]byte(flow), "/flow.wf.ts").WithModules(loadTestModule)
+	require.NoError(t, ci.TranspileAndValidate())
+	require.Len(t, ci.ValidationErrors, 2)
+	require.ErrorContains(t, ci.ValidationErrors[0], "cannot find module './missing'")
+	require.ErrorContains(t, ci.ValidationErrors[1], "only relative imports are supported")
+
+	// without loader, imports are not resolved.
+	ci = compiler.NewCompileItem([]byte(flow), "/flow.wf.ts")
+	require.NoError(t, ci.TranspileAndValidate())
+	require.Empty(t, ci.ValidationErrors)
+	require.Empty(t, ci.Config().Dependencies)
+}
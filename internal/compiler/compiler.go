@@ -2,9 +2,13 @@ package compiler
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
+	"path"
 	"strings"
 
 	"github.com/direktiv/direktiv/internal/cluster/cache"
@@ -17,6 +21,9 @@ type Compiler struct {
 	db             *gorm.DB
 	cache          cache.Cache[core.TypescriptFlow]
 	secretsManager core.SecretsManager
+
+	// readFile reads the files of a namespace, from the filestore by default.
+	readFile func(ctx context.Context, namespace, path string) ([]byte, error)
 }
 
 type CompileItem struct {
@@ -26,14 +33,21 @@ type CompileItem struct {
 
 	script, mapping string
 	config          core.FlowConfig
+
+	// loadModule loads the modules the flow imports, nil does not resolve imports.
+	loadModule ModuleLoader
+	modules    map[string]string
 }
 
 func NewCompiler(db *gorm.DB, secretsManager core.SecretsManager, cache cache.Cache[core.TypescriptFlow]) (*Compiler, error) {
-	return &Compiler{
+	c := &Compiler{
 		db:             db,
 		cache:          cache,
 		secretsManager: secretsManager,
-	}, nil
+	}
+	c.readFile = c.getFile
+
+	return c, nil
 }
 
 func (c *Compiler) getFile(ctx context.Context, namespace, path string) ([]byte, error) {
@@ -45,11 +59,31 @@ func (c *Compiler) getFile(ctx context.Context, namespace, path string) ([]byte,
 	return filesql.NewStore(c.db).ForFile(f).GetData(ctx)
 }
 
+// contentHash returns the hash of the content of a file, compiled flows keep the hashes of
+// the files they depend on.
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// scriptCacheKey returns the key of the file at path in the flow cache. The filesystem API
+// notifies it when the file changes.
+func scriptCacheKey(namespace, path string) string {
+	return fmt.Sprintf("%s-%s-%s", namespace, "script", path)
+}
+
 func (c *Compiler) FetchScript(ctx context.Context, namespace, path string, withSecrets bool) (core.TypescriptFlow, error) {
-	cacheKey := fmt.Sprintf("%s-%s-%s", namespace, "script", path)
+	cacheKey := scriptCacheKey(namespace, path)
 	flow, err := c.cache.Get(cacheKey, func(a ...any) (core.TypescriptFlow, error) {
 		return c.genFlow(ctx, namespace, path)
 	})
+	if err == nil && c.dependenciesChanged(ctx, namespace, flow) {
+		c.cache.Delete(cacheKey)
+		flow, err = c.cache.Get(cacheKey, func(a ...any) (core.TypescriptFlow, error) {
+			return c.genFlow(ctx, namespace, path)
+		})
+	}
 	if err != nil {
 		slog.Error("cannot fetch sript during compile", slog.Any("error", err))
 		return flow, err
@@ -76,15 +110,14 @@ func (c *Compiler) FetchScript(ctx context.Context, namespace, path string, with
 }
 
 func (c *Compiler) genFlow(ctx context.Context, namespace, path string) (core.TypescriptFlow, error) {
-	b, err := c.getFile(ctx, namespace, path)
+	b, err := c.readFile(ctx, namespace, path)
 	if err != nil {
 		return core.TypescriptFlow{}, err
 	}
 
-	ci := &CompileItem{
-		tsScript: b,
-		path:     path,
-	}
+	ci := NewCompileItem(b, path).WithModules(func(file string) ([]byte, error) {
+		return c.loadDependency(ctx, namespace, file)
+	})
 
 	err = ci.TranspileAndValidate()
 	if err != nil {
@@ -100,7 +133,79 @@ func (c *Compiler) genFlow(ctx context.Context, namespace, path string) (core.Ty
 		return core.TypescriptFlow{}, fmt.Errorf("%s", strings.Join(errList, ", "))
 	}
 
-	return ci.Config(), nil
+	flow := ci.Config()
+	for _, schema := range []*core.FlowSchema{flow.Config.Input, flow.Config.Output} {
+		err = c.loadSchema(ctx, namespace, path, schema, &flow)
+		if err != nil {
+			return core.TypescriptFlow{}, err
+		}
+	}
+
+	return flow, nil
+}
+
+// loadDependency returns the content of a file a flow depends on, a module it imports or a
+// schema file. The files are cached like flows, the filesystem API drops the entry of a
+// changed file.
+func (c *Compiler) loadDependency(ctx context.Context, namespace, path string) ([]byte, error) {
+	mod, err := c.cache.Get(scriptCacheKey(namespace, path), func(a ...any) (core.TypescriptFlow, error) {
+		b, err := c.readFile(ctx, namespace, path)
+		if err != nil {
+			return core.TypescriptFlow{}, err
+		}
+
+		return core.TypescriptFlow{Script: string(b)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(mod.Script), nil
+}
+
+// dependenciesChanged reports if a file flow depends on changed since it was compiled. The
+// current content of the files is compared with the hashes kept by the flow, every flow
+// depending on a changed file is compiled again.
+func (c *Compiler) dependenciesChanged(ctx context.Context, namespace string, flow core.TypescriptFlow) bool {
+	for path, hash := range flow.Dependencies {
+		b, err := c.loadDependency(ctx, namespace, path)
+		if err != nil {
+			// the file was deleted, compiling the flow reports it.
+			return true
+		}
+		if contentHash(b) != hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadSchema loads the schema file of the flow at flowPath into schema and adds it to the
+// dependencies of flow. Relative files are resolved from the directory of the flow.
+func (c *Compiler) loadSchema(ctx context.Context, namespace, flowPath string, schema *core.FlowSchema, flow *core.TypescriptFlow) error {
+	if schema == nil || schema.File == "" {
+		return nil
+	}
+
+	file := schema.File
+	if !path.IsAbs(file) {
+		file = path.Join(path.Dir(flowPath), file)
+	}
+	b, err := c.loadDependency(ctx, namespace, file)
+	if err != nil {
+		return fmt.Errorf("load schema %s: %w", file, err)
+	}
+	if _, err := core.ParseSchema(b); err != nil {
+		return fmt.Errorf("load schema %s: %w", file, err)
+	}
+	schema.Schema = b
+	if flow.Dependencies == nil {
+		flow.Dependencies = make(map[string]string)
+	}
+	flow.Dependencies[file] = contentHash(b)
+
+	return nil
 }
 
 func NewCompileItem(script []byte, path string) *CompileItem {
@@ -111,11 +216,20 @@ func NewCompileItem(script []byte, path string) *CompileItem {
 	}
 }
 
+// WithModules resolves the imports of the flow with load and bundles the imported modules
+// into the script of the flow.
+func (ci *CompileItem) WithModules(load ModuleLoader) *CompileItem {
+	ci.loadModule = load
+
+	return ci
+}
+
 func (ci *CompileItem) Config() core.TypescriptFlow {
 	return core.TypescriptFlow{
-		Script:  ci.script,
-		Mapping: ci.mapping,
-		Config:  ci.config,
+		Script:       ci.script,
+		Mapping:      ci.mapping,
+		Config:       ci.config,
+		Dependencies: maps.Clone(ci.modules),
 	}
 }
 
@@ -130,10 +244,10 @@ func (ci *CompileItem) TranspileAndValidate() error {
 		return err
 	}
 
-	return ci.validate()
+	return ci.validate(transpiler)
 }
 
-func (ci *CompileItem) validate() error {
+func (ci *CompileItem) validate(transpiler *Transpiler) error {
 	pr, err := NewASTParser(ci.script, ci.mapping)
 	if err != nil {
 		return err
@@ -144,6 +258,21 @@ func (ci *CompileItem) validate() error {
 		return err
 	}
 
+	if len(pr.imports) > 0 && ci.loadModule != nil {
+		modules, deps, err := ci.resolveImports(transpiler, pr)
+		if err != nil {
+			return err
+		}
+		ci.script, ci.mapping, err = bundle(path.Base(ci.path), ci.script, ci.mapping, modules, deps)
+		if err != nil {
+			return fmt.Errorf("bundle modules: %w", err)
+		}
+		ci.modules = make(map[string]string, len(modules))
+		for _, m := range modules {
+			ci.modules[m.path] = m.hash
+		}
+	}
+
 	ci.config = pr.FlowConfig
 	ci.config.Actions = pr.Actions
 	ci.config.Secrets = pr.allSecretNames
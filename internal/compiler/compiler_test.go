@@ -0,0 +1,142 @@
+package compiler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/cluster/cache"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/stretchr/testify/require"
+)
+
+// mapCache is an in-memory cache.Cache, notifications drop the entry of their key.
+type mapCache struct {
+	mu      sync.Mutex
+	entries map[string]core.TypescriptFlow
+}
+
+func (m *mapCache) Get(key string, fetch func(...any) (core.TypescriptFlow, error)) (core.TypescriptFlow, error) {
+	m.mu.Lock()
+	v, ok := m.entries[key]
+	m.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	v, err := fetch()
+	if err != nil {
+		return v, err
+	}
+	m.Set(key, v)
+
+	return v, nil
+}
+
+func (m *mapCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+func (m *mapCache) Set(key string, value core.TypescriptFlow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = value
+}
+
+func (m *mapCache) Notify(_ context.Context, notify cache.CacheNotify) {
+	m.Delete(notify.Key)
+}
+
+// newTestCompiler returns a compiler reading files, reads counts the reads of each file.
+func newTestCompiler(files map[string]string) (*Compiler, map[string]int) {
+	reads := make(map[string]int)
+	c := &Compiler{cache: &mapCache{entries: map[string]core.TypescriptFlow{}}}
+	c.readFile = func(ctx context.Context, namespace, path string) ([]byte, error) {
+		reads[path]++
+		src, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("file %s not found", path)
+		}
+
+		return []byte(src), nil
+	}
+
+	return c, reads
+}
+
+func TestFetchScriptSharedModule(t *testing.T) {
+	flow := `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+var shared_1 = require("./shared");
+function stateOne() {
+	return finish((0, shared_1.value)());
+}`
+	module := `"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+exports.value = value;
+function value() { return %d; }`
+
+	files := map[string]string{
+		"/a.wf.ts":   flow,
+		"/b.wf.ts":   flow,
+		"/shared.ts": fmt.Sprintf(module, 1),
+	}
+	c, _ := newTestCompiler(files)
+
+	for _, path := range []string{"/a.wf.ts", "/b.wf.ts"} {
+		f, err := c.FetchScript(t.Context(), "ns", path, false)
+		require.NoError(t, err)
+		require.Contains(t, f.Script, "return 1;")
+	}
+
+	// the filesystem API notifies the key of the changed module only.
+	files["/shared.ts"] = fmt.Sprintf(module, 2)
+	c.cache.Notify(t.Context(), cache.CacheNotify{Key: scriptCacheKey("ns", "/shared.ts"), Action: cache.CacheUpdate})
+
+	// every flow bundling the module is compiled again, not only the first one fetched.
+	for _, path := range []string{"/a.wf.ts", "/b.wf.ts"} {
+		f, err := c.FetchScript(t.Context(), "ns", path, false)
+		require.NoError(t, err)
+		require.Contains(t, f.Script, "return 2;", path)
+		require.Equal(t, contentHash([]byte(files["/shared.ts"])), f.Dependencies["/shared.ts"])
+	}
+}
+
+func TestFetchScriptSchemaFile(t *testing.T) {
+	flow := `var flow = { input: "/schemas/order.json" }
+function stateOne() { return finish(); }`
+
+	files := map[string]string{
+		"/a.wf.ts":            flow,
+		"/b.wf.ts":            flow,
+		"/c.wf.ts":            `function stateOne() { return finish(); }`,
+		"/schemas/order.json": `{"type": "object"}`,
+	}
+	c, reads := newTestCompiler(files)
+
+	for _, path := range []string{"/a.wf.ts", "/b.wf.ts", "/c.wf.ts"} {
+		f, err := c.FetchScript(t.Context(), "ns", path, false)
+		require.NoError(t, err)
+		if path != "/c.wf.ts" {
+			require.JSONEq(t, `{"type": "object"}`, string(f.Config.Input.Schema))
+		}
+	}
+
+	files["/schemas/order.json"] = `{"type": "string"}`
+	c.cache.Notify(t.Context(), cache.CacheNotify{Key: scriptCacheKey("ns", "/schemas/order.json"), Action: cache.CacheUpdate})
+
+	// the flows referencing the schema file compile again, the others stay cached.
+	for _, path := range []string{"/a.wf.ts", "/b.wf.ts", "/c.wf.ts"} {
+		f, err := c.FetchScript(t.Context(), "ns", path, false)
+		require.NoError(t, err)
+		if path != "/c.wf.ts" {
+			require.JSONEq(t, `{"type": "string"}`, string(f.Config.Input.Schema), path)
+		}
+	}
+	require.Equal(t, 2, reads["/a.wf.ts"])
+	require.Equal(t, 2, reads["/b.wf.ts"])
+	require.Equal(t, 1, reads["/c.wf.ts"])
+}
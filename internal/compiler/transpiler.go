@@ -49,7 +49,7 @@ func NewTranspiler() (*Transpiler, error) {
 }
 
 func (t *Transpiler) Transpile(script, name string) (string, string, error) {
-	s := fmt.Sprintf("ts.transpileModule(%s('%s'), { compilerOptions: { sourceMap: true }, fileName: \"%s\", moduleName: \"default\", reportDiagnostics: false })",
+	s := fmt.Sprintf("ts.transpileModule(%s('%s'), { compilerOptions: { sourceMap: true, module: ts.ModuleKind.CommonJS }, fileName: \"%s\", moduleName: \"default\", reportDiagnostics: false })",
 		t.fn, base64.StdEncoding.EncodeToString([]byte(script)), filepath.Base(name))
 
 	value, err := t.vm.RunString(s)
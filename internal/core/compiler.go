@@ -50,6 +50,53 @@ type FlowConfig struct {
 	Actions    []ActionConfig
 	Secrets    []string
 	StateViews map[string]*StateView
+	// States holds the error policies and timeouts of the state functions by name.
+	States map[string]*StatePolicy
+	// Concurrency limits the instances of the flow running at the same time, nil is unlimited.
+	Concurrency *ConcurrencyConfig
+	// Input and Output are the JSON schemas the input and the output of the flow must match,
+	// nil accepts any JSON.
+	Input  *FlowSchema
+	Output *FlowSchema
+}
+
+const (
+	// ConcurrencyOverflowQueue keeps instances over the limit pending until a running one ends.
+	ConcurrencyOverflowQueue = "queue"
+	// ConcurrencyOverflowSkip fails instances over the limit.
+	ConcurrencyOverflowSkip = "skip"
+)
+
+// ConcurrencyConfig limits the instances of a flow running at the same time in the cluster.
+type ConcurrencyConfig struct {
+	Limit int `json:"limit"`
+	// Overflow is what happens to instances over the limit, queue or skip.
+	Overflow string `json:"overflow"`
+}
+
+// StatePolicy configures how the engine handles errors thrown by a state function and
+// how long it may run. Retry rules are checked before catch handlers.
+type StatePolicy struct {
+	Retry []RetryPolicy `json:"retry,omitempty"`
+	Catch []CatchPolicy `json:"catch,omitempty"`
+	// Timeout is the ISO8601 duration a single execution of the state function may take.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// RetryPolicy runs the failed state function again, up to MaxAttempts executions in total.
+type RetryPolicy struct {
+	// Codes are glob patterns of the error codes the rule applies to, empty matches all errors.
+	Codes       []string `json:"codes,omitempty"`
+	MaxAttempts int      `json:"maxAttempts"`
+	// Backoff is the ISO8601 duration to wait before the first retry, it doubles with every attempt.
+	Backoff string `json:"backoff,omitempty"`
+}
+
+// CatchPolicy transitions to the state function State instead of failing the instance.
+type CatchPolicy struct {
+	// Codes are glob patterns of the error codes the handler applies to, empty matches all errors.
+	Codes []string `json:"codes,omitempty"`
+	State string   `json:"state"`
 }
 
 type EventConfig struct {
@@ -61,6 +108,9 @@ type TypescriptFlow struct {
 	Script, Mapping string
 	Config          FlowConfig
 	Secrets         string // json map
+	// Dependencies holds the hashes of the content of the files the script was compiled
+	// with, the bundled modules and the schema files, keyed by their path.
+	Dependencies map[string]string
 }
 
 // SortedStateViews returns the state views as a slice sorted by name.
@@ -60,6 +60,20 @@ type Config struct {
 	LogHistoryHours      int `env:"DIREKTIV_LOG_HISTORY_HOURS"      envDefault:"48"`
 	MirrorHistoryHours   int `env:"DIREKTIV_MIRROR_HISTORY_HOURS"   envDefault:"192"`
 	InstanceHistoryHours int `env:"DIREKTIV_INSTANCE_HISTORY_HOURS" envDefault:"24"`
+
+	// EngineWorkers is the number of instances a replica executes at the same time.
+	EngineWorkers int `env:"DIREKTIV_ENGINE_WORKERS" envDefault:"5"`
+	// EngineNamespaceMaxRunning limits the running instances per namespace in the cluster, 0 is unlimited.
+	EngineNamespaceMaxRunning int `env:"DIREKTIV_ENGINE_NAMESPACE_MAX_RUNNING" envDefault:"0"`
+
+	// EngineLimitCPUTime limits the seconds of CPU time a state function uses running JavaScript, 0 is unlimited.
+	EngineLimitCPUTime int `env:"DIREKTIV_ENGINE_LIMIT_CPU_TIME" envDefault:"60"`
+	// EngineLimitAllocations limits the MB of values a state function creates, 0 is unlimited.
+	EngineLimitAllocations int `env:"DIREKTIV_ENGINE_LIMIT_ALLOCATIONS" envDefault:"0"`
+	// EngineLimitMemorySize limits the size of the transition memory in bytes, 0 is unlimited.
+	EngineLimitMemorySize int `env:"DIREKTIV_ENGINE_LIMIT_MEMORY_SIZE" envDefault:"0"`
+	// EngineLimitOutputSize limits the size of the output of instances in bytes, 0 is unlimited.
+	EngineLimitOutputSize int `env:"DIREKTIV_ENGINE_LIMIT_OUTPUT_SIZE" envDefault:"0"`
 }
 
 func (conf *Config) GetFunctionsTimeout() time.Duration {
@@ -80,6 +94,20 @@ func (conf *Config) Init() error {
 		return err
 	}
 
+	if conf.EngineWorkers < 1 {
+		return fmt.Errorf("DIREKTIV_ENGINE_WORKERS must be at least 1, got %d", conf.EngineWorkers)
+	}
+	for name, v := range map[string]int{
+		"DIREKTIV_ENGINE_LIMIT_CPU_TIME":    conf.EngineLimitCPUTime,
+		"DIREKTIV_ENGINE_LIMIT_ALLOCATIONS": conf.EngineLimitAllocations,
+		"DIREKTIV_ENGINE_LIMIT_MEMORY_SIZE": conf.EngineLimitMemorySize,
+		"DIREKTIV_ENGINE_LIMIT_OUTPUT_SIZE": conf.EngineLimitOutputSize,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", name, v)
+		}
+	}
+
 	return nil
 }
 
@@ -5,7 +5,14 @@ const (
 	EngineMappingNamespace = "namespace"
 	EngineMappingSecrets   = "secrets"
 
+	// EngineMappingTimeout holds the unix time the flow of the instance times out.
 	EngineMappingTimeout = "timeout"
+	// EngineMappingStates holds the json encoded error policies of the state functions.
+	EngineMappingStates = "states"
+	// EngineMappingConcurrency holds the json encoded concurrency limit of the flow.
+	EngineMappingConcurrency = "concurrency"
+	// EngineMappingOutputSchema holds the JSON schema the output of the flow must match.
+	EngineMappingOutputSchema = "outputSchema"
 
 	EngineHeaderActionID  = "Direktiv-ActionID"
 	EngineHeaderState     = "Direktiv-State"
@@ -20,3 +27,33 @@ const (
 	EngineHeaderErrorCode    = "Direktiv-ErrorCode"
 	EngineHeaderErrorMessage = "Direktiv-ErrorMessage"
 )
+
+// Error codes of failed actions, services and instances, scripts read them from err.code.
+const (
+	// ErrorCodeHTTP prefixes the code of a failed call without error code, the status
+	// code of the response is appended, e.g. io.direktiv.error.http.404.
+	ErrorCodeHTTP = "io.direktiv.error.http"
+	// ErrorCodeActionUnavailable is the code of calls to actions or services that did not become ready.
+	ErrorCodeActionUnavailable = "io.direktiv.error.action.unavailable"
+	// ErrorCodeActionTimeout is the code of calls to actions or services that exceeded their timeout.
+	ErrorCodeActionTimeout = "io.direktiv.error.action.timeout"
+	// ErrorCodeTimeout is the code of instances that exceeded the timeout of their flow or state.
+	ErrorCodeTimeout = "io.direktiv.error.timeout"
+	// ErrorCodeConcurrency is the code of instances skipped because their flow reached its concurrency limit.
+	ErrorCodeConcurrency = "io.direktiv.error.concurrency"
+	// ErrorCodeOutputInvalid is the code of instances finishing with output not matching the output schema of their flow.
+	ErrorCodeOutputInvalid = "io.direktiv.error.output.invalid"
+	// ErrorCodeLimitCPU is the code of state functions that exceeded the CPU time limit of the engine.
+	ErrorCodeLimitCPU = "io.direktiv.error.limit.cpu"
+	// ErrorCodeLimitMemory is the code of state functions that exceeded the allocation limit of the engine.
+	ErrorCodeLimitMemory = "io.direktiv.error.limit.memory"
+	// ErrorCodeLimitSize is the code of transition memory or output exceeding the size limit of the engine.
+	ErrorCodeLimitSize = "io.direktiv.error.limit.size"
+	// ErrorCodeFileNotFound is the code of reading a namespace file or directory that does not exist.
+	ErrorCodeFileNotFound = "io.direktiv.error.file.notfound"
+	// ErrorCodeVariableConflict is the code of variable updates that kept conflicting with concurrent updates.
+	ErrorCodeVariableConflict = "io.direktiv.error.variable.conflict"
+	// ErrorCodeLockTimeout is the code of locks and semaphores that did not become free within the wait time,
+	// or were lost because their lease could not be renewed.
+	ErrorCodeLockTimeout = "io.direktiv.error.lock.timeout"
+)
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// FlowSchema is the JSON schema of the input or output of a flow, either inline or in a
+// json file of the namespace. The compiler loads the file into Schema.
+type FlowSchema struct {
+	File   string          `json:"file,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// SchemaError lists the violations of a JSON schema, keyed by the JSON pointer of the
+// invalid value.
+type SchemaError struct {
+	Violations map[string]string
+}
+
+func (e *SchemaError) Error() string {
+	list := make([]string, 0, len(e.Violations))
+	for _, path := range slices.Sorted(maps.Keys(e.Violations)) {
+		list = append(list, fmt.Sprintf("%s: %s", path, e.Violations[path]))
+	}
+
+	return fmt.Sprintf("does not match schema: %s", strings.Join(list, ", "))
+}
+
+// ParseSchema parses and checks a JSON schema.
+func ParseSchema(data []byte) (*openapi3.Schema, error) {
+	schema := &openapi3.Schema{}
+	err := json.Unmarshal(data, schema)
+	if err != nil {
+		return nil, fmt.Errorf("parse schema: %w", err)
+	}
+	err = schema.Validate(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+// ValidateSchema checks the JSON data against schema, violations are returned as
+// *SchemaError.
+func ValidateSchema(schema json.RawMessage, data []byte) error {
+	s, err := ParseSchema(schema)
+	if err != nil {
+		return err
+	}
+
+	var value any
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return fmt.Errorf("parse data: %w", err)
+	}
+
+	err = s.VisitJSON(value, openapi3.MultiErrors())
+	if err == nil {
+		return nil
+	}
+
+	violations := make(map[string]string)
+	collectViolations(err, violations)
+	if len(violations) == 0 {
+		return err
+	}
+
+	return &SchemaError{Violations: violations}
+}
+
+// collectViolations adds the schema errors of err to violations.
+func collectViolations(err error, violations map[string]string) {
+	var multi openapi3.MultiError
+	if errors.As(err, &multi) {
+		for _, e := range multi {
+			collectViolations(e, violations)
+		}
+
+		return
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return
+	}
+	path := "/" + strings.Join(schemaErr.JSONPointer(), "/")
+	if prev, ok := violations[path]; ok {
+		violations[path] = prev + "; " + schemaErr.Reason
+		return
+	}
+	violations[path] = schemaErr.Reason
+}
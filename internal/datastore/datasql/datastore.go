@@ -34,6 +34,10 @@ func (s *store) RuntimeVariables() datastore.RuntimeVariablesStore {
 	return &sqlRuntimeVariablesStore{db: s.db}
 }
 
+func (s *store) Leases() datastore.LeasesStore {
+	return &sqlLeasesStore{db: s.db}
+}
+
 func (s *store) StagingEvents() datastore.StagingEventStore {
 	return &sqlStagingEventStore{db: s.db}
 }
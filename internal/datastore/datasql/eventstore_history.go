@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -90,13 +91,15 @@ func (hs *sqlEventHistoryStore) GetNew(ctx context.Context, namespace string, t
 	return hs.getEventsWithWhereClause(ctx, namespace, t, "where (namespace= ? and received_at > ? )", keyAndValues...)
 }
 
+// GetStartingIDUntilTime returns the events after the serial id lastID, oldest first.
 func (hs *sqlEventHistoryStore) GetStartingIDUntilTime(ctx context.Context, namespace string, lastID int, t time.Time, keyAndValues ...string) ([]*datastore.Event, error) {
+	if len(keyAndValues)%2 != 0 {
+		return nil, fmt.Errorf("keyAndValues have to be a pair of keys and values")
+	}
 	qs := []string{"where (namespace= ? and received_at <= ? and serial_id > ?)"}
 	qv := []any{namespace, t, lastID}
-	qs, qv = unzipAndAppendToQueryParams(qs, qv, keyAndValues)
-	qv = append(qv, pageSize)
 
-	return hs.getEventsQvQs(ctx, qv, qs, keyAndValues...)
+	return hs.getEventsQvQs(ctx, qv, qs, "serial_id ASC", keyAndValues...)
 }
 
 func (hs *sqlEventHistoryStore) GetAll(ctx context.Context) ([]*datastore.Event, error) {
@@ -121,14 +124,17 @@ func (hs *sqlEventHistoryStore) GetAll(ctx context.Context) ([]*datastore.Event,
 	return conv, nil
 }
 
-func (hs *sqlEventHistoryStore) GetByID(ctx context.Context, id string) (*datastore.Event, error) {
-	q := "SELECT id, type, source, cloudevent, namespace_id, namespace, received_at, created_at FROM events_history WHERE id = $1 ;"
+func (hs *sqlEventHistoryStore) GetByID(ctx context.Context, namespace string, id string) (*datastore.Event, error) {
+	q := "SELECT serial_id, id, type, source, cloudevent, namespace_id, namespace, received_at, created_at FROM events_history WHERE namespace = $1 AND id = $2 ;"
 
 	e := gormEventHistoryEntry{}
-	tx := hs.db.WithContext(ctx).Raw(q, id).Scan(&e)
+	tx := hs.db.WithContext(ctx).Raw(q, namespace, id).Scan(&e)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
+	if tx.RowsAffected == 0 {
+		return nil, datastore.ErrNotFound
+	}
 
 	var finalCE event.Event
 	err := json.Unmarshal([]byte(e.Cloudevent), &finalCE)
@@ -136,7 +142,7 @@ func (hs *sqlEventHistoryStore) GetByID(ctx context.Context, id string) (*datast
 		return nil, err
 	}
 
-	return &datastore.Event{NamespaceID: e.NamespaceID, Namespace: e.Namespace, ReceivedAt: e.ReceivedAt, Event: &finalCE}, nil
+	return &datastore.Event{NamespaceID: e.NamespaceID, Namespace: e.Namespace, ReceivedAt: e.ReceivedAt, Event: &finalCE, SerialID: e.SerialID}, nil
 }
 
 func unzipAndAppendToQueryParams(qs []string, qv []any, keyAndValues []string) ([]string, []any) {
@@ -162,6 +168,15 @@ func unzipAndAppendToQueryParams(qs []string, qv []any, keyAndValues []string) (
 			qs = append(qs, " and cloudevent like ?")
 			qv = append(qv, fmt.Sprintf("%%%v%%", v))
 		}
+		if keyAndValues[i] == "before_id" {
+			// the api validates the id, invalid ids match no events.
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				id = 0
+			}
+			qs = append(qs, " and serial_id < ?")
+			qv = append(qv, id)
+		}
 		if keyAndValues[i] == "type_contains" {
 			qs = append(qs, " and type like ?")
 			qv = append(qv, fmt.Sprintf("%%%v%%", v))
@@ -171,7 +186,7 @@ func unzipAndAppendToQueryParams(qs []string, qv []any, keyAndValues []string) (
 	return qs, qv
 }
 
-func (hs *sqlEventHistoryStore) getEventsQvQs(ctx context.Context, qv []any, qs []string, keyAndValues ...string) ([]*datastore.Event, error) {
+func (hs *sqlEventHistoryStore) getEventsQvQs(ctx context.Context, qv []any, qs []string, order string, keyAndValues ...string) ([]*datastore.Event, error) {
 	if len(keyAndValues)%2 != 0 {
 		return nil, fmt.Errorf("keyAndValues have to be a pair of keys and values")
 	}
@@ -180,7 +195,7 @@ func (hs *sqlEventHistoryStore) getEventsQvQs(ctx context.Context, qv []any, qs
 	qv = append(qv, pageSize)
 
 	q := fmt.Sprintf(`SELECT serial_id, id, type, source, cloudevent, namespace_id, namespace, received_at, created_at FROM events_history
-	%v ORDER BY created_at DESC LIMIT ?`, strings.Join(qs, ""))
+	%v ORDER BY %v LIMIT ?`, strings.Join(qs, ""), order)
 
 	res := make([]gormEventHistoryEntry, 0, pageSize)
 	tx := hs.db.WithContext(ctx).Raw(q, qv...).Scan(&res)
@@ -209,5 +224,6 @@ func (hs *sqlEventHistoryStore) getEventsWithWhereClause(ctx context.Context, na
 	qs := []string{whereClause}
 	qv := []any{namespace, t}
 
-	return hs.getEventsQvQs(ctx, qv, qs, keyAndValues...)
+	// events received in one batch share the receive time, the serial id orders them.
+	return hs.getEventsQvQs(ctx, qv, qs, "serial_id DESC", keyAndValues...)
 }
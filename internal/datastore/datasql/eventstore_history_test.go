@@ -0,0 +1,76 @@
+package datasql_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+func Test_EventHistory_SerialPaging(t *testing.T) {
+	ns := uuid.NewString()
+	conn, err := database.NewTestDBWithNamespace(t, ns)
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	ds := datasql.NewStore(conn)
+
+	// a broadcast batch stamps all its events with the same receive time.
+	receivedAt := time.Now().UTC().Add(-time.Minute)
+	batch := make([]*datastore.Event, 0, 250)
+	for i := range 250 {
+		ev := cloudevents.NewEvent()
+		ev.SetID(strconv.Itoa(i))
+		ev.SetType("batch")
+		ev.SetSource("test")
+		batch = append(batch, &datastore.Event{Event: &ev, Namespace: ns, ReceivedAt: receivedAt})
+	}
+	_, errs := ds.EventHistory().Append(context.Background(), batch)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected Append() error: %v", err)
+		}
+	}
+
+	// pages of the history continue after the serial id of the previous page.
+	seen := map[string]bool{}
+	var params []string
+	for {
+		page, err := ds.EventHistory().GetOld(context.Background(), ns, time.Now().UTC(), params...)
+		if err != nil {
+			t.Fatalf("unexpected GetOld() error: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, ev := range page {
+			seen[ev.Event.ID()] = true
+		}
+		params = []string{"before_id", strconv.Itoa(page[len(page)-1].SerialID)}
+	}
+	if len(seen) != 250 {
+		t.Fatalf("expected 250 paged events, got %d", len(seen))
+	}
+
+	// streams return the events after the last serial id, oldest first.
+	first, err := ds.EventHistory().GetStartingIDUntilTime(context.Background(), ns, 0, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("unexpected GetStartingIDUntilTime() error: %v", err)
+	}
+	if len(first) != 200 || first[0].Event.ID() != "0" {
+		t.Fatalf("unexpected first page: %d events", len(first))
+	}
+	rest, err := ds.EventHistory().GetStartingIDUntilTime(context.Background(), ns, first[len(first)-1].SerialID, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("unexpected GetStartingIDUntilTime() error: %v", err)
+	}
+	if len(rest) != 50 || rest[0].Event.ID() != "200" {
+		t.Fatalf("unexpected second page: %d events", len(rest))
+	}
+}
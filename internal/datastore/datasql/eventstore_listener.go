@@ -3,6 +3,7 @@ package datasql
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -58,6 +59,10 @@ func (s *sqlEventListenerStore) Append(ctx context.Context, listener *datastore.
 		string(b),
 		listener.Metadata,
 		string(filters))
+	// checks for duplicate listener id violates unique constraint (SQLSTATE 23505).
+	if tx.Error != nil && strings.Contains(tx.Error.Error(), "23505") {
+		return fmt.Errorf("%w + %w", tx.Error, datastore.ErrDuplication)
+	}
 	if tx.Error != nil {
 		return tx.Error
 	}
@@ -144,10 +149,10 @@ func (s *sqlEventListenerStore) GetOld(ctx context.Context, namespace string, t
 	return conv, nil
 }
 
-func (s *sqlEventListenerStore) Get(ctx context.Context, namespace uuid.UUID, limit int, offset int) ([]*datastore.EventListener, int, error) {
+func (s *sqlEventListenerStore) Get(ctx context.Context, namespace string, limit int, offset int) ([]*datastore.EventListener, int, error) {
 	q := `SELECT 
 	id, namespace_id, namespace, created_at, updated_at, deleted, received_events, trigger_type, events_lifespan, event_types, trigger_info, metadata, event_context_filters
-	FROM event_listeners WHERE namespace_id = $1 `
+	FROM event_listeners WHERE namespace = $1 and deleted = false `
 	q += " ORDER BY created_at DESC "
 	if limit > 0 {
 		q += fmt.Sprintf(" LIMIT %v", limit)
@@ -155,7 +160,7 @@ func (s *sqlEventListenerStore) Get(ctx context.Context, namespace uuid.UUID, li
 	if offset > 0 {
 		q += fmt.Sprintf(" OFFSET %v", offset)
 	}
-	qCount := `SELECT count(id) FROM event_listeners WHERE namespace_id = $1 and deleted = false;`
+	qCount := `SELECT count(id) FROM event_listeners WHERE namespace = $1 and deleted = false;`
 	var count int
 	tx := s.db.WithContext(ctx).Raw(qCount, namespace).Scan(&count)
 	if tx.Error != nil {
@@ -179,7 +184,7 @@ func (s *sqlEventListenerStore) Get(ctx context.Context, namespace uuid.UUID, li
 
 func (s *sqlEventListenerStore) GetAll(ctx context.Context) ([]*datastore.EventListener, error) {
 	q := `SELECT 
-	id, namespace_id, namespace, created_at, updated_at, deleted, received_events, trigger_type, events_lifespan, event_types, trigger_info, metadata
+	id, namespace_id, namespace, created_at, updated_at, deleted, received_events, trigger_type, events_lifespan, event_types, trigger_info, metadata, event_context_filters
 	FROM event_listeners Where deleted = false`
 	q += " ORDER BY created_at DESC;"
 	res := make([]*gormEventListener, 0)
@@ -210,12 +215,16 @@ type gormEventListener struct {
 	ReceivedEvents      []byte
 	Metadata            string
 	EventContextFilters string
+	Version             int64
 }
 
 func (s *sqlEventListenerStore) GetByID(ctx context.Context, id uuid.UUID) (*datastore.EventListener, error) {
-	q := "SELECT id, namespace_id, namespace, created_at, updated_at, received_events, trigger_type, events_lifespan, event_types, trigger_info, metadata, event_context_filters FROM event_listeners WHERE id = $1 ;"
+	q := "SELECT id, namespace_id, namespace, created_at, updated_at, received_events, trigger_type, events_lifespan, event_types, trigger_info, metadata, event_context_filters, version FROM event_listeners WHERE id = $1 ;"
 	var l gormEventListener
 	tx := s.db.WithContext(ctx).Raw(q, id).First(&l)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, datastore.ErrNotFound
+	}
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -227,10 +236,6 @@ func (s *sqlEventListenerStore) GetByID(ctx context.Context, id uuid.UUID) (*dat
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal([]byte(l.EventContextFilters), &filters)
-	if err != nil {
-		return nil, err
-	}
 	err = json.Unmarshal(l.ReceivedEvents, &ev)
 	if err != nil {
 		return nil, err
@@ -257,12 +262,13 @@ func (s *sqlEventListenerStore) GetByID(ctx context.Context, id uuid.UUID) (*dat
 		ReceivedEventsForAndTrigger: ev,
 		Metadata:                    l.Metadata,
 		EventContextFilters:         filters,
+		Version:                     l.Version,
 	}, nil
 }
 
 func (s *sqlEventListenerStore) UpdateOrDelete(ctx context.Context, listeners []*datastore.EventListener) []error {
 	q := `UPDATE event_listeners SET
-	 updated_at = $1 , deleted = $2, received_events = $3 WHERE id = $4;`
+	 updated_at = $1 , deleted = $2, received_events = $3, version = version + 1 WHERE id = $4;`
 
 	errs := make([]error, len(listeners))
 	for i := range listeners {
@@ -297,6 +303,26 @@ func (s *sqlEventListenerStore) UpdateOrDelete(ctx context.Context, listeners []
 	return errs
 }
 
+func (s *sqlEventListenerStore) CompareAndUpdate(ctx context.Context, listener *datastore.EventListener) error {
+	q := `UPDATE event_listeners SET
+	 updated_at = $1, received_events = $2, version = version + 1 WHERE id = $3 AND version = $4;`
+
+	b, err := json.Marshal(listener.ReceivedEventsForAndTrigger)
+	if err != nil {
+		return err
+	}
+	tx := s.db.WithContext(ctx).Exec(q, listener.UpdatedAt, string(b), listener.ID, listener.Version)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return datastore.ErrVersionConflict
+	}
+	listener.Version++
+
+	return nil
+}
+
 // encodeStrings uses a custom, non-standard encoding to maintain compatibility
 // with existing database entries that used spaces as literal data. This was
 // due to a historical specification oversight. Ideally, a structured format
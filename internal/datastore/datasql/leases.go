@@ -0,0 +1,123 @@
+package datasql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/datastore"
+	"gorm.io/gorm"
+)
+
+type sqlLeasesStore struct {
+	db *gorm.DB
+}
+
+func (s *sqlLeasesStore) Acquire(ctx context.Context, lease *datastore.Lease) (*datastore.Lease, error) {
+	if lease.Limit < 1 {
+		return nil, fmt.Errorf("invalid lease limit %d", lease.Limit)
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// serializes the acquisitions of the key across the cluster until the transaction ends.
+		res := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext(?))`, lease.Namespace+"/"+lease.Key)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		res = tx.Exec(`DELETE FROM runtime_leases WHERE namespace = ? AND key = ? AND expires_at <= NOW()`,
+			lease.Namespace, lease.Key)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		var held struct {
+			Total int
+			Own   int
+		}
+		res = tx.Raw(`
+				SELECT count(*) AS total, count(*) FILTER (WHERE holder = ?) AS own
+				FROM runtime_leases WHERE namespace = ? AND key = ?`,
+			lease.Holder, lease.Namespace, lease.Key).Scan(&held)
+		if res.Error != nil {
+			return res.Error
+		}
+		// the holder acquires again, e.g. a redelivered queue message.
+		if held.Own > 0 {
+			return tx.Raw(`
+				SELECT namespace, key, holder, instance_id, lease_limit AS "limit", expires_at, created_at
+				FROM runtime_leases WHERE namespace = ? AND key = ? AND holder = ?`,
+				lease.Namespace, lease.Key, lease.Holder).First(lease).Error
+		}
+		if held.Total >= lease.Limit {
+			return datastore.ErrLeaseUnavailable
+		}
+
+		res = tx.Exec(`
+				INSERT INTO runtime_leases(namespace, key, holder, instance_id, lease_limit, expires_at)
+				VALUES(?, ?, ?, ?, ?, ?)`,
+			lease.Namespace, lease.Key, lease.Holder, lease.InstanceID, lease.Limit, lease.ExpiresAt)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return fmt.Errorf("unexpected gorm insert count, got: %d, want: %d", res.RowsAffected, 1)
+		}
+
+		return tx.Raw(`
+				SELECT namespace, key, holder, instance_id, lease_limit AS "limit", expires_at, created_at
+				FROM runtime_leases WHERE namespace = ? AND key = ? AND holder = ?`,
+			lease.Namespace, lease.Key, lease.Holder).First(lease).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+func (s *sqlLeasesStore) Release(ctx context.Context, namespace string, key string, holder string) error {
+	res := s.db.WithContext(ctx).Exec(
+		`DELETE FROM runtime_leases WHERE namespace = ? AND key = ? AND holder = ? AND expires_at > NOW()`,
+		namespace, key, holder)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlLeasesStore) Renew(ctx context.Context, namespace string, key string, holder string, expiresAt time.Time) error {
+	res := s.db.WithContext(ctx).Exec(
+		`UPDATE runtime_leases SET expires_at = ? WHERE namespace = ? AND key = ? AND holder = ? AND expires_at > NOW()`,
+		expiresAt, namespace, key, holder)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return datastore.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *sqlLeasesStore) List(ctx context.Context, namespace string) ([]*datastore.Lease, error) {
+	var list []*datastore.Lease
+
+	res := s.db.WithContext(ctx).Raw(`
+							SELECT namespace, key, holder, instance_id, lease_limit AS "limit", expires_at, created_at
+							FROM runtime_leases
+							WHERE namespace = ? AND expires_at > NOW()
+							ORDER BY key ASC, created_at ASC`, namespace).
+		Find(&list)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return list, nil
+}
+
+var _ datastore.LeasesStore = &sqlLeasesStore{}
@@ -0,0 +1,88 @@
+package datasql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/datastore/datasql"
+	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/google/uuid"
+)
+
+func Test_Leases(t *testing.T) {
+	ns := uuid.NewString()
+	conn, err := database.NewTestDBWithNamespace(t, ns)
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	ds := datasql.NewStore(conn)
+
+	acquire := func(holder string, limit int, ttl time.Duration) error {
+		_, err := ds.Leases().Acquire(context.Background(), &datastore.Lease{
+			Namespace:  ns,
+			Key:        "order-7",
+			Holder:     holder,
+			InstanceID: uuid.New(),
+			Limit:      limit,
+			ExpiresAt:  time.Now().Add(ttl),
+		})
+
+		return err
+	}
+
+	if err := acquire("a", 2, time.Minute); err != nil {
+		t.Fatalf("unexpected Acquire() error: %v", err)
+	}
+	if err := acquire("b", 2, time.Minute); err != nil {
+		t.Fatalf("unexpected Acquire() error: %v", err)
+	}
+	if err := acquire("c", 2, time.Minute); !errors.Is(err, datastore.ErrLeaseUnavailable) {
+		t.Fatalf("unexpected Acquire() error: %v", err)
+	}
+	// holders acquire their slot again.
+	if err := acquire("b", 2, time.Minute); err != nil {
+		t.Fatalf("unexpected Acquire() error: %v", err)
+	}
+
+	list, err := ds.Leases().List(context.Background(), ns)
+	if err != nil {
+		t.Fatalf("unexpected List() error: %v", err)
+	}
+	if len(list) != 2 || list[0].Holder != "a" || list[0].Limit != 2 {
+		t.Fatalf("unexpected List() result: %v", list)
+	}
+
+	// renewed leases are held longer.
+	renewed := time.Now().Add(time.Hour)
+	if err := ds.Leases().Renew(context.Background(), ns, "order-7", "a", renewed); err != nil {
+		t.Fatalf("unexpected Renew() error: %v", err)
+	}
+	if err := ds.Leases().Renew(context.Background(), ns, "order-7", "c", renewed); !errors.Is(err, datastore.ErrNotFound) {
+		t.Fatalf("unexpected Renew() error: %v", err)
+	}
+	list, err = ds.Leases().List(context.Background(), ns)
+	if err != nil {
+		t.Fatalf("unexpected List() error: %v", err)
+	}
+	if !list[0].ExpiresAt.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("unexpected Renew() expiry: %v", list[0].ExpiresAt)
+	}
+
+	if err := ds.Leases().Release(context.Background(), ns, "order-7", "a"); err != nil {
+		t.Fatalf("unexpected Release() error: %v", err)
+	}
+	if err := ds.Leases().Release(context.Background(), ns, "order-7", "a"); !errors.Is(err, datastore.ErrNotFound) {
+		t.Fatalf("unexpected Release() error: %v", err)
+	}
+
+	// expired leases free their slots.
+	if err := acquire("c", 2, -time.Second); err != nil {
+		t.Fatalf("unexpected Acquire() error: %v", err)
+	}
+	if err := acquire("d", 2, time.Minute); err != nil {
+		t.Fatalf("unexpected Acquire() error: %v", err)
+	}
+}
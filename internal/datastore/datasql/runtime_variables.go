@@ -27,7 +27,7 @@ func (s *sqlRuntimeVariablesStore) GetForNamespace(ctx context.Context, namespac
 	res := s.db.WithContext(ctx).Raw(`
 							SELECT 
 								id, namespace, workflow_path, instance_id, 
-								name, length(data) AS size, mime_type,
+								name, length(data) AS size, mime_type, version,
 								created_at, updated_at
 							FROM runtime_variables WHERE name = ? AND namespace = ? AND workflow_path IS NULL AND instance_id IS NULL`,
 		name, namespace).First(variable)
@@ -51,7 +51,7 @@ func (s *sqlRuntimeVariablesStore) GetForInstance(ctx context.Context, instanceI
 	res := s.db.WithContext(ctx).Raw(`
 							SELECT 
 								id, namespace, workflow_path, instance_id, 
-								name, length(data) AS size, mime_type,
+								name, length(data) AS size, mime_type, version,
 								created_at, updated_at
 							FROM runtime_variables WHERE name = ? AND (instance_id=?)`,
 		name, instanceID.String()).First(variable)
@@ -77,7 +77,7 @@ func (s *sqlRuntimeVariablesStore) GetForWorkflow(ctx context.Context, namespace
 	res := s.db.WithContext(ctx).Raw(`
 							SELECT 
 								id, namespace, workflow_path, instance_id, 
-								name, length(data) AS size, mime_type,
+								name, length(data) AS size, mime_type, version,
 								created_at, updated_at
 							FROM runtime_variables WHERE namespace = ? AND name = ? AND (workflow_path=?)`,
 		namespace, name, workflowPath).First(variable)
@@ -96,7 +96,7 @@ func (s *sqlRuntimeVariablesStore) GetByID(ctx context.Context, id uuid.UUID) (*
 	res := s.db.WithContext(ctx).Raw(`
 							SELECT 
 								id, namespace, workflow_path, instance_id, 
-								name, length(data) AS size, mime_type,
+								name, length(data) AS size, mime_type, version,
 								created_at, updated_at
 							FROM runtime_variables WHERE "id" = ?`,
 		id).First(variable)
@@ -130,7 +130,7 @@ func (s *sqlRuntimeVariablesStore) listByFieldValue(ctx context.Context, fieldNa
 	res := s.db.WithContext(ctx).Raw(fmt.Sprintf(`
 							SELECT 
 								id, namespace, workflow_path, instance_id, 
-								name, length(data) AS size, mime_type, 
+								name, length(data) AS size, mime_type, version, 
 								created_at, updated_at
 							FROM runtime_variables WHERE %s ORDER BY created_at ASC `, aggregateConditions),
 		vals...).Find(&variables)
@@ -209,6 +209,7 @@ func (s *sqlRuntimeVariablesStore) Set(ctx context.Context, variable *datastore.
 		`UPDATE runtime_variables SET
 						mime_type=?,
 						data=?,
+						version=version+1,
 						updated_at=CURRENT_TIMESTAMP
 					WHERE namespace = ? AND name = ? %s;`, extra)
 
@@ -280,7 +281,7 @@ func (s *sqlRuntimeVariablesStore) LoadData(ctx context.Context, id uuid.UUID) (
 	res := s.db.WithContext(ctx).Raw(`
 							SELECT 
 								id, namespace, workflow_path, instance_id, 
-								name, length(data) AS size, mime_type, data,
+								name, length(data) AS size, mime_type, version, data,
 								created_at, updated_at
 							FROM runtime_variables WHERE "id" = ?`,
 		id).First(variable)
@@ -392,6 +393,7 @@ func (s *sqlRuntimeVariablesStore) Patch(ctx context.Context, id uuid.UUID, patc
 		fields += ", data=?"
 		args = append(args, patch.Data)
 	}
+	fields += ", version=version+1"
 	args = append(args, id)
 	fields = strings.Trim(fields, ",")
 
@@ -414,3 +416,55 @@ func (s *sqlRuntimeVariablesStore) Patch(ctx context.Context, id uuid.UUID, patc
 
 	return s.GetByID(ctx, id)
 }
+
+func (s *sqlRuntimeVariablesStore) CompareAndSet(ctx context.Context, variable *datastore.RuntimeVariable, version int64) (*datastore.RuntimeVariable, error) {
+	if version == 0 {
+		created, err := s.Create(ctx, variable)
+		if errors.Is(err, datastore.ErrDuplication) {
+			return nil, datastore.ErrVersionConflict
+		}
+
+		return created, err
+	}
+
+	if variable.Name == "" {
+		return nil, datastore.ErrInvalidRuntimeVariableName
+	}
+
+	var extra string
+	args := []any{
+		variable.MimeType, variable.Data, variable.Namespace, variable.Name, version,
+	}
+
+	if variable.WorkflowPath != "" {
+		variable.WorkflowPath = path.Clean("/" + variable.WorkflowPath)
+	}
+	if variable.InstanceID.String() != (uuid.UUID{}).String() {
+		extra = "AND instance_id = ?"
+		args = append(args, variable.InstanceID.String())
+	} else if variable.WorkflowPath != "" {
+		extra = "AND workflow_path = ?"
+		args = append(args, variable.WorkflowPath)
+	} else {
+		extra = "AND workflow_path IS NULL AND instance_id IS NULL"
+	}
+
+	res := s.db.WithContext(ctx).Exec(fmt.Sprintf(
+		`UPDATE runtime_variables SET
+						mime_type=?,
+						data=?,
+						version=version+1,
+						updated_at=CURRENT_TIMESTAMP
+					WHERE namespace = ? AND name = ? AND version = ? %s;`, extra), args...)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, datastore.ErrVersionConflict
+	}
+	if res.RowsAffected > 1 {
+		return nil, fmt.Errorf("unexpected gorm update count, got: %d, want: %d", res.RowsAffected, 1)
+	}
+
+	return s.get(ctx, variable)
+}
@@ -54,7 +54,7 @@ func (ss *sqlStagingEventStore) Append(ctx context.Context, events ...*datastore
 		}
 	}
 
-	return events, nil
+	return events, errs
 }
 
 type gormStagingEvent struct {
@@ -71,7 +71,7 @@ type gormStagingEvent struct {
 }
 
 func (ss *sqlStagingEventStore) GetDelayedEvents(ctx context.Context, currentTime time.Time, limit int, offset int) ([]*datastore.StagingEvent, int, error) {
-	q := `SELECT id, source, type, cloudevent, namespace_id, namespace_name, created_at, delayed_until FROM staging_events WHERE delayed_until < $1`
+	q := `SELECT id, source, type, cloudevent, namespace_id, namespace_name, received_at, created_at, delayed_until FROM staging_events WHERE delayed_until < $1`
 
 	var count int
 	tx := ss.db.WithContext(ctx).Raw(`SELECT COUNT(id) FROM staging_events WHERE delayed_until < $1`, currentTime).Scan(&count)
@@ -18,6 +18,9 @@ type Store interface {
 
 	RuntimeVariables() RuntimeVariablesStore
 
+	// Leases returns datastore.LeasesStore, is responsible for the locks and semaphores of workflows.
+	Leases() LeasesStore
+
 	EventHistory() EventHistoryStore
 	EventListener() EventListenerStore
 	EventListenerTopics() EventTopicsStore
@@ -30,8 +30,12 @@ type EventHistoryStore interface {
 	// Append adds at least one and optionally multiple events to the storage.
 	// It returns the events that were successfully appended along with any errors encountered.
 	Append(ctx context.Context, event []*Event) ([]*Event, []error)
-	GetByID(ctx context.Context, id string) (*Event, error)
+	GetByID(ctx context.Context, namespace string, id string) (*Event, error)
+	// GetOld returns a page of the events received before t, newest first. The "before_id" key pages
+	// by the serial id of the last event of the previous page.
 	GetOld(ctx context.Context, namespace string, t time.Time, keyAndValues ...string) ([]*Event, error)
+	// GetStartingIDUntilTime returns a page of the events after the serial id lastID received until t,
+	// oldest first.
 	GetStartingIDUntilTime(ctx context.Context, namespace string, lastID int, t time.Time, keyAndValues ...string) ([]*Event, error)
 	GetNew(ctx context.Context, namespace string, t time.Time, keyAndValues ...string) ([]*Event, error)
 	GetAll(ctx context.Context) ([]*Event, error)
@@ -41,7 +45,7 @@ type EventHistoryStore interface {
 
 // Helps query the proper event-listeners for a namespace and event-type.
 type EventTopicsStore interface {
-	// topic SHOULD be a compound of namespaceID and the eventType like this: "uuid-eventType"
+	// topic SHOULD be a compound of namespace and the eventType like this: "namespace-eventType"
 	Append(ctx context.Context, namespaceID uuid.UUID, namespace string, eventListenerID uuid.UUID, topic string, filter string) error
 	GetListeners(ctx context.Context, topic string) ([]*EventListener, error)
 	Delete(ctx context.Context, eventListenerID uuid.UUID) error
@@ -65,6 +69,7 @@ type EventListener struct {
 	TriggerInstance             string               `json:"triggerInstance,omitempty"`     // Optional; The ID of a specific workflow instance to resume (for instance-waiting triggers).
 	EventContextFilters         []EventContextFilter `json:"eventContextFilters,omitempty"` // Optional: The context field and values required to be present in a event to be considered for trigger.
 	Metadata                    string               `json:"metadata"`                      // Field for storing arbitrary metadata associated with the listener.
+	Version                     int64                `json:"-"`                             // Increases with every update of the listener, see CompareAndUpdate.
 }
 
 type EventContextFilter struct {
@@ -107,10 +112,13 @@ type EventListenerStore interface {
 	Append(ctx context.Context, listener *EventListener) error
 	// updates the EventListeners.
 	UpdateOrDelete(ctx context.Context, listener []*EventListener) []error
+	// updates the received events of the listener if it still has the version it was read with.
+	// If the listener was updated meanwhile, it returns datastore.ErrVersionConflict error.
+	CompareAndUpdate(ctx context.Context, listener *EventListener) error
 	GetByID(ctx context.Context, id uuid.UUID) (*EventListener, error)
 	GetAll(ctx context.Context) ([]*EventListener, error)
 	// return EventListeners for a given namespace with the total row count for pagination.
-	Get(ctx context.Context, namespace uuid.UUID, limit, offet int) ([]*EventListener, int, error)
+	Get(ctx context.Context, namespace string, limit, offet int) ([]*EventListener, int, error)
 	// deletes EventListeners that have the deleted flag set.
 	Delete(ctx context.Context) error
 	DeleteByID(ctx context.Context, id uuid.UUID) error
@@ -0,0 +1,45 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Lease is a slot of a namespace semaphore, held by an instance until it is released or
+// expires. Locks are semaphores with a limit of one slot. Expiring leases free the slots
+// of crashed holders.
+type Lease struct {
+	Namespace string
+	Key       string
+	// Holder identifies the acquisition, an instance can hold several slots.
+	Holder     string
+	InstanceID uuid.UUID
+	// Limit is the number of slots of the semaphore the lease was acquired with.
+	Limit int
+
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// LeasesStore manages the leases of the semaphores of the namespaces.
+type LeasesStore interface {
+	// Acquire takes a slot of the semaphore lease.Key, if less than lease.Limit unexpired leases hold it.
+	// If all slots are held, it returns datastore.ErrLeaseUnavailable error. If lease.Holder already
+	// holds an unexpired slot of the key, it returns that lease.
+	Acquire(ctx context.Context, lease *Lease) (*Lease, error)
+
+	// Release frees the slot of the holder. if no unexpired lease is found, it returns datastore.ErrNotFound error.
+	Release(ctx context.Context, namespace string, key string, holder string) error
+
+	// Renew extends the slot of the holder until expiresAt. if no unexpired lease is found, it returns
+	// datastore.ErrNotFound error, others might have taken the slot meanwhile.
+	Renew(ctx context.Context, namespace string, key string, holder string, expiresAt time.Time) error
+
+	// List gets the unexpired leases of the namespace, ordered by key and acquisition.
+	List(ctx context.Context, namespace string) ([]*Lease, error)
+}
+
+var ErrLeaseUnavailable = errors.New("ErrLeaseUnavailable")
@@ -22,6 +22,8 @@ type RuntimeVariable struct {
 	Size     int
 	MimeType string
 	Data     []byte
+	// Version increases with every update of the variable, see CompareAndSet.
+	Version int64
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -69,6 +71,11 @@ type RuntimeVariablesStore interface {
 	Create(ctx context.Context, variable *RuntimeVariable) (*RuntimeVariable, error)
 	Patch(ctx context.Context, id uuid.UUID, patch *RuntimeVariablePatch) (*RuntimeVariable, error)
 
+	// CompareAndSet updates runtime variable data and mimetype fields if the variable has the version, version 0
+	// inserts the variable if it does not exist. Param variable should have one reference field set and name field set.
+	// If the version does not match, it returns datastore.ErrVersionConflict error.
+	CompareAndSet(ctx context.Context, variable *RuntimeVariable, version int64) (*RuntimeVariable, error)
+
 	// DeleteForWorkflow removes all entries that are linked to a workflow.
 	DeleteForWorkflow(ctx context.Context, namespace string, workflowPath string) error
 
@@ -84,4 +91,7 @@ type RuntimeVariablesStore interface {
 
 const RuntimeVariableNameRegexPattern = `^(([a-zA-Z][a-zA-Z0-9_\-\.]*[a-zA-Z0-9])|([a-zA-Z]))$`
 
-var ErrInvalidRuntimeVariableName = errors.New("ErrInvalidRuntimeVariableName")
+var (
+	ErrInvalidRuntimeVariableName = errors.New("ErrInvalidRuntimeVariableName")
+	ErrVersionConflict            = errors.New("ErrVersionConflict")
+)
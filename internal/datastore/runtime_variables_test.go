@@ -2,6 +2,7 @@ package datastore_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -379,6 +380,58 @@ func Test_sqlRuntimeVariablesStore_CreateAndUpdate(t *testing.T) {
 	}
 }
 
+func Test_sqlRuntimeVariablesStore_CompareAndSet(t *testing.T) {
+	ns := uuid.NewString()
+	conn, err := database.NewTestDBWithNamespace(t, ns)
+	if err != nil {
+		t.Fatalf("unepxected NewTestDBWithNamespace() error = %v", err)
+	}
+	ds := datasql.NewStore(conn)
+
+	testVar := &datastore.RuntimeVariable{
+		Namespace: ns,
+		Name:      "counter",
+		MimeType:  "application/json",
+		Data:      []byte("1"),
+	}
+	variable, err := ds.RuntimeVariables().CompareAndSet(context.Background(), testVar, 0)
+	if err != nil {
+		t.Fatalf("unexpected CompareAndSet() error: %v", err)
+	}
+	if variable.Version != 1 {
+		t.Fatalf("unexpected CompareAndSet() version: %d", variable.Version)
+	}
+
+	// creating an existing variable conflicts.
+	_, err = ds.RuntimeVariables().CompareAndSet(context.Background(), testVar, 0)
+	if !errors.Is(err, datastore.ErrVersionConflict) {
+		t.Fatalf("unexpected CompareAndSet() error: %v", err)
+	}
+
+	testVar.Data = []byte("2")
+	variable, err = ds.RuntimeVariables().CompareAndSet(context.Background(), testVar, 1)
+	if err != nil {
+		t.Fatalf("unexpected CompareAndSet() error: %v", err)
+	}
+	if variable.Version != 2 {
+		t.Fatalf("unexpected CompareAndSet() version: %d", variable.Version)
+	}
+
+	// the stale version conflicts and keeps the data.
+	testVar.Data = []byte("3")
+	_, err = ds.RuntimeVariables().CompareAndSet(context.Background(), testVar, 1)
+	if !errors.Is(err, datastore.ErrVersionConflict) {
+		t.Fatalf("unexpected CompareAndSet() error: %v", err)
+	}
+	data, err := ds.RuntimeVariables().LoadData(context.Background(), variable.ID)
+	if err != nil {
+		t.Fatalf("unexpected LoadData() error: %v", err)
+	}
+	if string(data) != "2" {
+		t.Fatalf("unexpected LoadData() result: %s", data)
+	}
+}
+
 func createFile(t *testing.T, fs filestore.FileStore, namespace string) *filestore.File {
 	t.Helper()
 
@@ -0,0 +1,391 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/internal/telemetry"
+	"github.com/google/uuid"
+)
+
+// concurrencyConfig returns the concurrency limit of the flow of ev, nil if it has none.
+func concurrencyConfig(ev *InstanceEvent) *core.ConcurrencyConfig {
+	data, ok := ev.Metadata[core.EngineMappingConcurrency]
+	if !ok {
+		return nil
+	}
+
+	var cfg core.ConcurrencyConfig
+	err := json.Unmarshal([]byte(data), &cfg)
+	if err != nil {
+		slog.Error("could not parse the concurrency limit for flow", slog.Any("error", err))
+		return nil
+	}
+
+	return &cfg
+}
+
+// queuedBefore reports if the instance a was started before b, ties are ordered by id.
+func queuedBefore(a, b *InstanceEvent) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+
+	return a.InstanceID.String() < b.InstanceID.String()
+}
+
+// Limits deferring a pending instance, recorded in the params of its suspension.
+const (
+	limitFlow      = "flow"
+	limitNamespace = "namespace"
+)
+
+// deferredBy returns the limit the pending instance st waits for, empty if it is not deferred.
+func deferredBy(st *InstanceEvent) string {
+	if st.Suspension == nil || st.Suspension.Kind != runtime.SuspensionKindConcurrency {
+		return ""
+	}
+
+	var limit string
+	err := json.Unmarshal(st.Suspension.Params, &limit)
+	if err != nil {
+		slog.Error("could not parse the deferral limit", "instance", st.InstanceID, "error", err)
+		return limitFlow
+	}
+
+	return limit
+}
+
+// slotsTaken counts the instances listed by filters holding a slot ev competes for. Pending
+// instances started before ev hold a slot as well, so all replicas agree on which instances
+// start first. holds reports if an instance competes for the slot at all.
+func (e *Engine) slotsTaken(ctx context.Context, ev *InstanceEvent, holds func(st *InstanceEvent) bool,
+	filters ...func() (string, string, string),
+) int {
+	filters = append(filters,
+		filter.FieldEQ("namespace", ev.Namespace),
+		filter.FieldIN("status", string(StateCodePending)+","+string(StateCodeRunning)),
+	)
+	list, _ := e.dataBus.ListInstanceStatuses(ctx, 0, 0, filter.With(nil, filters...))
+
+	taken := 0
+	for _, st := range list {
+		if st.InstanceID == ev.InstanceID || !holds(st) {
+			continue
+		}
+		if st.State == StateCodeRunning || queuedBefore(st, ev) {
+			taken++
+		}
+	}
+
+	return taken
+}
+
+// hasSlot reports if the pending instance ev may start without exceeding the concurrency
+// limit of its flow.
+func (e *Engine) hasSlot(ctx context.Context, ev *InstanceEvent, limit int) bool {
+	taken := e.slotsTaken(ctx, ev, func(*InstanceEvent) bool { return true },
+		filter.FieldEQ("metadata_"+core.EngineMappingPath, ev.Metadata[core.EngineMappingPath]))
+
+	return taken < limit
+}
+
+// hasNamespaceSlot reports if the pending instance ev may start without exceeding the
+// running instances limit of its namespace. Instances waiting for their flow do not
+// compete for the namespace, synchronous subflows run in the slot of their parent.
+func (e *Engine) hasNamespaceSlot(ctx context.Context, ev *InstanceEvent) bool {
+	if e.namespaceMaxRunning <= 0 || isSyncExec(ev) {
+		return true
+	}
+	taken := e.slotsTaken(ctx, ev, func(st *InstanceEvent) bool {
+		return deferredBy(st) != limitFlow && !isSyncExec(st)
+	})
+
+	return taken < e.namespaceMaxRunning
+}
+
+// blockingLimit returns the limit keeping the pending instance ev from starting, empty if
+// it may start.
+func (e *Engine) blockingLimit(ctx context.Context, ev *InstanceEvent) string {
+	if cfg := concurrencyConfig(ev); cfg != nil && !e.hasSlot(ctx, ev, cfg.Limit) {
+		return limitFlow
+	}
+	if !e.hasNamespaceSlot(ctx, ev) {
+		return limitNamespace
+	}
+
+	return ""
+}
+
+// isSyncExec reports if the instance ev is a subflow its parent executes and waits for.
+func isSyncExec(ev *InstanceEvent) bool {
+	return ev.Metadata[LabelWithSyncExec] == "true"
+}
+
+// admit reports if the pending instance ev may start. Instances over the concurrency limit
+// of their flow or the limit of their namespace are deferred until a slot is free, or fail
+// if their flow skips them. Synchronous subflows are never deferred, their parent waits
+// for them, they fail over the limit of their flow. woken reports if ev continues a
+// deferral, it already waited for the instances started before it.
+func (e *Engine) admit(ctx context.Context, ev *InstanceEvent, woken bool) (bool, error) {
+	// the status cache orders the instances, the slots are taken atomically across the cluster.
+	limit := ""
+	if !woken {
+		limit = e.blockingLimit(ctx, ev)
+	}
+	if limit == "" {
+		var err error
+		limit, err = e.takeSlots(ctx, ev)
+		if err != nil {
+			return false, err
+		}
+	}
+	if limit == "" {
+		return true, nil
+	}
+
+	if limit == limitFlow && (isSyncExec(ev) || concurrencyConfig(ev).Overflow == core.ConcurrencyOverflowSkip) {
+		return false, e.skipInstance(ctx, ev)
+	}
+
+	return false, e.deferInstance(ctx, ev, limit)
+}
+
+// slotTTL bounds how long an instance holds its slots. Slots are released when their
+// instance ends, the TTL only frees slots whose release got lost.
+const slotTTL = 90 * 24 * time.Hour
+
+// slotLimits returns the limits the instance ev takes a slot of and their number of slots.
+func (e *Engine) slotLimits(ev *InstanceEvent) map[string]int {
+	limits := map[string]int{}
+	if cfg := concurrencyConfig(ev); cfg != nil {
+		limits[limitFlow] = cfg.Limit
+	}
+	if e.namespaceMaxRunning > 0 && !isSyncExec(ev) {
+		limits[limitNamespace] = e.namespaceMaxRunning
+	}
+
+	return limits
+}
+
+// slotKey returns the key of the leases holding the slots of limit.
+func slotKey(ev *InstanceEvent, limit string) string {
+	if limit == limitFlow {
+		return runtime.ReservedLockPrefix + "concurrency.flow:" + ev.Metadata[core.EngineMappingPath]
+	}
+
+	return runtime.ReservedLockPrefix + "concurrency.namespace"
+}
+
+// takeSlots acquires the slots of the instance ev, see slotLimits. It returns the limit
+// without a free slot, empty if all slots were acquired. The leases are shared by all
+// replicas, two replicas never start more instances than a limit allows.
+func (e *Engine) takeSlots(ctx context.Context, ev *InstanceEvent) (string, error) {
+	limits := e.slotLimits(ev)
+	var taken []string
+	for _, limit := range []string{limitFlow, limitNamespace} {
+		slots, ok := limits[limit]
+		if !ok {
+			continue
+		}
+		_, err := e.store.Leases().Acquire(ctx, &datastore.Lease{
+			Namespace:  ev.Namespace,
+			Key:        slotKey(ev, limit),
+			Holder:     ev.InstanceID.String(),
+			InstanceID: ev.InstanceID,
+			Limit:      slots,
+			ExpiresAt:  time.Now().Add(slotTTL),
+		})
+		if err == nil {
+			taken = append(taken, limit)
+			continue
+		}
+		e.releaseSlots(ctx, ev, taken...)
+		if errors.Is(err, datastore.ErrLeaseUnavailable) {
+			return limit, nil
+		}
+
+		return "", fmt.Errorf("acquire %s slot: %w", limit, err)
+	}
+
+	return "", nil
+}
+
+// releaseSlots frees the slots of limits the instance ev holds.
+func (e *Engine) releaseSlots(ctx context.Context, ev *InstanceEvent, limits ...string) {
+	for _, limit := range limits {
+		err := e.store.Leases().Release(ctx, ev.Namespace, slotKey(ev, limit), ev.InstanceID.String())
+		if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			slog.Error("release slot", "instance", ev.InstanceID, "limit", limit, "error", err)
+		}
+	}
+}
+
+// onInstanceEnd frees the slots of the ended instance st and admits the instances waiting
+// for them. All replicas receive the end, releasing and waking are idempotent.
+func (e *Engine) onInstanceEnd(ctx context.Context, st *InstanceEvent) {
+	limits := e.slotLimits(st)
+	if len(limits) == 0 {
+		return
+	}
+	for limit := range limits {
+		e.releaseSlots(ctx, st, limit)
+	}
+
+	e.admitDeferred(ctx, st.Namespace, func(w *InstanceEvent) bool {
+		limit := deferredBy(w)
+		_, held := limits[limit]
+
+		return held && slotKey(w, limit) == slotKey(st, limit)
+	})
+}
+
+// deferInstance keeps the pending instance ev waiting for a slot of limit, see onInstanceEnd.
+func (e *Engine) deferInstance(ctx context.Context, ev *InstanceEvent, limit string) error {
+	params, err := json.Marshal(limit)
+	if err != nil {
+		return fmt.Errorf("marshal deferral limit: %w", err)
+	}
+
+	deferEv := ev.Clone()
+	deferEv.EventID = uuid.New()
+	deferEv.State = StateCodePending
+	deferEv.Suspension = &runtime.Suspension{
+		Kind:   runtime.SuspensionKindConcurrency,
+		Params: params,
+	}
+
+	err = e.dataBus.PublishInstanceHistoryEvent(ctx, deferEv)
+	if err != nil {
+		return fmt.Errorf("push history defer event, inst: %s: %w", ev.InstanceID, err)
+	}
+	telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+		fmt.Sprintf("%s reached its limit of running instances, waiting for a running instance to end", limit))
+
+	return nil
+}
+
+// skipInstance ends the pending instance ev, its flow reached the concurrency limit.
+func (e *Engine) skipInstance(ctx context.Context, ev *InstanceEvent) error {
+	endEv := ev.Clone()
+	endEv.EventID = uuid.New()
+	endEv.State = StateCodeFailed
+	endEv.Fn = ""
+	endEv.Suspension = nil
+	endEv.Error = "concurrency limit of flow reached"
+	endEv.ErrorCode = core.ErrorCodeConcurrency
+	endEv.EndedAt = time.Now()
+
+	notifyIfRequested(endEv)
+
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+	if err != nil {
+		return fmt.Errorf("push history skip event, inst: %s: %w", ev.InstanceID, err)
+	}
+
+	return nil
+}
+
+// admitDeferred enqueues the deferred instances matched by waits for the free slots of the
+// limits they wait for, the oldest first. namespace limits the instances to a namespace,
+// all namespaces if empty.
+func (e *Engine) admitDeferred(ctx context.Context, namespace string, waits func(st *InstanceEvent) bool) {
+	filters := []func() (string, string, string){
+		filter.FieldEQ("status", string(StateCodePending)),
+	}
+	if namespace != "" {
+		filters = append(filters, filter.FieldEQ("namespace", namespace))
+	}
+	list, _ := e.dataBus.ListInstanceStatuses(ctx, 0, 0, filter.With(nil, filters...))
+
+	// the waiting instances of each slot of each namespace.
+	waiting := map[string]map[string][]*InstanceEvent{}
+	for _, st := range list {
+		limit := deferredBy(st)
+		if limit == "" || !waits(st) {
+			continue
+		}
+		if waiting[st.Namespace] == nil {
+			waiting[st.Namespace] = map[string][]*InstanceEvent{}
+		}
+		key := slotKey(st, limit)
+		waiting[st.Namespace][key] = append(waiting[st.Namespace][key], st)
+	}
+
+	for ns, slots := range waiting {
+		leases, err := e.store.Leases().List(ctx, ns)
+		if err != nil {
+			slog.Error("list slots", "namespace", ns, "error", err)
+			continue
+		}
+		held := map[string]int{}
+		for _, l := range leases {
+			held[l.Key]++
+		}
+
+		for key, list := range slots {
+			slices.SortFunc(list, func(a, b *InstanceEvent) int {
+				if queuedBefore(a, b) {
+					return -1
+				}
+
+				return 1
+			})
+			// the limit might have been lifted meanwhile.
+			free := len(list)
+			if limit, ok := e.slotLimits(list[0])[deferredBy(list[0])]; ok {
+				free = min(free, limit-held[key])
+			}
+			for _, st := range list[:max(free, 0)] {
+				// all replicas admit the instances, wake dedupes the continuation.
+				err := e.wake(ctx, st, nil)
+				if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+					slog.Error("enqueue admitted instance", "instance", st.InstanceID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// sweepSlots frees the slots of ended instances whose release got lost and admits the
+// deferred instances, e.g. deferred while the last holder of their slot ended.
+func (e *Engine) sweepSlots(ctx context.Context) {
+	namespaces, err := e.store.Namespaces().GetAll(ctx)
+	if err != nil {
+		slog.Error("list namespaces of slots", "error", err)
+		return
+	}
+
+	for _, ns := range namespaces {
+		leases, err := e.store.Leases().List(ctx, ns.Name)
+		if err != nil {
+			slog.Error("list slots", "namespace", ns.Name, "error", err)
+			continue
+		}
+		for _, l := range leases {
+			if !strings.HasPrefix(l.Key, runtime.ReservedLockPrefix+"concurrency.") {
+				continue
+			}
+			st, err := e.GetInstanceStatus(ctx, ns.Name, l.InstanceID)
+			if err != nil || !st.IsEndStatus() {
+				continue
+			}
+			err = e.store.Leases().Release(ctx, ns.Name, l.Key, l.Holder)
+			if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+				slog.Error("release slot", "instance", l.InstanceID, "error", err)
+			}
+		}
+	}
+
+	e.admitDeferred(ctx, "", func(*InstanceEvent) bool { return true })
+}
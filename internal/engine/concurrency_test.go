@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func concurrencyEvent(t *testing.T, state StateCode, createdAt time.Time, cfg *core.ConcurrencyConfig) *InstanceEvent {
+	t.Helper()
+
+	ev := &InstanceEvent{
+		State:      state,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata: map[string]string{
+			LabelWithScope:         "main",
+			core.EngineMappingPath: "/flow.wf.ts",
+		},
+		Script:    `function stateOne() { return finish("done") }`,
+		Fn:        "stateOne",
+		Input:     json.RawMessage(`{}`),
+		EventID:   uuid.New(),
+		CreatedAt: createdAt,
+	}
+	if cfg != nil {
+		b, err := json.Marshal(cfg)
+		require.NoError(t, err)
+		ev.Metadata[core.EngineMappingConcurrency] = string(b)
+	}
+
+	return ev
+}
+
+func TestHasSlot(t *testing.T) {
+	now := time.Now()
+	cfg := &core.ConcurrencyConfig{Limit: 1, Overflow: core.ConcurrencyOverflowQueue}
+	first := concurrencyEvent(t, StateCodePending, now, cfg)
+	second := concurrencyEvent(t, StateCodePending, now.Add(time.Second), cfg)
+	other := concurrencyEvent(t, StateCodeRunning, now, cfg)
+	other.Metadata[core.EngineMappingPath] = "/other.wf.ts"
+
+	bus := &fakeDataBus{statuses: []*InstanceEvent{second, first, other}}
+	e := &Engine{dataBus: bus}
+
+	// the instance started first gets the slot, no matter which replica checks first.
+	require.True(t, e.hasSlot(context.Background(), first, 1))
+	require.False(t, e.hasSlot(context.Background(), second, 1))
+	require.True(t, e.hasSlot(context.Background(), second, 2))
+}
+
+func TestExecInstanceConcurrencyQueue(t *testing.T) {
+	now := time.Now()
+	cfg := &core.ConcurrencyConfig{Limit: 1, Overflow: core.ConcurrencyOverflowQueue}
+	running := concurrencyEvent(t, StateCodeRunning, now, cfg)
+	pending := concurrencyEvent(t, StateCodePending, now.Add(time.Second), cfg)
+
+	bus := &fakeDataBus{statuses: []*InstanceEvent{running, pending}}
+	e := &Engine{dataBus: bus, store: &fakeLeasesStore{leases: &fakeLeases{}}}
+	taken, err := e.takeSlots(context.Background(), running)
+	require.NoError(t, err)
+	require.Empty(t, taken)
+
+	require.NoError(t, e.execInstance(context.Background(), pending))
+	require.Len(t, bus.history, 1)
+
+	deferred := bus.history[0]
+	require.Equal(t, StateCodePending, deferred.State)
+	require.Equal(t, runtime.SuspensionKindConcurrency, deferred.Suspension.Kind)
+
+	// no slot while the other instance runs.
+	bus.statuses = []*InstanceEvent{running, deferred}
+	e.admitDeferred(context.Background(), "", func(*InstanceEvent) bool { return true })
+	require.Empty(t, bus.queued)
+
+	// the end of the running instance frees its slot and admits the deferred instance.
+	ended := running.Clone()
+	ended.State = StateCodeComplete
+	bus.statuses = []*InstanceEvent{ended, deferred}
+	e.onInstanceEnd(context.Background(), ended)
+	require.Len(t, bus.queued, 1)
+	require.Equal(t, wakeEventID(deferred), bus.queued[0].EventID)
+
+	// only the continuation of the deferral starts the instance.
+	require.NoError(t, e.execInstance(context.Background(), pending))
+	require.Len(t, bus.history, 1)
+
+	require.NoError(t, e.execInstance(context.Background(), bus.queued[0]))
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `"done"`, string(end.Output))
+}
+
+func TestExecInstanceConcurrencySkip(t *testing.T) {
+	now := time.Now()
+	cfg := &core.ConcurrencyConfig{Limit: 1, Overflow: core.ConcurrencyOverflowSkip}
+	running := concurrencyEvent(t, StateCodeRunning, now, cfg)
+	pending := concurrencyEvent(t, StateCodePending, now.Add(time.Second), cfg)
+
+	bus := &fakeDataBus{statuses: []*InstanceEvent{running, pending}}
+	e := &Engine{dataBus: bus, store: &fakeLeasesStore{leases: &fakeLeases{}}}
+
+	require.NoError(t, e.execInstance(context.Background(), pending))
+	require.Len(t, bus.history, 1)
+	require.Equal(t, StateCodeFailed, bus.history[0].State)
+	require.Equal(t, core.ErrorCodeConcurrency, bus.history[0].ErrorCode)
+}
+
+func TestExecInstanceNamespaceMaxRunning(t *testing.T) {
+	now := time.Now()
+	running := concurrencyEvent(t, StateCodeRunning, now, nil)
+	running.Metadata[core.EngineMappingPath] = "/other.wf.ts"
+	// waits for its own flow, it does not hold a slot of the namespace.
+	waiting := concurrencyEvent(t, StateCodePending, now, &core.ConcurrencyConfig{Limit: 1})
+	waiting.Suspension = &runtime.Suspension{Kind: runtime.SuspensionKindConcurrency, Params: []byte(`"flow"`)}
+	pending := concurrencyEvent(t, StateCodePending, now.Add(time.Second), nil)
+	pending.Metadata[core.EngineMappingPath] = "/third.wf.ts"
+	other := concurrencyEvent(t, StateCodeRunning, now, nil)
+	other.Namespace = "other"
+
+	bus := &fakeDataBus{statuses: []*InstanceEvent{running, waiting, pending, other}}
+	e := &Engine{dataBus: bus, store: &fakeLeasesStore{leases: &fakeLeases{}}, namespaceMaxRunning: 2}
+
+	require.NoError(t, e.execInstance(context.Background(), pending))
+	require.Equal(t, StateCodeComplete, bus.history[len(bus.history)-1].State)
+
+	e.namespaceMaxRunning = 1
+	e.store = &fakeLeasesStore{leases: &fakeLeases{}}
+	bus.history = nil
+	require.NoError(t, e.execInstance(context.Background(), pending))
+	require.Len(t, bus.history, 1)
+	require.Equal(t, limitNamespace, deferredBy(bus.history[0]))
+}
+
+func TestExecInstanceSyncExecSubflow(t *testing.T) {
+	now := time.Now()
+	parent := concurrencyEvent(t, StateCodeRunning, now, nil)
+	parent.Metadata[core.EngineMappingPath] = "/parent.wf.ts"
+	cfg := &core.ConcurrencyConfig{Limit: 1, Overflow: core.ConcurrencyOverflowQueue}
+	subflow := concurrencyEvent(t, StateCodePending, now.Add(time.Second), cfg)
+	subflow.Metadata[LabelWithSyncExec] = "true"
+
+	// the subflow runs in the namespace slot of its parent.
+	bus := &fakeDataBus{statuses: []*InstanceEvent{parent, subflow}}
+	e := &Engine{dataBus: bus, store: &fakeLeasesStore{leases: &fakeLeases{}}, namespaceMaxRunning: 1}
+	require.NoError(t, e.execInstance(context.Background(), subflow))
+	require.Equal(t, StateCodeComplete, bus.history[len(bus.history)-1].State)
+
+	// running subflows do not take namespace slots either.
+	running := concurrencyEvent(t, StateCodeRunning, now, nil)
+	running.Metadata[LabelWithSyncExec] = "true"
+	pending := concurrencyEvent(t, StateCodePending, now.Add(time.Second), nil)
+	bus.statuses = []*InstanceEvent{running, pending}
+	require.True(t, e.hasNamespaceSlot(context.Background(), pending))
+
+	// over the limit of its flow the subflow fails instead of blocking its parent.
+	bus.statuses = []*InstanceEvent{parent, concurrencyEvent(t, StateCodeRunning, now, cfg), subflow}
+	bus.history = nil
+	require.NoError(t, e.execInstance(context.Background(), subflow))
+	require.Len(t, bus.history, 1)
+	require.Equal(t, StateCodeFailed, bus.history[0].State)
+	require.Equal(t, core.ErrorCodeConcurrency, bus.history[0].ErrorCode)
+}
+
+func TestExecInstanceConcurrencyAcrossReplicas(t *testing.T) {
+	now := time.Now()
+	cfg := &core.ConcurrencyConfig{Limit: 1, Overflow: core.ConcurrencyOverflowQueue}
+	first := concurrencyEvent(t, StateCodePending, now, cfg)
+	second := concurrencyEvent(t, StateCodePending, now.Add(time.Second), cfg)
+
+	// the caches of the replicas did not see the instance of the other one yet.
+	leases := &fakeLeases{}
+	busA := &fakeDataBus{statuses: []*InstanceEvent{first}}
+	busB := &fakeDataBus{statuses: []*InstanceEvent{second}}
+	replicaA := &Engine{dataBus: busA, store: &fakeLeasesStore{leases: leases}}
+	replicaB := &Engine{dataBus: busB, store: &fakeLeasesStore{leases: leases}}
+
+	// the instance holds its slot until its end is received.
+	require.NoError(t, replicaA.execInstance(context.Background(), first))
+	require.Equal(t, StateCodeComplete, busA.history[len(busA.history)-1].State)
+
+	require.NoError(t, replicaB.execInstance(context.Background(), second))
+	require.Len(t, busB.history, 1)
+	require.Equal(t, limitFlow, deferredBy(busB.history[0]))
+
+	// a redelivered instance keeps its slot.
+	taken, err := replicaA.takeSlots(context.Background(), first)
+	require.NoError(t, err)
+	require.Empty(t, taken)
+
+	ended := first.Clone()
+	ended.State = StateCodeComplete
+	busB.statuses = []*InstanceEvent{ended, busB.history[0]}
+	replicaB.onInstanceEnd(context.Background(), ended)
+	require.Len(t, busB.queued, 1)
+
+	// the continuation takes the slot without waiting for the cache of the replica.
+	stale := first.Clone()
+	stale.State = StateCodeRunning
+	busB.statuses = []*InstanceEvent{stale, busB.history[0]}
+	require.NoError(t, replicaB.execInstance(context.Background(), busB.queued[0]))
+	require.Equal(t, StateCodeComplete, busB.history[len(busB.history)-1].State)
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/direktiv/direktiv/internal/api/filter"
 	"github.com/direktiv/direktiv/internal/cluster/pubsub"
@@ -19,7 +21,10 @@ type DataBus struct {
 	js           nats.JetStreamContext
 	statusCache  *StatusCache
 	historyCache *StatusCache
+	leaseCache   *LeaseCache
 	pubSub       pubsub.EventBus
+	// onEnd receives the end statuses, see SubscribeInstanceEnd.
+	onEnd atomic.Pointer[func(st *engine.InstanceEvent)]
 }
 
 func New(js nats.JetStreamContext, pubSub pubsub.EventBus) *DataBus {
@@ -27,6 +32,7 @@ func New(js nats.JetStreamContext, pubSub pubsub.EventBus) *DataBus {
 		js:           js,
 		statusCache:  NewStatusCache(),
 		historyCache: NewStatusCache(),
+		leaseCache:   NewLeaseCache(),
 		pubSub:       pubSub,
 	}
 }
@@ -49,13 +55,15 @@ func (d *DataBus) PublishInstanceHistoryEvent(ctx context.Context, event *engine
 	}
 
 	subject := intNats.StreamEngineHistory.Subject(event.Namespace, event.InstanceID.String())
-	_, err = d.js.Publish(subject, data,
+	ack, err := d.js.Publish(subject, data,
 		nats.Context(ctx),
 		nats.MsgId(fmt.Sprintf("engine::history::%s", event.EventID)))
 	if err != nil {
 		return fmt.Errorf("nats publish: %w", err)
 	}
 
+	// the status is published for duplicates too, a previous publisher might have
+	// failed in between.
 	subject = intNats.StreamEngineStatus.Subject(event.Namespace, event.InstanceID.String())
 	_, err = d.js.Publish(subject, data,
 		nats.Context(ctx),
@@ -63,8 +71,11 @@ func (d *DataBus) PublishInstanceHistoryEvent(ctx context.Context, event *engine
 	if err != nil {
 		return fmt.Errorf("nats publish: %w", err)
 	}
+	if ack.Duplicate {
+		return engine.ErrDuplicateEvent
+	}
 
-	return err
+	return nil
 }
 
 func (d *DataBus) PublishInstanceQueueEvent(ctx context.Context, event *engine.InstanceEvent) error {
@@ -75,11 +86,17 @@ func (d *DataBus) PublishInstanceQueueEvent(ctx context.Context, event *engine.I
 
 	subject := intNats.StreamEngineQueue.Subject(event.Namespace, event.InstanceID.String())
 
-	_, err = d.js.Publish(subject, data,
+	ack, err := d.js.Publish(subject, data,
 		nats.Context(ctx),
 		nats.MsgId(fmt.Sprintf("engine::queue::%s", event.EventID)))
+	if err != nil {
+		return err
+	}
+	if ack.Duplicate {
+		return engine.ErrDuplicateEvent
+	}
 
-	return err
+	return nil
 }
 
 func (d *DataBus) ListInstanceStatuses(ctx context.Context, limit int, offset int, filters filter.Values) ([]*engine.InstanceEvent, int) {
@@ -91,6 +108,7 @@ func (d *DataBus) DeleteNamespace(ctx context.Context, name string) error {
 		intNats.StreamEngineHistory,
 		intNats.StreamEngineStatus,
 		intNats.StreamEngineQueue,
+		intNats.StreamEngineLease,
 	}
 
 	for _, dp := range dpList {
@@ -126,7 +144,7 @@ func (d *DataBus) startCaches(ctx context.Context) error {
 			return
 		}
 		ev.Sequence = metadata.Sequence.Stream
-		d.statusCache.Upsert(&ev)
+		d.upsertStatus(&ev)
 	}, nats.AckNone())
 	if err != nil {
 		return fmt.Errorf("start status cache subscriber: %w", err)
@@ -154,9 +172,79 @@ func (d *DataBus) startCaches(ctx context.Context) error {
 		return fmt.Errorf("start history cache subscriber: %w", err)
 	}
 
+	// 3- start the lease cache subscriber
+	subj = intNats.StreamEngineLease.Subject("*", "*")
+	_, err = d.js.Subscribe(subj, func(msg *nats.Msg) {
+		var hb engine.InstanceHeartbeat
+		if err := json.Unmarshal(msg.Data, &hb); err != nil {
+			// best-effort; ignore bad payloads
+			return
+		}
+		d.beat(&hb)
+	}, nats.AckNone())
+	if err != nil {
+		return fmt.Errorf("start lease cache subscriber: %w", err)
+	}
+
+	return nil
+}
+
+// upsertStatus caches the status ev, the heartbeats of ended instances are dropped.
+func (d *DataBus) upsertStatus(ev *engine.InstanceEvent) {
+	prev, known := d.statusCache.Get(ev.InstanceID)
+	d.statusCache.Upsert(ev)
+	st, ok := d.statusCache.Get(ev.InstanceID)
+	if !ok || !st.IsEndStatus() {
+		return
+	}
+	d.leaseCache.Delete(ev.InstanceID)
+
+	// the statuses replayed at startup have no previous status, they ended before.
+	if known && !prev.IsEndStatus() {
+		if h := d.onEnd.Load(); h != nil {
+			(*h)(st)
+		}
+	}
+}
+
+func (d *DataBus) SubscribeInstanceEnd(h func(st *engine.InstanceEvent)) error {
+	d.onEnd.Store(&h)
+
+	return nil
+}
+
+// beat caches the heartbeat hb, unless the instance already ended. Heartbeats are streamed
+// apart from the statuses and can arrive after the end status.
+func (d *DataBus) beat(hb *engine.InstanceHeartbeat) {
+	if st, ok := d.statusCache.Get(hb.InstanceID); ok && st.IsEndStatus() {
+		return
+	}
+	d.leaseCache.Beat(hb.InstanceID, hb.BeatAt)
+}
+
+func (d *DataBus) PublishInstanceHeartbeat(ctx context.Context, event *engine.InstanceEvent) error {
+	data, err := json.Marshal(&engine.InstanceHeartbeat{
+		InstanceID: event.InstanceID,
+		Namespace:  event.Namespace,
+		BeatAt:     time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal heartbeat: %w", err)
+	}
+
+	subject := intNats.StreamEngineLease.Subject(event.Namespace, event.InstanceID.String())
+	_, err = d.js.Publish(subject, data, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("nats publish: %w", err)
+	}
+
 	return nil
 }
 
+func (d *DataBus) GetInstanceHeartbeat(ctx context.Context, instanceID uuid.UUID) (time.Time, bool) {
+	return d.leaseCache.Get(instanceID)
+}
+
 func (d *DataBus) GetInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID) []*engine.InstanceEvent {
 	list := d.historyCache.Snapshot(filter.With(nil,
 		filter.FieldEQ("namespace", namespace),
@@ -167,7 +255,62 @@ func (d *DataBus) GetInstanceHistory(ctx context.Context, namespace string, inst
 	return list
 }
 
+// SubscribeInstanceHistory streams the history events of an instance recorded after the
+// stream sequence afterSequence, zero streams all of them. The stream ends with ctx.
+func (d *DataBus) SubscribeInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID, afterSequence uint64) (<-chan *engine.InstanceEvent, error) {
+	opts := []nats.SubOpt{nats.OrderedConsumer(), nats.DeliverAll()}
+	if afterSequence > 0 {
+		opts = []nats.SubOpt{nats.OrderedConsumer(), nats.StartSequence(afterSequence + 1)}
+	}
+
+	ch := make(chan *engine.InstanceEvent, 16)
+	subj := intNats.StreamEngineHistory.Subject(namespace, instanceID.String())
+	sub, err := d.js.Subscribe(subj, func(msg *nats.Msg) {
+		var ev engine.InstanceEvent
+		if err := json.Unmarshal(msg.Data, &ev); err != nil {
+			// best-effort; ignore bad payloads
+			return
+		}
+		metadata, err := msg.Metadata()
+		if err != nil {
+			// best-effort; ignore bad payloads
+			return
+		}
+		ev.Sequence = metadata.Sequence.Stream
+
+		select {
+		case ch <- &ev:
+		case <-ctx.Done():
+		}
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe instance history: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = sub.Unsubscribe()
+	}()
+
+	return ch, nil
+}
+
 func (d *DataBus) PublishIgniteAction(ctx context.Context, svcID string) error {
 	err := d.pubSub.Publish(pubsub.SubjServiceIgnite, []byte(svcID))
 	return err
 }
+
+func (d *DataBus) PublishInstanceCancel(ctx context.Context, instanceID uuid.UUID) error {
+	return d.pubSub.Publish(pubsub.SubjInstanceCancel, []byte(instanceID.String()))
+}
+
+func (d *DataBus) SubscribeInstanceCancel(h func(instanceID uuid.UUID)) error {
+	return d.pubSub.Subscribe(pubsub.SubjInstanceCancel, func(data []byte) {
+		id, err := uuid.ParseBytes(data)
+		if err != nil {
+			// best-effort; ignore bad payloads
+			return
+		}
+		h(id)
+	})
+}
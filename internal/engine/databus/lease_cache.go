@@ -0,0 +1,45 @@
+package databus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LeaseCache keeps the last heartbeat time per instance.
+type LeaseCache struct {
+	mu    sync.RWMutex
+	items map[uuid.UUID]time.Time
+}
+
+func NewLeaseCache() *LeaseCache {
+	return &LeaseCache{
+		items: make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (c *LeaseCache) Beat(id uuid.UUID, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// heartbeats can arrive out of order during stream replay, keep the newest.
+	if cur, ok := c.items[id]; ok && cur.After(at) {
+		return
+	}
+	c.items[id] = at
+}
+
+func (c *LeaseCache) Get(id uuid.UUID) (time.Time, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.items[id]
+
+	return t, ok
+}
+
+func (c *LeaseCache) Delete(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, id)
+}
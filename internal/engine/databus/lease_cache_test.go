@@ -0,0 +1,81 @@
+package databus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/google/uuid"
+)
+
+func TestLeaseCache_KeepsNewestBeat(t *testing.T) {
+	c := NewLeaseCache()
+	id := uuid.New()
+	now := time.Now()
+
+	if _, ok := c.Get(id); ok {
+		t.Fatalf("expected no heartbeat for unknown instance")
+	}
+
+	c.Beat(id, now)
+	c.Beat(id, now.Add(-time.Minute))
+
+	got, ok := c.Get(id)
+	if !ok || !got.Equal(now) {
+		t.Fatalf("expected newest heartbeat %v, got %v", now, got)
+	}
+
+	c.Beat(id, now.Add(time.Minute))
+	got, _ = c.Get(id)
+	if !got.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected heartbeat to advance, got %v", got)
+	}
+
+	c.Delete(id)
+	if _, ok := c.Get(id); ok {
+		t.Fatalf("expected heartbeat to be deleted")
+	}
+}
+
+func TestDataBus_EndStatusDropsHeartbeat(t *testing.T) {
+	d := &DataBus{statusCache: NewStatusCache(), leaseCache: NewLeaseCache()}
+	id := uuid.New()
+	now := time.Now()
+
+	d.upsertStatus(&engine.InstanceEvent{InstanceID: id, Namespace: "ns", State: engine.StateCodeRunning, Sequence: 1})
+	d.beat(&engine.InstanceHeartbeat{InstanceID: id, Namespace: "ns", BeatAt: now})
+	if _, ok := d.leaseCache.Get(id); !ok {
+		t.Fatalf("expected heartbeat of running instance")
+	}
+
+	d.upsertStatus(&engine.InstanceEvent{InstanceID: id, Namespace: "ns", State: engine.StateCodeComplete, Sequence: 2})
+	if _, ok := d.leaseCache.Get(id); ok {
+		t.Fatalf("expected heartbeat to be deleted with the end status")
+	}
+
+	// late heartbeats of ended instances are not cached again.
+	d.beat(&engine.InstanceHeartbeat{InstanceID: id, Namespace: "ns", BeatAt: now.Add(time.Second)})
+	if _, ok := d.leaseCache.Get(id); ok {
+		t.Fatalf("expected late heartbeat to be dropped")
+	}
+}
+
+func TestDataBus_SubscribeInstanceEnd(t *testing.T) {
+	d := &DataBus{statusCache: NewStatusCache(), leaseCache: NewLeaseCache()}
+	var ended []uuid.UUID
+	_ = d.SubscribeInstanceEnd(func(st *engine.InstanceEvent) {
+		ended = append(ended, st.InstanceID)
+	})
+
+	// replayed statuses of instances that ended before are not received.
+	d.upsertStatus(&engine.InstanceEvent{InstanceID: uuid.New(), Namespace: "ns", State: engine.StateCodeComplete, Sequence: 1})
+
+	id := uuid.New()
+	d.upsertStatus(&engine.InstanceEvent{InstanceID: id, Namespace: "ns", State: engine.StateCodeRunning, Sequence: 2})
+	d.upsertStatus(&engine.InstanceEvent{InstanceID: id, Namespace: "ns", State: engine.StateCodeFailed, Sequence: 3})
+	d.upsertStatus(&engine.InstanceEvent{InstanceID: id, Namespace: "ns", State: engine.StateCodeFailed, Sequence: 4})
+
+	if len(ended) != 1 || ended[0] != id {
+		t.Fatalf("expected the end of %s once, got %v", id, ended)
+	}
+}
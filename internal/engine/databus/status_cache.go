@@ -57,6 +57,18 @@ func (c *StatusCache) Upsert(s *engine.InstanceEvent) {
 	}
 }
 
+// Get returns a copy of the cached status of the instance.
+func (c *StatusCache) Get(id uuid.UUID) (*engine.InstanceEvent, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	i, ok := c.index[id]
+	if !ok || i < 0 || i >= len(c.items) {
+		return nil, false
+	}
+
+	return c.items[i].Clone(), true
+}
+
 func (c *StatusCache) Snapshot(filters filter.Values) []*engine.InstanceEvent {
 	res, _ := c.SnapshotPage(0, 0, filters)
 	return res
@@ -83,6 +95,9 @@ func (c *StatusCache) SnapshotPage(limit int, offset int, filters filter.Values)
 		if !filters.Match("createdAt", v.CreatedAt.Format(time.RFC3339Nano)) {
 			continue
 		}
+		if !filters.Match("errorCode", v.ErrorCode) {
+			continue
+		}
 		workflowPath := v.Metadata[core.EngineMappingPath]
 		if !filters.Match("metadata_"+core.EngineMappingPath, workflowPath) {
 			continue
@@ -91,6 +106,14 @@ func (c *StatusCache) SnapshotPage(limit int, offset int, filters filter.Values)
 		if !filters.Match("metadata_"+engine.LabelInvokerType, invokerType) {
 			continue
 		}
+		parent := v.Metadata[engine.LabelParentInstance]
+		if !filters.Match("metadata_"+engine.LabelParentInstance, parent) {
+			continue
+		}
+		retryOf := v.Metadata[engine.LabelRetryOf]
+		if !filters.Match("metadata_"+engine.LabelRetryOf, retryOf) {
+			continue
+		}
 
 		total++
 		if offset > 0 {
@@ -102,6 +102,24 @@ func TestSnapshot_FilteringAndOrdering(t *testing.T) {
 	}
 }
 
+func TestSnapshot_FilterErrorCode(t *testing.T) {
+	c := NewStatusCache()
+	now := time.Now()
+
+	failed := mk("ns", uuid.New(), now, 1)
+	failed.State = engine.StateCodeFailed
+	failed.ErrorCode = "io.direktiv.error.http.404"
+	c.Upsert(failed)
+	c.Upsert(mk("ns", uuid.New(), now, 1))
+
+	got := c.Snapshot(filter.With(nil,
+		filter.FieldEQ("errorCode", "io.direktiv.error.http.404"),
+	))
+	if len(got) != 1 || got[0].InstanceID != failed.InstanceID {
+		t.Fatalf("expected exactly the failed item, got: %+v", got)
+	}
+}
+
 func TestSnapshotPage_LimitOffsetAndTotal(t *testing.T) {
 	c := NewStatusCache()
 	base := time.Now()
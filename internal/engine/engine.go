@@ -7,14 +7,18 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/direktiv/direktiv/internal/api/filter"
 	"github.com/direktiv/direktiv/internal/core"
 	"github.com/direktiv/direktiv/internal/datastore"
 	"github.com/direktiv/direktiv/internal/engine/runtime"
 	"github.com/direktiv/direktiv/internal/telemetry"
+	"github.com/direktiv/direktiv/pkg/filestore"
 	"github.com/direktiv/direktiv/pkg/lifecycle"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
@@ -23,6 +27,9 @@ import (
 
 var ErrDataNotFound = fmt.Errorf("data not found")
 
+// EmitEventFunc delivers the event ev emitted by an instance in namespace, delayed by delay.
+type EmitEventFunc func(ctx context.Context, namespace string, ev *cloudevents.Event, delay time.Duration) error
+
 // LabelWithNotify used to mark an instance as called with a notify-chanel.
 const (
 	LabelWithNotify   = "WithNotify"
@@ -35,24 +42,68 @@ const (
 	// For main execution, use string "main"
 	// For subflow execution, use string uuid that uniquely identifies the subflow.
 	LabelWithScope = "WithScope"
+
+	// LabelParentInstance and LabelParentScope mark a subflow instance with the instance
+	// that started it.
+	LabelParentInstance = "ParentInstance"
+	LabelParentScope    = "ParentScope"
+	// LabelLineage holds the ancestors of a subflow instance, see InstanceEvent.Lineage.
+	LabelLineage = "Lineage"
+	// LabelRetryOf marks an instance as the retry of the ended instance with this id.
+	LabelRetryOf = "RetryOf"
+	// LabelWithParkable marks a subflow its parent waits for in a synchronous call and
+	// can park with, see runtime.CanSuspend.
+	LabelWithParkable = "WithParkable"
 )
 
 type Engine struct {
-	dataBus  DataBus
-	compiler core.Compiler
-	js       nats.JetStreamContext
-	store    datastore.Store
+	dataBus   DataBus
+	compiler  core.Compiler
+	js        nats.JetStreamContext
+	store     datastore.Store
+	fileStore filestore.FileStore
+
+	// workers is the number of instances executed at the same time.
+	workers int
+	// namespaceMaxRunning limits the running instances per namespace, 0 is unlimited.
+	namespaceMaxRunning int
+	// limits guards the resources of the scripts.
+	limits runtime.Limits
+	// emitEvent delivers the events emitted by instances, see SetEventEmitter.
+	emitEvent EmitEventFunc
 }
 
-func NewEngine(bus DataBus, compiler core.Compiler, js nats.JetStreamContext, store datastore.Store) (*Engine, error) {
+func NewEngine(bus DataBus, compiler core.Compiler, js nats.JetStreamContext, store datastore.Store,
+	fileStore filestore.FileStore, config *core.Config,
+) (*Engine, error) {
+	if config.EngineWorkers < 1 {
+		return nil, fmt.Errorf("engine workers must be at least 1, got %d", config.EngineWorkers)
+	}
+
 	return &Engine{
-		dataBus:  bus,
-		compiler: compiler,
-		js:       js,
-		store:    store,
+		dataBus:   bus,
+		compiler:  compiler,
+		js:        js,
+		store:     store,
+		fileStore: fileStore,
+
+		workers:             config.EngineWorkers,
+		namespaceMaxRunning: config.EngineNamespaceMaxRunning,
+		limits: runtime.Limits{
+			CPUTime:     time.Duration(config.EngineLimitCPUTime) * time.Second,
+			Allocations: uint64(config.EngineLimitAllocations) << 20,
+			MemorySize:  config.EngineLimitMemorySize,
+			OutputSize:  config.EngineLimitOutputSize,
+		},
 	}, nil
 }
 
+// SetEventEmitter sets the delivery of the events emitted by instances. The event processor
+// starts instances of the engine, so it is set after both are created and before Start.
+func (e *Engine) SetEventEmitter(emit EmitEventFunc) {
+	e.emitEvent = emit
+}
+
 func (e *Engine) Start(lc *lifecycle.Manager) error {
 	err := e.dataBus.Start(lc)
 	if err != nil {
@@ -64,14 +115,52 @@ func (e *Engine) Start(lc *lifecycle.Manager) error {
 		return fmt.Errorf("start queue workers: %w", err)
 	}
 
+	err = e.dataBus.SubscribeInstanceCancel(cancelLocal)
+	if err != nil {
+		return fmt.Errorf("subscribe instance cancel: %w", err)
+	}
+
+	err = e.dataBus.SubscribeInstanceEnd(func(st *InstanceEvent) {
+		go e.onInstanceEnd(lc.Context(), st)
+		if st.Metadata[LabelWithParkable] == "true" {
+			go func() {
+				err := e.wakeParent(lc.Context(), st)
+				if err != nil {
+					slog.Error("wake parent of subflow", "instance", st.InstanceID, "error", err)
+				}
+			}()
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe instance end: %w", err)
+	}
+
+	e.startRecovery(lc)
+	e.startTimers(lc)
+
 	return nil
 }
 
 func (e *Engine) StartWorkflow(ctx context.Context, instID uuid.UUID, namespace string, workflowPath string, input string, metadata map[string]string) (*InstanceEvent, <-chan *InstanceEvent, error) {
+	return e.startFlow(ctx, instID, namespace, workflowPath, "", input, metadata)
+}
+
+// startFlow starts an instance of the flow at the state function fn, empty starts at the
+// start state of the flow.
+func (e *Engine) startFlow(ctx context.Context, instID uuid.UUID, namespace string, workflowPath string, fn string, input string, metadata map[string]string) (*InstanceEvent, <-chan *InstanceEvent, error) {
 	flowDetails, err := e.compiler.FetchScript(ctx, namespace, workflowPath, true)
 	if err != nil {
 		return nil, nil, fmt.Errorf("fetch script: %w", err)
 	}
+	if fn == "" {
+		fn = flowDetails.Config.State
+
+		// the input schema applies to new instances, not the ones continuing at a state.
+		err = validateInput(flowDetails, input)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	to, err := duration.Parse(flowDetails.Config.Timeout)
 	if err != nil {
@@ -82,13 +171,31 @@ func (e *Engine) StartWorkflow(ctx context.Context, instID uuid.UUID, namespace
 		metadata[core.EngineMappingTimeout] = fmt.Sprintf("%v", time.Now().UTC().Add(to.ToTimeDuration()).Unix())
 	}
 
+	if len(flowDetails.Config.States) > 0 {
+		states, err := json.Marshal(flowDetails.Config.States)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal state policies: %w", err)
+		}
+		metadata[core.EngineMappingStates] = string(states)
+	}
+	if flowDetails.Config.Concurrency != nil {
+		concurrency, err := json.Marshal(flowDetails.Config.Concurrency)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal concurrency limit: %w", err)
+		}
+		metadata[core.EngineMappingConcurrency] = string(concurrency)
+	}
+	if flowDetails.Config.Output != nil {
+		metadata[core.EngineMappingOutputSchema] = string(flowDetails.Config.Output.Schema)
+	}
+
 	// fetch all the secrets here
 	metadata[core.EngineMappingSecrets] = flowDetails.Secrets
 	metadata[core.EngineMappingNamespace] = namespace
 	metadata[core.EngineMappingPath] = workflowPath
 
 	notify := make(chan *InstanceEvent, 1)
-	st, err := e.startScript(ctx, instID, namespace, flowDetails.Script, flowDetails.Mapping, flowDetails.Config.State, input, notify, metadata)
+	st, err := e.startScript(ctx, instID, namespace, flowDetails.Script, flowDetails.Mapping, fn, input, notify, metadata)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -138,7 +245,7 @@ func registerInstanceCancel(ctx context.Context, fullID string) (context.Context
 
 func (e *Engine) startScript(ctx context.Context, instID uuid.UUID, namespace string, script string, mappings string, fn string, input string, notify chan<- *InstanceEvent, metadata map[string]string) (*InstanceEvent, error) {
 	if !json.Valid([]byte(input)) {
-		return nil, fmt.Errorf("input is not a valid json string: %s", input)
+		return nil, fmt.Errorf("%w: input is not a valid json string: %s", ErrInvalidInput, input)
 	}
 
 	if metadata == nil {
@@ -195,10 +302,37 @@ func (e *Engine) startScript(ctx context.Context, instID uuid.UUID, namespace st
 }
 
 func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
-	// If this instance was cancelled before it started running, skip execution.
+	// woken reports if inst continues the deferral of a pending instance.
+	woken := false
 	// We rely on status-cache being populated by PublishInstanceHistoryEvent.
-	if st, err := e.GetInstanceStatus(ctx, inst.Namespace, inst.InstanceID); err == nil && st.State == StateCodeCancelled {
-		return nil
+	if st, err := e.GetInstanceStatus(ctx, inst.Namespace, inst.InstanceID); err == nil {
+		// If this instance was cancelled before it started running or already
+		// ended, skip execution.
+		if st.IsEndStatus() {
+			return nil
+		}
+		// A redelivered queue message of an instance that already started. The
+		// instance continues from its last transition, see recoverOrphans.
+		if inst.State == StateCodePending && st.State != StateCodePending {
+			return nil
+		}
+		// Only the continuation of its current wait resumes a parked or deferred instance.
+		if st.Suspension != nil && inst.EventID != wakeEventID(st) {
+			return nil
+		}
+		// Another replica is still executing this instance.
+		if !st.IsParked() && inst.State != StateCodePending && e.hasLiveLease(ctx, inst.InstanceID) {
+			return nil
+		}
+		woken = st.State == StateCodePending && st.Suspension != nil
+	}
+
+	// the concurrency limit of the flow applies before the instance starts.
+	if inst.State == StateCodePending {
+		ok, err := e.admit(ctx, inst, woken)
+		if err != nil || !ok {
+			return err
+		}
 	}
 
 	// Create a cancellable context per instance so API cancellation can stop
@@ -206,14 +340,35 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 	instCtx, cleanupCancel := registerInstanceCancel(ctx, inst.FullID())
 	defer cleanupCancel()
 
+	stopHeartbeat := e.startHeartbeat(ctx, inst)
+	defer stopHeartbeat()
+
+	// a failed state might be retried or caught by the next state.
+	for inst != nil {
+		var err error
+		inst, err = e.runState(ctx, instCtx, inst)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runState executes the instance from the state function of inst. It returns the event
+// continuing the instance if the error policy of a failed state applies.
+func (e *Engine) runState(ctx context.Context, instCtx context.Context, inst *InstanceEvent) (*InstanceEvent, error) {
 	startEv := inst.Clone()
 	startEv.EventID = uuid.New()
 	startEv.State = StateCodeRunning
-	startEv.StartedAt = time.Now()
+	if startEv.StartedAt.IsZero() {
+		startEv.StartedAt = time.Now()
+	}
 
-	err := e.dataBus.PublishInstanceHistoryEvent(ctx, startEv)
+	// a retry or catch handler carries the records of the failed execution.
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, startEv.withRecords(inst.Records))
 	if err != nil {
-		return fmt.Errorf("push history start event, inst: %s: %w", inst.InstanceID, err)
+		return nil, fmt.Errorf("push history start event, inst: %s: %w", inst.InstanceID, err)
 	}
 
 	sc := &runtime.Script{
@@ -221,8 +376,44 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 		Text:     startEv.Script,
 		Mappings: startEv.Mappings,
 		Fn:       startEv.Fn,
-		Input:    string(startEv.Input),
+		Input:    string(startEv.StateInput()),
 		Metadata: startEv.Metadata,
+		Journal:  startEv.Journal,
+		Resume:   startEv.Resume,
+		Limits:   e.limits,
+
+		Transitions: startEv.Transitions,
+		Attempt:     startEv.Attempt,
+
+		Suspendable: startEv.Metadata[LabelWithScope] == "main",
+	}
+
+	// the watchdog interrupts the script once the flow or the current state times out.
+	stateCtx, wd := newWatchdog(instCtx, startEv)
+	defer wd.stop()
+
+	// the last recorded state, a suspended instance waits in it.
+	current := startEv
+	// the state asynchronous subflows are started in, they read it concurrently to the script.
+	var currentFn atomic.Value
+	currentFn.Store(startEv.Fn)
+	var subflowStep atomic.Int64
+
+	// the records of the execution, published with the next event.
+	var recordsMu sync.Mutex
+	var records []*runtime.Record
+	var onRecord runtime.OnRecordHook = func(rec *runtime.Record) {
+		recordsMu.Lock()
+		defer recordsMu.Unlock()
+		records = append(records, rec)
+	}
+	takeRecords := func() []*runtime.Record {
+		recordsMu.Lock()
+		defer recordsMu.Unlock()
+		taken := records
+		records = nil
+
+		return taken
 	}
 
 	var onAction runtime.OnActionHook = func(svcID string) error {
@@ -231,6 +422,11 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 		return e.dataBus.PublishIgniteAction(ctx, svcID)
 	}
 	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		err := ValidateOutput(startEv.Metadata, output)
+		if err != nil {
+			return err
+		}
+
 		endEv := startEv.Clone()
 		endEv.EventID = uuid.New()
 		endEv.State = StateCodeComplete
@@ -240,7 +436,7 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 
 		notifyIfRequested(endEv)
 
-		return e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+		return e.dataBus.PublishInstanceHistoryEvent(ctx, endEv.withRecords(takeRecords()))
 	}
 	var onTransition runtime.OnTransitionHook = func(memory []byte, fn string) error {
 		endEv := startEv.Clone()
@@ -248,37 +444,69 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 		endEv.State = StateCodeRunning
 		endEv.Output = memory
 		endEv.Fn = fn
+		endEv.Journal = nil
+		endEv.Resume = nil
+		endEv.Attempt = 0
+		endEv.Transitions = current.Transitions + 1
 
-		to, ok := inst.Metadata[core.EngineMappingTimeout]
-		if ok {
-			unixSec, err := strconv.ParseInt(to, 10, 64)
-			if err != nil {
-				// we just log
-				slog.Error("could not parse the timeout time for flow", slog.Any("error", err))
-			} else {
-				// the deadline time
-				t := time.Unix(unixSec, 0)
-
-				if time.Now().UTC().After(t) {
-					return fmt.Errorf("timeout for flow exceeded")
-				}
-			}
+		err := e.dataBus.PublishInstanceHistoryEvent(ctx, endEv.withRecords(takeRecords()))
+		if err != nil {
+			return err
 		}
+		current = endEv
+		currentFn.Store(fn)
+		wd.enterState(endEv)
 
-		return e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+		return nil
 	}
 
 	var onSubflow runtime.OnSubflowHook = func(ctx context.Context, path string, input []byte) ([]byte, error) {
-		_, notify, err := e.StartWorkflow(ctx, inst.InstanceID, inst.Namespace, path, string(input), map[string]string{
-			LabelWithNotify:   strconv.FormatBool(true),
-			LabelWithSyncExec: strconv.FormatBool(true),
-			LabelInvokerType:  inst.Metadata[LabelInvokerType],
-			LabelWithScope:    uuid.New().String(),
+		lineage, err := json.Marshal(append(startEv.Lineage(), &LineageEntry{
+			InstanceID: startEv.InstanceID,
+			Scope:      startEv.Metadata[LabelWithScope],
+			State:      currentFn.Load().(string),
+			Step:       int(subflowStep.Add(1)) - 1,
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("marshal lineage: %w", err)
+		}
+
+		// the subflow parks with the instance, if the instance waits for it and can park.
+		parkable := runtime.CanSuspend(ctx) && startEv.canPark()
+
+		childID := uuid.New()
+		_, notify, err := e.StartWorkflow(ctx, childID, inst.Namespace, path, string(input), map[string]string{
+			LabelWithNotify:     strconv.FormatBool(true),
+			LabelWithSyncExec:   strconv.FormatBool(true),
+			LabelWithParkable:   strconv.FormatBool(parkable),
+			LabelInvokerType:    inst.Metadata[LabelInvokerType],
+			LabelWithScope:      uuid.New().String(),
+			LabelParentInstance: startEv.InstanceID.String(),
+			LabelParentScope:    startEv.Metadata[LabelWithScope],
+			LabelLineage:        string(lineage),
 		})
 		if err != nil {
 			return nil, err
 		}
-		st := <-notify
+		// a synchronous subflow that ended notified before StartWorkflow returned.
+		if parkable && len(notify) == 0 {
+			// the subflow parked, the instance waits parked until it ended.
+			return nil, subflowSuspension(childID)
+		}
+		var st *InstanceEvent
+		select {
+		case st = <-notify:
+		case <-ctx.Done():
+			// nothing waits for the subflow anymore, e.g. the parent timed out.
+			e.cancelSubflow(context.WithoutCancel(ctx), inst.Namespace, childID)
+			return nil, context.Cause(ctx)
+		}
+		if st.State != StateCodeComplete && st.ErrorCode != "" {
+			return nil, &runtime.Error{
+				Code:    st.ErrorCode,
+				Message: fmt.Sprintf("subflow did not complete: %s", st.Error),
+			}
+		}
 		if st.State != StateCodeComplete {
 			return nil, fmt.Errorf("subflow did not complete: %s", st.Error)
 		}
@@ -287,29 +515,79 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 	}
 	onSetVariable := e.makeOnSetVariableHook(inst)
 	onGetVariable := e.makeOnGetVariableHook(inst)
+	onEmitEvent := e.makeOnEmitEventHook(inst)
+	onGetFile := e.makeOnGetFileHook(inst)
+	onListFiles := e.makeOnListFilesHook(inst)
+	onReadVariable := e.makeOnReadVariableHook(inst)
+	onListVariables := e.makeOnListVariablesHook(inst)
+	onWriteVariable := e.makeOnWriteVariableHook(inst)
+	onDeleteVariable := e.makeOnDeleteVariableHook(inst)
+	onAcquireLock := e.makeOnAcquireLockHook(inst)
+	onReleaseLock := e.makeOnReleaseLockHook(inst)
+	onRenewLock := e.makeOnRenewLockHook(inst)
+	// only subflows wait for signals in the hook, main instances are parked.
+	var onWaitSignal runtime.OnWaitSignalHook = func(ctx context.Context, s *runtime.Suspension) (json.RawMessage, error) {
+		if current.canPark() {
+			return nil, s
+		}
 
-	err = runtime.ExecScript(instCtx, sc, onFinish, onTransition, onAction, onSubflow, onSetVariable, onGetVariable)
+		return e.waitSignal(ctx, current, s)
+	}
+
+	err = runtime.ExecScript(stateCtx, sc, onFinish, onTransition, onAction, onSubflow, onSetVariable, onGetVariable,
+		onReadVariable, onListVariables, onWriteVariable, onDeleteVariable,
+		onEmitEvent, onWaitSignal, onGetFile, onListFiles, onAcquireLock, onReleaseLock, onRenewLock, onRecord)
+	var susp *runtime.Suspension
+	if errors.As(err, &susp) {
+		// the state function is executed again once the wait is resolved, the calls it made
+		// until the wait return their recorded results then.
+		parked := current.Clone()
+		parked.Records = takeRecords()
+		parked.Resume = append(parked.Resume, parked.Records...)
+		err = e.park(ctx, parked, susp)
+	}
 	if err == nil {
-		return nil
+		return nil, nil
 	}
 
 	// If the instance context was cancelled, treat as cancelled not failed.
 	if errors.Is(instCtx.Err(), context.Canceled) {
-		cancelEv := startEv.Clone()
-		cancelEv.EventID = uuid.New()
-		cancelEv.State = StateCodeCancelled
-		cancelEv.Fn = ""
-		cancelEv.Error = ""
-		cancelEv.EndedAt = time.Now()
+		return nil, e.publishCancel(ctx, startEv)
+	}
+	// the watchdog interrupted the script, the cause is the timeout error.
+	if stateCtx.Err() != nil {
+		err = context.Cause(stateCtx)
+	}
 
-		notifyIfRequested(cancelEv)
+	var next *InstanceEvent
+	var delay time.Duration
+	if !errors.Is(err, errFlowTimeout) {
+		next, delay = RecoverState(current, err)
+	}
+	if next != nil {
+		next.Records = takeRecords()
+		telemetry.LogInstance(ctx, telemetry.LogLevelWarn,
+			fmt.Sprintf("state '%s' failed, continuing with '%s': %s", current.Fn, next.Fn, err.Error()))
 
-		pubErr := e.dataBus.PublishInstanceHistoryEvent(ctx, cancelEv)
-		if pubErr != nil {
-			return fmt.Errorf("push history cancel event, inst: %s: %w", inst.InstanceID, pubErr)
+		if delay == 0 {
+			return next, nil
+		}
+		if next.Metadata[LabelWithScope] == "main" {
+			return nil, e.park(ctx, next, &runtime.Suspension{
+				Kind:     runtime.SuspensionKindRetry,
+				Deadline: time.Now().UTC().Add(delay),
+			})
 		}
 
-		return nil
+		// subflows are executed synchronously by their parent and wait in place.
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return next, nil
+		case <-instCtx.Done():
+			return nil, e.publishCancel(ctx, startEv)
+		}
 	}
 
 	telemetry.LogInstance(ctx, telemetry.LogLevelError, fmt.Sprintf("flow execution failed: %s", err.Error()))
@@ -319,17 +597,44 @@ func (e *Engine) execInstance(ctx context.Context, inst *InstanceEvent) error {
 	endEv.State = StateCodeFailed
 	endEv.Fn = ""
 	endEv.Error = err.Error()
+	endEv.ErrorCode = runtime.ErrorCode(err)
 	endEv.EndedAt = time.Now()
 
 	notifyIfRequested(endEv)
-	err = e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+	err = e.dataBus.PublishInstanceHistoryEvent(ctx, endEv.withRecords(takeRecords()))
 	if err != nil {
-		return fmt.Errorf("push history end event, inst: %s: %w", inst.InstanceID, err)
+		return nil, fmt.Errorf("push history end event, inst: %s: %w", inst.InstanceID, err)
+	}
+
+	return nil, nil
+}
+
+// publishCancel ends the instance of ev as cancelled.
+func (e *Engine) publishCancel(ctx context.Context, ev *InstanceEvent) error {
+	cancelEv := ev.Clone()
+	cancelEv.EventID = cancelEventID(ev)
+	cancelEv.State = StateCodeCancelled
+	cancelEv.Fn = ""
+	cancelEv.Error = ""
+	cancelEv.EndedAt = time.Now()
+
+	notifyIfRequested(cancelEv)
+
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, cancelEv)
+	if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+		return fmt.Errorf("push history cancel event, inst: %s: %w", ev.InstanceID, err)
 	}
 
 	return nil
 }
 
+// cancelEventID returns the id of the event cancelling the instance of ev. A cancelled
+// instance is recorded once, the history stream dedupes e.g. a parked instance cancelled
+// by two requests.
+func cancelEventID(ev *InstanceEvent) uuid.UUID {
+	return uuid.NewSHA1(ev.InstanceID, []byte("cancel/"+ev.Metadata[LabelWithScope]))
+}
+
 func (e *Engine) ListInstanceStatuses(ctx context.Context, limit int, offset int, filters filter.Values) ([]*InstanceEvent, int, error) {
 	data, total := e.dataBus.ListInstanceStatuses(ctx, limit, offset, filters)
 
@@ -357,6 +662,12 @@ func (e *Engine) GetInstanceHistory(ctx context.Context, namespace string, id uu
 	return list, nil
 }
 
+// SubscribeInstanceHistory streams the history events of an instance recorded after the
+// history sequence afterSequence until ctx is done.
+func (e *Engine) SubscribeInstanceHistory(ctx context.Context, namespace string, id uuid.UUID, afterSequence uint64) (<-chan *InstanceEvent, error) {
+	return e.dataBus.SubscribeInstanceHistory(ctx, namespace, id, afterSequence)
+}
+
 func (e *Engine) DeleteNamespace(ctx context.Context, name string) error {
 	return e.dataBus.DeleteNamespace(ctx, name)
 }
@@ -396,6 +707,16 @@ func (e *Engine) makeOnSetVariableHook(inst *InstanceEvent) runtime.OnSetVariabl
 	}
 }
 
+func (e *Engine) makeOnEmitEventHook(inst *InstanceEvent) runtime.OnEmitEventHook {
+	return func(ctx context.Context, ev *cloudevents.Event, delay time.Duration) error {
+		if e.emitEvent == nil {
+			return fmt.Errorf("emitting events is not supported")
+		}
+
+		return e.emitEvent(ctx, inst.Namespace, ev, delay)
+	}
+}
+
 func (e *Engine) makeOnGetVariableHook(inst *InstanceEvent) runtime.OnGetVariableHook {
 	return func(ctx context.Context, scope string, name string) ([]byte, error) {
 		var (
@@ -439,15 +760,73 @@ func (e *Engine) makeOnGetVariableHook(inst *InstanceEvent) runtime.OnGetVariabl
 	}
 }
 
+// CancelInstance cancels an instance and its subflows on whichever replica executes them.
 func (e *Engine) CancelInstance(ctx context.Context, namespace string, id uuid.UUID) error {
-	// Cancel any running contexts for this instance (all scopes).
+	st, err := e.GetInstanceStatus(ctx, namespace, id)
+	if err != nil {
+		return err
+	}
+	if st.IsEndStatus() {
+		return nil
+	}
+
+	switch {
+	case st.IsParked():
+		err = e.cancelParked(ctx, st)
+	case st.State == StateCodePending:
+		// nothing executes the instance yet, the queue skips ended instances.
+		err = e.publishCancel(ctx, st)
+	case !e.hasLiveLease(ctx, id):
+		// the executing replica died, or the continuation of the instance waits in a queue.
+		// Nothing receives the cancellation, recoverOrphans and the queue skip ended instances.
+		err = e.publishCancel(ctx, st)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the replica executing the instance cancels its context.
+	err = e.dataBus.PublishInstanceCancel(ctx, id)
+	if err != nil {
+		return fmt.Errorf("publish instance cancel: %w", err)
+	}
+
+	children, _ := e.dataBus.ListInstanceStatuses(ctx, 0, 0, filter.With(nil,
+		filter.FieldEQ("namespace", namespace),
+		filter.FieldEQ("metadata_"+LabelParentInstance, id.String()),
+	))
+	for _, child := range children {
+		if child.IsEndStatus() {
+			continue
+		}
+		err = e.CancelInstance(ctx, namespace, child.InstanceID)
+		if err != nil {
+			return fmt.Errorf("cancel subflow %s: %w", child.InstanceID, err)
+		}
+	}
+
+	return nil
+}
+
+// cancelSubflow cancels the subflow id its parent does not wait for anymore. The status
+// of a subflow that just started might not be known yet, the cancellation is broadcast then.
+func (e *Engine) cancelSubflow(ctx context.Context, namespace string, id uuid.UUID) {
+	err := e.CancelInstance(ctx, namespace, id)
+	if errors.Is(err, ErrDataNotFound) {
+		err = e.dataBus.PublishInstanceCancel(ctx, id)
+	}
+	if err != nil {
+		slog.Error("cancel subflow", "instance", id, "error", err)
+	}
+}
+
+// cancelLocal cancels the executions of an instance on this replica.
+func cancelLocal(id uuid.UUID) {
 	cancelLock.Lock()
 	defer cancelLock.Unlock()
 	for key, cancel := range cancelMap {
-		if len(key) >= len(id.String()) && key[:len(id.String())] == id.String() {
+		if strings.HasPrefix(key, id.String()) {
 			cancel()
 		}
 	}
-
-	return nil
 }
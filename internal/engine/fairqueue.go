@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// queueItem is a fetched queue message waiting for a worker.
+type queueItem struct {
+	msg *nats.Msg
+	ev  *InstanceEvent
+	// stop ends keeping the message from being redelivered.
+	stop func()
+}
+
+// fairQueue hands out fetched queue messages round robin across namespaces, so a
+// namespace with many pending instances does not starve the others.
+type fairQueue struct {
+	mu    sync.Mutex
+	items map[string][]*queueItem
+	// order holds the namespaces with items, the next one to serve first.
+	order []string
+	size  int
+
+	signal chan struct{}
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{
+		items:  make(map[string][]*queueItem),
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (q *fairQueue) push(item *queueItem) {
+	q.mu.Lock()
+	ns := item.ev.Namespace
+	if len(q.items[ns]) == 0 {
+		q.order = append(q.order, ns)
+	}
+	q.items[ns] = append(q.items[ns], item)
+	q.size++
+	q.mu.Unlock()
+
+	q.notify()
+}
+
+// pop returns the oldest item of the next namespace, it blocks until there is one or
+// ctx is done.
+func (q *fairQueue) pop(ctx context.Context) (*queueItem, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.order) > 0 {
+			ns := q.order[0]
+			q.order = q.order[1:]
+			list := q.items[ns]
+			item := list[0]
+			if len(list) > 1 {
+				q.items[ns] = list[1:]
+				q.order = append(q.order, ns)
+			} else {
+				delete(q.items, ns)
+			}
+			q.size--
+			more := q.size > 0
+			q.mu.Unlock()
+
+			// pass the signal on to the next waiting worker.
+			if more {
+				q.notify()
+			}
+
+			return item, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-q.signal:
+		}
+	}
+}
+
+func (q *fairQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.size
+}
+
+// lenOf returns the number of waiting items of namespace.
+func (q *fairQueue) lenOf(namespace string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items[namespace])
+}
+
+func (q *fairQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFairQueue(t *testing.T) {
+	q := newFairQueue()
+	for _, ns := range []string{"a", "a", "a", "b", "c", "c"} {
+		q.push(&queueItem{ev: &InstanceEvent{Namespace: ns}})
+	}
+	require.Equal(t, 6, q.len())
+
+	var order []string
+	for range 6 {
+		item, ok := q.pop(context.Background())
+		require.True(t, ok)
+		order = append(order, item.ev.Namespace)
+	}
+	require.Equal(t, []string{"a", "b", "c", "a", "c", "a"}, order)
+	require.Equal(t, 0, q.len())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, ok := q.pop(ctx)
+	require.False(t, ok)
+}
+
+func TestFairQueueWaitingWorkers(t *testing.T) {
+	q := newFairQueue()
+	done := make(chan string)
+	for range 2 {
+		go func() {
+			item, ok := q.pop(context.Background())
+			if ok {
+				done <- item.ev.Namespace
+			}
+		}()
+	}
+
+	q.push(&queueItem{ev: &InstanceEvent{Namespace: "a"}})
+	q.push(&queueItem{ev: &InstanceEvent{Namespace: "b"}})
+
+	got := []string{<-done, <-done}
+	require.ElementsMatch(t, []string{"a", "b"}, got)
+}
+
+// fakeFetcher is the consumer of the queue messages of a namespace.
+type fakeFetcher struct {
+	mu   sync.Mutex
+	msgs []*nats.Msg
+}
+
+func (f *fakeFetcher) Fetch(batch int, opts ...nats.PullOpt) ([]*nats.Msg, error) {
+	f.mu.Lock()
+	n := min(batch, len(f.msgs))
+	msgs := f.msgs[:n]
+	f.msgs = f.msgs[n:]
+	f.mu.Unlock()
+	if n > 0 {
+		return msgs, nil
+	}
+
+	// like a pull request, wait for the deadline if there are no messages.
+	time.Sleep(10 * time.Millisecond)
+
+	return nil, nats.ErrTimeout
+}
+
+func queueMessages(t *testing.T, namespace string, n int) *fakeFetcher {
+	t.Helper()
+	f := &fakeFetcher{}
+	for i := range n {
+		data, err := json.Marshal(&InstanceEvent{InstanceID: uuid.New(), Namespace: namespace})
+		require.NoError(t, err)
+		f.msgs = append(f.msgs, &nats.Msg{
+			Data:  data,
+			Sub:   &nats.Subscription{},
+			Reply: fmt.Sprintf("$JS.ACK.engine-queue.engine-queue-%s.1.%d.%d.0.0", namespace, i+1, i+1),
+		})
+	}
+
+	return f
+}
+
+func TestQueueFetchersFloodedNamespace(t *testing.T) {
+	// a namespace floods the stream before a quiet namespace queues one instance.
+	streams := map[string]*fakeFetcher{
+		"flood": queueMessages(t, "flood", 10000),
+		"quiet": queueMessages(t, "quiet", 1),
+	}
+	queue := newFairQueue()
+	fetchers := newQueueFetchers(t.Context(), queue, 10, func(namespace string) (queueFetcher, error) {
+		return streams[namespace], nil
+	})
+	fetchers.sync([]string{"flood", "quiet"})
+
+	require.Eventually(t, func() bool {
+		return queue.lenOf("quiet") == 1 && queue.lenOf("flood") == 10
+	}, time.Second, 5*time.Millisecond)
+
+	// the flooded namespace does not fill the buffer, the quiet one is served next.
+	var got []string
+	for range 2 {
+		item, ok := queue.pop(t.Context())
+		require.True(t, ok)
+		got = append(got, item.ev.Namespace)
+	}
+	require.ElementsMatch(t, []string{"flood", "quiet"}, got)
+	require.LessOrEqual(t, queue.len(), 10)
+
+	// namespaces that are gone are not fetched anymore.
+	fetchers.sync([]string{"quiet"})
+	left := func() int {
+		streams["flood"].mu.Lock()
+		defer streams["flood"].mu.Unlock()
+
+		return len(streams["flood"].msgs)
+	}
+	time.Sleep(50 * time.Millisecond)
+	before := left()
+	for queue.len() > 0 {
+		_, ok := queue.pop(t.Context())
+		require.True(t, ok)
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, before, left())
+}
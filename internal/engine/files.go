@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/pkg/filestore"
+)
+
+// fileError converts the errors of the filestore to the errors of the scripts.
+func fileError(path string, err error) error {
+	switch {
+	case errors.Is(err, filestore.ErrNotFound):
+		return &runtime.Error{
+			Code:    core.ErrorCodeFileNotFound,
+			Message: fmt.Sprintf("file '%s' not found", path),
+		}
+	case errors.Is(err, filestore.ErrInvalidPathParameter):
+		return fmt.Errorf("invalid file path '%s', expected an absolute path", path)
+	}
+
+	return fmt.Errorf("read file '%s': %w", path, err)
+}
+
+// makeOnGetFileHook reads the files of the namespace of inst, instances can not read the
+// files of other namespaces.
+func (e *Engine) makeOnGetFileHook(inst *InstanceEvent) runtime.OnGetFileHook {
+	return func(ctx context.Context, path string) ([]byte, error) {
+		if e.fileStore == nil {
+			return nil, errors.New("files not supported")
+		}
+		path, err := filestore.ValidatePath(path)
+		if err != nil {
+			return nil, fileError(path, err)
+		}
+
+		f, err := e.fileStore.ForRoot(inst.Namespace).GetFile(ctx, path)
+		if err != nil {
+			return nil, fileError(path, err)
+		}
+		if f.Typ == filestore.FileTypeDirectory {
+			return nil, fmt.Errorf("file '%s' is a directory", path)
+		}
+
+		data, err := e.fileStore.ForFile(f).GetData(ctx)
+		if err != nil {
+			return nil, fileError(path, err)
+		}
+
+		return data, nil
+	}
+}
+
+// makeOnListFilesHook lists the directories of the namespace of inst.
+func (e *Engine) makeOnListFilesHook(inst *InstanceEvent) runtime.OnListFilesHook {
+	return func(ctx context.Context, path string) ([]*runtime.File, error) {
+		if e.fileStore == nil {
+			return nil, errors.New("files not supported")
+		}
+		list, err := e.fileStore.ForRoot(inst.Namespace).ReadDirectory(ctx, path)
+		if err != nil {
+			return nil, fileError(path, err)
+		}
+
+		files := make([]*runtime.File, 0, len(list))
+		for _, f := range list {
+			files = append(files, &runtime.File{
+				Path:     f.Path,
+				Name:     f.Name(),
+				Type:     string(f.Typ),
+				MIMEType: f.MIMEType,
+				Size:     f.Size,
+			})
+		}
+
+		return files, nil
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/internal/telemetry"
+	"github.com/google/uuid"
+)
+
+// lockPollInterval is how often waiting instances try to acquire a held lock.
+const lockPollInterval = 100 * time.Millisecond
+
+// makeOnAcquireLockHook acquires the locks of the namespace of inst. The leases are shared
+// by all engine nodes, held slots free up when released or when their TTL expires.
+func (e *Engine) makeOnAcquireLockHook(inst *InstanceEvent) runtime.OnAcquireLockHook {
+	return func(ctx context.Context, lock *runtime.Lock) (string, error) {
+		holder := uuid.NewString()
+		deadline := time.Now().Add(lock.Wait)
+
+		for waited := false; ; waited = true {
+			_, err := e.store.Leases().Acquire(ctx, &datastore.Lease{
+				Namespace:  inst.Namespace,
+				Key:        lock.Key,
+				Holder:     holder,
+				InstanceID: inst.InstanceID,
+				Limit:      lock.Limit,
+				ExpiresAt:  time.Now().Add(lock.TTL),
+			})
+			if err == nil {
+				return holder, nil
+			}
+			if !errors.Is(err, datastore.ErrLeaseUnavailable) {
+				return "", fmt.Errorf("acquire lock '%s': %w", lock.Key, err)
+			}
+			if !waited {
+				telemetry.LogInstance(ctx, telemetry.LogLevelInfo, fmt.Sprintf("waiting for lock '%s'", lock.Key))
+			}
+
+			wait := min(lockPollInterval, time.Until(deadline))
+			if wait <= 0 {
+				return "", &runtime.Error{
+					Code:    core.ErrorCodeLockTimeout,
+					Message: fmt.Sprintf("lock '%s' not acquired within %s", lock.Key, lock.Wait),
+				}
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return "", context.Cause(ctx)
+			}
+		}
+	}
+}
+
+// makeOnRenewLockHook extends the locks of the namespace of inst by their TTL.
+func (e *Engine) makeOnRenewLockHook(inst *InstanceEvent) runtime.OnRenewLockHook {
+	return func(ctx context.Context, lock *runtime.Lock, holder string) error {
+		err := e.store.Leases().Renew(ctx, inst.Namespace, lock.Key, holder, time.Now().Add(lock.TTL))
+		if errors.Is(err, datastore.ErrNotFound) {
+			return errors.New("the lease expired, others might hold it")
+		}
+		if err != nil {
+			return fmt.Errorf("renew lease: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// makeOnReleaseLockHook releases the locks of the namespace of inst.
+func (e *Engine) makeOnReleaseLockHook(inst *InstanceEvent) runtime.OnReleaseLockHook {
+	return func(ctx context.Context, key string, holder string) error {
+		err := e.store.Leases().Release(ctx, inst.Namespace, key, holder)
+		if errors.Is(err, datastore.ErrNotFound) {
+			// the renewal of the lease failed, the function was interrupted.
+			telemetry.LogInstance(ctx, telemetry.LogLevelWarn,
+				fmt.Sprintf("lock '%s' expired before it was released", key))
+
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("release lock '%s': %w", key, err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLeases is an in-memory datastore.LeasesStore without expiry.
+type fakeLeases struct {
+	mu     sync.Mutex
+	leases []*datastore.Lease
+}
+
+func (f *fakeLeases) Acquire(_ context.Context, lease *datastore.Lease) (*datastore.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	held := 0
+	for _, l := range f.leases {
+		if l.Namespace == lease.Namespace && l.Key == lease.Key {
+			if l.Holder == lease.Holder {
+				return l, nil
+			}
+			held++
+		}
+	}
+	if held >= lease.Limit {
+		return nil, datastore.ErrLeaseUnavailable
+	}
+	f.leases = append(f.leases, lease)
+
+	return lease, nil
+}
+
+func (f *fakeLeases) Release(_ context.Context, namespace string, key string, holder string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, l := range f.leases {
+		if l.Namespace == namespace && l.Key == key && l.Holder == holder {
+			f.leases = append(f.leases[:i], f.leases[i+1:]...)
+			return nil
+		}
+	}
+
+	return datastore.ErrNotFound
+}
+
+func (f *fakeLeases) Renew(_ context.Context, namespace string, key string, holder string, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, l := range f.leases {
+		if l.Namespace == namespace && l.Key == key && l.Holder == holder {
+			l.ExpiresAt = expiresAt
+			return nil
+		}
+	}
+
+	return datastore.ErrNotFound
+}
+
+func (f *fakeLeases) List(_ context.Context, namespace string) ([]*datastore.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var list []*datastore.Lease
+	for _, l := range f.leases {
+		if l.Namespace == namespace {
+			list = append(list, l)
+		}
+	}
+
+	return list, nil
+}
+
+type fakeLeasesStore struct {
+	datastore.Store
+	leases *fakeLeases
+}
+
+func (s *fakeLeasesStore) Leases() datastore.LeasesStore {
+	return s.leases
+}
+
+func TestLockHooks(t *testing.T) {
+	leases := &fakeLeases{}
+	e := &Engine{store: &fakeLeasesStore{leases: leases}}
+	inst := &InstanceEvent{InstanceID: uuid.New(), Namespace: "ns"}
+	acquire := e.makeOnAcquireLockHook(inst)
+	release := e.makeOnReleaseLockHook(inst)
+
+	lock := &runtime.Lock{Key: "db", Limit: 2, TTL: time.Minute, Wait: 50 * time.Millisecond}
+	first, err := acquire(t.Context(), lock)
+	require.NoError(t, err)
+	second, err := acquire(t.Context(), lock)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	list, _ := leases.List(t.Context(), "ns")
+	require.Len(t, list, 2)
+	require.Equal(t, inst.InstanceID, list[0].InstanceID)
+
+	_, err = acquire(t.Context(), lock)
+	require.Equal(t, core.ErrorCodeLockTimeout, runtime.ErrorCode(err))
+
+	// a slot freed during the wait is acquired.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = release(context.Background(), "db", first)
+	}()
+	lock.Wait = time.Second
+	_, err = acquire(t.Context(), lock)
+	require.NoError(t, err)
+
+	// other namespaces have their own locks.
+	other := e.makeOnAcquireLockHook(&InstanceEvent{InstanceID: uuid.New(), Namespace: "other"})
+	_, err = other(t.Context(), lock)
+	require.NoError(t, err)
+
+	// releasing an expired lease is not an error.
+	require.NoError(t, release(t.Context(), "db", first))
+
+	// held leases are renewed, lost ones are not.
+	renew := e.makeOnRenewLockHook(inst)
+	lock.TTL = time.Hour
+	require.NoError(t, renew(t.Context(), lock, second))
+	list, _ = leases.List(t.Context(), "ns")
+	require.True(t, list[0].ExpiresAt.After(time.Now().Add(time.Minute)))
+	require.Error(t, renew(t.Context(), lock, first))
+}
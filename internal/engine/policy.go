@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"encoding/json"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/sosodev/duration"
+)
+
+// maxBackoffDoublings caps the exponential backoff of retries.
+const maxBackoffDoublings = 16
+
+// StateError is the payload a catch handler state is called with.
+type StateError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	State   string          `json:"state"`
+	Input   json.RawMessage `json:"input,omitempty"`
+}
+
+// statePolicy returns the error policy of the state function of ev, nil if it has none.
+func statePolicy(ev *InstanceEvent) *core.StatePolicy {
+	data, ok := ev.Metadata[core.EngineMappingStates]
+	if !ok {
+		return nil
+	}
+
+	var states map[string]*core.StatePolicy
+	err := json.Unmarshal([]byte(data), &states)
+	if err != nil {
+		slog.Error("could not parse the state policies for flow", slog.Any("error", err))
+		return nil
+	}
+
+	return states[ev.Fn]
+}
+
+// matchCode reports if code matches any of the glob patterns, no patterns match all codes.
+func matchCode(patterns []string, code string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, code); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RecoverState applies the error policy of the state function current failed in. It
+// returns the event continuing the instance and the time to wait before, nil if the
+// instance fails.
+func RecoverState(current *InstanceEvent, err error) (*InstanceEvent, time.Duration) {
+	policy := statePolicy(current)
+	if policy == nil {
+		return nil, 0
+	}
+	code := runtime.ErrorCode(err)
+
+	// the first matching rule decides, the next rules do not retry an exhausted one.
+	for _, retry := range policy.Retry {
+		if !matchCode(retry.Codes, code) {
+			continue
+		}
+		if current.Attempt+1 >= retry.MaxAttempts {
+			break
+		}
+
+		var delay time.Duration
+		if retry.Backoff != "" {
+			d, err := duration.Parse(retry.Backoff)
+			if err != nil {
+				// cannot happen, already checked in AST parsing
+				slog.Error("could not parse the retry backoff", slog.Any("error", err))
+			} else {
+				delay = d.ToTimeDuration() << min(current.Attempt, maxBackoffDoublings)
+			}
+		}
+
+		next := current.Clone()
+		next.EventID = uuid.New()
+		next.State = StateCodeRunning
+		next.Journal = nil
+		next.Resume = nil
+		next.Suspension = nil
+		next.Attempt++
+
+		return next, delay
+	}
+
+	for _, catch := range policy.Catch {
+		if !matchCode(catch.Codes, code) {
+			continue
+		}
+
+		memory, mErr := json.Marshal(&StateError{
+			Code:    code,
+			Message: err.Error(),
+			State:   current.Fn,
+			Input:   current.StateInput(),
+		})
+		if mErr != nil {
+			slog.Error("could not marshal the state error", slog.Any("error", mErr))
+			return nil, 0
+		}
+
+		next := current.Clone()
+		next.EventID = uuid.New()
+		next.State = StateCodeRunning
+		next.Fn = catch.State
+		next.Transitions++
+		next.Output = memory
+		next.Journal = nil
+		next.Resume = nil
+		next.Suspension = nil
+		next.Attempt = 0
+
+		return next, 0
+	}
+
+	return nil, 0
+}
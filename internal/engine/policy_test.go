@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func policyEvent(t *testing.T, states map[string]*core.StatePolicy) *InstanceEvent {
+	t.Helper()
+
+	b, err := json.Marshal(states)
+	require.NoError(t, err)
+
+	return &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata: map[string]string{
+			LabelWithScope:           "main",
+			core.EngineMappingStates: string(b),
+		},
+		Fn:      "stateOne",
+		Input:   json.RawMessage(`{"a":1}`),
+		EventID: uuid.New(),
+	}
+}
+
+func TestRecoverState(t *testing.T) {
+	current := policyEvent(t, map[string]*core.StatePolicy{
+		"stateOne": {
+			Retry: []core.RetryPolicy{{MaxAttempts: 3, Backoff: "PT2S"}},
+			Catch: []core.CatchPolicy{{State: "stateFailed"}},
+		},
+	})
+	fail := errors.New("boom")
+
+	next, delay := RecoverState(current, fail)
+	require.NotNil(t, next)
+	require.Equal(t, "stateOne", next.Fn)
+	require.Equal(t, 1, next.Attempt)
+	require.Equal(t, 0, next.Transitions)
+	require.Equal(t, 2*time.Second, delay)
+
+	next, delay = RecoverState(next, fail)
+	require.NotNil(t, next)
+	require.Equal(t, 2, next.Attempt)
+	require.Equal(t, 4*time.Second, delay)
+
+	// attempts exhausted, the catch handler takes over.
+	next, delay = RecoverState(next, fail)
+	require.NotNil(t, next)
+	require.Equal(t, "stateFailed", next.Fn)
+	require.Equal(t, 0, next.Attempt)
+	require.Equal(t, 1, next.Transitions)
+	require.Zero(t, delay)
+
+	var stErr StateError
+	require.NoError(t, json.Unmarshal(next.StateInput(), &stErr))
+	require.Equal(t, "stateOne", stErr.State)
+	require.Equal(t, "boom", stErr.Message)
+	require.JSONEq(t, `{"a":1}`, string(stErr.Input))
+
+	// no policy for the catch state.
+	next, _ = RecoverState(next, fail)
+	require.Nil(t, next)
+}
+
+func TestRecoverStateCodes(t *testing.T) {
+	current := policyEvent(t, map[string]*core.StatePolicy{
+		"stateOne": {
+			Retry: []core.RetryPolicy{{Codes: []string{"http.*"}, MaxAttempts: 2}},
+			Catch: []core.CatchPolicy{{Codes: []string{"auth"}, State: "stateAuth"}},
+		},
+	})
+
+	errWithCode := func(code string) error {
+		err := runtime.ExecScript(context.Background(), &runtime.Script{
+			InstID: uuid.New(),
+			Text:   `function stateOne() { throw { code: "` + code + `" } }`,
+			Fn:     "stateOne",
+			Input:  `{}`,
+		})
+		require.Error(t, err)
+
+		return err
+	}
+
+	next, _ := RecoverState(current, errWithCode("http.500"))
+	require.NotNil(t, next)
+	require.Equal(t, "stateOne", next.Fn)
+
+	next, _ = RecoverState(current, errWithCode("auth"))
+	require.NotNil(t, next)
+	require.Equal(t, "stateAuth", next.Fn)
+
+	next, _ = RecoverState(current, errWithCode("other"))
+	require.Nil(t, next)
+
+	next, _ = RecoverState(current, errors.New("no code"))
+	require.Nil(t, next)
+}
+
+func TestExecInstanceRetry(t *testing.T) {
+	inst := policyEvent(t, map[string]*core.StatePolicy{
+		"stateOne": {
+			Retry: []core.RetryPolicy{{MaxAttempts: 3}},
+			Catch: []core.CatchPolicy{{State: "stateFailed"}},
+		},
+	})
+	inst.State = StateCodePending
+	inst.Script = `
+	function stateOne() { throw "boom" }
+	function stateFailed(e) { return finish(e.state) }`
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.execInstance(context.Background(), inst))
+
+	var fns []string
+	for _, ev := range bus.history {
+		if ev.State == StateCodeRunning {
+			fns = append(fns, ev.Fn)
+		}
+	}
+	require.Equal(t, []string{"stateOne", "stateOne", "stateOne", "stateFailed"}, fns)
+
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `"stateOne"`, string(end.Output))
+}
+
+func TestExecInstanceRetryBackoff(t *testing.T) {
+	inst := policyEvent(t, map[string]*core.StatePolicy{
+		"stateOne": {
+			Retry: []core.RetryPolicy{{MaxAttempts: 2, Backoff: "PT1M"}},
+		},
+	})
+	inst.State = StateCodePending
+	inst.Script = `function stateOne() { throw "boom" }`
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.execInstance(context.Background(), inst))
+
+	parked := bus.history[len(bus.history)-1]
+	require.True(t, parked.IsParked())
+	require.Equal(t, runtime.SuspensionKindRetry, parked.Suspension.Kind)
+	require.Equal(t, 1, parked.Attempt)
+
+	bus.statuses = []*InstanceEvent{parked}
+	e.fireTimers(context.Background(), parked.Suspension.Deadline.Add(time.Second))
+	require.Len(t, bus.queued, 1)
+	require.Empty(t, bus.queued[0].Journal)
+	require.Nil(t, bus.queued[0].Suspension)
+
+	// the retry is the last attempt, the instance fails.
+	bus.statuses = nil
+	require.NoError(t, e.execInstance(context.Background(), bus.queued[0]))
+	require.Equal(t, StateCodeFailed, bus.history[len(bus.history)-1].State)
+}
+
+func TestExecInstanceErrorCode(t *testing.T) {
+	inst := policyEvent(t, nil)
+	inst.State = StateCodePending
+	inst.Script = `function stateOne() { throw { code: "com.example.failed" } }`
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.execInstance(context.Background(), inst))
+
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeFailed, end.State)
+	require.Equal(t, "com.example.failed", end.ErrorCode)
+}
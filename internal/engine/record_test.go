@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecInstanceRecords(t *testing.T) {
+	inst := policyEvent(t, map[string]*core.StatePolicy{
+		"stateTwo": {
+			Catch: []core.CatchPolicy{{State: "stateFailed"}},
+		},
+	})
+	inst.State = StateCodePending
+	inst.Script = `
+	function stateOne() { return transition(stateTwo, {t: now().unix()}) }
+	function stateTwo() {
+		now()
+		throw getSecret("token")
+	}
+	function stateFailed(e) {
+		now()
+		return finish(e.state)
+	}`
+	inst.Metadata[core.EngineMappingSecrets] = `{"token":"YWJj"}`
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.execInstance(context.Background(), inst))
+	require.Equal(t, StateCodeComplete, bus.history[len(bus.history)-1].State)
+
+	// the records of the failed execution are published with the catch handler.
+	var perEvent [][]runtime.RecordKind
+	for _, ev := range bus.history {
+		var kinds []runtime.RecordKind
+		for _, rec := range ev.Records {
+			kinds = append(kinds, rec.Kind)
+		}
+		perEvent = append(perEvent, kinds)
+	}
+	require.Equal(t, [][]runtime.RecordKind{
+		nil,
+		{runtime.RecordKindNow},
+		{runtime.RecordKindNow, runtime.RecordKindSecret},
+		{runtime.RecordKindNow},
+	}, perEvent)
+	require.Len(t, Recording(bus.history), 4)
+
+	// clones continuing the instance do not carry the records.
+	require.Nil(t, bus.history[1].Clone().Records)
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/pkg/lifecycle"
+	"github.com/google/uuid"
+)
+
+const (
+	// heartbeatInterval is how often the executing replica refreshes the lease of an instance.
+	heartbeatInterval = 10 * time.Second
+
+	// leaseTimeout is the age after which an instance without heartbeat is considered orphaned.
+	leaseTimeout = 45 * time.Second
+
+	recoveryInterval = 30 * time.Second
+)
+
+// startHeartbeat keeps the lease of an instance alive while it is executed by this replica.
+func (e *Engine) startHeartbeat(ctx context.Context, inst *InstanceEvent) func() {
+	beat := func() {
+		err := e.dataBus.PublishInstanceHeartbeat(ctx, inst)
+		if err != nil {
+			slog.Error("publish instance heartbeat", "instance", inst.InstanceID, "error", err)
+		}
+	}
+	beat()
+
+	return every(ctx, heartbeatInterval, beat)
+}
+
+// hasLiveLease reports if another replica executed the instance recently.
+func (e *Engine) hasLiveLease(ctx context.Context, id uuid.UUID) bool {
+	t, ok := e.dataBus.GetInstanceHeartbeat(ctx, id)
+
+	return ok && time.Since(t) < leaseTimeout
+}
+
+func (e *Engine) startRecovery(lc *lifecycle.Manager) {
+	lc.Go(func() error {
+		// give the caches time to replay the streams and live replicas time to
+		// publish heartbeats before we consider anything orphaned.
+		wait := leaseTimeout
+		for {
+			select {
+			case <-lc.Done():
+				return nil
+			case <-time.After(wait):
+			}
+			wait = recoveryInterval
+
+			err := e.recoverOrphans(lc.Context())
+			if err != nil {
+				slog.Error("recover orphaned instances", "error", err)
+			}
+			e.sweepSlots(lc.Context())
+		}
+	})
+}
+
+// recoverOrphans re-enqueues running instances whose executing replica died. The
+// instance continues from its last recorded transition.
+func (e *Engine) recoverOrphans(ctx context.Context) error {
+	list, _ := e.dataBus.ListInstanceStatuses(ctx, 0, 0, filter.With(nil,
+		filter.FieldEQ("status", string(StateCodeRunning)),
+	))
+
+	for _, st := range list {
+		// parked instances are resumed by their wait.
+		if st.IsParked() {
+			continue
+		}
+		if time.Since(st.StartedAt) < leaseTimeout || e.hasLiveLease(ctx, st.InstanceID) {
+			continue
+		}
+		// subflows are executed synchronously by their parent, the recovered parent starts
+		// them again. A subflow continued after it parked with its parent runs on its own.
+		if st.Metadata[LabelParentInstance] != "" {
+			if _, ok := e.waitingParent(ctx, st); !ok {
+				e.failOrphanedSubflow(ctx, st)
+				continue
+			}
+		}
+
+		ev, err := e.resumeEvent(ctx, st)
+		if err != nil {
+			slog.Error("build resume event", "instance", st.InstanceID, "error", err)
+			continue
+		}
+
+		// all replicas run the recovery, a deterministic event id lets the queue
+		// stream dedupe the entry.
+		ev.EventID = uuid.NewSHA1(st.InstanceID, fmt.Appendf(nil, "recover-%d", st.Sequence))
+
+		slog.Info("recovering orphaned instance", "instance", st.InstanceID,
+			"namespace", st.Namespace, "fn", ev.Fn)
+
+		err = e.dataBus.PublishInstanceQueueEvent(ctx, ev)
+		if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+			slog.Error("enqueue orphaned instance", "instance", st.InstanceID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// failOrphanedSubflow ends the subflow st whose parent is gone.
+func (e *Engine) failOrphanedSubflow(ctx context.Context, st *InstanceEvent) {
+	endEv := st.Clone()
+	// all replicas run the recovery, the history stream dedupes the event.
+	endEv.EventID = uuid.NewSHA1(st.EventID, []byte("orphaned"))
+	endEv.State = StateCodeFailed
+	endEv.Fn = ""
+	endEv.Error = "subflow orphaned, the executing replica of its parent died"
+	endEv.EndedAt = time.Now()
+
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+	if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+		slog.Error("fail orphaned subflow", "instance", st.InstanceID, "error", err)
+	}
+}
+
+// resumeEvent builds the queue event that continues an instance from its last
+// recorded transition.
+func (e *Engine) resumeEvent(ctx context.Context, st *InstanceEvent) (*InstanceEvent, error) {
+	list := e.dataBus.GetInstanceHistory(ctx, st.Namespace, st.InstanceID)
+
+	var last *InstanceEvent
+	for _, ev := range list {
+		if ev.Metadata[LabelWithScope] != st.Metadata[LabelWithScope] {
+			continue
+		}
+		if ev.State == StateCodeRunning && ev.Fn != "" {
+			last = ev
+		}
+	}
+	if last == nil {
+		return nil, fmt.Errorf("no transition recorded for instance %s", st.InstanceID)
+	}
+
+	ev := last.Clone()
+	ev.EventID = uuid.New()
+
+	return ev, nil
+}
+
+// every calls fn each interval until the returned stop function is called or ctx is done.
+func every(ctx context.Context, interval time.Duration, fn func()) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				fn()
+			}
+		}
+	}()
+
+	return cancel
+}
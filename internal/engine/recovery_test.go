@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func runningStatus(startedAt time.Time) *InstanceEvent {
+	return &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		StartedAt:  startedAt,
+		Sequence:   3,
+		EventID:    uuid.New(),
+	}
+}
+
+func TestRecoverOrphans(t *testing.T) {
+	old := time.Now().Add(-2 * leaseTimeout)
+
+	orphan := runningStatus(old)
+	first := orphan.Clone()
+	first.EventID, first.Fn = uuid.New(), "stateOne"
+	second := orphan.Clone()
+	second.EventID, second.Fn, second.Sequence = uuid.New(), "stateTwo", 2
+	// a transition of another scope is not resumed.
+	scoped := orphan.Clone()
+	scoped.EventID, scoped.Fn = uuid.New(), "stateOther"
+	scoped.Metadata = map[string]string{LabelWithScope: "other"}
+	// the recorded state change of the function has no transition.
+	changed := orphan.Clone()
+	changed.EventID = uuid.New()
+
+	parked := runningStatus(old)
+	parked.Suspension = &runtime.Suspension{Kind: runtime.SuspensionKindSignal, Step: 1}
+	leased := runningStatus(old)
+	young := runningStatus(time.Now())
+
+	bus := &fakeDataBus{
+		statuses: []*InstanceEvent{orphan, parked, leased, young},
+		history:  []*InstanceEvent{first, second, scoped, changed},
+		beats:    map[uuid.UUID]time.Time{leased.InstanceID: time.Now()},
+	}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.recoverOrphans(context.Background()))
+	require.Len(t, bus.queued, 1)
+	ev := bus.queued[0]
+	require.Equal(t, orphan.InstanceID, ev.InstanceID)
+	require.Equal(t, "stateTwo", ev.Fn)
+	require.Equal(t, uuid.NewSHA1(orphan.InstanceID, fmt.Appendf(nil, "recover-%d", orphan.Sequence)), ev.EventID)
+
+	// other replicas run the same recovery, the queue dedupes it.
+	require.NoError(t, e.recoverOrphans(context.Background()))
+	require.Len(t, bus.queued, 1)
+	require.Len(t, bus.history, 4)
+}
+
+func TestRecoverOrphanedSubflow(t *testing.T) {
+	old := time.Now().Add(-2 * leaseTimeout)
+
+	parent := runningStatus(old)
+	child := runningStatus(old)
+	child.Fn = "stateOne"
+	child.Metadata[LabelParentInstance] = parent.InstanceID.String()
+	// the parent has a live lease and is not recovered itself.
+	bus := &fakeDataBus{
+		statuses: []*InstanceEvent{parent, child},
+		history:  []*InstanceEvent{child.Clone()},
+		beats:    map[uuid.UUID]time.Time{parent.InstanceID: time.Now()},
+	}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.recoverOrphans(context.Background()))
+	require.NoError(t, e.recoverOrphans(context.Background()))
+
+	require.Empty(t, bus.queued)
+	require.Len(t, bus.history, 2)
+	end := bus.history[1]
+	require.Equal(t, child.InstanceID, end.InstanceID)
+	require.Equal(t, StateCodeFailed, end.State)
+	require.Equal(t, "subflow orphaned, the executing replica of its parent died", end.Error)
+	require.False(t, end.EndedAt.IsZero())
+}
+
+func TestRecoverParkedSubflow(t *testing.T) {
+	old := time.Now().Add(-2 * leaseTimeout)
+
+	child := runningStatus(old)
+	child.Fn = "stateOne"
+	parent := runningStatus(old)
+	parent.Suspension = subflowSuspension(child.InstanceID)
+	child.Metadata[LabelParentInstance] = parent.InstanceID.String()
+	child.Metadata[LabelWithParkable] = "true"
+
+	bus := &fakeDataBus{
+		statuses: []*InstanceEvent{parent, child},
+		history:  []*InstanceEvent{child.Clone()},
+	}
+	e := &Engine{dataBus: bus}
+
+	// the subflow continued after it parked with its parent runs on its own.
+	require.NoError(t, e.recoverOrphans(context.Background()))
+	require.Len(t, bus.history, 1)
+	require.Len(t, bus.queued, 1)
+	require.Equal(t, child.InstanceID, bus.queued[0].InstanceID)
+}
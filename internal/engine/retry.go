@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/google/uuid"
+)
+
+// RetryFromLast retries an instance from the last state function it transitioned to.
+const RetryFromLast = "last"
+
+var (
+	ErrRetryNotFailed     = errors.New("only failed or cancelled instances can be retried")
+	ErrRetryStateNotFound = errors.New("instance did not transition to the state")
+)
+
+// RetryInstance starts a new instance of the flow of the failed or cancelled instance id,
+// linked to it with LabelRetryOf. The new instance starts at the state function from, or
+// RetryFromLast, with the memory the instance transitioned to it with. It runs the current
+// version of the flow.
+func (e *Engine) RetryInstance(ctx context.Context, namespace string, id uuid.UUID, from string) (*InstanceEvent, error) {
+	st, err := e.GetInstanceStatus(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	if st.State != StateCodeFailed && st.State != StateCodeCancelled {
+		return nil, ErrRetryNotFailed
+	}
+
+	history, err := e.GetInstanceHistory(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	transition := retryTransition(history, from)
+	if transition == nil {
+		return nil, ErrRetryStateNotFound
+	}
+
+	retryEv, _, err := e.startFlow(ctx, uuid.New(), namespace, st.Metadata[core.EngineMappingPath],
+		transition.Fn, string(transition.StateInput()), map[string]string{
+			LabelWithNotify:   strconv.FormatBool(false),
+			LabelWithSyncExec: strconv.FormatBool(false),
+			LabelInvokerType:  "retry",
+			LabelWithScope:    "main",
+			LabelRetryOf:      id.String(),
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return retryEv, nil
+}
+
+// retryTransition returns the last recorded transition to the state function from, nil if
+// there is none.
+func retryTransition(history []*InstanceEvent, from string) *InstanceEvent {
+	var last *InstanceEvent
+	for _, ev := range history {
+		if ev.State != StateCodeRunning || ev.Fn == "" {
+			continue
+		}
+		if from == RetryFromLast || ev.Fn == from {
+			last = ev
+		}
+	}
+
+	return last
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryInstance(t *testing.T) {
+	comp := &fakeCompiler{flows: map[string]core.TypescriptFlow{
+		"/flow.wf.ts": {
+			Script: `
+			function stateOne(input) { return transition(stateTwo, { n: input.n + 1 }) }
+			function stateTwo(input) { return transition(stateThree, { n: input.n * 10 }) }
+			function stateThree(input) { return finish(input.n) }`,
+			Config: core.FlowConfig{Timeout: "PT1M", State: "stateOne"},
+		},
+	}}
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus, compiler: comp}
+
+	id := uuid.New()
+	ev := func(state StateCode, fn string, output string) *InstanceEvent {
+		return &InstanceEvent{
+			State:      state,
+			InstanceID: id,
+			Namespace:  "ns",
+			Metadata: map[string]string{
+				LabelWithScope:         "main",
+				core.EngineMappingPath: "/flow.wf.ts",
+			},
+			Fn:      fn,
+			Input:   json.RawMessage(`{"n":1}`),
+			Output:  json.RawMessage(output),
+			EventID: uuid.New(),
+		}
+	}
+	failed := ev(StateCodeFailed, "", "")
+	bus.history = []*InstanceEvent{
+		ev(StateCodePending, "stateOne", ""),
+		ev(StateCodeRunning, "stateOne", ""),
+		ev(StateCodeRunning, "stateTwo", `{"n":2}`),
+		ev(StateCodeRunning, "stateThree", `{"n":20}`),
+		failed,
+	}
+	bus.statuses = []*InstanceEvent{failed}
+
+	_, err := e.RetryInstance(context.Background(), "ns", id, "stateFour")
+	require.ErrorIs(t, err, ErrRetryStateNotFound)
+
+	retried, err := e.RetryInstance(context.Background(), "ns", id, RetryFromLast)
+	require.NoError(t, err)
+	require.NotEqual(t, id, retried.InstanceID)
+	require.Equal(t, "stateThree", retried.Fn)
+	require.JSONEq(t, `{"n":20}`, string(retried.Input))
+	require.Equal(t, id.String(), retried.Metadata[LabelRetryOf])
+	require.Equal(t, retried, bus.queued[len(bus.queued)-1])
+
+	retried, err = e.RetryInstance(context.Background(), "ns", id, "stateTwo")
+	require.NoError(t, err)
+	require.NoError(t, e.execInstance(context.Background(), retried))
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, retried.InstanceID, end.InstanceID)
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `20`, string(end.Output))
+
+	// completed instances are not retried.
+	bus.statuses = []*InstanceEvent{end}
+	_, err = e.RetryInstance(context.Background(), "ns", retried.InstanceID, RetryFromLast)
+	require.ErrorIs(t, err, ErrRetryNotFailed)
+}
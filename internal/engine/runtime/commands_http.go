@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -50,7 +51,63 @@ type httpResponseObject struct {
 	err string
 }
 
-func doHttpRequest(addr string, config any) (*httpResponseObject, error) {
+// recordedResponse is the recorded form of httpResponseObject.
+type recordedResponse struct {
+	ResponseType string      `json:"responseType"`
+	URL          string      `json:"url"`
+	Redirected   bool        `json:"redirected,omitempty"`
+	Status       int         `json:"status"`
+	StatusText   string      `json:"statusText"`
+	OK           bool        `json:"ok"`
+	Headers      http.Header `json:"headers,omitempty"`
+	Body         []byte      `json:"body,omitempty"`
+	Err          string      `json:"error,omitempty"`
+}
+
+func (o *httpResponseObject) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&recordedResponse{
+		ResponseType: o.responseType,
+		URL:          o.url,
+		Redirected:   o.redirected,
+		Status:       o.status,
+		StatusText:   o.statusText,
+		OK:           o.ok,
+		Headers:      o.headers,
+		Body:         o.body,
+		Err:          o.err,
+	})
+}
+
+func (o *httpResponseObject) UnmarshalJSON(data []byte) error {
+	var r recordedResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*o = httpResponseObject{
+		responseType: r.ResponseType,
+		url:          r.URL,
+		redirected:   r.Redirected,
+		status:       r.Status,
+		statusText:   r.StatusText,
+		ok:           r.OK,
+		headers:      r.Headers,
+		body:         r.Body,
+		err:          r.Err,
+	}
+
+	return nil
+}
+
+// roundTripFunc calls the function as http.RoundTripper.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// doHttpRequest sends the request configured by config to addr. A non-nil onFetch answers
+// the request instead of the network.
+func doHttpRequest(ctx context.Context, addr string, config any, onFetch OnFetchHook) (*httpResponseObject, error) {
 	// url requires value
 	u, err := url.Parse(addr)
 	if err != nil {
@@ -106,7 +163,7 @@ func doHttpRequest(addr string, config any) (*httpResponseObject, error) {
 		rBody = strings.NewReader(string(b))
 	}
 
-	request, err := http.NewRequest(req.Method, u.String(), rBody)
+	request, err := http.NewRequestWithContext(ctx, req.Method, u.String(), rBody)
 	if err != nil {
 		obj.err = err.Error()
 		return obj, nil
@@ -138,6 +195,9 @@ func doHttpRequest(addr string, config any) (*httpResponseObject, error) {
 		cr.TLSClientConfig = &tls.Config{InsecureSkipVerify: req.SkipTls}
 		client.Transport = cr
 	}
+	if onFetch != nil {
+		client.Transport = roundTripFunc(onFetch)
+	}
 
 	resp, err := client.Do(request)
 	if err != nil {
@@ -186,9 +246,16 @@ func (rt *Runtime) populateResponseObject(response *httpResponseObject) *sobek.O
 	responseObject.Set("headers", m)
 
 	responseObject.Set("text", func(call sobek.FunctionCall) sobek.Value {
+		if rt.allocate(len(response.body)) != nil {
+			return sobek.Undefined()
+		}
+
 		return rt.vm.ToValue(string(response.body))
 	})
 	responseObject.Set("json", func(call sobek.FunctionCall) sobek.Value {
+		if rt.allocate(len(response.body)) != nil {
+			return sobek.Undefined()
+		}
 		var r any
 		err := json.Unmarshal(response.body, &r)
 		if err != nil {
@@ -209,7 +276,9 @@ func (rt *Runtime) fetchSync(addr string, config any) *sobek.Object {
 	})
 	defer span.End()
 
-	response, err := doHttpRequest(addr, config)
+	response, err := blocking(rt, recorded(rt, RecordKindFetch, addr, func() (*httpResponseObject, error) {
+		return doHttpRequest(rt.tracingPack.ctx, addr, config, rt.onFetch)
+	}))
 	if err != nil {
 		rt.tracingPack.thrownError = err
 		span.SetStatus(codes.Error, err.Error())
@@ -223,17 +292,22 @@ func (rt *Runtime) fetchSync(addr string, config any) *sobek.Object {
 
 func (rt *Runtime) fetch(addr string, config any) *sobek.Promise {
 	span := rt.tracingPack.trace("calling http async")
-	defer span.End()
+	span.SetAttributes(attribute.KeyValue{
+		Key:   "url",
+		Value: attribute.StringValue(addr),
+	})
+	ctx := rt.tracingPack.ctx
+	call := recorded(rt, RecordKindFetch, addr, func() (*httpResponseObject, error) {
+		return doHttpRequest(ctx, addr, config, rt.onFetch)
+	})
 
-	p, resolve, reject := rt.vm.NewPromise()
-	go func() {
-		response, err := doHttpRequest(addr, config)
-		if err != nil {
-			reject(rt.vm.ToValue(err.Error()))
-			return
-		}
-		resolve(rt.populateResponseObject(response))
-	}()
+	return rt.runAsync(func() (any, error) {
+		defer span.End()
+
+		return call()
+	}, func(result any) sobek.Value {
+		response, _ := result.(*httpResponseObject)
 
-	return p
+		return rt.populateResponseObject(response)
+	})
 }
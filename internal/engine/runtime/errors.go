@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/grafana/sobek"
+)
+
+// Error is an error with a code. Scripts catch it as an Error object with the code
+// property set.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// httpError returns the error of a call answered with status, code and message are read
+// from the response headers if the callee sets them.
+func httpError(status int, code, message string) *Error {
+	if code == "" {
+		code = fmt.Sprintf("%s.%d", core.ErrorCodeHTTP, status)
+	}
+	if message == "" {
+		message = fmt.Sprintf("request failed with status code %d", status)
+	}
+
+	return &Error{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// errorValue converts err to the value thrown to the script. Errors with code become
+// Error objects carrying the code, all other errors are thrown as string.
+func (rt *Runtime) errorValue(err error) sobek.Value {
+	var codeErr *Error
+	if !errors.As(err, &codeErr) {
+		return rt.vm.ToValue(err.Error())
+	}
+
+	ctor, ok := sobek.AssertConstructor(rt.vm.Get("Error"))
+	if !ok {
+		return rt.vm.ToValue(err.Error())
+	}
+	obj, cErr := ctor(nil, rt.vm.ToValue(err.Error()))
+	if cErr != nil {
+		return rt.vm.ToValue(err.Error())
+	}
+	_ = obj.Set("code", codeErr.Code)
+
+	return obj
+}
+
+// ErrorCode returns the code of an error of a script, the code property of the thrown
+// object. Errors without code return an empty string.
+func ErrorCode(err error) string {
+	var exception *sobek.Exception
+	if errors.As(err, &exception) {
+		obj, ok := exception.Value().(*sobek.Object)
+		if !ok {
+			return ""
+		}
+		code := obj.Get("code")
+		if code == nil || sobek.IsUndefined(code) || sobek.IsNull(code) {
+			return ""
+		}
+
+		return code.String()
+	}
+
+	var codeErr *Error
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+
+	return ""
+}
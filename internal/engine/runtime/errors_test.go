@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/telemetry"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallRetryableErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"a":1}`))
+	})
+	mux.HandleFunc("/coded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(core.EngineHeaderErrorCode, "com.example.failed")
+		w.Header().Set(core.EngineHeaderErrorMessage, "it failed")
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not here", http.StatusNotFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.WithValue(context.Background(),
+		telemetry.DirektivLogCtx(telemetry.LogObjectIdentifier), telemetry.LogObject{})
+
+	out, err := callRetryable(ctx, srv.URL+"/ok", http.MethodPost, nil, 0, time.Second, nil)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"a":1}`, string(out))
+
+	var codeErr *Error
+
+	_, err = callRetryable(ctx, srv.URL+"/coded", http.MethodPost, nil, 1, time.Second, nil)
+	require.ErrorAs(t, err, &codeErr)
+	require.Equal(t, "com.example.failed", codeErr.Code)
+	require.Equal(t, "it failed", codeErr.Message)
+
+	_, err = callRetryable(ctx, srv.URL+"/plain", http.MethodPost, nil, 0, time.Second, nil)
+	require.ErrorAs(t, err, &codeErr)
+	require.Equal(t, "io.direktiv.error.http.404", codeErr.Code)
+	require.Equal(t, "not here", codeErr.Message)
+}
+
+func TestErrorValue(t *testing.T) {
+	rt := New(uuid.New(), nil, "")
+	require.NoError(t, rt.vm.Set("fail", func(coded bool) {
+		if coded {
+			panic(rt.errorValue(&Error{Code: "com.example.failed", Message: "boom"}))
+		}
+		panic(rt.errorValue(errors.New("plain")))
+	}))
+
+	v, err := rt.vm.RunString(`
+		const caught = []
+		try { fail(true) } catch (e) { caught.push(e instanceof Error, e.code, e.message) }
+		try { fail(false) } catch (e) { caught.push(e) }
+		caught`)
+	require.NoError(t, err)
+	require.Equal(t, []any{true, "com.example.failed", "boom", "plain"}, v.Export())
+
+	_, err = rt.vm.RunString(`fail(true)`)
+	require.Equal(t, "com.example.failed", ErrorCode(err))
+
+	_, err = rt.vm.RunString(`fail(false)`)
+	require.Empty(t, ErrorCode(err))
+}
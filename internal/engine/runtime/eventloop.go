@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/grafana/sobek"
+)
+
+// asyncOp is an asynchronous call of the script. The work runs in its own goroutine, the
+// promise of the call is settled on the VM thread by the event loop.
+type asyncOp struct {
+	done   chan struct{}
+	result any
+	err    error
+	settle func() error
+}
+
+// runAsync runs work concurrently to the script and returns a promise of its result. The
+// VM is not goroutine-safe, work must not use it. toValue converts the result on the VM
+// thread.
+func (rt *Runtime) runAsync(work func() (any, error), toValue func(result any) sobek.Value) *sobek.Promise {
+	p, resolve, reject := rt.vm.NewPromise()
+
+	op := &asyncOp{
+		done: make(chan struct{}),
+	}
+	op.settle = func() error {
+		if op.err != nil {
+			return reject(rt.errorValue(op.err))
+		}
+
+		return resolve(toValue(op.result))
+	}
+	rt.pending = append(rt.pending, op)
+
+	go func() {
+		defer close(op.done)
+		op.result, op.err = work()
+	}()
+
+	return p
+}
+
+// runEventLoop settles the promises of the pending asynchronous calls until there are none
+// left. Promises settle in the order the calls were made, so the script runs the same
+// no matter which call finishes first.
+func (rt *Runtime) runEventLoop() error {
+	for len(rt.pending) > 0 {
+		op := rt.pending[0]
+		rt.guard.pause()
+		select {
+		case <-op.done:
+		case <-rt.tracingPack.ctx.Done():
+			rt.guard.resume()
+			return rt.tracingPack.ctx.Err()
+		}
+		rt.guard.resume()
+		rt.pending = rt.pending[1:]
+
+		// settling runs the reactions of the promise, they might add calls.
+		err := op.settle()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(rt.rejected) > 0 {
+		return fmt.Errorf("unhandled promise rejection: %s", rt.rejected[0].Result().String())
+	}
+
+	return nil
+}
+
+// trackRejection records rejected promises without a handler, see runEventLoop.
+func (rt *Runtime) trackRejection(p *sobek.Promise, op sobek.PromiseRejectionOperation) {
+	switch op {
+	case sobek.PromiseRejectionReject:
+		rt.rejected = append(rt.rejected, p)
+	case sobek.PromiseRejectionHandle:
+		rt.rejected = slices.DeleteFunc(rt.rejected, func(r *sobek.Promise) bool {
+			return r == p
+		})
+	}
+}
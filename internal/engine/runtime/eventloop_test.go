@@ -0,0 +1,104 @@
+package runtime_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLoop(t *testing.T) {
+	// responds after the requested delay in milliseconds.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms, _ := strconv.Atoi(r.URL.Query().Get("delay"))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		_, _ = w.Write([]byte(r.URL.Query().Get("delay")))
+	}))
+	defer srv.Close()
+
+	script := `
+		async function start() {
+			const order = []
+			const calls = [300, 200, 100].map(d =>
+				fetch("` + srv.URL + `", {params: {delay: String(d)}}).then(r => {
+					order.push(r.text())
+					return r.text()
+				}))
+
+			const results = await Promise.all(calls)
+
+			return finish({results, order})
+		}
+	`
+
+	var gotOutput string
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		gotOutput = string(output)
+		return nil
+	}
+
+	begin := time.Now()
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text:   script,
+		Fn:     "start",
+		Input:  "{}",
+	}, onFinish)
+	require.NoError(t, err)
+
+	// the calls run concurrently, the promises settle in call order.
+	require.Less(t, time.Since(begin), 550*time.Millisecond)
+	require.JSONEq(t, `{"results":["300","200","100"],"order":["300","200","100"]}`, gotOutput)
+}
+
+func TestEventLoopErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		js   string
+		ok   bool
+	}{
+		{
+			"unhandled rejection",
+			`async function start() {
+				await fetch("http://[::1", {})
+			}`,
+			false,
+		},
+		{
+			"handled rejection",
+			`function start() {
+				fetch("http://[::1", {}).catch(e => finish(e))
+			}`,
+			true,
+		},
+		{
+			"thrown in reaction",
+			`function start() {
+				Promise.resolve(1).then(() => { throw "fails" })
+			}`,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runtime.ExecScript(context.Background(), &runtime.Script{
+				InstID: uuid.New(),
+				Text:   tt.js,
+				Fn:     "start",
+				Input:  "{}",
+			})
+			if tt.ok {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
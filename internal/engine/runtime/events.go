@@ -0,0 +1,116 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/google/uuid"
+	"github.com/grafana/sobek"
+	"github.com/sosodev/duration"
+)
+
+// OnEmitEventHook delivers the event ev emitted by the script, delayed by delay.
+type OnEmitEventHook func(ctx context.Context, ev *cloudevents.Event, delay time.Duration) error
+
+// EmitEventConfig is the configuration of emitEvent.
+type EmitEventConfig struct {
+	Type string `json:"type"`
+	// Source defaults to the path of the flow.
+	Source     string         `json:"source,omitempty"`
+	Data       any            `json:"data,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+	// Delay is an ISO8601 duration the delivery of the event is delayed by.
+	Delay string `json:"delay,omitempty"`
+}
+
+// emitEvent publishes a cloud event in the namespace of the instance and returns its id.
+func (rt *Runtime) emitEvent(config sobek.Value) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling emitEvent")
+
+	var data any
+	if err := rt.vm.ExportTo(config, &data); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error exporting emitEvent config: %s", err.Error())))
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling emitEvent config: %s", err.Error())))
+	}
+	var cfg EmitEventConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("invalid emitEvent config: %s", err.Error())))
+	}
+
+	ev, delay, err := rt.newEvent(&cfg)
+	if err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("invalid emitEvent config: %s", err.Error())))
+	}
+
+	if rt.onEmitEvent == nil && rt.replay == nil {
+		panic(rt.vm.ToValue("emitEvent not supported"))
+	}
+
+	ctx := rt.tracingPack.ctx
+	id, err := blocking(rt, recorded(rt, RecordKindEvent, cfg.Type, func() (string, error) {
+		err := rt.onEmitEvent(ctx, ev, delay)
+		if err != nil {
+			return "", fmt.Errorf("error emitting event: %w", err)
+		}
+
+		return ev.ID(), nil
+	}))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	return rt.vm.ToValue(id)
+}
+
+// nextEventID returns the id of the next event emitted by the current state. The id is
+// derived from the execution of the state, executing it again emits the event with the
+// same id and receivers can dedupe it.
+func (rt *Runtime) nextEventID() string {
+	name := fmt.Sprintf("%s/%d/%d/%d", rt.fn, rt.transitions, rt.attempt, rt.emitted)
+	rt.emitted++
+
+	return uuid.NewSHA1(rt.instID, []byte(name)).String()
+}
+
+// newEvent builds the cloud event of cfg and returns its delay.
+func (rt *Runtime) newEvent(cfg *EmitEventConfig) (*cloudevents.Event, time.Duration, error) {
+	ev := cloudevents.NewEvent()
+	ev.SetID(rt.nextEventID())
+	ev.SetType(cfg.Type)
+	ev.SetSource(cfg.Source)
+	if cfg.Source == "" {
+		ev.SetSource(rt.metadata[core.EngineMappingPath])
+	}
+	ev.SetTime(time.Now().UTC())
+	for k, v := range cfg.Extensions {
+		ev.SetExtension(k, v)
+	}
+	if cfg.Data != nil {
+		err := ev.SetData(cloudevents.ApplicationJSON, cfg.Data)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	err := ev.Validate()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var delay time.Duration
+	if cfg.Delay != "" {
+		d, err := duration.Parse(cfg.Delay)
+		if err != nil {
+			return nil, 0, fmt.Errorf("delay: %w", err)
+		}
+		delay = d.ToTimeDuration()
+	}
+
+	return &ev, delay, nil
+}
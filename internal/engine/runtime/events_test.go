@@ -0,0 +1,108 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitEvent(t *testing.T) {
+	script := `
+		function start() {
+			const id = emitEvent({type: "order.created", data: {id: 7}, extensions: {tenant: "a"}})
+			emitEvent({type: "order.reminder", source: "reminders", delay: "PT1H"})
+			let failed = ""
+			try {
+				emitEvent({source: "no-type"})
+			} catch (e) {
+				failed = e
+			}
+
+			return finish({id: id, failed: failed})
+		}
+	`
+
+	var emitted []*cloudevents.Event
+	var delays []time.Duration
+	var onEmitEvent runtime.OnEmitEventHook = func(ctx context.Context, ev *cloudevents.Event, delay time.Duration) error {
+		emitted = append(emitted, ev)
+		delays = append(delays, delay)
+
+		return nil
+	}
+	var output map[string]any
+	var onFinish runtime.OnFinishHook = func(b []byte) error {
+		return json.Unmarshal(b, &output)
+	}
+
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:   uuid.New(),
+		Text:     script,
+		Fn:       "start",
+		Input:    "{}",
+		Metadata: map[string]string{core.EngineMappingPath: "/orders.wf.ts"},
+	}, onEmitEvent, onFinish)
+	require.NoError(t, err)
+
+	require.Len(t, emitted, 2)
+	require.Equal(t, emitted[0].ID(), output["id"])
+	require.Equal(t, "order.created", emitted[0].Type())
+	require.Equal(t, "/orders.wf.ts", emitted[0].Source())
+	require.JSONEq(t, `{"id":7}`, string(emitted[0].Data()))
+	require.Equal(t, "a", emitted[0].Extensions()["tenant"])
+	require.Equal(t, "reminders", emitted[1].Source())
+	require.Equal(t, []time.Duration{0, time.Hour}, delays)
+	require.Contains(t, output["failed"], "invalid emitEvent config")
+}
+
+func TestEmitEventIDs(t *testing.T) {
+	script := `
+		function start() {
+			emitEvent({type: "a", source: "test"})
+			return transition(next, {})
+		}
+
+		function next() {
+			emitEvent({type: "b", source: "test"})
+			emitEvent({type: "b", source: "test"})
+			return finish(true)
+		}
+	`
+
+	emit := func(sc *runtime.Script) []string {
+		var ids []string
+		var onEmitEvent runtime.OnEmitEventHook = func(ctx context.Context, ev *cloudevents.Event, delay time.Duration) error {
+			ids = append(ids, ev.ID())
+			return nil
+		}
+		var onTransition runtime.OnTransitionHook = func(memory []byte, fn string) error {
+			return nil
+		}
+		require.NoError(t, runtime.ExecScript(context.Background(), sc, onEmitEvent, onTransition))
+
+		return ids
+	}
+
+	instID := uuid.New()
+	first := emit(&runtime.Script{InstID: instID, Text: script, Fn: "start", Input: "{}"})
+	require.Len(t, first, 3)
+	require.Len(t, map[string]bool{first[0]: true, first[1]: true, first[2]: true}, 3)
+
+	// executing the state again emits its events with the same ids.
+	again := emit(&runtime.Script{InstID: instID, Text: script, Fn: "next", Input: "{}", Transitions: 1})
+	require.Equal(t, first[1:], again)
+
+	// retries and later visits of the state emit new events.
+	retried := emit(&runtime.Script{InstID: instID, Text: script, Fn: "next", Input: "{}", Transitions: 1, Attempt: 1})
+	require.NotContains(t, first, retried[0])
+	visited := emit(&runtime.Script{InstID: instID, Text: script, Fn: "next", Input: "{}", Transitions: 3})
+	require.NotContains(t, first, visited[0])
+	require.NotContains(t, retried, visited[0])
+}
@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/sobek"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// File is a file or directory in the namespace of the instance.
+type File struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	MIMEType string `json:"mimeType,omitempty"`
+	Size     int    `json:"size"`
+}
+
+type (
+	// OnGetFileHook returns the data of the file at path in the namespace of the instance.
+	OnGetFileHook func(ctx context.Context, path string) ([]byte, error)
+	// OnListFilesHook returns the files of the directory at path in the namespace of the instance.
+	OnListFilesHook func(ctx context.Context, path string) ([]*File, error)
+)
+
+// File encodings of getFile.
+const (
+	FileAsText   = "text"
+	FileAsJSON   = "json"
+	FileAsBase64 = "base64"
+)
+
+// GetFileOptions are the options of getFile.
+type GetFileOptions struct {
+	// As is the encoding the data is returned in, text by default.
+	As string `json:"as,omitempty"`
+}
+
+// getFile reads a file of the namespace and returns its data as text, parsed JSON or base64.
+func (rt *Runtime) getFile(path string, options sobek.Value) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling getFile", trace.WithAttributes(attribute.String("path", path)))
+
+	opts := GetFileOptions{As: FileAsText}
+	if options != nil && !sobek.IsUndefined(options) && !sobek.IsNull(options) {
+		var data any
+		if err := rt.vm.ExportTo(options, &data); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error exporting getFile options: %s", err.Error())))
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error marshaling getFile options: %s", err.Error())))
+		}
+		if err := json.Unmarshal(b, &opts); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid getFile options: %s", err.Error())))
+		}
+	}
+	if opts.As != FileAsText && opts.As != FileAsJSON && opts.As != FileAsBase64 {
+		panic(rt.vm.ToValue(fmt.Sprintf("invalid getFile encoding '%s', expected text, json or base64", opts.As)))
+	}
+
+	if rt.onGetFile == nil && rt.replay == nil {
+		panic(rt.vm.ToValue("getFile not supported"))
+	}
+
+	ctx := rt.tracingPack.ctx
+	data, err := blocking(rt, recorded(rt, RecordKindFile, path, func() ([]byte, error) {
+		return rt.onGetFile(ctx, path)
+	}))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+	rt.tracingPack.span.AddEvent("file read", trace.WithAttributes(
+		attribute.String("path", path),
+		attribute.Int("size", len(data)),
+	))
+	if rt.allocate(len(data)) != nil {
+		return sobek.Undefined()
+	}
+
+	switch opts.As {
+	case FileAsJSON:
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("file '%s' is not valid json: %s", path, err.Error())))
+		}
+
+		return rt.vm.ToValue(v)
+	case FileAsBase64:
+		return rt.vm.ToValue(base64.StdEncoding.EncodeToString(data))
+	default:
+		return rt.vm.ToValue(string(data))
+	}
+}
+
+// listFiles returns the files and directories of a directory of the namespace.
+func (rt *Runtime) listFiles(path string) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling listFiles", trace.WithAttributes(attribute.String("path", path)))
+
+	if rt.onListFiles == nil && rt.replay == nil {
+		panic(rt.vm.ToValue("listFiles not supported"))
+	}
+
+	ctx := rt.tracingPack.ctx
+	files, err := blocking(rt, recorded(rt, RecordKindFile, path, func() ([]*File, error) {
+		return rt.onListFiles(ctx, path)
+	}))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	// the JSON names are the property names of the script.
+	b, err := json.Marshal(files)
+	if err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling files: %s", err.Error())))
+	}
+	var list []any
+	if err := json.Unmarshal(b, &list); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error unmarshaling files: %s", err.Error())))
+	}
+	if list == nil {
+		list = []any{}
+	}
+
+	return rt.vm.ToValue(list)
+}
@@ -0,0 +1,85 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiles(t *testing.T) {
+	files := map[string]string{
+		"/data/table.json": `{"a":1}`,
+		"/data/mail.txt":   "hello",
+	}
+	var onGetFile runtime.OnGetFileHook = func(ctx context.Context, path string) ([]byte, error) {
+		data, ok := files[path]
+		if !ok {
+			return nil, &runtime.Error{Code: core.ErrorCodeFileNotFound, Message: fmt.Sprintf("file '%s' not found", path)}
+		}
+
+		return []byte(data), nil
+	}
+	var onListFiles runtime.OnListFilesHook = func(ctx context.Context, path string) ([]*runtime.File, error) {
+		return []*runtime.File{
+			{Path: "/data/mail.txt", Name: "mail.txt", Type: "file", MIMEType: "text/plain", Size: 5},
+			{Path: "/data/table.json", Name: "table.json", Type: "file", MIMEType: "application/json", Size: 7},
+		}, nil
+	}
+	var output map[string]any
+	var onFinish runtime.OnFinishHook = func(b []byte) error {
+		return json.Unmarshal(b, &output)
+	}
+	var records []*runtime.Record
+	var onRecord runtime.OnRecordHook = func(rec *runtime.Record) {
+		records = append(records, rec)
+	}
+
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text: `function start() {
+			let missing = ""
+			try {
+				getFile("/missing.txt")
+			} catch (e) {
+				missing = e.code
+			}
+
+			return finish({
+				text: getFile("/data/mail.txt"),
+				base64: getFile("/data/mail.txt", {as: "base64"}),
+				json: getFile("/data/table.json", {as: "json"}).a,
+				names: listFiles("/data").map(f => f.name),
+				missing: missing,
+			})
+		}`,
+		Fn:    "start",
+		Input: "{}",
+	}, onGetFile, onListFiles, onFinish, onRecord)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"text":    "hello",
+		"base64":  "aGVsbG8=",
+		"json":    float64(1),
+		"names":   []any{"mail.txt", "table.json"},
+		"missing": core.ErrorCodeFileNotFound,
+	}, output)
+
+	// file reads are replayed from the records.
+	require.Len(t, records, 5)
+	require.Equal(t, runtime.RecordKindFile, records[1].Kind)
+	require.Equal(t, "/data/mail.txt", records[1].Key)
+
+	err = runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text:   `function start() { getFile("/data/mail.txt", {as: "xml"}) }`,
+		Fn:     "start",
+		Input:  "{}",
+	}, onGetFile)
+	require.ErrorContains(t, err, "invalid getFile encoding 'xml'")
+}
@@ -0,0 +1,260 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/grafana/sobek"
+)
+
+// Limits guards the resources a script uses, zero values are not limited.
+type Limits struct {
+	// CPUTime limits the CPU time of the thread running the JavaScript of a state
+	// function. Calls the script waits for, e.g. actions, subflows or sleeps, are not
+	// counted.
+	CPUTime time.Duration
+	// Allocations limits the bytes of the values a state function creates. The data it
+	// reads, e.g. responses, files, variables and results of calls, and the strings and
+	// arrays grown by builtins repeat, padStart, padEnd and push are counted.
+	Allocations uint64
+	// MemorySize limits the size of the transition memory in bytes.
+	MemorySize int
+	// OutputSize limits the size of the finish output in bytes.
+	OutputSize int
+}
+
+// guardInterval is how often the guard checks the CPU time of a running script.
+const guardInterval = 10 * time.Millisecond
+
+// guard interrupts scripts exceeding the CPU time or allocation limit of their state. The
+// VM thread pauses the guard while it waits for calls, so only the time running
+// JavaScript is counted.
+type guard struct {
+	limits    Limits
+	interrupt func(err *Error)
+	// clock returns the CPU time of the thread running the script.
+	clock func() time.Duration
+
+	mu sync.Mutex
+	// used is the CPU time of the state up to mark, the clock at the last sample while
+	// running. allocs counts the bytes the state allocated.
+	used    time.Duration
+	mark    time.Duration
+	running bool
+	allocs  uint64
+	done    chan struct{}
+}
+
+// newGuard returns a guard of the calling thread, the VM must run locked to it.
+func newGuard(limits Limits, interrupt func(err *Error)) *guard {
+	return &guard{
+		limits:    limits,
+		interrupt: interrupt,
+		clock:     threadClock(),
+	}
+}
+
+// start starts guarding the script, the script runs JavaScript.
+func (g *guard) start() {
+	g.running = true
+	g.mark = g.clock()
+	if g.limits.CPUTime <= 0 {
+		return
+	}
+
+	g.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(guardInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := g.check(); err != nil {
+					g.interrupt(err)
+					return
+				}
+			case <-g.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop ends the guard.
+func (g *guard) stop() {
+	if g.done != nil {
+		close(g.done)
+	}
+}
+
+// reset starts counting for a new state.
+func (g *guard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.used = 0
+	g.allocs = 0
+	g.mark = g.clock()
+}
+
+// pause stops counting while the VM thread waits, resume continues counting.
+func (g *guard) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.running {
+		return
+	}
+	g.used += g.clock() - g.mark
+	g.running = false
+}
+
+func (g *guard) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.running = true
+	g.mark = g.clock()
+}
+
+// check returns the error to interrupt the script with if it exceeds the CPU time limit.
+func (g *guard) check() *Error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// a waiting script does not use CPU.
+	if !g.running {
+		return nil
+	}
+	now := g.clock()
+	g.used += now - g.mark
+	g.mark = now
+
+	if g.used > g.limits.CPUTime {
+		return &Error{
+			Code:    core.ErrorCodeLimitCPU,
+			Message: fmt.Sprintf("cpu time limit of %s for state exceeded", g.limits.CPUTime),
+		}
+	}
+
+	return nil
+}
+
+// allocate charges n bytes to the allocations of the state and returns the error to
+// interrupt the script with once they exceed the limit.
+func (g *guard) allocate(n int) *Error {
+	if g.limits.Allocations == 0 || n <= 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if uint64(n) > g.limits.Allocations-min(g.allocs, g.limits.Allocations) {
+		g.allocs = g.limits.Allocations + 1
+		return &Error{
+			Code:    core.ErrorCodeLimitMemory,
+			Message: fmt.Sprintf("allocation limit of %d bytes for state exceeded", g.limits.Allocations),
+		}
+	}
+	g.allocs += uint64(n)
+
+	return nil
+}
+
+// allocate charges n bytes the script creates to the allocation limit of the state. Once
+// the limit is exceeded, the script is interrupted and the limit error returned, the caller
+// must not create the value. The interruption can not be caught by the script.
+func (rt *Runtime) allocate(n int) error {
+	if err := rt.guard.allocate(n); err != nil {
+		rt.vm.Interrupt(err)
+		return err
+	}
+
+	return nil
+}
+
+// valueSize estimates the bytes a value takes in an array or string.
+func valueSize(v sobek.Value) int {
+	const slot = 16
+	if s, ok := v.Export().(string); ok {
+		return slot + len(s)
+	}
+
+	return slot
+}
+
+// mulSize returns a*b, saturated instead of overflowing.
+func mulSize(a, b int64) int {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > math.MaxInt/b {
+		return math.MaxInt
+	}
+
+	return int(a * b)
+}
+
+// guardAllocations wraps the builtins growing strings and arrays, their results are
+// charged to the allocation limit before they are created.
+func (rt *Runtime) guardAllocations() {
+	wrap := func(ctor, name string, size func(call sobek.FunctionCall) int) {
+		proto := rt.vm.Get(ctor).ToObject(rt.vm).Get("prototype").ToObject(rt.vm)
+		orig, ok := sobek.AssertFunction(proto.Get(name))
+		if !ok {
+			return
+		}
+		_ = proto.Set(name, func(call sobek.FunctionCall) sobek.Value {
+			if rt.allocate(size(call)) != nil {
+				return sobek.Undefined()
+			}
+			v, err := orig(call.This, call.Arguments...)
+			if err != nil {
+				panic(err)
+			}
+
+			return v
+		})
+	}
+
+	pad := func(call sobek.FunctionCall) int {
+		return int(call.Argument(0).ToInteger()) - len(call.This.String())
+	}
+	wrap("String", "repeat", func(call sobek.FunctionCall) int {
+		return mulSize(int64(len(call.This.String())), call.Argument(0).ToInteger())
+	})
+	wrap("String", "padStart", pad)
+	wrap("String", "padEnd", pad)
+	wrap("Array", "push", func(call sobek.FunctionCall) int {
+		n := 0
+		for _, arg := range call.Arguments {
+			n += valueSize(arg)
+		}
+
+		return n
+	})
+}
+
+// blocking runs call on the VM thread without counting it against the limits of the script.
+func blocking[T any](rt *Runtime, call func() (T, error)) (T, error) {
+	rt.guard.pause()
+	defer rt.guard.resume()
+
+	return call()
+}
+
+// checkSize throws a size limit error if data of what exceeds limit bytes.
+func (rt *Runtime) checkSize(what string, data []byte, limit int) {
+	if limit <= 0 || len(data) <= limit {
+		return
+	}
+
+	panic(rt.errorValue(&Error{
+		Code:    core.ErrorCodeLimitSize,
+		Message: fmt.Sprintf("%s of %d bytes exceeds the limit of %d bytes", what, len(data), limit),
+	}))
+}
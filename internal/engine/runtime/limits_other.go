@@ -0,0 +1,15 @@
+//go:build !linux
+
+package runtime
+
+import "time"
+
+// threadClock returns the wall-clock time since the call, other platforms do not expose the
+// CPU time of a thread to other threads.
+func threadClock() func() time.Duration {
+	start := time.Now()
+
+	return func() time.Duration {
+		return time.Since(start)
+	}
+}
@@ -0,0 +1,108 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	exec := func(script string, limits runtime.Limits, hooks ...any) error {
+		return runtime.ExecScript(context.Background(), &runtime.Script{
+			InstID: uuid.New(),
+			Text:   script,
+			Fn:     "start",
+			Input:  "{}",
+			Limits: limits,
+		}, hooks...)
+	}
+
+	// endless loops are interrupted, the script can not catch it.
+	err := exec(`function start() {
+		try {
+			while (true) {}
+		} catch (e) {
+			return finish("caught")
+		}
+	}`, runtime.Limits{CPUTime: 100 * time.Millisecond})
+	require.Error(t, err)
+	require.Equal(t, core.ErrorCodeLimitCPU, runtime.ErrorCode(err))
+
+	// waiting for calls is not counted, every state has its own limit.
+	var onSubflow runtime.OnSubflowHook = func(ctx context.Context, path string, input []byte) ([]byte, error) {
+		time.Sleep(150 * time.Millisecond)
+		return []byte(`1`), nil
+	}
+	var onTransition runtime.OnTransitionHook = func(memory []byte, fn string) error {
+		time.Sleep(150 * time.Millisecond)
+		return nil
+	}
+	require.NoError(t, exec(`function start() {
+		execSubflow("/sub.wf.ts", {})
+		return transition(next, {})
+	}
+	function next() {
+		execSubflow("/sub.wf.ts", {})
+		return finish(1)
+	}`, runtime.Limits{CPUTime: 100 * time.Millisecond}, onSubflow, onTransition))
+
+	// values the state creates are counted, the script can not catch the interruption.
+	err = exec(`function start() {
+		try {
+			const list = []
+			while (true) {
+				list.push({value: "x".repeat(1024)})
+			}
+		} catch (e) {
+			return finish("caught")
+		}
+	}`, runtime.Limits{Allocations: 32 << 20})
+	require.Error(t, err)
+	require.Equal(t, core.ErrorCodeLimitMemory, runtime.ErrorCode(err))
+	require.ErrorContains(t, err, "allocation limit of 33554432 bytes for state exceeded")
+
+	// oversized strings are refused before they are created.
+	err = exec(`function start() {
+		return finish("x".repeat(1 << 30).length)
+	}`, runtime.Limits{Allocations: 1 << 20})
+	require.Error(t, err)
+	require.Equal(t, core.ErrorCodeLimitMemory, runtime.ErrorCode(err))
+
+	// every state has its own allocations.
+	require.NoError(t, exec(`function start() {
+		"x".repeat(600 << 10)
+		return transition(next, {})
+	}
+	function next() {
+		"x".repeat(600 << 10)
+		return finish(1)
+	}`, runtime.Limits{Allocations: 1 << 20}))
+
+	// size limits can be caught.
+	var output string
+	var onFinish runtime.OnFinishHook = func(b []byte) error {
+		output = string(b)
+		return nil
+	}
+	require.NoError(t, exec(`function start() {
+		try {
+			return transition(next, "x".repeat(100))
+		} catch (e) {
+			return finish(e.code)
+		}
+	}
+	function next() {}`, runtime.Limits{MemorySize: 64}, onFinish))
+	require.JSONEq(t, `"`+core.ErrorCodeLimitSize+`"`, output)
+
+	err = exec(`function start() {
+		return finish("x".repeat(100))
+	}`, runtime.Limits{OutputSize: 64}, onFinish)
+	require.Error(t, err)
+	require.Equal(t, core.ErrorCodeLimitSize, runtime.ErrorCode(err))
+	require.ErrorContains(t, err, "output of 102 bytes exceeds the limit of 64 bytes")
+}
@@ -0,0 +1,228 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/grafana/sobek"
+	"github.com/sosodev/duration"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultLockTTL is how long locks and semaphore slots are held at most by default.
+const DefaultLockTTL = time.Minute
+
+// ReservedLockPrefix prefixes the keys of the slots the engine takes itself, e.g. for the
+// concurrency limits of flows. Scripts can not use these keys.
+const ReservedLockPrefix = "direktiv."
+
+// Lock is a slot of the namespace semaphore Key with Limit slots, locks have one slot.
+type Lock struct {
+	Key   string `json:"key"`
+	Limit int    `json:"limit"`
+	// TTL is how long the slot is held at most, slots of crashed holders free up once it expires.
+	TTL time.Duration `json:"ttl"`
+	// Wait is how long to wait for a free slot.
+	Wait time.Duration `json:"wait"`
+}
+
+type (
+	// OnAcquireLockHook waits for a free slot of the lock and returns the holder id of the
+	// acquired slot.
+	OnAcquireLockHook func(ctx context.Context, lock *Lock) (string, error)
+	// OnReleaseLockHook frees the slot of holder.
+	OnReleaseLockHook func(ctx context.Context, key string, holder string) error
+	// OnRenewLockHook extends the slot of holder by the TTL of lock. Slots are renewed
+	// while the function holding them runs.
+	OnRenewLockHook func(ctx context.Context, lock *Lock, holder string) error
+)
+
+// LockConfig is the configuration of withLock and withSemaphore.
+type LockConfig struct {
+	// TTL is an ISO8601 duration, DefaultLockTTL by default.
+	TTL string `json:"ttl,omitempty"`
+	// Wait is an ISO8601 duration, the TTL by default.
+	Wait string `json:"wait,omitempty"`
+}
+
+// withLock runs fn while holding the lock key: withLock(key, [config], fn).
+func (rt *Runtime) withLock(call sobek.FunctionCall) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling withLock")
+
+	options, fn := call.Argument(1), call.Argument(2)
+	if _, ok := sobek.AssertFunction(options); ok {
+		options, fn = sobek.Undefined(), options
+	}
+
+	return rt.withLease("withLock", call.Argument(0).String(), 1, options, fn)
+}
+
+// withSemaphore runs fn while holding one of limit slots of the semaphore key:
+// withSemaphore(key, limit, [config], fn).
+func (rt *Runtime) withSemaphore(call sobek.FunctionCall) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling withSemaphore")
+
+	options, fn := call.Argument(2), call.Argument(3)
+	if _, ok := sobek.AssertFunction(options); ok {
+		options, fn = sobek.Undefined(), options
+	}
+	limit := call.Argument(1).ToInteger()
+	if limit < 1 {
+		panic(rt.vm.ToValue("withSemaphore requires a limit of at least 1"))
+	}
+
+	return rt.withLease("withSemaphore", call.Argument(0).String(), int(limit), options, fn)
+}
+
+// withLease runs the function fn of the script holding a slot of the semaphore key and
+// returns its result. The slot is renewed while fn runs and released when fn returns or
+// throws.
+func (rt *Runtime) withLease(name string, key string, limit int, options sobek.Value, fn sobek.Value) sobek.Value {
+	callback, ok := sobek.AssertFunction(fn)
+	if !ok {
+		panic(rt.vm.ToValue(fmt.Sprintf("%s requires a function", name)))
+	}
+	if key == "" {
+		panic(rt.vm.ToValue(fmt.Sprintf("%s requires a key", name)))
+	}
+	if strings.HasPrefix(key, ReservedLockPrefix) {
+		panic(rt.vm.ToValue(fmt.Sprintf("%s keys starting with '%s' are reserved", name, ReservedLockPrefix)))
+	}
+
+	lock := rt.newLock(name, options)
+	lock.Key = key
+	lock.Limit = limit
+
+	if (rt.onAcquireLock == nil || rt.onReleaseLock == nil) && rt.replay == nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("%s not supported", name)))
+	}
+
+	ctx := rt.tracingPack.ctx
+	holder, err := blocking(rt, recorded(rt, RecordKindLock, key, func() (string, error) {
+		return rt.onAcquireLock(ctx, lock)
+	}))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+	rt.tracingPack.span.AddEvent("lock acquired", trace.WithAttributes(attribute.String("key", key)))
+
+	stopRenewal := rt.renewLease(ctx, lock, holder)
+
+	var thrown any
+	result, cbErr := callback(sobek.Undefined())
+	if cbErr != nil {
+		thrown = cbErr
+	} else if _, async := result.Export().(*sobek.Promise); async {
+		thrown = rt.vm.ToValue(fmt.Sprintf("%s does not support async functions", name))
+	}
+	if lost := stopRenewal(); lost != nil && thrown == nil {
+		thrown = rt.errorValue(lost)
+	}
+
+	// the state might be cancelled, the slot is released anyway.
+	releaseCtx := context.WithoutCancel(ctx)
+	_, err = blocking(rt, recorded(rt, RecordKindLock, key, func() (any, error) {
+		return nil, rt.onReleaseLock(releaseCtx, key, holder)
+	}))
+	rt.tracingPack.span.AddEvent("lock released", trace.WithAttributes(attribute.String("key", key)))
+	if thrown != nil {
+		panic(thrown)
+	}
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	return result
+}
+
+// renewLease renews the slot of holder every third of the TTL of lock until the returned
+// function is called, it returns the error the slot was lost with. Others might hold the
+// slot once it is lost, the script is interrupted right away.
+func (rt *Runtime) renewLease(ctx context.Context, lock *Lock, holder string) func() error {
+	if rt.onRenewLock == nil || rt.replay != nil {
+		return func() error { return nil }
+	}
+
+	done := make(chan struct{})
+	var lost error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(max(lock.TTL/3, time.Millisecond))
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			err := rt.onRenewLock(ctx, lock, holder)
+			if err != nil {
+				lost = &Error{
+					Code:    core.ErrorCodeLockTimeout,
+					Message: fmt.Sprintf("lock '%s' lost: %s", lock.Key, err.Error()),
+				}
+				rt.vm.Interrupt(lost)
+
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		wg.Wait()
+
+		return lost
+	}
+}
+
+// newLock parses the config of name.
+func (rt *Runtime) newLock(name string, options sobek.Value) *Lock {
+	var cfg LockConfig
+	if options != nil && !sobek.IsUndefined(options) && !sobek.IsNull(options) {
+		var data any
+		if err := rt.vm.ExportTo(options, &data); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error exporting %s config: %s", name, err.Error())))
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error marshaling %s config: %s", name, err.Error())))
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid %s config: %s", name, err.Error())))
+		}
+	}
+
+	lock := &Lock{TTL: DefaultLockTTL}
+	if cfg.TTL != "" {
+		d, err := duration.Parse(cfg.TTL)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid %s ttl: %s", name, err.Error())))
+		}
+		lock.TTL = d.ToTimeDuration()
+	}
+	if lock.TTL <= 0 {
+		panic(rt.vm.ToValue(fmt.Sprintf("invalid %s ttl: must be positive", name)))
+	}
+	lock.Wait = lock.TTL
+	if cfg.Wait != "" {
+		d, err := duration.Parse(cfg.Wait)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid %s wait: %s", name, err.Error())))
+		}
+		lock.Wait = d.ToTimeDuration()
+	}
+
+	return lock
+}
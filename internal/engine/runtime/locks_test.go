@@ -0,0 +1,155 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocks(t *testing.T) {
+	held := map[string]int{}
+	var acquired []*runtime.Lock
+	var onAcquireLock runtime.OnAcquireLockHook = func(ctx context.Context, lock *runtime.Lock) (string, error) {
+		if held[lock.Key] >= lock.Limit {
+			return "", &runtime.Error{Code: core.ErrorCodeLockTimeout, Message: fmt.Sprintf("lock '%s' not acquired", lock.Key)}
+		}
+		held[lock.Key]++
+		acquired = append(acquired, lock)
+
+		return fmt.Sprintf("%s-%d", lock.Key, held[lock.Key]), nil
+	}
+	var released []string
+	var onReleaseLock runtime.OnReleaseLockHook = func(ctx context.Context, key string, holder string) error {
+		held[key]--
+		released = append(released, holder)
+
+		return nil
+	}
+	var output map[string]any
+	var onFinish runtime.OnFinishHook = func(b []byte) error {
+		return json.Unmarshal(b, &output)
+	}
+
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text: `function start() {
+			const locked = withLock("db", {ttl: "PT30S", wait: "PT5S"}, () => "locked")
+
+			let thrown = ""
+			try {
+				withLock("db", () => { throw new Error("failed") })
+			} catch (e) {
+				thrown = e.message
+			}
+
+			const nested = withSemaphore("api", 2, () => withSemaphore("api", 2, () => {
+				try {
+					withSemaphore("api", 2, () => "third")
+				} catch (e) {
+					return e.code
+				}
+			}))
+
+			return finish({locked, thrown, nested})
+		}`,
+		Fn:    "start",
+		Input: "{}",
+	}, onAcquireLock, onReleaseLock, onFinish)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"locked": "locked",
+		"thrown": "failed",
+		"nested": core.ErrorCodeLockTimeout,
+	}, output)
+
+	// every acquired lock is released, also when the function throws.
+	require.Equal(t, []string{"db-1", "db-1", "api-2", "api-1"}, released)
+	require.Equal(t, &runtime.Lock{Key: "db", Limit: 1, TTL: 30 * time.Second, Wait: 5 * time.Second}, acquired[0])
+	require.Equal(t, &runtime.Lock{Key: "db", Limit: 1, TTL: runtime.DefaultLockTTL, Wait: runtime.DefaultLockTTL}, acquired[1])
+	require.Equal(t, 2, acquired[2].Limit)
+
+	for _, text := range []string{
+		`withLock("db", async () => 1)`,
+		`withLock("db", {ttl: "soon"}, () => 1)`,
+		`withSemaphore("api", 0, () => 1)`,
+		`withLock("db")`,
+		`withLock("direktiv.concurrency", () => 1)`,
+	} {
+		err = runtime.ExecScript(context.Background(), &runtime.Script{
+			InstID: uuid.New(),
+			Text:   fmt.Sprintf(`function start() { %s }`, text),
+			Fn:     "start",
+			Input:  "{}",
+		}, onAcquireLock, onReleaseLock)
+		require.Error(t, err, text)
+	}
+	require.Empty(t, held["db"])
+}
+
+func TestLockRenewal(t *testing.T) {
+	var onAcquireLock runtime.OnAcquireLockHook = func(ctx context.Context, lock *runtime.Lock) (string, error) {
+		return lock.Key, nil
+	}
+	var released []string
+	var onReleaseLock runtime.OnReleaseLockHook = func(ctx context.Context, key string, holder string) error {
+		released = append(released, holder)
+		return nil
+	}
+	var mu sync.Mutex
+	renewals := 0
+	var onRenewLock runtime.OnRenewLockHook = func(ctx context.Context, lock *runtime.Lock, holder string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		renewals++
+		if holder == "lost" {
+			return errors.New("the lease expired")
+		}
+
+		return nil
+	}
+	var output any
+	var onFinish runtime.OnFinishHook = func(b []byte) error {
+		return json.Unmarshal(b, &output)
+	}
+
+	// the lock is held longer than its TTL.
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text: `function start() {
+			return finish(withLock("held", {ttl: "PT0.1S"}, () => {
+				const end = Date.now() + 300
+				while (Date.now() < end) {}
+				return "done"
+			}))
+		}`,
+		Fn:    "start",
+		Input: "{}",
+	}, onAcquireLock, onReleaseLock, onRenewLock, onFinish)
+	require.NoError(t, err)
+	require.Equal(t, "done", output)
+	require.GreaterOrEqual(t, renewals, 2)
+
+	// losing the lock stops the function.
+	err = runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text: `function start() {
+			try {
+				withLock("lost", {ttl: "PT0.1S"}, () => { while (true) {} })
+			} catch (e) {}
+			return finish("unreachable")
+		}`,
+		Fn:    "start",
+		Input: "{}",
+	}, onAcquireLock, onReleaseLock, onRenewLock, onFinish)
+	require.Equal(t, core.ErrorCodeLockTimeout, runtime.ErrorCode(err))
+	require.Equal(t, []string{"held", "lost"}, released)
+}
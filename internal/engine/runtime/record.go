@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type RecordKind string
+
+const (
+	RecordKindAction   RecordKind = "action"
+	RecordKindService  RecordKind = "service"
+	RecordKindSubflow  RecordKind = "subflow"
+	RecordKindFetch    RecordKind = "fetch"
+	RecordKindNow      RecordKind = "now"
+	RecordKindVariable RecordKind = "variable"
+	RecordKindEvent    RecordKind = "event"
+	RecordKindFile     RecordKind = "file"
+	RecordKindLock     RecordKind = "lock"
+	// RecordKindSecret records that a secret was read, the value is redacted.
+	RecordKindSecret RecordKind = "secret"
+	// RecordKindWait records the result of a suspending call, see Script.Journal.
+	RecordKindWait RecordKind = "wait"
+)
+
+// redactedSecret is the value of secrets replayed without the secret in the metadata.
+const redactedSecret = "[redacted]"
+
+// Record is the result of a non-deterministic call of a script. Replaying the script
+// with its records reproduces the execution without calling external systems.
+type Record struct {
+	Kind RecordKind `json:"kind"`
+	// Key identifies the call within its kind, e.g. the url of a fetch.
+	Key    string          `json:"key,omitempty"`
+	Output json.RawMessage `json:"output,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// Code is the code of the error, if it has one.
+	Code string `json:"code,omitempty"`
+
+	done bool
+}
+
+// OnRecordHook receives the records of the calls of a script in the order the calls were made.
+type OnRecordHook func(rec *Record)
+
+// recorder passes records to the hook in call order, asynchronous calls might finish in any
+// order.
+type recorder struct {
+	mu      sync.Mutex
+	hook    OnRecordHook
+	pending []*Record
+}
+
+// reserve returns the record of a call of kind to key, nil if calls are not recorded.
+func (r *recorder) reserve(kind RecordKind, key string) *Record {
+	if r.hook == nil {
+		return nil
+	}
+
+	rec := &Record{Kind: kind, Key: key}
+	r.mu.Lock()
+	r.pending = append(r.pending, rec)
+	r.mu.Unlock()
+
+	return rec
+}
+
+// complete records the result of the call of rec.
+func (r *recorder) complete(rec *Record, output any, err error) {
+	if rec == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		rec.Error = err.Error()
+		rec.Code = ErrorCode(err)
+	} else if output != nil {
+		b, mErr := json.Marshal(output)
+		if mErr != nil {
+			rec.Error = fmt.Sprintf("could not marshal recorded output: %s", mErr.Error())
+		}
+		rec.Output = b
+	}
+	rec.done = true
+
+	for len(r.pending) > 0 && r.pending[0].done {
+		r.hook(r.pending[0])
+		r.pending = r.pending[1:]
+	}
+}
+
+// discard drops the record of a call that did not finish.
+func (r *recorder) discard(rec *Record) {
+	if rec == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, p := range r.pending {
+		if p == rec {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			break
+		}
+	}
+	for len(r.pending) > 0 && r.pending[0].done {
+		r.hook(r.pending[0])
+		r.pending = r.pending[1:]
+	}
+}
+
+// recorded returns call, recording its result. Replaying an execution, or resuming one
+// that already made the call, the returned call returns the recorded result instead. The
+// record is taken when recorded is called, so asynchronous calls replay in the order they
+// were made.
+func recorded[T any](rt *Runtime, kind RecordKind, key string, call func() (T, error)) func() (T, error) {
+	if rec := rt.resumed(kind, key); rec != nil {
+		return func() (T, error) {
+			var out T
+			err := rec.result(&out)
+
+			return out, err
+		}
+	}
+	if rt.replay != nil {
+		rec, err := rt.replay.next(kind, key)
+
+		return func() (T, error) {
+			var out T
+			if err != nil {
+				return out, err
+			}
+			err := rec.result(&out)
+
+			return out, err
+		}
+	}
+
+	rec := rt.recorder.reserve(kind, key)
+
+	return func() (T, error) {
+		out, err := call()
+		// the call suspended the script, the journal holds its result.
+		var susp *Suspension
+		if errors.As(err, &susp) {
+			rt.recorder.discard(rec)
+			return out, err
+		}
+		rt.recorder.complete(rec, out, err)
+
+		return out, err
+	}
+}
+
+// Replay feeds the records of an execution back to a script, see Script.Replay. Calls
+// take the records of their kind and key in the order they were recorded.
+type Replay struct {
+	mu      sync.Mutex
+	records map[string][]*Record
+}
+
+func NewReplay(records []*Record) *Replay {
+	r := &Replay{
+		records: make(map[string][]*Record),
+	}
+	for _, rec := range records {
+		k := replayKey(rec.Kind, rec.Key)
+		r.records[k] = append(r.records[k], rec)
+	}
+
+	return r
+}
+
+func replayKey(kind RecordKind, key string) string {
+	return string(kind) + " " + key
+}
+
+// next returns the next record of a call of kind to key.
+func (r *Replay) next(kind RecordKind, key string) (*Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := replayKey(kind, key)
+	list := r.records[k]
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no recorded result for %s", k)
+	}
+	r.records[k] = list[1:]
+
+	return list[0], nil
+}
+
+// take returns the next record of a call of kind to key, nil if none is left.
+func (r *Replay) take(kind RecordKind, key string) *Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := replayKey(kind, key)
+	list := r.records[k]
+	if len(list) == 0 {
+		return nil
+	}
+	r.records[k] = list[1:]
+
+	return list[0]
+}
+
+// resumed returns the record of a call the state function already made before it was
+// suspended, see Script.Resume. The call is not recorded again, its record is part of the
+// previous execution. Locks are released when the script suspends, they are acquired again.
+func (rt *Runtime) resumed(kind RecordKind, key string) *Record {
+	if rt.resume == nil || kind == RecordKindLock {
+		return nil
+	}
+
+	return rt.resume.take(kind, key)
+}
+
+// result returns the recorded output unmarshaled into v, or the recorded error. A nil v
+// only returns the error.
+func (rec *Record) result(v any) error {
+	if rec.Error != "" {
+		if rec.Code != "" {
+			return &Error{Code: rec.Code, Message: rec.Error}
+		}
+
+		return fmt.Errorf("%s", rec.Error)
+	}
+	if v == nil || len(rec.Output) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(rec.Output, v)
+}
@@ -0,0 +1,123 @@
+package runtime_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	script := `
+		async function start() {
+			const a = fetch("http://example.com/a")
+			const b = fetchSync("http://example.com/b")
+			const sub = execSubflow("/sub.wf.ts", {})
+			let failed = ""
+			try {
+				execSubflow("/broken.wf.ts", {})
+			} catch (e) {
+				failed = e.code
+			}
+			const out = {
+				a: (await a).text(),
+				b: b.status,
+				sub: sub,
+				failed: failed,
+				now: now().unix(),
+				secret: getSecret("token"),
+				variable: getVariable("namespace", "v"),
+			}
+
+			return finish(out)
+		}
+	`
+	secrets, err := json.Marshal(map[string]string{"token": base64.StdEncoding.EncodeToString([]byte("abc"))})
+	require.NoError(t, err)
+	sc := &runtime.Script{
+		InstID:   uuid.New(),
+		Text:     script,
+		Fn:       "start",
+		Input:    "{}",
+		Metadata: map[string]string{core.EngineMappingSecrets: string(secrets)},
+	}
+
+	var records []*runtime.Record
+	var onRecord runtime.OnRecordHook = func(rec *runtime.Record) {
+		records = append(records, rec)
+	}
+	var onFetch runtime.OnFetchHook = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Body:       io.NopCloser(bytes.NewReader([]byte(req.URL.Path))),
+		}, nil
+	}
+	var onSubflow runtime.OnSubflowHook = func(ctx context.Context, path string, input []byte) ([]byte, error) {
+		if path == "/broken.wf.ts" {
+			return nil, &runtime.Error{Code: "broken", Message: "subflow failed"}
+		}
+
+		return []byte(`{"done":true}`), nil
+	}
+	var onGetVariable runtime.OnGetVariableHook = func(ctx context.Context, scope, name string) ([]byte, error) {
+		return []byte("value"), nil
+	}
+	var recorded []byte
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		recorded = output
+		return nil
+	}
+
+	require.NoError(t, runtime.ExecScript(context.Background(), sc, onRecord, onFetch, onSubflow, onGetVariable, onFinish))
+
+	kinds := make([]runtime.RecordKind, len(records))
+	for i, rec := range records {
+		kinds[i] = rec.Kind
+	}
+	// records are passed in call order, no matter when the calls finished.
+	require.Equal(t, []runtime.RecordKind{
+		runtime.RecordKindFetch, runtime.RecordKindFetch, runtime.RecordKindSubflow, runtime.RecordKindSubflow,
+		runtime.RecordKindNow, runtime.RecordKindSecret, runtime.RecordKindVariable,
+	}, kinds)
+	require.Empty(t, records[5].Output, "secrets are redacted")
+	require.Equal(t, "broken", records[3].Code)
+
+	// the replay does not call any hook and ends the same way.
+	b, err := json.Marshal(records)
+	require.NoError(t, err)
+	var decoded []*runtime.Record
+	require.NoError(t, json.Unmarshal(b, &decoded))
+
+	var replayed []byte
+	onFinish = func(output []byte) error {
+		replayed = output
+		return nil
+	}
+	var onGetVariableReplayed runtime.OnGetVariableHook = func(ctx context.Context, scope, name string) ([]byte, error) {
+		return []byte("changed"), nil
+	}
+	sc.Replay = runtime.NewReplay(decoded)
+	require.NoError(t, runtime.ExecScript(context.Background(), sc, onGetVariableReplayed, onFinish))
+	require.JSONEq(t, string(recorded), string(replayed))
+
+	// without the secrets, the replay returns a placeholder.
+	sc.Metadata = nil
+	sc.Replay = runtime.NewReplay(decoded)
+	require.NoError(t, runtime.ExecScript(context.Background(), sc, onGetVariableReplayed, onFinish))
+	var out map[string]any
+	require.NoError(t, json.Unmarshal(replayed, &out))
+	require.Equal(t, "[redacted]", out["secret"])
+
+	// calls without records fail instead of calling external systems.
+	sc.Replay = runtime.NewReplay(nil)
+	err = runtime.ExecScript(context.Background(), sc, onFinish)
+	require.ErrorContains(t, err, "no recorded result for fetch")
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	goruntime "runtime"
 	"strings"
 	"time"
 
@@ -26,9 +28,53 @@ type Runtime struct {
 	onSubflow     OnSubflowHook
 	onSetVariable OnSetVariableHook
 	onGetVariable OnGetVariableHook
-	//nolint:containedctx // ctx is short-lived, only used during ExecScript; not stored long-term
-	ctx         context.Context
+	onCallAction  OnCallActionHook
+	onFetch       OnFetchHook
+	onEmitEvent   OnEmitEventHook
+	onWaitSignal  OnWaitSignalHook
+	onGetFile     OnGetFileHook
+	onListFiles   OnListFilesHook
+
+	onReadVariable   OnReadVariableHook
+	onListVariables  OnListVariablesHook
+	onWriteVariable  OnWriteVariableHook
+	onDeleteVariable OnDeleteVariableHook
+
+	onAcquireLock OnAcquireLockHook
+	onReleaseLock OnReleaseLockHook
+	onRenewLock   OnRenewLockHook
+
 	tracingPack *tracingPack
+
+	// journal holds the results of the suspending calls of the current state, step
+	// counts the suspending calls made by it.
+	journal []*JournalEntry
+	step    int
+
+	// fn, transitions and attempt identify the execution of the current state, emitted
+	// counts the events it emitted. Executing the state again emits them with the same ids.
+	fn          string
+	transitions int
+	attempt     int
+	emitted     int
+
+	// pending holds the asynchronous calls the event loop waits for, rejected the rejected
+	// promises without a handler.
+	pending  []*asyncOp
+	rejected []*sobek.Promise
+
+	// suspendable reports if the engine can park the instance.
+	suspendable bool
+
+	// recorder records the results of the non-deterministic calls, replay answers them
+	// with the results of a previous execution instead. resume answers the calls made
+	// before the last suspension of the state function.
+	recorder recorder
+	replay   *Replay
+	resume   *Replay
+
+	// guard enforces the limits of the script.
+	guard *guard
 }
 
 type (
@@ -38,6 +84,11 @@ type (
 	OnSubflowHook     func(ctx context.Context, path string, input []byte) ([]byte, error)
 	OnSetVariableHook func(ctx context.Context, scope string, name string, data []byte) error
 	OnGetVariableHook func(ctx context.Context, scope string, name string) ([]byte, error)
+	// OnCallActionHook answers the calls of actions and services instead of the service,
+	// e.g. to run flows offline.
+	OnCallActionHook func(ctx context.Context, sd *core.ServiceFileData, payload any) (any, error)
+	// OnFetchHook answers the requests of fetch and fetchSync instead of the network.
+	OnFetchHook func(req *http.Request) (*http.Response, error)
 )
 
 func New(instID uuid.UUID, metadata map[string]string, mappings string, hooks ...any) *Runtime {
@@ -54,6 +105,7 @@ func New(instID uuid.UUID, metadata map[string]string, mappings string, hooks ..
 		vm:       vm,
 		instID:   instID,
 		metadata: metadata,
+		guard:    newGuard(Limits{}, nil),
 	}
 
 	type setFunc struct {
@@ -74,9 +126,24 @@ func New(instID uuid.UUID, metadata map[string]string, mappings string, hooks ..
 		{"getSecrets", rt.secrets},
 		{"getSecret", rt.secret},
 		{"execSubflow", rt.execSubflow},
+		{"execSubflowAsync", rt.execSubflowAsync},
 		{"execService", rt.service},
+		{"execServiceAsync", rt.serviceAsync},
 		{"setVariable", rt.setVariable},
 		{"getVariable", rt.getVariable},
+		{"waitForEvent", rt.waitForEvent},
+		{"emitEvent", rt.emitEvent},
+		{"waitForSignal", rt.waitForSignal},
+		{"getFile", rt.getFile},
+		{"listFiles", rt.listFiles},
+		{"readVariable", rt.readVariable},
+		{"writeVariable", rt.writeVariable},
+		{"compareAndSetVariable", rt.compareAndSetVariable},
+		{"incrementVariable", rt.incrementVariable},
+		{"deleteVariable", rt.deleteVariable},
+		{"listVariables", rt.listVariables},
+		{"withLock", rt.withLock},
+		{"withSemaphore", rt.withSemaphore},
 	}
 
 	for _, v := range setList {
@@ -88,6 +155,7 @@ func New(instID uuid.UUID, metadata map[string]string, mappings string, hooks ..
 	for _, h := range hooks {
 		rt.setHook(h)
 	}
+	vm.SetPromiseRejectionTracker(rt.trackRejection)
 
 	return rt
 }
@@ -112,6 +180,34 @@ func (rt *Runtime) setHook(f any) *Runtime {
 		rt.onSetVariable = f
 	case OnGetVariableHook:
 		rt.onGetVariable = f
+	case OnCallActionHook:
+		rt.onCallAction = f
+	case OnFetchHook:
+		rt.onFetch = f
+	case OnRecordHook:
+		rt.recorder.hook = f
+	case OnEmitEventHook:
+		rt.onEmitEvent = f
+	case OnWaitSignalHook:
+		rt.onWaitSignal = f
+	case OnGetFileHook:
+		rt.onGetFile = f
+	case OnListFilesHook:
+		rt.onListFiles = f
+	case OnReadVariableHook:
+		rt.onReadVariable = f
+	case OnListVariablesHook:
+		rt.onListVariables = f
+	case OnWriteVariableHook:
+		rt.onWriteVariable = f
+	case OnDeleteVariableHook:
+		rt.onDeleteVariable = f
+	case OnAcquireLockHook:
+		rt.onAcquireLock = f
+	case OnReleaseLockHook:
+		rt.onReleaseLock = f
+	case OnRenewLockHook:
+		rt.onRenewLock = f
 
 	default:
 		panic(fmt.Sprintf("unknown hook type: %T", f))
@@ -123,51 +219,69 @@ func (rt *Runtime) setHook(f any) *Runtime {
 func (rt *Runtime) secret(secretName string) sobek.Value {
 	rt.tracingPack.span.AddEvent("fetching secret")
 
-	secretJsonMap := rt.metadata[core.EngineMappingSecrets]
-	us := make(map[string]string)
-	json.Unmarshal([]byte(secretJsonMap), &us)
-
-	value, ok := us[secretName]
-	if !ok {
-		panic(rt.vm.ToValue(fmt.Sprintf("error fetching secret %s: %s",
-			secretName, fmt.Errorf("secret not available"))))
-	}
-
-	decoded, err := base64.StdEncoding.DecodeString(value)
+	value, err := rt.lookupSecret(secretName)
 	if err != nil {
-		panic(rt.vm.ToValue(fmt.Sprintf("error fetching secret %s: %s",
-			secretName, err)))
+		panic(rt.vm.ToValue(fmt.Sprintf("error fetching secret %s: %s", secretName, err)))
 	}
 
-	return rt.vm.ToValue(string(decoded))
+	return rt.vm.ToValue(value)
 }
 
 func (rt *Runtime) secrets(secretNames []string) sobek.Value {
 	rt.tracingPack.span.AddEvent("fetching secrets")
 
-	secretJsonMap := rt.metadata[core.EngineMappingSecrets]
-
-	us := make(map[string]string)
-	json.Unmarshal([]byte(secretJsonMap), &us)
-
 	retSecrets := make(map[string]string)
 	for i := range secretNames {
-		value, ok := us[secretNames[i]]
-		if !ok {
-			panic(rt.vm.ToValue(fmt.Sprintf("error fetching secret %s: %s",
-				secretNames[i], fmt.Errorf("secret not available"))))
+		value, err := rt.lookupSecret(secretNames[i])
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error fetching secret %s: %s", secretNames[i], err)))
 		}
 
-		decoded, err := base64.StdEncoding.DecodeString(value)
+		retSecrets[secretNames[i]] = value
+	}
+
+	return rt.vm.ToValue(retSecrets)
+}
+
+// lookupSecret returns the secret name from the metadata. The lookup is recorded without
+// the value, replays without the secret in the metadata return a placeholder. Resumed
+// executions read the value again without recording the lookup twice.
+func (rt *Runtime) lookupSecret(name string) (string, error) {
+	us := make(map[string]string)
+	json.Unmarshal([]byte(rt.metadata[core.EngineMappingSecrets]), &us)
+
+	value, ok := us[name]
+	if rt.replay != nil {
+		rec, err := rt.replay.next(RecordKindSecret, name)
 		if err != nil {
-			panic(rt.vm.ToValue(fmt.Sprintf("error fetching secret %s: %s",
-				secretNames[i], err)))
+			return "", err
+		}
+		if err := rec.result(nil); err != nil {
+			return "", err
+		}
+		if !ok {
+			return redactedSecret, nil
 		}
+	}
+
+	var rec *Record
+	if rt.resumed(RecordKindSecret, name) == nil {
+		rec = rt.recorder.reserve(RecordKindSecret, name)
+	}
+	if !ok {
+		err := fmt.Errorf("secret not available")
+		rt.recorder.complete(rec, nil, err)
 
-		retSecrets[secretNames[i]] = string(decoded)
+		return "", err
 	}
 
-	return rt.vm.ToValue(retSecrets)
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	rt.recorder.complete(rec, nil, err)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
 }
 
 func (rt *Runtime) setVariable(scope string, name string, content string) sobek.Value {
@@ -180,7 +294,10 @@ func (rt *Runtime) setVariable(scope string, name string, content string) sobek.
 		panic(rt.vm.ToValue("setVariable not supported"))
 	}
 
-	err = rt.onSetVariable(rt.ctx, scope, name, data)
+	ctx := rt.tracingPack.ctx
+	_, err = blocking(rt, func() (any, error) {
+		return nil, rt.onSetVariable(ctx, scope, name, data)
+	})
 	if err != nil {
 		panic(rt.vm.ToValue(err.Error()))
 	}
@@ -189,11 +306,14 @@ func (rt *Runtime) setVariable(scope string, name string, content string) sobek.
 }
 
 func (rt *Runtime) getVariable(scope string, name string) sobek.Value {
-	if rt.onGetVariable == nil {
+	if rt.onGetVariable == nil && rt.replay == nil {
 		panic(rt.vm.ToValue("getVariable not supported"))
 	}
 
-	data, err := rt.onGetVariable(rt.ctx, scope, name)
+	ctx := rt.tracingPack.ctx
+	data, err := blocking(rt, recorded(rt, RecordKindVariable, scope+"/"+name, func() ([]byte, error) {
+		return rt.onGetVariable(ctx, scope, name)
+	}))
 	if err != nil {
 		panic(rt.vm.ToValue(err.Error()))
 	}
@@ -202,36 +322,24 @@ func (rt *Runtime) getVariable(scope string, name string) sobek.Value {
 	if data == nil {
 		return sobek.Null()
 	}
-
-	encoded := base64.StdEncoding.EncodeToString(data)
-
-	return rt.vm.ToValue(encoded)
-}
-
-func (rt *Runtime) sleep(seconds int) sobek.Value {
-	rt.tracingPack.span.AddEvent("calling sleep")
-
-	d := time.Duration(seconds) * time.Second
-	if d <= 0 {
+	if rt.allocate(len(data)) != nil {
 		return sobek.Undefined()
 	}
 
-	timer := time.NewTimer(d)
-	defer timer.Stop()
-	select {
-	case <-timer.C:
-	case <-rt.tracingPack.ctx.Done():
-		// Abort execution so the engine can mark the instance as cancelled.
-		panic(rt.vm.ToValue(rt.tracingPack.ctx.Err().Error()))
-	}
+	encoded := base64.StdEncoding.EncodeToString(data)
 
-	return sobek.Undefined()
+	return rt.vm.ToValue(encoded)
 }
 
 func (rt *Runtime) now() *sobek.Object {
 	rt.tracingPack.span.AddEvent("calling now")
 
-	t := time.Now()
+	t, err := recorded(rt, RecordKindNow, "", func() (time.Time, error) {
+		return time.Now(), nil
+	})()
+	if err != nil {
+		panic(rt.vm.ToValue(err.Error()))
+	}
 
 	obj := rt.vm.NewObject()
 
@@ -287,6 +395,7 @@ func (rt *Runtime) transition(call sobek.FunctionCall) sobek.Value {
 	if err != nil {
 		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling transition data: %s", err.Error())))
 	}
+	rt.checkSize("transition memory", b, rt.guard.limits.MemorySize)
 	var f string
 	if err := rt.vm.ExportTo(call.Arguments[0], &f); err != nil {
 		panic(rt.vm.ToValue(fmt.Sprintf("error exporting transition fn: %s", err.Error())))
@@ -299,7 +408,9 @@ func (rt *Runtime) transition(call sobek.FunctionCall) sobek.Value {
 	// otel: end previous and start new one
 	rt.tracingPack.tracingTransition(fName)
 	if rt.onTransition != nil {
-		err = rt.onTransition(b, fName)
+		_, err = blocking(rt, func() (any, error) {
+			return nil, rt.onTransition(b, fName)
+		})
 		if err != nil {
 			panic(rt.vm.ToValue(fmt.Sprintf("error calling onTransition hook: %s", err.Error())))
 		}
@@ -310,10 +421,21 @@ func (rt *Runtime) transition(call sobek.FunctionCall) sobek.Value {
 		panic(rt.vm.ToValue("first parameter of transition is not a function"))
 	}
 
+	// the journal and the resumed records belong to the state the script started with.
+	rt.journal = nil
+	rt.step = 0
+	rt.resume = nil
+	rt.guard.reset()
+	rt.fn = fName
+	rt.transitions++
+	rt.attempt = 0
+	rt.emitted = 0
+
 	value, err := fn(sobek.Undefined(), call.Arguments[1])
 	if err != nil {
 		exception := &sobek.Exception{}
-		if errors.As(err, &exception) {
+		interrupted := &sobek.InterruptedError{}
+		if errors.As(err, &exception) || errors.As(err, &interrupted) {
 			panic(err)
 		} else {
 			panic(rt.vm.ToValue(fmt.Sprintf("error executing transition: %s", err.Error())))
@@ -323,7 +445,49 @@ func (rt *Runtime) transition(call sobek.FunctionCall) sobek.Value {
 	return value
 }
 
+// execSubflow calls the subflow and waits for its output. A subflow that suspends itself
+// suspends the script, the call returns the journaled output once the subflow ended.
 func (rt *Runtime) execSubflow(call sobek.FunctionCall) sobek.Value {
+	path, input := rt.subflowArgs(call)
+	if rt.replay != nil {
+		out, err := rt.subflowCall(rt.tracingPack.ctx, path, input)()
+		if err != nil {
+			panic(rt.errorValue(err))
+		}
+
+		return rt.vm.ToValue(out)
+	}
+
+	step, j := rt.nextStep()
+	if j != nil {
+		rt.recordJournaled(RecordKindSubflow, path, j)
+		return rt.journaled(j)
+	}
+
+	ctx := context.WithValue(rt.tracingPack.ctx, canSuspendKey{}, true)
+	out, err := blocking(rt, rt.subflowCall(ctx, path, input))
+	var susp *Suspension
+	if errors.As(err, &susp) {
+		susp.Step = step
+		rt.vm.Interrupt(susp)
+
+		return sobek.Undefined()
+	}
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	return rt.vm.ToValue(out)
+}
+
+func (rt *Runtime) execSubflowAsync(call sobek.FunctionCall) sobek.Value {
+	path, input := rt.subflowArgs(call)
+
+	return rt.vm.ToValue(rt.runAsync(rt.subflowCall(rt.tracingPack.ctx, path, input), rt.vm.ToValue))
+}
+
+// subflowArgs validates the execSubflow arguments and returns the path and input of the subflow.
+func (rt *Runtime) subflowArgs(call sobek.FunctionCall) (string, []byte) {
 	if len(call.Arguments) != 2 {
 		panic(rt.vm.ToValue("exec requires a path, a function and a payload"))
 	}
@@ -342,20 +506,32 @@ func (rt *Runtime) execSubflow(call sobek.FunctionCall) sobek.Value {
 		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling execSubflow data: %s", err.Error())))
 	}
 
-	if rt.onSubflow == nil {
+	if rt.onSubflow == nil && rt.replay == nil {
 		panic(rt.vm.ToValue("onSubflow hook not set"))
 	}
-	out, err := rt.onSubflow(rt.tracingPack.ctx, path, b)
-	if err != nil {
-		panic(rt.vm.ToValue(fmt.Sprintf("error calling on subflow: %s", err.Error())))
-	}
 
-	err = json.Unmarshal(out, &memory)
-	if err != nil {
-		panic(rt.vm.ToValue(fmt.Sprintf("error unmarshaling execSubflow output: %s", err.Error())))
-	}
+	return path, b
+}
+
+// subflowCall returns the call of the subflow path with input.
+func (rt *Runtime) subflowCall(ctx context.Context, path string, input []byte) func() (any, error) {
+	return recorded(rt, RecordKindSubflow, path, func() (any, error) {
+		out, err := rt.onSubflow(ctx, path, input)
+		if err != nil {
+			return nil, fmt.Errorf("error calling on subflow: %w", err)
+		}
+		if err := rt.allocate(len(out)); err != nil {
+			return nil, err
+		}
+
+		var output any
+		err = json.Unmarshal(out, &output)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling execSubflow output: %w", err)
+		}
 
-	return rt.vm.ToValue(memory)
+		return output, nil
+	})
 }
 
 // TODO: remove return from finish() as it should be the last statement.
@@ -374,11 +550,14 @@ func (rt *Runtime) finish(data sobek.Value) sobek.Value {
 	if err != nil {
 		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling output: %s", err.Error())))
 	}
+	rt.checkSize("output", b, rt.guard.limits.OutputSize)
 
 	if rt.onFinish != nil {
-		err = rt.onFinish(b)
+		_, err = blocking(rt, func() (any, error) {
+			return nil, rt.onFinish(b)
+		})
 		if err != nil {
-			panic(rt.vm.ToValue(fmt.Sprintf("error calling onFinish hook: %s", err.Error())))
+			panic(rt.errorValue(fmt.Errorf("error calling onFinish hook: %w", err)))
 		}
 	}
 
@@ -406,6 +585,26 @@ type Script struct {
 	Fn       string
 	Input    string
 	Metadata map[string]string
+	// Journal holds the results of the suspending calls the state function Fn made
+	// in previous executions.
+	Journal []*JournalEntry
+	// Suspendable reports if the engine can park the instance. Subflows are executed
+	// synchronously by their parent and can not be parked.
+	Suspendable bool
+	// Replay answers the non-deterministic calls with the records of a previous execution,
+	// see OnRecordHook. Nil executes the calls.
+	Replay *Replay
+	// Transitions counts the transitions of the instance before it entered Fn, Attempt the
+	// retries of Fn. Together they identify the execution of Fn, e.g. in the ids of the
+	// events it emits.
+	Transitions int
+	Attempt     int
+	// Resume holds the records of the previous executions of Fn up to its last suspension.
+	// The calls made before the suspension return their recorded results instead of being
+	// made again, later calls are made and recorded.
+	Resume []*Record
+	// Limits guards the resources the script uses.
+	Limits Limits
 }
 
 func ExecScript(ctx context.Context, script *Script, hooks ...any) error {
@@ -415,6 +614,32 @@ func ExecScript(ctx context.Context, script *Script, hooks ...any) error {
 	defer tp.finish()
 
 	rt := New(script.InstID, script.Metadata, script.Mappings, hooks...).WithTracingPack(tp)
+	rt.journal = script.Journal
+	rt.fn = script.Fn
+	rt.transitions = script.Transitions
+	rt.attempt = script.Attempt
+	rt.suspendable = script.Suspendable
+	rt.replay = script.Replay
+	if len(script.Resume) > 0 && script.Replay == nil {
+		rt.resume = NewReplay(script.Resume)
+	}
+	// the guard reads the CPU time of the thread running the script.
+	goruntime.LockOSThread()
+	defer goruntime.UnlockOSThread()
+	rt.guard = newGuard(script.Limits, func(err *Error) {
+		rt.vm.Interrupt(err)
+	})
+	if script.Limits.Allocations > 0 {
+		rt.guardAllocations()
+	}
+	rt.guard.start()
+	defer rt.guard.stop()
+
+	// scripts busy computing do not check the context, interrupt them.
+	stop := context.AfterFunc(ctx, func() {
+		rt.vm.Interrupt(context.Cause(ctx))
+	})
+	defer stop()
 
 	tp.tracingStart(script.Fn)
 	telemetry.LogInstance(tp.ctx, telemetry.LogLevelInfo,
@@ -436,7 +661,15 @@ func ExecScript(ctx context.Context, script *Script, hooks ...any) error {
 	}
 
 	_, err = start(sobek.Undefined(), rt.vm.ToValue(inputMap))
+	if err == nil {
+		err = rt.runEventLoop()
+	}
 	if err != nil {
+		// a suspended instance is not an error, the engine parks it.
+		var s *Suspension
+		if errors.As(err, &s) {
+			return s
+		}
 		rt.tracingPack.handleError(err)
 		return fmt.Errorf("invoke start: %w", err)
 	}
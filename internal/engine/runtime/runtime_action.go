@@ -24,9 +24,24 @@ import (
 
 const defaultTimeout = 5 * time.Minute
 
+// maxErrorBody limits the body of a failed call used as error message.
+const maxErrorBody = 4096
+
 func (rt *Runtime) service(c map[string]any) sobek.Value {
-	// func (rt *Runtime) service(t, path string, payload any, retries int) sobek.Value {
+	data, err := blocking(rt, rt.serviceCall(c))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	return rt.vm.ToValue(data)
+}
+
+func (rt *Runtime) serviceAsync(c map[string]any) *sobek.Promise {
+	return rt.runAsync(rt.serviceCall(c), rt.vm.ToValue)
+}
 
+// serviceCall validates the execService configuration c and returns the call of the service.
+func (rt *Runtime) serviceCall(c map[string]any) func() (any, error) {
 	t, ok := c["scope"]
 	if !ok {
 		panic(rt.vm.ToValue(fmt.Errorf("scope not provided, must be namespace or system")))
@@ -95,15 +110,14 @@ func (rt *Runtime) service(c map[string]any) sobek.Value {
 		panic(rt.vm.ToValue(fmt.Errorf("unknown scope for script call")))
 	}
 
-	telemetry.LogInstance(rt.tracingPack.ctx, telemetry.LogLevelInfo,
-		fmt.Sprintf("executing service %s in scope %s", path, t))
+	ctx := rt.tracingPack.ctx
 
-	data, err := rt.callAction(sd, payload, int(retries), endDuration, nil)
-	if err != nil {
-		panic(rt.vm.ToValue(err))
-	}
+	return recorded(rt, RecordKindService, fmt.Sprintf("%s %s", t, path), func() (any, error) {
+		telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+			fmt.Sprintf("executing service %s in scope %s", path, t))
 
-	return rt.vm.ToValue(data)
+		return rt.callAction(ctx, sd, payload, int(retries), endDuration, nil)
+	})
 }
 
 func (rt *Runtime) action(c map[string]any) sobek.Value {
@@ -129,10 +143,8 @@ func (rt *Runtime) action(c map[string]any) sobek.Value {
 	}
 	sd.Name = sd.GetValueHash()
 
-	actionFunc := func(payload any, timeout string) sobek.Value {
-		telemetry.LogInstance(rt.tracingPack.ctx, telemetry.LogLevelInfo,
-			fmt.Sprintf("executing action with image %s", config.Image))
-
+	// actionCall validates the timeout and returns the call of the action.
+	actionCall := func(payload any, timeout string) func() (any, error) {
 		endDuration := defaultTimeout
 
 		if timeout != "" {
@@ -144,21 +156,46 @@ func (rt *Runtime) action(c map[string]any) sobek.Value {
 			endDuration = to.ToTimeDuration()
 		}
 
-		telemetry.LogInstance(rt.tracingPack.ctx, telemetry.LogLevelInfo,
-			fmt.Sprintf("action timeout in %s", endDuration.String()))
+		ctx := rt.tracingPack.ctx
 
-		data, err := rt.callAction(sd, payload, config.Retries, endDuration, config.Auth)
+		return recorded(rt, RecordKindAction, config.Image, func() (any, error) {
+			telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+				fmt.Sprintf("executing action with image %s", config.Image))
+			telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+				fmt.Sprintf("action timeout in %s", endDuration.String()))
+
+			return rt.callAction(ctx, sd, payload, config.Retries, endDuration, config.Auth)
+		})
+	}
+
+	actionFunc := func(payload any, timeout string) sobek.Value {
+		data, err := blocking(rt, actionCall(payload, timeout))
 		if err != nil {
-			panic(rt.vm.ToValue(err))
+			panic(rt.errorValue(err))
 		}
 
 		return rt.vm.ToValue(data)
 	}
+	actionAsyncFunc := func(payload any, timeout string) *sobek.Promise {
+		return rt.runAsync(actionCall(payload, timeout), rt.vm.ToValue)
+	}
+
+	// the action is called directly, action.async(payload) returns a promise.
+	fn := rt.vm.ToValue(actionFunc).ToObject(rt.vm)
+	if err := fn.Set("async", actionAsyncFunc); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error setting async action: %s", err.Error())))
+	}
 
-	return rt.vm.ToValue(actionFunc)
+	return fn
 }
 
-func (rt *Runtime) callAction(sd *core.ServiceFileData, payload any, retries int, timeout time.Duration, auth *core.BasicAuthConfig) (any, error) {
+// callAction calls the service or action sd. It does not use the VM and can be called
+// concurrently to the script.
+func (rt *Runtime) callAction(ctx context.Context, sd *core.ServiceFileData, payload any, retries int, timeout time.Duration, auth *core.BasicAuthConfig) (any, error) {
+	if rt.onCallAction != nil {
+		return rt.onCallAction(ctx, sd, payload)
+	}
+
 	if rt.onAction != nil {
 		err := rt.onAction(sd.GetID())
 		if err != nil {
@@ -166,19 +203,22 @@ func (rt *Runtime) callAction(sd *core.ServiceFileData, payload any, retries int
 		}
 	}
 
-	telemetry.LogInstance(rt.tracingPack.ctx, telemetry.LogLevelInfo, "connecting to action/service")
+	telemetry.LogInstance(ctx, telemetry.LogLevelInfo, "connecting to action/service")
 
 	svcUrl := fmt.Sprintf("http://%s.%s.svc", sd.GetID(), os.Getenv("DIREKTIV_SERVICE_NAMESPACE"))
 
 	// ping service
-	_, err := callRetryable(rt.tracingPack.ctx, svcUrl+"/up", http.MethodGet, []byte(""), 29, 10*time.Second, auth)
+	_, err := callRetryable(ctx, svcUrl+"/up", http.MethodGet, []byte(""), 29, 10*time.Second, auth)
 	if err != nil {
 		slog.Error("could not connect to service or action", slog.Any("error", err))
-		return nil, fmt.Errorf("cannot connect to service or action, please check action/service deployment")
+		return nil, &Error{
+			Code:    core.ErrorCodeActionUnavailable,
+			Message: "cannot connect to service or action, please check action/service deployment",
+		}
 		// panic(rt.vm.ToValue(fmt.Errorf("action did not start: %s", err.Error())))
 	}
 
-	telemetry.LogInstance(rt.tracingPack.ctx, telemetry.LogLevelInfo, "action ping successful, calling action")
+	telemetry.LogInstance(ctx, telemetry.LogLevelInfo, "action ping successful, calling action")
 
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -186,14 +226,18 @@ func (rt *Runtime) callAction(sd *core.ServiceFileData, payload any, retries int
 		return nil, fmt.Errorf("could not marshal payload for action: %s", err.Error())
 	}
 
-	outData, err := callRetryable(rt.tracingPack.ctx, svcUrl, http.MethodPost, data, retries, timeout, auth)
+	outData, err := callRetryable(ctx, svcUrl, http.MethodPost, data, retries, timeout, auth)
 	if err != nil {
 		slog.Error("could not call service or action", slog.Any("error", err))
 		// panic(rt.vm.ToValue(fmt.Errorf("calling action failed: %s", err.Error())))
-		return nil, fmt.Errorf("calling action failed: %s", err.Error())
+		return nil, fmt.Errorf("calling action failed: %w", err)
 	}
 
-	telemetry.LogInstance(rt.tracingPack.ctx, telemetry.LogLevelInfo, "action call successful")
+	telemetry.LogInstance(ctx, telemetry.LogLevelInfo, "action call successful")
+
+	if err := rt.allocate(len(outData)); err != nil {
+		return nil, err
+	}
 
 	var d any
 	err = json.Unmarshal(outData, &d)
@@ -213,6 +257,8 @@ func callRetryable(ctx context.Context, url, method string, payload []byte, retr
 	client.RetryWaitMax = 2 * time.Second
 	client.HTTPClient.Timeout = timeout // total timeout per request
 	// client.Logger = nil                          // silence internal
+	// return the last response once the retries are exhausted, it carries the error.
+	client.ErrorHandler = retryablehttp.PassthroughErrorHandler
 
 	req, err := retryablehttp.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
 	if err != nil {
@@ -237,8 +283,11 @@ func callRetryable(ctx context.Context, url, method string, payload []byte, retr
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, fmt.Errorf("request exceeded timeout of %s", timeout.String())
+		if errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err) {
+			return nil, &Error{
+				Code:    core.ErrorCodeActionTimeout,
+				Message: fmt.Sprintf("request exceeded timeout of %s", timeout.String()),
+			}
 		}
 		if errors.Is(err, context.Canceled) {
 			return nil, fmt.Errorf("request cancelled: %w", err)
@@ -248,7 +297,15 @@ func callRetryable(ctx context.Context, url, method string, payload []byte, retr
 	}
 	defer resp.Body.Close()
 
-	// TODO error handling, read headers
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg := resp.Header.Get(core.EngineHeaderErrorMessage)
+		if msg == "" {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+			msg = string(bytes.TrimSpace(body))
+		}
+
+		return nil, httpError(resp.StatusCode, resp.Header.Get(core.EngineHeaderErrorCode), msg)
+	}
 
 	return io.ReadAll(resp.Body)
 }
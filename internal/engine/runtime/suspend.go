@@ -0,0 +1,343 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grafana/sobek"
+	"github.com/sosodev/duration"
+)
+
+type SuspensionKind string
+
+const (
+	// SuspensionKindEvent waits for cloud events, see waitForEvent.
+	SuspensionKindEvent SuspensionKind = "event"
+	// SuspensionKindSleep waits until the deadline, see sleep.
+	SuspensionKindSleep SuspensionKind = "sleep"
+	// SuspensionKindSignal waits for a signal sent to the instance, see waitForSignal.
+	SuspensionKindSignal SuspensionKind = "signal"
+	// SuspensionKindRetry waits for the backoff of a failed state, the engine suspends
+	// the instance itself.
+	SuspensionKindRetry SuspensionKind = "retry"
+	// SuspensionKindConcurrency keeps a pending instance waiting until its flow is below
+	// the concurrency limit, the engine suspends the instance itself.
+	SuspensionKindConcurrency SuspensionKind = "concurrency"
+	// SuspensionKindSubflow waits for a subflow that suspended itself, see CanSuspend.
+	SuspensionKindSubflow SuspensionKind = "subflow"
+)
+
+// maxBlockingSleep is the longest sleep that blocks the worker instead of suspending the instance.
+const maxBlockingSleep = 5 * time.Second
+
+// ErrWaitTimeout is the error a suspending call throws when its deadline passes.
+const ErrWaitTimeout = "wait timed out"
+
+// Suspension interrupts a script when a state waits for something outside the runtime.
+// The engine parks the instance and calls the state function again once the wait is
+// resolved, the result is passed in with Script.Journal.
+type Suspension struct {
+	Kind SuspensionKind
+	// Step is the index of the suspending call within the state function.
+	Step   int
+	Params json.RawMessage `json:",omitempty"`
+	// Deadline is the time the wait times out, zero waits forever.
+	Deadline time.Time
+}
+
+func (s *Suspension) Error() string {
+	return fmt.Sprintf("instance suspended at step %d waiting for %s", s.Step, s.Kind)
+}
+
+// JournalEntry is the result of a suspending call. Replaying a state function returns
+// the journaled results instead of suspending again.
+type JournalEntry struct {
+	Step   int
+	Output json.RawMessage `json:",omitempty"`
+	Error  string          `json:",omitempty"`
+	// Code is the code of Error, if it has one.
+	Code string `json:",omitempty"`
+}
+
+// WaitForEventConfig configures the events waitForEvent waits for.
+type WaitForEventConfig struct {
+	Types []string `json:"types"`
+	// Context filters the events of all types by their context attributes.
+	Context map[string]string `json:"context,omitempty"`
+	// All waits for events of all types instead of any of them.
+	All bool `json:"all,omitempty"`
+	// Timeout is an ISO8601 duration.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// WaitForSignalConfig configures the signal waitForSignal waits for.
+type WaitForSignalConfig struct {
+	Name string `json:"name"`
+	// Timeout is an ISO8601 duration.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// OnWaitSignalHook blocks until the signal the suspension s waits for is received and
+// returns its payload. Instances the engine can not park wait for signals with it. The
+// hook returns s as error to suspend the script instead, see CanSuspend.
+type OnWaitSignalHook func(ctx context.Context, s *Suspension) (json.RawMessage, error)
+
+type canSuspendKey struct{}
+
+// CanSuspend reports if a hook called with ctx may suspend the script by returning a
+// *Suspension as error. The script is interrupted with it and the call returns the
+// journaled result once the state function is called again. Only calls whose result
+// can be journaled, e.g. synchronous subflows, can suspend.
+func CanSuspend(ctx context.Context) bool {
+	ok, _ := ctx.Value(canSuspendKey{}).(bool)
+
+	return ok
+}
+
+// nextStep returns the index of the next suspending call of the current state and its
+// journaled result, if the state already finished it in a previous execution.
+func (rt *Runtime) nextStep() (int, *JournalEntry) {
+	step := rt.step
+	rt.step++
+
+	for _, j := range rt.journal {
+		if j.Step == step {
+			return step, j
+		}
+	}
+
+	return step, nil
+}
+
+// journaled returns the result of a suspending call recorded in j.
+func (rt *Runtime) journaled(j *JournalEntry) sobek.Value {
+	if j.Error != "" && j.Code != "" {
+		panic(rt.errorValue(&Error{Code: j.Code, Message: j.Error}))
+	}
+	if j.Error != "" {
+		panic(rt.vm.ToValue(j.Error))
+	}
+	if len(j.Output) == 0 || rt.allocate(len(j.Output)) != nil {
+		return sobek.Undefined()
+	}
+
+	var output any
+	if err := json.Unmarshal(j.Output, &output); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error unmarshaling journaled result: %s", err.Error())))
+	}
+
+	return rt.vm.ToValue(output)
+}
+
+// suspend returns the journaled result if the current state already finished the
+// suspending call s. Otherwise, the script is interrupted with s. The interruption
+// can not be caught by the script.
+func (rt *Runtime) suspend(s *Suspension) sobek.Value {
+	if rt.replay != nil {
+		rec, err := rt.replay.next(RecordKindWait, string(s.Kind))
+		if err != nil {
+			panic(rt.vm.ToValue(err.Error()))
+		}
+
+		return rt.journaled(&JournalEntry{Output: rec.Output, Error: rec.Error})
+	}
+
+	step, j := rt.nextStep()
+	if j != nil {
+		rt.recordWait(s.Kind, j)
+		return rt.journaled(j)
+	}
+
+	s.Step = step
+	rt.vm.Interrupt(s)
+
+	return sobek.Undefined()
+}
+
+// recordWait records the journaled result j of a suspending call of kind, unless a
+// previous execution already recorded it.
+func (rt *Runtime) recordWait(kind SuspensionKind, j *JournalEntry) {
+	rt.recordJournaled(RecordKindWait, string(kind), j)
+}
+
+// recordJournaled records the journaled result j of a call of kind to key, unless a
+// previous execution already recorded it.
+func (rt *Runtime) recordJournaled(kind RecordKind, key string, j *JournalEntry) {
+	if rt.resumed(kind, key) != nil {
+		return
+	}
+	rec := rt.recorder.reserve(kind, key)
+
+	var output any
+	if len(j.Output) > 0 {
+		output = j.Output
+	}
+	var err error
+	if j.Error != "" && j.Code != "" {
+		err = &Error{Code: j.Code, Message: j.Error}
+	} else if j.Error != "" {
+		err = errors.New(j.Error)
+	}
+	rt.recorder.complete(rec, output, err)
+}
+
+func (rt *Runtime) waitForEvent(config sobek.Value) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling waitForEvent")
+
+	if !rt.suspendable {
+		panic(rt.vm.ToValue("waitForEvent is not supported in subflows"))
+	}
+
+	var data any
+	if err := rt.vm.ExportTo(config, &data); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error exporting waitForEvent config: %s", err.Error())))
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling waitForEvent config: %s", err.Error())))
+	}
+	var cfg WaitForEventConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("invalid waitForEvent config: %s", err.Error())))
+	}
+	if len(cfg.Types) == 0 {
+		panic(rt.vm.ToValue("waitForEvent requires at least one event type"))
+	}
+
+	params, err := json.Marshal(cfg)
+	if err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling waitForEvent config: %s", err.Error())))
+	}
+
+	s := &Suspension{
+		Kind:   SuspensionKindEvent,
+		Params: params,
+	}
+	if cfg.Timeout != "" {
+		d, err := duration.Parse(cfg.Timeout)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid waitForEvent timeout: %s", err.Error())))
+		}
+		s.Deadline = time.Now().UTC().Add(d.ToTimeDuration())
+	}
+
+	return rt.suspend(s)
+}
+
+// sleep suspends the instance for long sleeps, so the worker is free meanwhile. Short
+// sleeps and sleeps of instances that can not be suspended block.
+func (rt *Runtime) sleep(seconds int) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling sleep")
+
+	// replays do not wait, the recorded calls after the sleep return right away.
+	d := time.Duration(seconds) * time.Second
+	if d <= 0 || rt.replay != nil {
+		return sobek.Undefined()
+	}
+
+	step, j := rt.nextStep()
+	if j != nil {
+		return rt.journaled(j)
+	}
+
+	if rt.suspendable && d > maxBlockingSleep {
+		rt.vm.Interrupt(&Suspension{
+			Kind:     SuspensionKindSleep,
+			Step:     step,
+			Deadline: time.Now().UTC().Add(d),
+		})
+
+		return sobek.Undefined()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	rt.guard.pause()
+	defer rt.guard.resume()
+	select {
+	case <-timer.C:
+	case <-rt.tracingPack.ctx.Done():
+		// Abort execution so the engine can mark the instance as cancelled.
+		panic(rt.vm.ToValue(rt.tracingPack.ctx.Err().Error()))
+	}
+
+	return sobek.Undefined()
+}
+
+// waitForSignal waits for the signal name sent to the instance and returns its payload.
+// Instances that can not be suspended block until the signal is received.
+func (rt *Runtime) waitForSignal(name string, options sobek.Value) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling waitForSignal")
+
+	if name == "" {
+		panic(rt.vm.ToValue("waitForSignal requires a signal name"))
+	}
+
+	cfg := WaitForSignalConfig{Name: name}
+	if options != nil && !sobek.IsUndefined(options) && !sobek.IsNull(options) {
+		var data any
+		if err := rt.vm.ExportTo(options, &data); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error exporting waitForSignal options: %s", err.Error())))
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error marshaling waitForSignal options: %s", err.Error())))
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid waitForSignal options: %s", err.Error())))
+		}
+		cfg.Name = name
+	}
+	params, err := json.Marshal(cfg)
+	if err != nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error marshaling waitForSignal config: %s", err.Error())))
+	}
+
+	s := &Suspension{
+		Kind:   SuspensionKindSignal,
+		Params: params,
+	}
+	if cfg.Timeout != "" {
+		d, err := duration.Parse(cfg.Timeout)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid waitForSignal timeout: %s", err.Error())))
+		}
+		s.Deadline = time.Now().UTC().Add(d.ToTimeDuration())
+	}
+
+	if rt.suspendable || rt.replay != nil {
+		return rt.suspend(s)
+	}
+
+	step, j := rt.nextStep()
+	if j != nil {
+		rt.recordWait(s.Kind, j)
+		return rt.journaled(j)
+	}
+	if rt.onWaitSignal == nil {
+		panic(rt.vm.ToValue("waitForSignal not supported"))
+	}
+
+	s.Step = step
+	output, err := blocking(rt, func() (json.RawMessage, error) {
+		return rt.onWaitSignal(rt.tracingPack.ctx, s)
+	})
+	var susp *Suspension
+	if errors.As(err, &susp) {
+		rt.vm.Interrupt(susp)
+		return sobek.Undefined()
+	}
+	j = &JournalEntry{
+		Step:   step,
+		Output: output,
+	}
+	if err != nil {
+		j.Error = err.Error()
+	}
+	rt.recordWait(s.Kind, j)
+
+	return rt.journaled(j)
+}
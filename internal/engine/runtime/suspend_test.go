@@ -0,0 +1,290 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForEvent(t *testing.T) {
+	script := `
+		function start() {
+			return transition(wait, {count: 1})
+		}
+
+		function wait(payload) {
+			try {
+				const ev = waitForEvent({types: ["greeting"], timeout: "PT1M"})
+				payload.source = ev.source
+			} catch (e) {
+				payload.error = e
+			}
+
+			return finish(payload)
+		}
+	`
+
+	var fns []string
+	var onTransition runtime.OnTransitionHook = func(memory []byte, fn string) error {
+		fns = append(fns, fn)
+		return nil
+	}
+	var gotOutput string
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		gotOutput = string(output)
+		return nil
+	}
+
+	// the wait can not be caught by the script.
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        script,
+		Fn:          "start",
+		Input:       "{}",
+		Suspendable: true,
+	}, onTransition, onFinish)
+	var s *runtime.Suspension
+	require.True(t, errors.As(err, &s))
+	require.Equal(t, runtime.SuspensionKindEvent, s.Kind)
+	require.Equal(t, 0, s.Step)
+	require.False(t, s.Deadline.IsZero())
+	require.Equal(t, []string{"wait"}, fns)
+	require.Empty(t, gotOutput)
+
+	var cfg runtime.WaitForEventConfig
+	require.NoError(t, json.Unmarshal(s.Params, &cfg))
+	require.Equal(t, []string{"greeting"}, cfg.Types)
+
+	tests := []struct {
+		name  string
+		entry *runtime.JournalEntry
+		want  string
+	}{
+		{"event", &runtime.JournalEntry{Step: 0, Output: []byte(`{"source":"src"}`)}, `{"count":1,"source":"src"}`},
+		{"timeout", &runtime.JournalEntry{Step: 0, Error: runtime.ErrWaitTimeout}, `{"count":1,"error":"wait timed out"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotOutput = ""
+			err := runtime.ExecScript(context.Background(), &runtime.Script{
+				InstID:      uuid.New(),
+				Text:        script,
+				Fn:          "wait",
+				Input:       `{"count":1}`,
+				Suspendable: true,
+				Journal:     []*runtime.JournalEntry{tt.entry},
+			}, onTransition, onFinish)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.want, gotOutput)
+		})
+	}
+}
+
+func TestWaitForEventSteps(t *testing.T) {
+	script := `
+		function start() {
+			const a = waitForEvent({types: ["a"]})
+			const b = waitForEvent({types: ["b"]})
+
+			return finish([a, b])
+		}
+	`
+
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        script,
+		Fn:          "start",
+		Input:       "{}",
+		Suspendable: true,
+		Journal:     []*runtime.JournalEntry{{Step: 0, Output: []byte(`"a"`)}},
+	})
+	var s *runtime.Suspension
+	require.True(t, errors.As(err, &s))
+	require.Equal(t, 1, s.Step)
+	require.True(t, s.Deadline.IsZero())
+
+	err = runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        `function start() { waitForEvent({}) }`,
+		Fn:          "start",
+		Input:       "{}",
+		Suspendable: true,
+	})
+	require.Error(t, err)
+	require.False(t, errors.As(err, &s))
+}
+
+func TestWaitResume(t *testing.T) {
+	script := `
+		function start() {
+			const before = getVariable("namespace", "v")
+			const ev = waitForEvent({types: ["greeting"]})
+			const after = getVariable("namespace", "v")
+
+			return finish({before, source: ev.source, after})
+		}
+	`
+
+	calls := 0
+	var onGetVariable runtime.OnGetVariableHook = func(ctx context.Context, scope, name string) ([]byte, error) {
+		calls++
+		return []byte{byte('0' + calls)}, nil
+	}
+	var records []*runtime.Record
+	var onRecord runtime.OnRecordHook = func(rec *runtime.Record) {
+		records = append(records, rec)
+	}
+	var gotOutput string
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		gotOutput = string(output)
+		return nil
+	}
+
+	sc := &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        script,
+		Fn:          "start",
+		Input:       "{}",
+		Suspendable: true,
+	}
+	err := runtime.ExecScript(context.Background(), sc, onGetVariable, onRecord, onFinish)
+	var s *runtime.Suspension
+	require.True(t, errors.As(err, &s))
+	require.Len(t, records, 1)
+
+	// the call before the wait returns its recorded result instead of being made again.
+	sc.Journal = []*runtime.JournalEntry{{Step: 0, Output: []byte(`{"source":"src"}`)}}
+	sc.Resume = records
+	records = nil
+	require.NoError(t, runtime.ExecScript(context.Background(), sc, onGetVariable, onRecord, onFinish))
+	require.Equal(t, 2, calls)
+	require.JSONEq(t, `{"before":"MQ==","source":"src","after":"Mg=="}`, gotOutput)
+
+	// the execution records the wait and the calls after it only.
+	kinds := make([]runtime.RecordKind, len(records))
+	for i, rec := range records {
+		kinds[i] = rec.Kind
+	}
+	require.Equal(t, []runtime.RecordKind{runtime.RecordKindWait, runtime.RecordKindVariable}, kinds)
+}
+
+func TestSleep(t *testing.T) {
+	script := `
+		function start() {
+			sleep(60)
+			return finish("done")
+		}
+	`
+
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        script,
+		Fn:          "start",
+		Input:       "{}",
+		Suspendable: true,
+	})
+	var s *runtime.Suspension
+	require.True(t, errors.As(err, &s))
+	require.Equal(t, runtime.SuspensionKindSleep, s.Kind)
+	require.WithinDuration(t, time.Now().Add(time.Minute), s.Deadline, 5*time.Second)
+
+	var gotOutput string
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		gotOutput = string(output)
+		return nil
+	}
+	err = runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        script,
+		Fn:          "start",
+		Input:       "{}",
+		Journal:     []*runtime.JournalEntry{{Step: 0}},
+		Suspendable: true,
+	}, onFinish)
+	require.NoError(t, err)
+	require.Equal(t, `"done"`, gotOutput)
+}
+
+func TestWaitForEventInSubflow(t *testing.T) {
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text: `function start() {
+			try {
+				waitForEvent({types: ["a"]})
+			} catch (e) {
+				return finish(e)
+			}
+		}`,
+		Fn:    "start",
+		Input: "{}",
+	})
+	require.NoError(t, err)
+}
+
+func TestWaitForSignal(t *testing.T) {
+	script := `
+		function start() {
+			try {
+				return finish(waitForSignal("approval", {timeout: "PT1H"}))
+			} catch (e) {
+				return finish(e)
+			}
+		}
+	`
+
+	var gotOutput string
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		gotOutput = string(output)
+		return nil
+	}
+
+	err := runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID:      uuid.New(),
+		Text:        script,
+		Fn:          "start",
+		Input:       "{}",
+		Suspendable: true,
+	}, onFinish)
+	var s *runtime.Suspension
+	require.True(t, errors.As(err, &s))
+	require.Equal(t, runtime.SuspensionKindSignal, s.Kind)
+	require.False(t, s.Deadline.IsZero())
+
+	var cfg runtime.WaitForSignalConfig
+	require.NoError(t, json.Unmarshal(s.Params, &cfg))
+	require.Equal(t, runtime.WaitForSignalConfig{Name: "approval", Timeout: "PT1H"}, cfg)
+
+	// subflows can not be suspended, they block in the hook.
+	var waited *runtime.Suspension
+	var onWaitSignal runtime.OnWaitSignalHook = func(ctx context.Context, s *runtime.Suspension) (json.RawMessage, error) {
+		waited = s
+		return json.RawMessage(`{"by":"jane"}`), nil
+	}
+	err = runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text:   script,
+		Fn:     "start",
+		Input:  "{}",
+	}, onFinish, onWaitSignal)
+	require.NoError(t, err)
+	require.Equal(t, runtime.SuspensionKindSignal, waited.Kind)
+	require.JSONEq(t, `{"by":"jane"}`, gotOutput)
+
+	onWaitSignal = func(ctx context.Context, s *runtime.Suspension) (json.RawMessage, error) {
+		return nil, errors.New(runtime.ErrWaitTimeout)
+	}
+	err = runtime.ExecScript(context.Background(), &runtime.Script{
+		InstID: uuid.New(),
+		Text:   script,
+		Fn:     "start",
+		Input:  "{}",
+	}, onFinish, onWaitSignal)
+	require.NoError(t, err)
+	require.JSONEq(t, `"wait timed out"`, gotOutput)
+}
@@ -0,0 +1,305 @@
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/grafana/sobek"
+)
+
+// Variable is a runtime variable of the namespace, workflow or instance scope.
+type Variable struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+	// Version increases with every write of the variable.
+	Version int64  `json:"version"`
+	Data    []byte `json:"data,omitempty"`
+}
+
+// AnyVersion writes a variable regardless of its version.
+const AnyVersion int64 = -1
+
+// maxIncrementAttempts is how often incrementVariable retries conflicting writes.
+const maxIncrementAttempts = 16
+
+type (
+	// OnReadVariableHook returns the variable of scope with its data, nil if it does not exist.
+	OnReadVariableHook func(ctx context.Context, scope string, name string) (*Variable, error)
+	// OnListVariablesHook returns the variables of scope without their data.
+	OnListVariablesHook func(ctx context.Context, scope string) ([]*Variable, error)
+	// OnWriteVariableHook writes v to scope if the variable has version, 0 if it must not
+	// exist, or AnyVersion. It returns the written variable, nil if the version does not match.
+	OnWriteVariableHook func(ctx context.Context, scope string, v *Variable, version int64) (*Variable, error)
+	// OnDeleteVariableHook deletes the variable of scope and reports if it existed.
+	OnDeleteVariableHook func(ctx context.Context, scope string, name string) (bool, error)
+)
+
+// WriteVariableOptions are the options of writeVariable and compareAndSetVariable.
+type WriteVariableOptions struct {
+	// MimeType defaults to application/json, the value is stored as JSON. Text types store
+	// the string value, other types the base64 decoded string value.
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+func isJSONMimeType(mimeType string) bool {
+	t, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
+
+// variableValue returns the value of v for the script: JSON is parsed, text is a string
+// and other types are base64 encoded.
+func (rt *Runtime) variableValue(v *Variable) sobek.Value {
+	if rt.allocate(len(v.Data)) != nil {
+		return sobek.Undefined()
+	}
+	switch {
+	case isJSONMimeType(v.MimeType):
+		var value any
+		if err := json.Unmarshal(v.Data, &value); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("variable '%s' is not valid json: %s", v.Name, err.Error())))
+		}
+
+		return rt.vm.ToValue(value)
+	case strings.HasPrefix(v.MimeType, "text/"):
+		return rt.vm.ToValue(string(v.Data))
+	default:
+		return rt.vm.ToValue(base64.StdEncoding.EncodeToString(v.Data))
+	}
+}
+
+// variableObject returns the variable v for the script, with its value if withValue is set.
+func (rt *Runtime) variableObject(v *Variable, withValue bool) *sobek.Object {
+	obj := rt.vm.NewObject()
+	_ = obj.Set("name", v.Name)
+	_ = obj.Set("mimeType", v.MimeType)
+	_ = obj.Set("size", v.Size)
+	_ = obj.Set("version", v.Version)
+	if withValue {
+		_ = obj.Set("value", rt.variableValue(v))
+	}
+
+	return obj
+}
+
+// newVariable encodes value of the script as the data of the variable name.
+func (rt *Runtime) newVariable(fn string, name string, value sobek.Value, options sobek.Value) *Variable {
+	var opts WriteVariableOptions
+	if options != nil && !sobek.IsUndefined(options) && !sobek.IsNull(options) {
+		var data any
+		if err := rt.vm.ExportTo(options, &data); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error exporting %s options: %s", fn, err.Error())))
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error marshaling %s options: %s", fn, err.Error())))
+		}
+		if err := json.Unmarshal(b, &opts); err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("invalid %s options: %s", fn, err.Error())))
+		}
+	}
+	if opts.MimeType == "" {
+		opts.MimeType = "application/json"
+	}
+
+	v := &Variable{Name: name, MimeType: opts.MimeType}
+	switch {
+	case isJSONMimeType(opts.MimeType):
+		data, err := json.Marshal(value.Export())
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error marshaling %s value: %s", fn, err.Error())))
+		}
+		v.Data = data
+	case strings.HasPrefix(opts.MimeType, "text/"):
+		v.Data = []byte(value.String())
+	default:
+		data, err := base64.StdEncoding.DecodeString(value.String())
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("%s value of type %s is not base64", fn, opts.MimeType)))
+		}
+		v.Data = data
+	}
+	v.Size = len(v.Data)
+
+	return v
+}
+
+func (rt *Runtime) readVariableHook(scope string, name string) (*Variable, error) {
+	ctx := rt.tracingPack.ctx
+
+	return blocking(rt, recorded(rt, RecordKindVariable, scope+"/"+name, func() (*Variable, error) {
+		return rt.onReadVariable(ctx, scope, name)
+	}))
+}
+
+func (rt *Runtime) writeVariableHook(scope string, v *Variable, version int64) (*Variable, error) {
+	ctx := rt.tracingPack.ctx
+
+	return blocking(rt, recorded(rt, RecordKindVariable, scope+"/"+v.Name, func() (*Variable, error) {
+		return rt.onWriteVariable(ctx, scope, v, version)
+	}))
+}
+
+func (rt *Runtime) checkVariableHooks(fn string, read bool, write bool) {
+	if rt.replay != nil {
+		return
+	}
+	if (read && rt.onReadVariable == nil) || (write && rt.onWriteVariable == nil) {
+		panic(rt.vm.ToValue(fmt.Sprintf("%s not supported", fn)))
+	}
+}
+
+// readVariable returns the variable with its value, null if it does not exist.
+func (rt *Runtime) readVariable(scope string, name string) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling readVariable")
+	rt.checkVariableHooks("readVariable", true, false)
+
+	v, err := rt.readVariableHook(scope, name)
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+	if v == nil {
+		return sobek.Null()
+	}
+
+	return rt.variableObject(v, true)
+}
+
+// writeVariable writes the variable and returns its new version.
+func (rt *Runtime) writeVariable(scope string, name string, value sobek.Value, options sobek.Value) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling writeVariable")
+	rt.checkVariableHooks("writeVariable", false, true)
+
+	v, err := rt.writeVariableHook(scope, rt.newVariable("writeVariable", name, value, options), AnyVersion)
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+	if v == nil {
+		panic(rt.vm.ToValue(fmt.Sprintf("error writing variable '%s'", name)))
+	}
+
+	return rt.vm.ToValue(v.Version)
+}
+
+// compareAndSetVariable writes the variable if it has version, 0 if it must not exist. It
+// reports if the variable was written.
+func (rt *Runtime) compareAndSetVariable(scope string, name string, version int64, value sobek.Value,
+	options sobek.Value,
+) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling compareAndSetVariable")
+	rt.checkVariableHooks("compareAndSetVariable", false, true)
+
+	if version < 0 {
+		panic(rt.vm.ToValue("compareAndSetVariable requires a version of 0 or more"))
+	}
+
+	v, err := rt.writeVariableHook(scope, rt.newVariable("compareAndSetVariable", name, value, options), version)
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	return rt.vm.ToValue(v != nil)
+}
+
+// incrementVariable adds delta to the JSON number of the variable and returns the sum,
+// missing variables start at 0. Concurrent increments are retried.
+func (rt *Runtime) incrementVariable(scope string, name string, delta sobek.Value) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling incrementVariable")
+	rt.checkVariableHooks("incrementVariable", true, true)
+
+	d := 1.0
+	if delta != nil && !sobek.IsUndefined(delta) {
+		d = delta.ToFloat()
+	}
+
+	for range maxIncrementAttempts {
+		current, err := rt.readVariableHook(scope, name)
+		if err != nil {
+			panic(rt.errorValue(err))
+		}
+
+		var n float64
+		var version int64
+		if current != nil {
+			if !isJSONMimeType(current.MimeType) || json.Unmarshal(current.Data, &n) != nil {
+				panic(rt.vm.ToValue(fmt.Sprintf("variable '%s' is not a json number", name)))
+			}
+			version = current.Version
+		}
+		n += d
+
+		data, err := json.Marshal(n)
+		if err != nil {
+			panic(rt.vm.ToValue(fmt.Sprintf("error marshaling variable '%s': %s", name, err.Error())))
+		}
+		v, err := rt.writeVariableHook(scope, &Variable{
+			Name:     name,
+			MimeType: "application/json",
+			Size:     len(data),
+			Data:     data,
+		}, version)
+		if err != nil {
+			panic(rt.errorValue(err))
+		}
+		if v != nil {
+			return rt.vm.ToValue(n)
+		}
+	}
+
+	panic(rt.errorValue(&Error{
+		Code:    core.ErrorCodeVariableConflict,
+		Message: fmt.Sprintf("variable '%s' changed concurrently %d times", name, maxIncrementAttempts),
+	}))
+}
+
+// deleteVariable deletes the variable and reports if it existed.
+func (rt *Runtime) deleteVariable(scope string, name string) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling deleteVariable")
+
+	if rt.onDeleteVariable == nil && rt.replay == nil {
+		panic(rt.vm.ToValue("deleteVariable not supported"))
+	}
+
+	ctx := rt.tracingPack.ctx
+	deleted, err := blocking(rt, recorded(rt, RecordKindVariable, scope+"/"+name, func() (bool, error) {
+		return rt.onDeleteVariable(ctx, scope, name)
+	}))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	return rt.vm.ToValue(deleted)
+}
+
+// listVariables returns the variables of scope without their values.
+func (rt *Runtime) listVariables(scope string) sobek.Value {
+	rt.tracingPack.span.AddEvent("calling listVariables")
+
+	if rt.onListVariables == nil && rt.replay == nil {
+		panic(rt.vm.ToValue("listVariables not supported"))
+	}
+
+	ctx := rt.tracingPack.ctx
+	list, err := blocking(rt, recorded(rt, RecordKindVariable, scope, func() ([]*Variable, error) {
+		return rt.onListVariables(ctx, scope)
+	}))
+	if err != nil {
+		panic(rt.errorValue(err))
+	}
+
+	values := make([]any, 0, len(list))
+	for _, v := range list {
+		values = append(values, rt.variableObject(v, false))
+	}
+
+	return rt.vm.ToValue(values)
+}
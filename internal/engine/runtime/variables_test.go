@@ -0,0 +1,145 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryVariables stores the variables of a scope, conflicts simulates concurrent writes
+// failing the next compare and set calls.
+type memoryVariables struct {
+	vars      map[string]*runtime.Variable
+	conflicts int
+}
+
+func (m *memoryVariables) hooks() []any {
+	var onRead runtime.OnReadVariableHook = func(ctx context.Context, scope string, name string) (*runtime.Variable, error) {
+		v, ok := m.vars[name]
+		if !ok {
+			return nil, nil
+		}
+		read := *v
+
+		return &read, nil
+	}
+	var onWrite runtime.OnWriteVariableHook = func(ctx context.Context, scope string, v *runtime.Variable, version int64) (*runtime.Variable, error) {
+		var current int64
+		if stored, ok := m.vars[v.Name]; ok {
+			current = stored.Version
+		}
+		if version != runtime.AnyVersion && m.conflicts > 0 {
+			m.conflicts--
+			return nil, nil
+		}
+		if version != runtime.AnyVersion && version != current {
+			return nil, nil
+		}
+		stored := *v
+		stored.Version = current + 1
+		m.vars[v.Name] = &stored
+
+		return &stored, nil
+	}
+	var onDelete runtime.OnDeleteVariableHook = func(ctx context.Context, scope string, name string) (bool, error) {
+		_, ok := m.vars[name]
+		delete(m.vars, name)
+
+		return ok, nil
+	}
+	var onList runtime.OnListVariablesHook = func(ctx context.Context, scope string) ([]*runtime.Variable, error) {
+		var list []*runtime.Variable
+		for _, name := range []string{"config", "counter", "key", "raw"} {
+			if v, ok := m.vars[name]; ok {
+				list = append(list, &runtime.Variable{Name: v.Name, MimeType: v.MimeType, Size: v.Size, Version: v.Version})
+			}
+		}
+
+		return list, nil
+	}
+
+	return []any{onRead, onWrite, onDelete, onList}
+}
+
+func TestVariables(t *testing.T) {
+	store := &memoryVariables{vars: map[string]*runtime.Variable{}}
+	var output map[string]any
+	var onFinish runtime.OnFinishHook = func(b []byte) error {
+		return json.Unmarshal(b, &output)
+	}
+	exec := func(script string) error {
+		return runtime.ExecScript(context.Background(), &runtime.Script{
+			InstID: uuid.New(),
+			Text:   script,
+			Fn:     "start",
+			Input:  "{}",
+		}, append(store.hooks(), onFinish)...)
+	}
+
+	err := exec(`function start() {
+		writeVariable("namespace", "config", {retries: 3})
+		writeVariable("namespace", "raw", "aGVsbG8=", {mimeType: "application/octet-stream"})
+		const created = compareAndSetVariable("namespace", "key", 0, "first", {mimeType: "text/plain"})
+		const duplicate = compareAndSetVariable("namespace", "key", 0, "second", {mimeType: "text/plain"})
+		incrementVariable("namespace", "counter")
+		const counter = incrementVariable("namespace", "counter", 2)
+		const config = readVariable("namespace", "config")
+
+		return finish({
+			config: config.value,
+			version: config.version,
+			mimeType: config.mimeType,
+			raw: readVariable("namespace", "raw").value,
+			key: readVariable("namespace", "key").value,
+			created: created,
+			duplicate: duplicate,
+			counter: counter,
+			names: listVariables("namespace").map(v => v.name + ":" + v.version),
+			deleted: deleteVariable("namespace", "raw"),
+			missing: readVariable("namespace", "raw"),
+		})
+	}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"config":    map[string]any{"retries": float64(3)},
+		"version":   float64(1),
+		"mimeType":  "application/json",
+		"raw":       "aGVsbG8=",
+		"key":       "first",
+		"created":   true,
+		"duplicate": false,
+		"counter":   float64(3),
+		"names":     []any{"config:1", "counter:2", "key:1", "raw:1"},
+		"deleted":   true,
+		"missing":   nil,
+	}, output)
+	require.JSONEq(t, `{"retries":3}`, string(store.vars["config"].Data))
+	require.Equal(t, "first", string(store.vars["key"].Data))
+	require.JSONEq(t, `3`, string(store.vars["counter"].Data))
+
+	// increments retry concurrent writes.
+	store.conflicts = 2
+	require.NoError(t, exec(`function start() {
+		return finish({counter: incrementVariable("namespace", "counter")})
+	}`))
+	require.Equal(t, float64(4), output["counter"])
+
+	store.conflicts = 100
+	err = exec(`function start() {
+		incrementVariable("namespace", "counter")
+	}`)
+	require.Error(t, err)
+	require.Equal(t, core.ErrorCodeVariableConflict, runtime.ErrorCode(err))
+
+	store.conflicts = 0
+	store.vars["raw"] = &runtime.Variable{Name: "raw", MimeType: "text/plain", Data: []byte("x")}
+	err = exec(`function start() {
+		incrementVariable("namespace", "raw")
+	}`)
+	require.ErrorContains(t, err, "variable 'raw' is not a json number")
+}
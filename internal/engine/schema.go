@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+)
+
+// ErrInvalidInput is returned starting an instance with input that is not JSON or does not
+// match the input schema of its flow. Schema violations are wrapped as *core.SchemaError.
+var ErrInvalidInput = errors.New("invalid input")
+
+// validateInput checks the input of a new instance against the input schema of flow.
+func validateInput(flow core.TypescriptFlow, input string) error {
+	if flow.Config.Input == nil {
+		return nil
+	}
+
+	err := core.ValidateSchema(flow.Config.Input.Schema, []byte(input))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	return nil
+}
+
+// ValidateOutput checks the output of an instance against the output schema of its flow
+// stored in metadata. A mismatch fails the instance with code core.ErrorCodeOutputInvalid.
+func ValidateOutput(metadata map[string]string, output []byte) error {
+	schema := metadata[core.EngineMappingOutputSchema]
+	if schema == "" {
+		return nil
+	}
+
+	err := core.ValidateSchema(json.RawMessage(schema), output)
+	if err != nil {
+		return &runtime.Error{
+			Code:    core.ErrorCodeOutputInvalid,
+			Message: fmt.Sprintf("output %s", err.Error()),
+		}
+	}
+
+	return nil
+}
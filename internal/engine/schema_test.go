@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartWorkflowSchemas(t *testing.T) {
+	comp := &fakeCompiler{flows: map[string]core.TypescriptFlow{
+		"/flow.wf.ts": {
+			Script: `function stateOne(input) { return finish(input.count > 1 ? {total: input.count} : {}) }`,
+			Config: core.FlowConfig{
+				Timeout: "PT1M",
+				State:   "stateOne",
+				Input: &core.FlowSchema{Schema: json.RawMessage(`{
+					"type": "object",
+					"required": ["count"],
+					"properties": {"count": {"type": "integer", "minimum": 1}, "name": {"type": "string"}}
+				}`)},
+				Output: &core.FlowSchema{Schema: json.RawMessage(`{"type": "object", "required": ["total"]}`)},
+			},
+		},
+	}}
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus, compiler: comp}
+
+	start := func(input string) error {
+		_, _, err := e.StartWorkflow(context.Background(), uuid.New(), "ns", "/flow.wf.ts", input, map[string]string{
+			LabelWithScope:    "main",
+			LabelWithSyncExec: "true",
+		})
+
+		return err
+	}
+
+	// invalid input is rejected before the instance is created.
+	err := start(`{"count": 0, "name": 1}`)
+	require.ErrorIs(t, err, ErrInvalidInput)
+	var schemaErr *core.SchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	require.Len(t, schemaErr.Violations, 2)
+	require.Contains(t, schemaErr.Violations, "/count")
+	require.Contains(t, schemaErr.Violations, "/name")
+	require.ErrorIs(t, start(`not json`), ErrInvalidInput)
+	require.Empty(t, bus.history)
+
+	require.NoError(t, start(`{"count": 2}`))
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `{"total": 2}`, string(end.Output))
+
+	// output not matching the output schema fails the instance.
+	require.NoError(t, start(`{"count": 1}`))
+	end = bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeFailed, end.State)
+	require.Equal(t, core.ErrorCodeOutputInvalid, end.ErrorCode)
+	require.Contains(t, end.Error, `property "total" is missing`)
+}
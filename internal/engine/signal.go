@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/internal/telemetry"
+	"github.com/google/uuid"
+)
+
+// PendingSignal is a signal an instance or one of its subflows waits for.
+type PendingSignal struct {
+	InstanceID uuid.UUID
+	Name       string
+	// Deadline is the time the wait times out, zero waits forever.
+	Deadline time.Time
+}
+
+// PendingSignal returns the name of the signal the instance waits for, empty if it does
+// not wait for one.
+func (e *InstanceEvent) PendingSignal() string {
+	if e.State != StateCodeRunning || e.Suspension == nil || e.Suspension.Kind != runtime.SuspensionKindSignal {
+		return ""
+	}
+
+	var cfg runtime.WaitForSignalConfig
+	if err := json.Unmarshal(e.Suspension.Params, &cfg); err != nil {
+		return ""
+	}
+
+	return cfg.Name
+}
+
+// signalEventID returns the id of the event resuming the wait of st for a signal. A wait
+// receives one signal, the streams dedupe the others.
+func signalEventID(st *InstanceEvent) uuid.UUID {
+	return uuid.NewSHA1(st.EventID, []byte("signal"))
+}
+
+// SignalInstance sends the signal name with payload to the instance waiting for it. Parked
+// instances are woken, other subflows wait within the execution of their parent and receive
+// the signal with their history stream. A wait receives one signal, ErrWaitNotFound is
+// returned if it already received one or timed out.
+func (e *Engine) SignalInstance(ctx context.Context, namespace string, instanceID uuid.UUID, name string, payload []byte) error {
+	st, err := e.GetInstanceStatus(ctx, namespace, instanceID)
+	if err != nil {
+		return err
+	}
+	if name == "" || st.PendingSignal() != name {
+		return ErrWaitNotFound
+	}
+
+	entry := &runtime.JournalEntry{
+		Step:   st.Suspension.Step,
+		Output: payload,
+	}
+	if st.IsParked() {
+		err = e.wake(ctx, st, entry)
+	} else {
+		ev := st.Clone()
+		ev.EventID = signalEventID(st)
+		ev.Suspension = nil
+		ev.Journal = append(ev.Journal, entry)
+
+		err = e.dataBus.PublishInstanceHistoryEvent(ctx, ev)
+	}
+	if errors.Is(err, ErrDuplicateEvent) {
+		return ErrWaitNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("push signal, inst: %s: %w", instanceID, err)
+	}
+
+	return nil
+}
+
+// PendingSignals returns the signals the instance with the status st and its subflows wait for.
+func (e *Engine) PendingSignals(ctx context.Context, st *InstanceEvent) []*PendingSignal {
+	var list []*PendingSignal
+	var collect func(st *InstanceEvent)
+	collect = func(st *InstanceEvent) {
+		if st.IsEndStatus() {
+			return
+		}
+		if name := st.PendingSignal(); name != "" {
+			list = append(list, &PendingSignal{
+				InstanceID: st.InstanceID,
+				Name:       name,
+				Deadline:   st.Suspension.Deadline,
+			})
+		}
+		children, _ := e.dataBus.ListInstanceStatuses(ctx, 0, 0, filter.With(nil,
+			filter.FieldEQ("namespace", st.Namespace),
+			filter.FieldEQ("metadata_"+LabelParentInstance, st.InstanceID.String()),
+		))
+		for _, child := range children {
+			collect(child)
+		}
+	}
+	collect(st)
+
+	return list
+}
+
+// waitSignal blocks the subflow execution of current until it receives the signal s waits
+// for. The wait is recorded like a parked instance, so the signal API finds it. Subflows
+// that can park with their parent are parked instead, see LabelWithParkable.
+func (e *Engine) waitSignal(ctx context.Context, current *InstanceEvent, s *runtime.Suspension) (json.RawMessage, error) {
+	waitEv := current.Clone()
+	waitEv.EventID = uuid.New()
+	waitEv.Suspension = s
+
+	// subscribe before the wait is recorded, the signal can not be missed.
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := e.dataBus.SubscribeInstanceHistory(subCtx, waitEv.Namespace, waitEv.InstanceID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.dataBus.PublishInstanceHistoryEvent(ctx, waitEv)
+	if err != nil {
+		return nil, fmt.Errorf("push history wait event, inst: %s: %w", waitEv.InstanceID, err)
+	}
+	telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+		fmt.Sprintf("waiting for signal '%s'", waitEv.PendingSignal()))
+
+	var timeout <-chan time.Time
+	if !s.Deadline.IsZero() {
+		t := time.NewTimer(time.Until(s.Deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	want := signalEventID(waitEv)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.EventID != want {
+				continue
+			}
+			for _, j := range ev.Journal {
+				if j.Step == s.Step {
+					return j.Output, nil
+				}
+			}
+		case <-timeout:
+			// ends the wait, signals racing the timeout are deduped.
+			endEv := waitEv.Clone()
+			endEv.EventID = want
+			endEv.Suspension = nil
+			err := e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+			if errors.Is(err, ErrDuplicateEvent) {
+				// the signal won the race, it is received with the history.
+				timeout = nil
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("push history wait timeout event, inst: %s: %w", waitEv.InstanceID, err)
+			}
+
+			return nil, errors.New(runtime.ErrWaitTimeout)
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func signalSuspension(t *testing.T, name string, step int) *runtime.Suspension {
+	t.Helper()
+	params, err := json.Marshal(runtime.WaitForSignalConfig{Name: name})
+	require.NoError(t, err)
+
+	return &runtime.Suspension{Kind: runtime.SuspensionKindSignal, Step: step, Params: params}
+}
+
+func TestSignalInstance(t *testing.T) {
+	parked := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		Fn:         "approve",
+		Suspension: signalSuspension(t, "approval", 0),
+		EventID:    uuid.New(),
+	}
+	subflow := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata: map[string]string{
+			LabelWithScope:      uuid.NewString(),
+			LabelParentInstance: parked.InstanceID.String(),
+		},
+		Fn:         "review",
+		Suspension: signalSuspension(t, "review", 2),
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{statuses: []*InstanceEvent{parked, subflow}}
+	e := &Engine{dataBus: bus}
+
+	pending := e.PendingSignals(context.Background(), parked)
+	require.Len(t, pending, 2)
+	require.Equal(t, "approval", pending[0].Name)
+	require.Equal(t, subflow.InstanceID, pending[1].InstanceID)
+	require.Equal(t, "review", pending[1].Name)
+
+	err := e.SignalInstance(context.Background(), "ns", parked.InstanceID, "review", []byte(`{}`))
+	require.ErrorIs(t, err, ErrWaitNotFound)
+
+	// parked instances are woken.
+	err = e.SignalInstance(context.Background(), "ns", parked.InstanceID, "approval", []byte(`{"ok":true}`))
+	require.NoError(t, err)
+	require.Len(t, bus.queued, 1)
+	require.Equal(t, wakeEventID(parked), bus.queued[0].EventID)
+	require.Nil(t, bus.queued[0].Suspension)
+	require.JSONEq(t, `{"ok":true}`, string(bus.queued[0].Journal[0].Output))
+
+	// a wait receives one signal.
+	err = e.SignalInstance(context.Background(), "ns", parked.InstanceID, "approval", []byte(`{"ok":false}`))
+	require.ErrorIs(t, err, ErrWaitNotFound)
+	require.Len(t, bus.queued, 1)
+
+	// subflows receive the signal with their history.
+	err = e.SignalInstance(context.Background(), "ns", subflow.InstanceID, "review", []byte(`"done"`))
+	require.NoError(t, err)
+	require.Len(t, bus.queued, 1)
+	require.Len(t, bus.history, 1)
+
+	ev := bus.history[0]
+	require.Equal(t, signalEventID(subflow), ev.EventID)
+	require.Nil(t, ev.Suspension)
+	require.Len(t, ev.Journal, 1)
+	require.Equal(t, 2, ev.Journal[0].Step)
+	require.JSONEq(t, `"done"`, string(ev.Journal[0].Output))
+
+	err = e.SignalInstance(context.Background(), "ns", subflow.InstanceID, "review", []byte(`"again"`))
+	require.ErrorIs(t, err, ErrWaitNotFound)
+	require.Len(t, bus.history, 1)
+}
+
+func TestWaitSignalTimeout(t *testing.T) {
+	current := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	s := signalSuspension(t, "approval", 0)
+	s.Deadline = time.Now().Add(10 * time.Millisecond)
+	_, err := e.waitSignal(context.Background(), current, s)
+	require.EqualError(t, err, runtime.ErrWaitTimeout)
+
+	// the wait and its end are recorded.
+	require.Len(t, bus.history, 2)
+	require.Equal(t, "approval", bus.history[0].PendingSignal())
+	require.Equal(t, signalEventID(bus.history[0]), bus.history[1].EventID)
+	require.Empty(t, bus.history[1].PendingSignal())
+}
+
+func TestWaitSignalTimeoutRace(t *testing.T) {
+	current := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	// the signal is published right before the wait times out, but received after it.
+	go func() {
+		var wait *InstanceEvent
+		for wait == nil {
+			bus.mu.Lock()
+			if len(bus.history) > 0 {
+				wait = bus.history[0]
+			}
+			bus.mu.Unlock()
+		}
+
+		signal := wait.Clone()
+		signal.EventID = signalEventID(wait)
+		signal.Suspension = nil
+		signal.Journal = append(signal.Journal, &runtime.JournalEntry{Step: 0, Output: []byte(`"approved"`)})
+		bus.mu.Lock()
+		bus.history = append(bus.history, signal)
+		bus.mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+		bus.subs[0] <- signal
+	}()
+
+	s := signalSuspension(t, "approval", 0)
+	s.Deadline = time.Now().Add(30 * time.Millisecond)
+	out, err := e.waitSignal(context.Background(), current, s)
+	require.NoError(t, err)
+	require.JSONEq(t, `"approved"`, string(out))
+	require.Len(t, bus.history, 2)
+}
+
+// lastEvent returns the last event of the instance id published to bus.
+func lastEvent(bus *fakeDataBus, id uuid.UUID) *InstanceEvent {
+	var last *InstanceEvent
+	for _, ev := range bus.history {
+		if ev.InstanceID == id {
+			last = ev
+		}
+	}
+
+	return last
+}
+
+func TestExecSubflowSignalParksParent(t *testing.T) {
+	comp := &fakeCompiler{flows: map[string]core.TypescriptFlow{
+		"/child.wf.ts": {
+			Script: `
+			function stateChild(input) {
+				const approval = waitForSignal("approve")
+				return finish({n: input.n, approval})
+			}`,
+			Config: core.FlowConfig{Timeout: "PT1M", State: "stateChild"},
+		},
+	}}
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus, compiler: comp}
+
+	parent := &InstanceEvent{
+		State:      StateCodePending,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		Fn:         "stateParent",
+		Input:      json.RawMessage(`{}`),
+		EventID:    uuid.New(),
+		Script: `
+		function stateParent() {
+			const out = execSubflow("/child.wf.ts", {n: 1})
+			return finish(out)
+		}`,
+	}
+
+	// the parent does not block its worker while the subflow waits.
+	require.NoError(t, e.execInstance(context.Background(), parent))
+
+	parked := lastEvent(bus, parent.InstanceID)
+	require.True(t, parked.IsParked())
+	childID, ok := waitedSubflow(parked)
+	require.True(t, ok)
+	child := lastEvent(bus, childID)
+	require.True(t, child.IsParked())
+	require.Equal(t, "approve", child.PendingSignal())
+
+	bus.statuses = []*InstanceEvent{parked, child}
+	require.Len(t, e.PendingSignals(context.Background(), parked), 1)
+	require.NoError(t, e.SignalInstance(context.Background(), "ns", childID, "approve", []byte(`"yes"`)))
+	require.Len(t, bus.queued, 1)
+
+	// the subflow continues on its own and wakes its parent once it ended.
+	require.NoError(t, e.execInstance(context.Background(), bus.queued[0]))
+	end := lastEvent(bus, childID)
+	require.Equal(t, StateCodeComplete, end.State)
+
+	bus.statuses = []*InstanceEvent{parked, end}
+	require.NoError(t, e.wakeParent(context.Background(), end))
+	require.Len(t, bus.queued, 2)
+	// the timers catch up on missed subflow ends, the continuation is deduped.
+	e.fireTimers(context.Background(), time.Now())
+	require.Len(t, bus.queued, 2)
+
+	require.NoError(t, e.execInstance(context.Background(), bus.queued[1]))
+	end = lastEvent(bus, parent.InstanceID)
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `{"n":1,"approval":"yes"}`, string(end.Output))
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCompiler struct {
+	flows map[string]core.TypescriptFlow
+}
+
+func (f *fakeCompiler) FetchScript(ctx context.Context, namespace, path string, withSecrets bool) (core.TypescriptFlow, error) {
+	flow, ok := f.flows[path]
+	if !ok {
+		return flow, fmt.Errorf("flow %s not found", path)
+	}
+
+	return flow, nil
+}
+
+func TestExecSubflowAsync(t *testing.T) {
+	comp := &fakeCompiler{flows: map[string]core.TypescriptFlow{
+		"/child.wf.ts": {
+			Script: `function stateChild(input) { return finish(input.n * 2) }`,
+			Config: core.FlowConfig{Timeout: "PT1M", State: "stateChild"},
+		},
+	}}
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus, compiler: comp}
+
+	parent := &InstanceEvent{
+		State:      StateCodePending,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		Fn:         "stateParent",
+		Input:      json.RawMessage(`{}`),
+		EventID:    uuid.New(),
+		Script: `
+		async function stateParent() {
+			const a = execSubflowAsync("/child.wf.ts", {n: 1})
+			const b = execSubflowAsync("/child.wf.ts", {n: 2})
+			return finish(await Promise.all([a, b]))
+		}`,
+	}
+
+	require.NoError(t, e.execInstance(context.Background(), parent))
+
+	var end *InstanceEvent
+	children := map[uuid.UUID]*InstanceEvent{}
+	for _, ev := range bus.history {
+		if ev.InstanceID == parent.InstanceID {
+			end = ev
+			continue
+		}
+		children[ev.InstanceID] = ev
+	}
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `[2,4]`, string(end.Output))
+
+	require.Len(t, children, 2)
+	steps := map[int]bool{}
+	for _, child := range children {
+		require.Equal(t, StateCodeComplete, child.State)
+		require.Equal(t, parent.InstanceID.String(), child.Metadata[LabelParentInstance])
+		require.Equal(t, "main", child.Metadata[LabelParentScope])
+
+		lineage := child.Lineage()
+		require.Len(t, lineage, 1)
+		require.Equal(t, parent.InstanceID, lineage[0].InstanceID)
+		require.Equal(t, "stateParent", lineage[0].State)
+		steps[lineage[0].Step] = true
+	}
+	require.Equal(t, map[int]bool{0: true, 1: true}, steps)
+}
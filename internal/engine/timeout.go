@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/sosodev/duration"
+)
+
+// errFlowTimeout fails instances that exceeded the timeout of their flow. Unlike state
+// timeouts, it is not subject to the error policies of the state.
+var errFlowTimeout = &runtime.Error{
+	Code:    core.ErrorCodeTimeout,
+	Message: "timeout for flow exceeded",
+}
+
+// flowDeadline returns the time the flow of ev times out, false if it has no timeout.
+func flowDeadline(ev *InstanceEvent) (time.Time, bool) {
+	to, ok := ev.Metadata[core.EngineMappingTimeout]
+	if !ok {
+		return time.Time{}, false
+	}
+	unixSec, err := strconv.ParseInt(to, 10, 64)
+	if err != nil {
+		slog.Error("could not parse the timeout time for flow", slog.Any("error", err))
+		return time.Time{}, false
+	}
+
+	return time.Unix(unixSec, 0), true
+}
+
+// stateTimeout returns how long an execution of the state function of ev may take, zero
+// if it is not limited.
+func stateTimeout(ev *InstanceEvent) time.Duration {
+	policy := statePolicy(ev)
+	if policy == nil || policy.Timeout == "" {
+		return 0
+	}
+	d, err := duration.Parse(policy.Timeout)
+	if err != nil {
+		// cannot happen, already checked in AST parsing
+		slog.Error("could not parse the state timeout", slog.Any("error", err))
+		return 0
+	}
+
+	return d.ToTimeDuration()
+}
+
+// watchdog cancels the execution of an instance once its flow or current state exceeds
+// the timeout. The cause of the cancelled context is the timeout error.
+type watchdog struct {
+	cancel       context.CancelCauseFunc
+	flowDeadline time.Time
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// newWatchdog returns the context to execute the state function of ev with.
+func newWatchdog(ctx context.Context, ev *InstanceEvent) (context.Context, *watchdog) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &watchdog{
+		cancel: cancel,
+	}
+	if deadline, ok := flowDeadline(ev); ok {
+		w.flowDeadline = deadline
+	}
+	w.enterState(ev)
+
+	return ctx, w
+}
+
+// enterState restarts the timeout for the state function of ev, the script transitioned to it.
+func (w *watchdog) enterState(ev *InstanceEvent) {
+	deadline := w.flowDeadline
+	var cause error = errFlowTimeout
+	if d := stateTimeout(ev); d > 0 {
+		stateDeadline := time.Now().Add(d)
+		if deadline.IsZero() || stateDeadline.Before(deadline) {
+			deadline = stateDeadline
+			cause = &runtime.Error{
+				Code:    core.ErrorCodeTimeout,
+				Message: fmt.Sprintf("timeout for state '%s' exceeded", ev.Fn),
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if deadline.IsZero() {
+		return
+	}
+	w.timer = time.AfterFunc(time.Until(deadline), func() {
+		w.cancel(cause)
+	})
+}
+
+// stop ends the watchdog and cancels its context.
+func (w *watchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel(context.Canceled)
+}
+
+// failTimedOut ends the parked instance st whose flow timed out while it waited.
+func (e *Engine) failTimedOut(ctx context.Context, st *InstanceEvent) error {
+	endEv := st.Clone()
+	// all replicas fire the timers, the history stream dedupes the event.
+	endEv.EventID = uuid.NewSHA1(st.EventID, []byte("timeout"))
+	endEv.State = StateCodeFailed
+	endEv.Fn = ""
+	endEv.Suspension = nil
+	endEv.Error = errFlowTimeout.Message
+	endEv.ErrorCode = errFlowTimeout.Code
+	endEv.EndedAt = time.Now()
+
+	notifyIfRequested(endEv)
+
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, endEv)
+	if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+		return fmt.Errorf("push history timeout event, inst: %s: %w", st.InstanceID, err)
+	}
+
+	return e.endWait(ctx, st)
+}
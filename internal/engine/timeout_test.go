@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecInstanceStateTimeout(t *testing.T) {
+	inst := policyEvent(t, map[string]*core.StatePolicy{
+		"stateTwo": {
+			Timeout: "PT0.1S",
+			Catch:   []core.CatchPolicy{{Codes: []string{core.ErrorCodeTimeout}, State: "stateFailed"}},
+		},
+	})
+	inst.State = StateCodePending
+	inst.Script = `
+	function stateOne() { return transition(stateTwo, {}) }
+	function stateTwo() { while (true) {} }
+	function stateFailed(e) { return finish(e) }`
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.execInstance(context.Background(), inst))
+
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeComplete, end.State)
+	require.JSONEq(t, `{"code":"io.direktiv.error.timeout","message":"timeout for state 'stateTwo' exceeded","state":"stateTwo","input":{}}`,
+		string(end.Output))
+}
+
+func TestExecInstanceFlowTimeout(t *testing.T) {
+	inst := policyEvent(t, map[string]*core.StatePolicy{
+		"stateOne": {
+			Catch: []core.CatchPolicy{{State: "stateFailed"}},
+		},
+	})
+	inst.Metadata[core.EngineMappingTimeout] = strconv.FormatInt(time.Now().Add(100*time.Millisecond).Unix(), 10)
+	inst.State = StateCodePending
+	inst.Script = `
+	function stateOne() { while (true) {} }
+	function stateFailed(e) { return finish(e) }`
+	bus := &fakeDataBus{}
+	e := &Engine{dataBus: bus}
+
+	require.NoError(t, e.execInstance(context.Background(), inst))
+
+	// flow timeouts are not caught.
+	end := bus.history[len(bus.history)-1]
+	require.Equal(t, StateCodeFailed, end.State)
+	require.Equal(t, core.ErrorCodeTimeout, end.ErrorCode)
+	require.Equal(t, "timeout for flow exceeded", end.Error)
+}
+
+func TestFireTimersFlowTimeout(t *testing.T) {
+	now := time.Now()
+	parked := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Metadata: map[string]string{
+			core.EngineMappingTimeout: strconv.FormatInt(now.Add(-time.Second).Unix(), 10),
+		},
+		Suspension: &runtime.Suspension{Kind: runtime.SuspensionKindSleep, Deadline: now.Add(time.Hour)},
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{statuses: []*InstanceEvent{parked}}
+	e := &Engine{dataBus: bus}
+
+	e.fireTimers(context.Background(), now)
+	require.Empty(t, bus.queued)
+	require.Len(t, bus.history, 1)
+
+	end := bus.history[0]
+	require.Equal(t, StateCodeFailed, end.State)
+	require.Equal(t, core.ErrorCodeTimeout, end.ErrorCode)
+	require.Nil(t, end.Suspension)
+}
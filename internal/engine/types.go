@@ -3,10 +3,13 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"maps"
+	"slices"
 	"time"
 
 	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
 	"github.com/direktiv/direktiv/pkg/lifecycle"
 	"github.com/google/uuid"
 )
@@ -41,6 +44,25 @@ type InstanceEvent struct {
 	Input  json.RawMessage `json:",omitempty"`
 	Output json.RawMessage `json:",omitempty"`
 	Error  string
+	// ErrorCode is the code of the error a failed instance ended with, if it has one.
+	ErrorCode string `json:",omitempty"`
+
+	// Journal holds the results of the waits the state function Fn already finished.
+	Journal []*runtime.JournalEntry `json:",omitempty"`
+	// Suspension is set while the instance is parked on a wait, see Engine.park. A parked
+	// instance keeps the running status but is not executed by any replica.
+	Suspension *runtime.Suspension `json:",omitempty"`
+	// Attempt counts the retries of the state function Fn after it failed.
+	Attempt int `json:",omitempty"`
+	// Transitions counts the state functions the instance entered before Fn, it tells
+	// apart the visits of a state function in loops.
+	Transitions int `json:",omitempty"`
+	// Records holds the results of the non-deterministic calls of the execution that led to
+	// this event, see Recording.
+	Records []*runtime.Record `json:",omitempty"`
+	// Resume holds the records of the executions of the state function Fn until its last
+	// wait. Executing Fn again, the calls made before the wait return their recorded results.
+	Resume []*runtime.Record `json:",omitempty"`
 
 	CreatedAt time.Time
 	StartedAt time.Time
@@ -54,6 +76,46 @@ func (e *InstanceEvent) IsEndStatus() bool {
 	return e.State == StateCodeComplete || e.State == StateCodeFailed || e.State == StateCodeCancelled
 }
 
+// IsParked reports if the instance is suspended until a wait of the runtime is resolved.
+// Subflows waiting for signals record their suspension too, but keep being executed by
+// their parent unless it parks with them, see Engine.waitSignal.
+func (e *InstanceEvent) IsParked() bool {
+	return e.State == StateCodeRunning && e.Suspension != nil && e.canPark()
+}
+
+// canPark reports if the instance can be parked. Subflows park with their parent, if it
+// waits for them in a synchronous call it can park itself.
+func (e *InstanceEvent) canPark() bool {
+	return e.Metadata[LabelParentInstance] == "" || e.Metadata[LabelWithParkable] == "true"
+}
+
+// StateInput returns the payload the state function Fn is called with. Events
+// recorded by a transition carry the transition memory in Output, a fresh
+// instance starts with its Input.
+func (e *InstanceEvent) StateInput() json.RawMessage {
+	if e.Output != nil {
+		return e.Output
+	}
+
+	return e.Input
+}
+
+// Lineage returns the ancestors of a subflow instance, the instance that started the
+// subflow last. Other instances have none.
+func (e *InstanceEvent) Lineage() []*LineageEntry {
+	data, ok := e.Metadata[LabelLineage]
+	if !ok {
+		return nil
+	}
+
+	var lineage []*LineageEntry
+	if err := json.Unmarshal([]byte(data), &lineage); err != nil {
+		return nil
+	}
+
+	return lineage
+}
+
 func (e *InstanceEvent) FullID() string {
 	return e.InstanceID.String() + "/" + e.Metadata[LabelWithScope]
 }
@@ -82,23 +144,95 @@ func (e *InstanceEvent) Clone() *InstanceEvent {
 	clone.Input = copyRaw(e.Input)
 	clone.Output = copyRaw(e.Output)
 
+	if e.Journal != nil {
+		clone.Journal = slices.Clone(e.Journal)
+	}
+	if e.Resume != nil {
+		clone.Resume = slices.Clone(e.Resume)
+	}
+	if e.Suspension != nil {
+		s := *e.Suspension
+		clone.Suspension = &s
+	}
+	// records belong to the event they were recorded with.
+	clone.Records = nil
+
 	return &clone
 }
 
+// withRecords returns a copy of e carrying records, to publish it with them.
+func (e *InstanceEvent) withRecords(records []*runtime.Record) *InstanceEvent {
+	if len(records) == 0 {
+		return e
+	}
+	ev := *e
+	ev.Records = records
+
+	return &ev
+}
+
+// Recording returns the records of the instance with the history, in the order they were
+// recorded. Replaying the instance with them reproduces its execution.
+func Recording(history []*InstanceEvent) []*runtime.Record {
+	var records []*runtime.Record
+	for _, ev := range history {
+		records = append(records, ev.Records...)
+	}
+
+	return records
+}
+
+// LineageEntry is an ancestor of a subflow instance, it started the next instance of the
+// lineage in the state State. Step counts the subflows started before by the same execution.
+type LineageEntry struct {
+	InstanceID uuid.UUID
+	Scope      string
+	State      string
+	Step       int
+}
+
+// InstanceHeartbeat is published periodically by the engine replica that is
+// currently executing an instance. A missing heartbeat marks the instance as
+// orphaned, see Engine.recoverOrphans.
+type InstanceHeartbeat struct {
+	InstanceID uuid.UUID
+	Namespace  string
+	BeatAt     time.Time
+}
+
 type WorkflowRunner interface {
 	Execute(ctx context.Context, namespace string, scrip string, fn string, args any, labels map[string]string) (uuid.UUID, error)
 }
 
+// ErrDuplicateEvent is returned by DataBus when the stream already holds an event with the
+// id of the published one. Deterministic event ids make concurrent publishers agree on
+// the first one.
+var ErrDuplicateEvent = errors.New("duplicate event")
+
 type DataBus interface {
 	Start(lc *lifecycle.Manager) error
 
+	// PublishInstanceHistoryEvent and PublishInstanceQueueEvent return ErrDuplicateEvent
+	// if the stream deduped the event.
 	PublishInstanceHistoryEvent(ctx context.Context, event *InstanceEvent) error
 	PublishInstanceQueueEvent(ctx context.Context, event *InstanceEvent) error
+	PublishInstanceHeartbeat(ctx context.Context, event *InstanceEvent) error
 
 	ListInstanceStatuses(ctx context.Context, limit int, offset int, filters filter.Values) ([]*InstanceEvent, int)
 	GetInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID) []*InstanceEvent
+	GetInstanceHeartbeat(ctx context.Context, instanceID uuid.UUID) (time.Time, bool)
+	SubscribeInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID, afterSequence uint64) (<-chan *InstanceEvent, error)
 
 	DeleteNamespace(ctx context.Context, namespace string) error
 
 	PublishIgniteAction(ctx context.Context, svcID string) error
+
+	// PublishInstanceCancel asks all replicas to cancel the execution of an instance, they
+	// receive it with SubscribeInstanceCancel.
+	PublishInstanceCancel(ctx context.Context, instanceID uuid.UUID) error
+	SubscribeInstanceCancel(h func(instanceID uuid.UUID)) error
+
+	// SubscribeInstanceEnd calls h with the end status of every instance ending while the
+	// replica runs, all replicas receive it. h must not block.
+	SubscribeInstanceEnd(h func(st *InstanceEvent)) error
 }
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+)
+
+// scopedVariable returns the runtime variable name of scope as seen by inst, with the
+// reference fields of the scope set.
+func scopedVariable(inst *InstanceEvent, scope string, name string) (*datastore.RuntimeVariable, error) {
+	rv := &datastore.RuntimeVariable{
+		Namespace: inst.Namespace,
+		Name:      name,
+	}
+
+	switch core.VariableScope(scope) {
+	case core.VariableScopeNamespace:
+	case core.VariableScopeWorkflow:
+		rv.WorkflowPath = inst.Metadata[core.EngineMappingPath]
+		if rv.WorkflowPath == "" {
+			return nil, fmt.Errorf("missing workflow path in instance metadata for workflow-scoped variable")
+		}
+	case core.VariableScopeInstance:
+		rv.InstanceID = inst.InstanceID
+	default:
+		return nil, fmt.Errorf("invalid variable scope %q", scope)
+	}
+
+	return rv, nil
+}
+
+// getScopedVariable returns the stored variable rv references, datastore.ErrNotFound if it
+// does not exist.
+func (e *Engine) getScopedVariable(ctx context.Context, rv *datastore.RuntimeVariable) (*datastore.RuntimeVariable, error) {
+	switch {
+	case rv.WorkflowPath != "":
+		return e.store.RuntimeVariables().GetForWorkflow(ctx, rv.Namespace, rv.WorkflowPath, rv.Name)
+	case rv.InstanceID != uuid.Nil:
+		return e.store.RuntimeVariables().GetForInstance(ctx, rv.InstanceID, rv.Name)
+	default:
+		return e.store.RuntimeVariables().GetForNamespace(ctx, rv.Namespace, rv.Name)
+	}
+}
+
+func convertVariable(rv *datastore.RuntimeVariable) *runtime.Variable {
+	return &runtime.Variable{
+		Name:     rv.Name,
+		MimeType: rv.MimeType,
+		Size:     rv.Size,
+		Version:  rv.Version,
+	}
+}
+
+func (e *Engine) makeOnReadVariableHook(inst *InstanceEvent) runtime.OnReadVariableHook {
+	return func(ctx context.Context, scope string, name string) (*runtime.Variable, error) {
+		rv, err := scopedVariable(inst, scope, name)
+		if err != nil {
+			return nil, err
+		}
+		rv, err = e.getScopedVariable(ctx, rv)
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := e.store.RuntimeVariables().LoadData(ctx, rv.ID)
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		v := convertVariable(rv)
+		v.Data = data
+
+		return v, nil
+	}
+}
+
+func (e *Engine) makeOnListVariablesHook(inst *InstanceEvent) runtime.OnListVariablesHook {
+	return func(ctx context.Context, scope string) ([]*runtime.Variable, error) {
+		var (
+			list []*datastore.RuntimeVariable
+			err  error
+		)
+
+		switch core.VariableScope(scope) {
+		case core.VariableScopeNamespace:
+			list, err = e.store.RuntimeVariables().ListForNamespace(ctx, inst.Namespace)
+		case core.VariableScopeWorkflow:
+			wfPath := inst.Metadata[core.EngineMappingPath]
+			if wfPath == "" {
+				return nil, fmt.Errorf("missing workflow path in instance metadata for workflow-scoped variable")
+			}
+			list, err = e.store.RuntimeVariables().ListForWorkflow(ctx, inst.Namespace, wfPath)
+		case core.VariableScopeInstance:
+			list, err = e.store.RuntimeVariables().ListForInstance(ctx, inst.InstanceID)
+		default:
+			return nil, fmt.Errorf("invalid variable scope %q", scope)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]*runtime.Variable, 0, len(list))
+		for _, rv := range list {
+			res = append(res, convertVariable(rv))
+		}
+
+		return res, nil
+	}
+}
+
+func (e *Engine) makeOnWriteVariableHook(inst *InstanceEvent) runtime.OnWriteVariableHook {
+	return func(ctx context.Context, scope string, v *runtime.Variable, version int64) (*runtime.Variable, error) {
+		rv, err := scopedVariable(inst, scope, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		rv.MimeType = v.MimeType
+		rv.Data = v.Data
+
+		if version == runtime.AnyVersion {
+			rv, err = e.store.RuntimeVariables().Set(ctx, rv)
+		} else {
+			rv, err = e.store.RuntimeVariables().CompareAndSet(ctx, rv, version)
+		}
+		if errors.Is(err, datastore.ErrVersionConflict) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return convertVariable(rv), nil
+	}
+}
+
+func (e *Engine) makeOnDeleteVariableHook(inst *InstanceEvent) runtime.OnDeleteVariableHook {
+	return func(ctx context.Context, scope string, name string) (bool, error) {
+		rv, err := scopedVariable(inst, scope, name)
+		if err != nil {
+			return false, err
+		}
+		rv, err = e.getScopedVariable(ctx, rv)
+		if err == nil {
+			err = e.store.RuntimeVariables().Delete(ctx, rv.ID)
+		}
+		if errors.Is(err, datastore.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+}
@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/internal/events"
+	"github.com/direktiv/direktiv/internal/telemetry"
+	"github.com/direktiv/direktiv/pkg/lifecycle"
+	"github.com/google/uuid"
+)
+
+// timerInterval is how often the deadlines of parked instances are checked.
+const timerInterval = time.Second
+
+var ErrWaitNotFound = errors.New("instance is not waiting")
+
+// park records that the instance waits in the state of current and releases the worker.
+// The id of the parked event identifies the wait.
+func (e *Engine) park(ctx context.Context, current *InstanceEvent, s *runtime.Suspension) error {
+	// subflows are executed synchronously by their parent, unless it parks with them.
+	if !current.canPark() {
+		return fmt.Errorf("waiting for %s is not supported in subflows", s.Kind)
+	}
+
+	parkEv := current.Clone()
+	parkEv.EventID = uuid.New()
+	parkEv.State = StateCodeRunning
+	parkEv.Suspension = s
+
+	// a retry waiting for its backoff carries the records of the failed execution.
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, parkEv.withRecords(current.Records))
+	if err != nil {
+		return fmt.Errorf("push history park event, inst: %s: %w", parkEv.InstanceID, err)
+	}
+
+	switch s.Kind {
+	case runtime.SuspensionKindEvent:
+		var cfg runtime.WaitForEventConfig
+		err = json.Unmarshal(s.Params, &cfg)
+		if err != nil {
+			return fmt.Errorf("unmarshal wait config: %w", err)
+		}
+
+		l := events.NewWaitListener(parkEv.EventID, parkEv.Namespace, parkEv.InstanceID,
+			cfg.Types, cfg.Context, cfg.All)
+		err = events.AddListener(ctx, e.store, l)
+		if err != nil {
+			return fmt.Errorf("add wait listener: %w", err)
+		}
+
+		telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+			fmt.Sprintf("waiting for events '%s'", strings.Join(cfg.Types, ", ")))
+	case runtime.SuspensionKindSignal:
+		telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+			fmt.Sprintf("waiting for signal '%s'", parkEv.PendingSignal()))
+	case runtime.SuspensionKindSleep:
+		telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+			fmt.Sprintf("sleeping until %s", s.Deadline.Format(time.RFC3339)))
+	case runtime.SuspensionKindRetry:
+		telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+			fmt.Sprintf("retrying '%s' at %s", parkEv.Fn, s.Deadline.Format(time.RFC3339)))
+	case runtime.SuspensionKindSubflow:
+		id, _ := waitedSubflow(parkEv)
+		telemetry.LogInstance(ctx, telemetry.LogLevelInfo,
+			fmt.Sprintf("waiting for subflow '%s'", id))
+	default:
+		return fmt.Errorf("unknown suspension kind '%s'", s.Kind)
+	}
+
+	return nil
+}
+
+// WakeInstance continues the instance parked by the wait waitID. The state function is
+// called again and the wait returns output.
+func (e *Engine) WakeInstance(ctx context.Context, namespace string, instanceID uuid.UUID, waitID uuid.UUID, output []byte) error {
+	st, err := e.GetInstanceStatus(ctx, namespace, instanceID)
+	if err != nil {
+		return err
+	}
+	if !st.IsParked() || st.EventID != waitID {
+		return ErrWaitNotFound
+	}
+
+	err = e.wake(ctx, st, &runtime.JournalEntry{
+		Step:   st.Suspension.Step,
+		Output: output,
+	})
+	// the wait was resolved by someone else meanwhile.
+	if errors.Is(err, ErrDuplicateEvent) {
+		return ErrWaitNotFound
+	}
+
+	return err
+}
+
+// wake enqueues the continuation of the parked instance st with the result of its wait.
+// Retries and deferred instances have no result, entry is nil. It returns
+// ErrDuplicateEvent if the wait was already resumed.
+func (e *Engine) wake(ctx context.Context, st *InstanceEvent, entry *runtime.JournalEntry) error {
+	ev := st.Clone()
+	ev.EventID = wakeEventID(st)
+	if entry != nil {
+		ev.Journal = append(ev.Journal, entry)
+	}
+	ev.Suspension = nil
+
+	return e.dataBus.PublishInstanceQueueEvent(ctx, ev)
+}
+
+// wakeEventID returns the id of the continuation of the parked instance st. A wait is
+// resumed once, the queue stream dedupes e.g. an event racing the timeout of the wait.
+func wakeEventID(st *InstanceEvent) uuid.UUID {
+	return uuid.NewSHA1(st.EventID, []byte("wake"))
+}
+
+// subflowWait is the suspension params of an instance waiting for a parked subflow.
+type subflowWait struct {
+	InstanceID uuid.UUID `json:"instanceID"`
+}
+
+// subflowSuspension suspends an instance until the parked subflow id ended, see wakeParent.
+func subflowSuspension(id uuid.UUID) *runtime.Suspension {
+	params, _ := json.Marshal(&subflowWait{InstanceID: id})
+
+	return &runtime.Suspension{
+		Kind:   runtime.SuspensionKindSubflow,
+		Params: params,
+	}
+}
+
+// waitedSubflow returns the id of the subflow the parked instance st waits for.
+func waitedSubflow(st *InstanceEvent) (uuid.UUID, bool) {
+	if st.Suspension == nil || st.Suspension.Kind != runtime.SuspensionKindSubflow {
+		return uuid.Nil, false
+	}
+	var w subflowWait
+	if err := json.Unmarshal(st.Suspension.Params, &w); err != nil {
+		return uuid.Nil, false
+	}
+
+	return w.InstanceID, true
+}
+
+// waitingParent returns the status of the parent parked waiting for the subflow child.
+func (e *Engine) waitingParent(ctx context.Context, child *InstanceEvent) (*InstanceEvent, bool) {
+	id, err := uuid.Parse(child.Metadata[LabelParentInstance])
+	if err != nil {
+		return nil, false
+	}
+	parent, err := e.GetInstanceStatus(ctx, child.Namespace, id)
+	if err != nil || !parent.IsParked() {
+		return nil, false
+	}
+	waited, ok := waitedSubflow(parent)
+
+	return parent, ok && waited == child.InstanceID
+}
+
+// wakeParent continues the parent parked waiting for the ended subflow child, the
+// subflow call returns its output.
+func (e *Engine) wakeParent(ctx context.Context, child *InstanceEvent) error {
+	parent, ok := e.waitingParent(ctx, child)
+	if !ok {
+		return nil
+	}
+
+	return e.wakeWaiting(ctx, parent, child)
+}
+
+// wakeWaiting continues the instance st parked waiting for the ended subflow child.
+func (e *Engine) wakeWaiting(ctx context.Context, st *InstanceEvent, child *InstanceEvent) error {
+	entry := &runtime.JournalEntry{
+		Step: st.Suspension.Step,
+	}
+	if child.State == StateCodeComplete {
+		entry.Output = child.Output
+	} else {
+		entry.Error = fmt.Sprintf("subflow did not complete: %s", child.Error)
+		entry.Code = child.ErrorCode
+	}
+
+	// all replicas see the subflow end, wake dedupes the continuation.
+	err := e.wake(ctx, st, entry)
+	if errors.Is(err, ErrDuplicateEvent) {
+		return nil
+	}
+
+	return err
+}
+
+// cancelParked ends the parked instance st, nothing executes it that could be cancelled.
+func (e *Engine) cancelParked(ctx context.Context, st *InstanceEvent) error {
+	cancelEv := st.Clone()
+	cancelEv.EventID = cancelEventID(st)
+	cancelEv.State = StateCodeCancelled
+	cancelEv.Fn = ""
+	cancelEv.Suspension = nil
+	cancelEv.EndedAt = time.Now()
+
+	notifyIfRequested(cancelEv)
+
+	err := e.dataBus.PublishInstanceHistoryEvent(ctx, cancelEv)
+	if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+		return fmt.Errorf("push history cancel event, inst: %s: %w", st.InstanceID, err)
+	}
+
+	return e.endWait(ctx, st)
+}
+
+// endWait removes what the parked instance st waits on.
+func (e *Engine) endWait(ctx context.Context, st *InstanceEvent) error {
+	// nothing waits for the subflow anymore.
+	if id, ok := waitedSubflow(st); ok {
+		e.cancelSubflow(ctx, st.Namespace, id)
+		return nil
+	}
+	if st.Suspension.Kind != runtime.SuspensionKindEvent {
+		return nil
+	}
+
+	err := e.store.EventListener().DeleteByID(ctx, st.EventID)
+	if err != nil {
+		return fmt.Errorf("delete wait listener: %w", err)
+	}
+
+	return nil
+}
+
+// startTimers resumes parked instances once the deadline of their wait passed. Sleeps and
+// retry backoffs end, other waits time out. Instances whose flow timed out fail.
+func (e *Engine) startTimers(lc *lifecycle.Manager) {
+	lc.Go(func() error {
+		t := time.NewTicker(timerInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-lc.Done():
+				return nil
+			case <-t.C:
+			}
+
+			e.fireTimers(lc.Context(), time.Now())
+		}
+	})
+}
+
+func (e *Engine) fireTimers(ctx context.Context, now time.Time) {
+	list, _ := e.dataBus.ListInstanceStatuses(ctx, 0, 0, filter.With(nil,
+		filter.FieldEQ("status", string(StateCodeRunning)),
+	))
+
+	for _, st := range list {
+		if !st.IsParked() {
+			continue
+		}
+		// the flow timed out while the instance waited.
+		if deadline, ok := flowDeadline(st); ok && !now.Before(deadline) {
+			err := e.failTimedOut(ctx, st)
+			if err != nil {
+				slog.Error("fail timed out instance", "instance", st.InstanceID, "error", err)
+			}
+			continue
+		}
+		// the end of the subflow the instance waits for is missed, if it ended before
+		// the instance parked.
+		if id, ok := waitedSubflow(st); ok {
+			child, err := e.GetInstanceStatus(ctx, st.Namespace, id)
+			if err == nil && child.IsEndStatus() {
+				err = e.wakeWaiting(ctx, st, child)
+			}
+			if err != nil && !errors.Is(err, ErrDataNotFound) {
+				slog.Error("wake instance waiting for subflow", "instance", st.InstanceID, "error", err)
+			}
+			continue
+		}
+		if st.Suspension.Deadline.IsZero() || now.Before(st.Suspension.Deadline) {
+			continue
+		}
+
+		// all replicas fire the timers, wake dedupes the continuation.
+		err := e.endWait(ctx, st)
+		if err != nil {
+			slog.Error("end expired wait", "instance", st.InstanceID, "error", err)
+			continue
+		}
+		var entry *runtime.JournalEntry
+		switch st.Suspension.Kind {
+		case runtime.SuspensionKindRetry:
+		case runtime.SuspensionKindSleep:
+			entry = &runtime.JournalEntry{
+				Step: st.Suspension.Step,
+			}
+		default:
+			entry = &runtime.JournalEntry{
+				Step:  st.Suspension.Step,
+				Error: runtime.ErrWaitTimeout,
+			}
+		}
+		err = e.wake(ctx, st, entry)
+		if err != nil && !errors.Is(err, ErrDuplicateEvent) {
+			slog.Error("enqueue expired instance", "instance", st.InstanceID, "error", err)
+		}
+	}
+}
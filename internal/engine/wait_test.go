@@ -0,0 +1,276 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/api/filter"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/pkg/lifecycle"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDataBus struct {
+	mu       sync.Mutex
+	statuses []*InstanceEvent
+	queued   []*InstanceEvent
+	history  []*InstanceEvent
+
+	cancelled []uuid.UUID
+	// subs receive the published history events.
+	subs []chan *InstanceEvent
+	// beats are the heartbeats of the instances executed by live replicas.
+	beats map[uuid.UUID]time.Time
+}
+
+// published reports if list holds an event with the id of ev, the streams dedupe it.
+func published(list []*InstanceEvent, ev *InstanceEvent) bool {
+	for _, p := range list {
+		if p.EventID == ev.EventID {
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ DataBus = &fakeDataBus{}
+
+func (f *fakeDataBus) Start(lc *lifecycle.Manager) error { return nil }
+
+func (f *fakeDataBus) PublishInstanceHistoryEvent(ctx context.Context, event *InstanceEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if published(f.history, event) {
+		return ErrDuplicateEvent
+	}
+	f.history = append(f.history, event)
+	for _, ch := range f.subs {
+		ch <- event
+	}
+	return nil
+}
+
+func (f *fakeDataBus) PublishInstanceQueueEvent(ctx context.Context, event *InstanceEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if published(f.queued, event) {
+		return ErrDuplicateEvent
+	}
+	f.queued = append(f.queued, event)
+	return nil
+}
+
+func (f *fakeDataBus) PublishInstanceHeartbeat(ctx context.Context, event *InstanceEvent) error {
+	return nil
+}
+
+func (f *fakeDataBus) ListInstanceStatuses(ctx context.Context, limit int, offset int, filters filter.Values) ([]*InstanceEvent, int) {
+	var list []*InstanceEvent
+	for _, st := range f.statuses {
+		if filters.Match("instanceID", st.InstanceID.String()) &&
+			filters.Match("namespace", st.Namespace) &&
+			filters.Match("status", string(st.State)) &&
+			filters.Match("metadata_"+core.EngineMappingPath, st.Metadata[core.EngineMappingPath]) &&
+			filters.Match("metadata_"+LabelParentInstance, st.Metadata[LabelParentInstance]) {
+			list = append(list, st)
+		}
+	}
+
+	return list, len(list)
+}
+
+func (f *fakeDataBus) GetInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID) []*InstanceEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []*InstanceEvent
+	for _, ev := range f.history {
+		if ev.InstanceID == instanceID {
+			list = append(list, ev)
+		}
+	}
+
+	return list
+}
+
+func (f *fakeDataBus) GetInstanceHeartbeat(ctx context.Context, instanceID uuid.UUID) (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.beats[instanceID]
+
+	return t, ok
+}
+
+func (f *fakeDataBus) SubscribeInstanceHistory(ctx context.Context, namespace string, instanceID uuid.UUID, afterSequence uint64) (<-chan *InstanceEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan *InstanceEvent, 16)
+	f.subs = append(f.subs, ch)
+
+	return ch, nil
+}
+
+func (f *fakeDataBus) DeleteNamespace(ctx context.Context, namespace string) error { return nil }
+
+func (f *fakeDataBus) PublishIgniteAction(ctx context.Context, svcID string) error { return nil }
+
+func (f *fakeDataBus) PublishInstanceCancel(ctx context.Context, instanceID uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelled = append(f.cancelled, instanceID)
+	return nil
+}
+
+func (f *fakeDataBus) SubscribeInstanceCancel(h func(instanceID uuid.UUID)) error { return nil }
+
+func (f *fakeDataBus) SubscribeInstanceEnd(h func(st *InstanceEvent)) error { return nil }
+
+func TestWakeInstance(t *testing.T) {
+	parked := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		Fn:         "wait",
+		Journal:    []*runtime.JournalEntry{{Step: 0, Output: []byte(`1`)}},
+		Suspension: &runtime.Suspension{Kind: runtime.SuspensionKindEvent, Step: 1},
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{statuses: []*InstanceEvent{parked}}
+	e := &Engine{dataBus: bus}
+
+	err := e.WakeInstance(context.Background(), "ns", parked.InstanceID, uuid.New(), []byte(`2`))
+	require.ErrorIs(t, err, ErrWaitNotFound)
+
+	err = e.WakeInstance(context.Background(), "ns", parked.InstanceID, parked.EventID, []byte(`2`))
+	require.NoError(t, err)
+	require.Len(t, bus.queued, 1)
+
+	ev := bus.queued[0]
+	require.Equal(t, wakeEventID(parked), ev.EventID)
+	require.Equal(t, "wait", ev.Fn)
+	require.Nil(t, ev.Suspension)
+	require.Len(t, ev.Journal, 2)
+	require.Equal(t, 1, ev.Journal[1].Step)
+	require.JSONEq(t, `2`, string(ev.Journal[1].Output))
+
+	// the parked status is not modified.
+	require.Len(t, parked.Journal, 1)
+	require.NotNil(t, parked.Suspension)
+}
+
+func TestFireTimers(t *testing.T) {
+	now := time.Now()
+	sleeping := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Suspension: &runtime.Suspension{Kind: runtime.SuspensionKindSleep, Deadline: now.Add(-time.Second)},
+		EventID:    uuid.New(),
+	}
+	notDue := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Suspension: &runtime.Suspension{Kind: runtime.SuspensionKindSleep, Deadline: now.Add(time.Minute)},
+		EventID:    uuid.New(),
+	}
+	running := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{statuses: []*InstanceEvent{sleeping, notDue, running}}
+	e := &Engine{dataBus: bus}
+
+	e.fireTimers(context.Background(), now)
+	require.Len(t, bus.queued, 1)
+	require.Equal(t, sleeping.InstanceID, bus.queued[0].InstanceID)
+	require.Equal(t, wakeEventID(sleeping), bus.queued[0].EventID)
+	require.Empty(t, bus.queued[0].Journal[0].Error)
+}
+
+func TestCancelInstance(t *testing.T) {
+	parent := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		EventID:    uuid.New(),
+	}
+	child := &InstanceEvent{
+		State:      StateCodePending,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata: map[string]string{
+			LabelWithScope:      "child",
+			LabelParentInstance: parent.InstanceID.String(),
+		},
+		EventID: uuid.New(),
+	}
+	ended := &InstanceEvent{
+		State:      StateCodeComplete,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelParentInstance: parent.InstanceID.String()},
+		EventID:    uuid.New(),
+	}
+	bus := &fakeDataBus{
+		statuses: []*InstanceEvent{parent, child, ended},
+		beats:    map[uuid.UUID]time.Time{parent.InstanceID: time.Now()},
+	}
+	e := &Engine{dataBus: bus}
+
+	err := e.CancelInstance(context.Background(), "ns", uuid.New())
+	require.ErrorIs(t, err, ErrDataNotFound)
+
+	err = e.CancelInstance(context.Background(), "ns", parent.InstanceID)
+	require.NoError(t, err)
+
+	// the running parent and the pending child are cancelled on all replicas.
+	require.Equal(t, []uuid.UUID{parent.InstanceID, child.InstanceID}, bus.cancelled)
+
+	// nothing executes the pending child yet, it is ended right away.
+	require.Len(t, bus.history, 1)
+	require.Equal(t, child.InstanceID, bus.history[0].InstanceID)
+	require.Equal(t, StateCodeCancelled, bus.history[0].State)
+	require.Equal(t, cancelEventID(child), bus.history[0].EventID)
+
+	// ended instances are not cancelled.
+	err = e.CancelInstance(context.Background(), "ns", ended.InstanceID)
+	require.NoError(t, err)
+	require.Len(t, bus.cancelled, 2)
+}
+
+func TestCancelOrphanedInstance(t *testing.T) {
+	orphan := &InstanceEvent{
+		State:      StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  "ns",
+		Metadata:   map[string]string{LabelWithScope: "main"},
+		Fn:         "stateSecond",
+		Script:     `function stateSecond() { return finish("ran") }`,
+		Input:      []byte(`{}`),
+		EventID:    uuid.New(),
+		StartedAt:  time.Now().Add(-time.Hour),
+	}
+	bus := &fakeDataBus{statuses: []*InstanceEvent{orphan}}
+	e := &Engine{dataBus: bus}
+
+	// nothing executes the instance, it is ended right away.
+	require.NoError(t, e.CancelInstance(context.Background(), "ns", orphan.InstanceID))
+	require.Len(t, bus.history, 1)
+	require.Equal(t, StateCodeCancelled, bus.history[0].State)
+	require.Equal(t, cancelEventID(orphan), bus.history[0].EventID)
+
+	// the continuation queued before the cancellation does not run it again.
+	resumed := orphan.Clone()
+	resumed.EventID = uuid.New()
+	bus.statuses = []*InstanceEvent{bus.history[0]}
+	require.NoError(t, e.execInstance(context.Background(), resumed))
+	require.Len(t, bus.history, 1)
+	require.NoError(t, e.recoverOrphans(context.Background()))
+	require.Empty(t, bus.queued)
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	intNats "github.com/direktiv/direktiv/internal/nats"
@@ -13,22 +13,36 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-const workersCount = 5
+// queuePrefetch is the number of fetched messages per worker waiting to be executed.
+// Each namespace fetches its own messages, waiting messages are handed out round robin
+// across namespaces.
+const queuePrefetch = 2
+
+// fetchBackoff is how long fetching pauses while enough messages are waiting.
+const fetchBackoff = 100 * time.Millisecond
+
+// namespaceRefresh is how often the namespaces fetching queue messages are updated.
+const namespaceRefresh = 5 * time.Second
+
+// queueFetcher fetches the queue messages of a namespace, see nats.Subscription.
+type queueFetcher interface {
+	Fetch(batch int, opts ...nats.PullOpt) ([]*nats.Msg, error)
+}
 
 func (e *Engine) startQueueWorkers(lc *lifecycle.Manager) error {
-	// Bind to the existing durable consumer
-	sub, err := intNats.StreamEngineQueue.PullSubscribe(e.js, nats.ManualAck())
-	if err != nil {
-		return fmt.Errorf("nats pull subscribe %s: %w", intNats.StreamEngineQueue, err)
-	}
+	queue := newFairQueue()
+	fetchers := newQueueFetchers(lc.Context(), queue, e.workers*queuePrefetch, func(namespace string) (queueFetcher, error) {
+		// bind to the durable consumer of the namespace.
+		return intNats.StreamEngineQueue.PullSubscribeNamespace(e.js, namespace, nats.ManualAck())
+	})
+	lc.Go(func() error {
+		e.watchNamespaces(lc, fetchers)
+		return nil
+	})
 
-	for i := range workersCount {
+	for range e.workers {
 		lc.Go(func() error {
-			err := e.runLoop(lc, sub)
-			if err != nil {
-				return fmt.Errorf("runLoop(%d), err: %w", i, err)
-			}
-
+			e.runLoop(lc, queue)
 			return nil
 		})
 	}
@@ -36,25 +50,152 @@ func (e *Engine) startQueueWorkers(lc *lifecycle.Manager) error {
 	return nil
 }
 
-func (e *Engine) runLoop(lc *lifecycle.Manager, sub *nats.Subscription) error {
+// watchNamespaces keeps a fetcher running for every namespace.
+func (e *Engine) watchNamespaces(lc *lifecycle.Manager, fetchers *queueFetchers) {
 	for {
+		list, err := e.store.Namespaces().GetAll(lc.Context())
+		if err != nil {
+			slog.Error("list namespaces of queue", "error", err)
+		} else {
+			names := make([]string, 0, len(list))
+			for _, ns := range list {
+				names = append(names, ns.Name)
+			}
+			fetchers.sync(names)
+		}
+
 		select {
 		case <-lc.Done():
-			return nil
+			return
+		case <-time.After(namespaceRefresh):
+		}
+	}
+}
+
+// queueFetchers fetch the queue messages of every namespace into a fair queue. Every
+// namespace has its own consumer and at most limit messages waiting, a namespace
+// flooding the queue does not keep the messages of the others from being fetched.
+type queueFetchers struct {
+	ctx       context.Context
+	queue     *fairQueue
+	limit     int
+	subscribe func(namespace string) (queueFetcher, error)
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func newQueueFetchers(ctx context.Context, queue *fairQueue, limit int, subscribe func(namespace string) (queueFetcher, error)) *queueFetchers {
+	return &queueFetchers{
+		ctx:       ctx,
+		queue:     queue,
+		limit:     limit,
+		subscribe: subscribe,
+		running:   make(map[string]context.CancelFunc),
+	}
+}
+
+// sync starts fetching the namespaces not fetched yet and stops fetching the ones that
+// are gone.
+func (f *queueFetchers) sync(namespaces []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keep := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		keep[ns] = true
+		if _, ok := f.running[ns]; ok {
+			continue
+		}
+		sub, err := f.subscribe(ns)
+		if err != nil {
+			// retried with the next sync.
+			slog.Error("subscribe queue of namespace", "error", err, "namespace", ns)
+			continue
+		}
+		ctx, cancel := context.WithCancel(f.ctx)
+		f.running[ns] = cancel
+		go f.fetchLoop(ctx, ns, sub)
+	}
+	for ns, cancel := range f.running {
+		if !keep[ns] {
+			cancel()
+			delete(f.running, ns)
+		}
+	}
+}
+
+// fetchLoop pulls the messages of namespace until it has enough waiting.
+func (f *queueFetchers) fetchLoop(ctx context.Context, namespace string, sub queueFetcher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
 		default:
 		}
-		msgList, err := sub.Fetch(1, nats.MaxWait(1*time.Second))
-		if err != nil && !errors.Is(err, nats.ErrTimeout) {
-			slog.Error("subscriber fetch", "error", err, "subject", sub.Subject)
+
+		free := f.limit - f.queue.lenOf(namespace)
+		if free <= 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchBackoff):
+			}
+
+			continue
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Second)
+		msgList, err := sub.Fetch(free, nats.Context(fetchCtx))
+		cancel()
+		if err != nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("subscriber fetch", "error", err, "namespace", namespace)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fetchBackoff):
+			}
+
 			continue
 		}
 		for _, msg := range msgList {
-			if err := e.handleQueueMessage(lc.Context(), msg); err != nil {
-				slog.Error("handle queue message", "error", err, "msg", string(msg.Data))
+			ev, err := decodeQueueMessage(msg)
+			if err != nil {
+				slog.Error("decode queue msg", "error", err, "msg", string(msg.Data))
 				_ = msg.Nak()
-			} else {
-				_ = msg.Ack()
+
+				continue
 			}
+
+			f.queue.push(&queueItem{
+				msg: msg,
+				ev:  ev,
+				// keep the message from being redelivered while it waits and the instance is executing.
+				stop: every(f.ctx, heartbeatInterval, func() {
+					_ = msg.InProgress()
+				}),
+			})
+		}
+	}
+}
+
+func (e *Engine) runLoop(lc *lifecycle.Manager, queue *fairQueue) {
+	for {
+		item, ok := queue.pop(lc.Context())
+		if !ok {
+			return
+		}
+
+		err := e.execInstance(lc.Context(), item.ev)
+		item.stop()
+		if err != nil {
+			slog.Error("exec instance", "error", err, "msg", string(item.msg.Data))
+			_ = item.msg.Nak()
+		} else {
+			_ = item.msg.Ack()
 		}
 	}
 }
@@ -72,17 +213,3 @@ func decodeQueueMessage(msg *nats.Msg) (*InstanceEvent, error) {
 
 	return &ev, nil
 }
-
-func (e *Engine) handleQueueMessage(ctx context.Context, msg *nats.Msg) error {
-	ev, err := decodeQueueMessage(msg)
-	if err != nil {
-		return fmt.Errorf("decode queue msg: %w", err)
-	}
-
-	err = e.execInstance(ctx, ev)
-	if err != nil {
-		return fmt.Errorf("exec instance: %w", err)
-	}
-
-	return nil
-}
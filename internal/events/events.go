@@ -0,0 +1,391 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/direktiv/direktiv/pkg/lifecycle"
+	"github.com/google/uuid"
+)
+
+// deliveryInterval is how often delayed events are checked, deliveryBatch is the number of
+// delayed events delivered at once.
+const (
+	deliveryInterval = time.Second
+	deliveryBatch    = 100
+)
+
+// maxAccumulateAttempts is how often conflicting updates of and-trigger listeners are retried.
+const maxAccumulateAttempts = 16
+
+// StartWorkflowFunc starts a new instance of the workflow at path with the given input.
+type StartWorkflowFunc func(ctx context.Context, namespace string, path string, input []byte) error
+
+// WakeInstanceFunc continues the instance parked by the wait waitID with the result of the wait.
+type WakeInstanceFunc func(ctx context.Context, namespace string, instanceID uuid.UUID, waitID uuid.UUID, output []byte) error
+
+// Processor matches incoming cloud events against the event listeners of a namespace and
+// triggers the listeners the events complete.
+type Processor struct {
+	store         datastore.Store
+	startWorkflow StartWorkflowFunc
+	wakeInstance  WakeInstanceFunc
+}
+
+func NewProcessor(store datastore.Store, startWorkflow StartWorkflowFunc, wakeInstance WakeInstanceFunc) *Processor {
+	return &Processor{
+		store:         store,
+		startWorkflow: startWorkflow,
+		wakeInstance:  wakeInstance,
+	}
+}
+
+// Topic returns the event topic listeners of namespace are registered under for eventType.
+func Topic(namespace string, eventType string) string {
+	return fmt.Sprintf("%s-%s", namespace, eventType)
+}
+
+// Broadcast stores the events received in namespace in the event history and matches them
+// against the namespace listeners. Events already in the history were delivered before,
+// they are skipped without error so senders can retry a batch. Failing listeners are logged
+// and do not fail the broadcast.
+func (p *Processor) Broadcast(ctx context.Context, namespace string, events []*datastore.Event) error {
+	_, errs := p.store.EventHistory().Append(ctx, events)
+
+	appended := make([]*datastore.Event, 0, len(events))
+	var appendErr error
+	for i := range events {
+		if errors.Is(errs[i], datastore.ErrDuplication) {
+			continue
+		}
+		if errs[i] != nil {
+			appendErr = errs[i]
+			continue
+		}
+		appended = append(appended, events[i])
+	}
+
+	_ = p.ProcessEvents(ctx, namespace, appended)
+
+	return appendErr
+}
+
+// Emit delivers the event ev emitted by an instance in namespace like a broadcast event.
+// Events with a delay are staged and delivered once the delay passed, see Start.
+func (p *Processor) Emit(ctx context.Context, namespace string, ev *cloudevents.Event, delay time.Duration) error {
+	now := time.Now().UTC()
+	received := &datastore.Event{
+		Event:      ev,
+		Namespace:  namespace,
+		ReceivedAt: now,
+	}
+	if delay <= 0 {
+		return p.Broadcast(ctx, namespace, []*datastore.Event{received})
+	}
+
+	_, errs := p.store.StagingEvents().Append(ctx, &datastore.StagingEvent{
+		Event:        received,
+		DelayedUntil: now.Add(delay),
+	})
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("stage event %s: %w", ev.ID(), err)
+	}
+
+	return nil
+}
+
+// Start delivers the staged events once their delay passed.
+func (p *Processor) Start(lc *lifecycle.Manager) {
+	lc.Go(func() error {
+		t := time.NewTicker(deliveryInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-lc.Done():
+				return nil
+			case <-t.C:
+			}
+
+			err := p.deliverDelayed(lc.Context(), time.Now().UTC())
+			if err != nil {
+				slog.Error("deliver delayed events", "error", err)
+			}
+		}
+	})
+}
+
+// deliverDelayed delivers the staged events delayed until before now. All replicas deliver
+// them, the event history rejects the copies of the other replicas. The events count as
+// received when they are delivered.
+func (p *Processor) deliverDelayed(ctx context.Context, now time.Time) error {
+	for {
+		staged, _, err := p.store.StagingEvents().GetDelayedEvents(ctx, now, deliveryBatch, 0)
+		if err != nil {
+			return fmt.Errorf("get delayed events: %w", err)
+		}
+		if len(staged) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(staged))
+		for _, ev := range staged {
+			ev.ReceivedAt = now
+			// copies of other replicas fail as duplicates, they are delivered already.
+			_ = p.Broadcast(ctx, ev.Namespace, []*datastore.Event{ev.Event})
+			ids = append(ids, ev.DatabaseID)
+		}
+
+		err = p.store.StagingEvents().DeleteByDatabaseIDs(ctx, ids...)
+		if err != nil {
+			return fmt.Errorf("delete delivered events: %w", err)
+		}
+		if len(staged) < deliveryBatch {
+			return nil
+		}
+	}
+}
+
+// ProcessEvents matches events received in namespace against the namespace listeners.
+func (p *Processor) ProcessEvents(ctx context.Context, namespace string, events []*datastore.Event) error {
+	var errs []error
+	for _, ev := range events {
+		listeners, err := p.store.EventListenerTopics().GetListeners(ctx, Topic(namespace, ev.Event.Type()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("get listeners for event %s: %w", ev.Event.ID(), err))
+			continue
+		}
+
+		for _, l := range listeners {
+			// topics are not unique if namespace names contain dashes.
+			if l.Namespace != namespace || !MatchesFilters(l.EventContextFilters, ev.Event) {
+				continue
+			}
+
+			err = p.handle(ctx, l, ev)
+			if err != nil {
+				slog.Error("trigger event listener", "listener", l.ID, "event", ev.Event.ID(),
+					"namespace", namespace, "error", err)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Processor) handle(ctx context.Context, l *datastore.EventListener, ev *datastore.Event) error {
+	switch l.TriggerType {
+	case datastore.StartSimple, datastore.StartOR, datastore.WaitSimple, datastore.WaitOR:
+		return p.trigger(ctx, l, []*datastore.Event{ev})
+	case datastore.StartAnd, datastore.WaitAnd:
+		return p.accumulate(ctx, l, ev)
+	default:
+		return fmt.Errorf("unsupported trigger type %s", l.TriggerType)
+	}
+}
+
+// accumulate stores ev in the and-trigger listener l and triggers it once events of all
+// listened types are received. Replicas receiving events of l concurrently retry their
+// update, only the update completing the events triggers l.
+func (p *Processor) accumulate(ctx context.Context, l *datastore.EventListener, ev *datastore.Event) error {
+	for range maxAccumulateAttempts {
+		// reload the listener, the received events might be updated by a previous event.
+		fresh, err := p.store.EventListener().GetByID(ctx, l.ID)
+		if err != nil {
+			return fmt.Errorf("get listener %s: %w", l.ID, err)
+		}
+
+		now := time.Now().UTC()
+		received, complete := AddAndTriggerEvent(fresh, ev, now)
+		if complete {
+			fresh.ReceivedEventsForAndTrigger = make([]*datastore.Event, 0)
+		} else {
+			fresh.ReceivedEventsForAndTrigger = received
+		}
+		fresh.UpdatedAt = now
+
+		err = p.store.EventListener().CompareAndUpdate(ctx, fresh)
+		if errors.Is(err, datastore.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("update listener %s: %w", l.ID, err)
+		}
+
+		if !complete {
+			return nil
+		}
+
+		return p.trigger(ctx, fresh, received)
+	}
+
+	return fmt.Errorf("update listener %s: changed concurrently %d times", l.ID, maxAccumulateAttempts)
+}
+
+func (p *Processor) trigger(ctx context.Context, l *datastore.EventListener, events []*datastore.Event) error {
+	if l.TriggerInstance != "" {
+		return p.wake(ctx, l, events)
+	}
+
+	input, err := WorkflowInput(events)
+	if err != nil {
+		return err
+	}
+
+	err = p.startWorkflow(ctx, l.Namespace, l.TriggerWorkflow, input)
+	if err != nil {
+		return fmt.Errorf("start workflow %s: %w", l.TriggerWorkflow, err)
+	}
+
+	return nil
+}
+
+// wake continues the instance waiting on l. Wait listeners trigger once.
+func (p *Processor) wake(ctx context.Context, l *datastore.EventListener, events []*datastore.Event) error {
+	instID, err := uuid.Parse(l.TriggerInstance)
+	if err != nil {
+		return fmt.Errorf("parse trigger instance of listener %s: %w", l.ID, err)
+	}
+
+	output, err := WaitOutput(l, events)
+	if err != nil {
+		return err
+	}
+
+	err = p.wakeInstance(ctx, l.Namespace, instID, l.ID, output)
+	if err != nil {
+		return fmt.Errorf("wake instance %s: %w", instID, err)
+	}
+
+	err = p.store.EventListener().DeleteByID(ctx, l.ID)
+	if err != nil {
+		return fmt.Errorf("delete listener %s: %w", l.ID, err)
+	}
+
+	return nil
+}
+
+// AddAndTriggerEvent adds ev to the events received by the and-trigger listener l. Expired
+// events are dropped and an event replaces an earlier one of the same type. It reports if
+// events of all listened types are received.
+func AddAndTriggerEvent(l *datastore.EventListener, ev *datastore.Event, now time.Time) ([]*datastore.Event, bool) {
+	received := make([]*datastore.Event, 0, len(l.ReceivedEventsForAndTrigger)+1)
+	for _, r := range l.ReceivedEventsForAndTrigger {
+		if r == nil || r.Event == nil || r.Event.Type() == ev.Event.Type() {
+			continue
+		}
+		lifespan := time.Duration(l.LifespanOfReceivedEvents) * time.Millisecond
+		if lifespan > 0 && r.ReceivedAt.Add(lifespan).Before(now) {
+			continue
+		}
+		received = append(received, r)
+	}
+	received = append(received, ev)
+
+	for _, t := range l.ListeningForEventTypes {
+		if !slices.ContainsFunc(received, func(r *datastore.Event) bool {
+			return r.Event.Type() == t
+		}) {
+			return received, false
+		}
+	}
+
+	return received, true
+}
+
+// WorkflowInput builds the input of a triggered workflow. The events are keyed by their type.
+func WorkflowInput(events []*datastore.Event) ([]byte, error) {
+	input := make(map[string]*cloudevents.Event, len(events))
+	for _, ev := range events {
+		input[ev.Event.Type()] = ev.Event
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal workflow input: %w", err)
+	}
+
+	return b, nil
+}
+
+// WaitOutput builds the result of the wait of an instance. And-triggers return the events
+// keyed by their type like WorkflowInput, the others the event itself.
+func WaitOutput(l *datastore.EventListener, events []*datastore.Event) ([]byte, error) {
+	if l.TriggerType == datastore.WaitAnd || len(events) != 1 {
+		return WorkflowInput(events)
+	}
+
+	b, err := json.Marshal(events[0].Event)
+	if err != nil {
+		return nil, fmt.Errorf("marshal wait output: %w", err)
+	}
+
+	return b, nil
+}
+
+// MatchesFilters reports if ev satisfies the context filters defined for its type. Filter values
+// support '*' and '?' wildcards.
+func MatchesFilters(filters []datastore.EventContextFilter, ev *cloudevents.Event) bool {
+	for _, f := range filters {
+		if f.Type != ev.Type() {
+			continue
+		}
+		for k, want := range f.Context {
+			got, ok := contextValue(ev, k)
+			if !ok || !matchGlob(want, got) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func contextValue(ev *cloudevents.Event, key string) (string, bool) {
+	switch key {
+	case "id":
+		return ev.ID(), true
+	case "source":
+		return ev.Source(), true
+	case "type":
+		return ev.Type(), true
+	case "subject":
+		return ev.Subject(), true
+	case "specversion":
+		return ev.SpecVersion(), true
+	case "datacontenttype":
+		return ev.DataContentType(), true
+	case "dataschema":
+		return ev.DataSchema(), true
+	}
+
+	v, ok := ev.Extensions()[key]
+	if !ok {
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", v), true
+}
+
+func matchGlob(pattern string, value string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == value
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+
+	ok, _ := regexp.MatchString("^"+expr+"$", value)
+
+	return ok
+}
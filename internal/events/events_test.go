@@ -0,0 +1,393 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newEvent(typ string, source string, ext map[string]any) *datastore.Event {
+	ev := cloudevents.NewEvent()
+	ev.SetID(typ + "-id")
+	ev.SetType(typ)
+	ev.SetSource(source)
+	for k, v := range ext {
+		ev.SetExtension(k, v)
+	}
+
+	return &datastore.Event{Event: &ev, ReceivedAt: time.Now()}
+}
+
+func TestMatchesFilters(t *testing.T) {
+	ev := newEvent("greeting", "https://direktiv.io/test", map[string]any{"hello": "world"})
+
+	tests := []struct {
+		name    string
+		filters []datastore.EventContextFilter
+		want    bool
+	}{
+		{"no filters", nil, true},
+		{"other type", []datastore.EventContextFilter{{Type: "other", Context: map[string]string{"hello": "x"}}}, true},
+		{"extension", []datastore.EventContextFilter{{Type: "greeting", Context: map[string]string{"hello": "world"}}}, true},
+		{"extension mismatch", []datastore.EventContextFilter{{Type: "greeting", Context: map[string]string{"hello": "moon"}}}, false},
+		{"missing attribute", []datastore.EventContextFilter{{Type: "greeting", Context: map[string]string{"foo": "bar"}}}, false},
+		{"source glob", []datastore.EventContextFilter{{Type: "greeting", Context: map[string]string{"source": "https://direktiv.io/*"}}}, true},
+		{"single char glob", []datastore.EventContextFilter{{Type: "greeting", Context: map[string]string{"hello": "w?rld"}}}, true},
+		{"glob mismatch", []datastore.EventContextFilter{{Type: "greeting", Context: map[string]string{"source": "*.com/*"}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, MatchesFilters(tt.filters, ev.Event))
+		})
+	}
+}
+
+func TestAddAndTriggerEvent(t *testing.T) {
+	now := time.Now()
+	l := &datastore.EventListener{
+		ListeningForEventTypes:   []string{"a", "b"},
+		LifespanOfReceivedEvents: 1000,
+	}
+
+	received, complete := AddAndTriggerEvent(l, newEvent("a", "src", nil), now)
+	require.False(t, complete)
+	require.Len(t, received, 1)
+	l.ReceivedEventsForAndTrigger = received
+
+	// a second event of the same type replaces the first one.
+	received, complete = AddAndTriggerEvent(l, newEvent("a", "src2", nil), now)
+	require.False(t, complete)
+	require.Len(t, received, 1)
+	require.Equal(t, "src2", received[0].Event.Source())
+	l.ReceivedEventsForAndTrigger = received
+
+	// expired events do not count.
+	_, complete = AddAndTriggerEvent(l, newEvent("b", "src", nil), now.Add(2*time.Second))
+	require.False(t, complete)
+
+	received, complete = AddAndTriggerEvent(l, newEvent("b", "src", nil), now)
+	require.True(t, complete)
+	require.Len(t, received, 2)
+}
+
+func TestWorkflowInput(t *testing.T) {
+	b, err := WorkflowInput([]*datastore.Event{newEvent("a", "src", nil), newEvent("b", "src", nil)})
+	require.NoError(t, err)
+
+	var input map[string]map[string]any
+	require.NoError(t, json.Unmarshal(b, &input))
+	require.Equal(t, "a-id", input["a"]["id"])
+	require.Equal(t, "b-id", input["b"]["id"])
+}
+
+func TestNewStartListener(t *testing.T) {
+	config := core.FlowConfig{
+		Type: "eventsAnd",
+		Events: []core.EventConfig{
+			{Type: "a", Context: map[string]any{"hello": "world"}},
+			{Type: "b"},
+		},
+	}
+
+	l := NewStartListener("ns", "/wf.ts", config)
+	require.NotNil(t, l)
+	require.Equal(t, datastore.StartAnd, l.TriggerType)
+	require.Equal(t, []string{"a", "b"}, l.ListeningForEventTypes)
+	require.Len(t, l.EventContextFilters, 1)
+	require.Equal(t, "/wf.ts", l.TriggerWorkflow)
+
+	// ids are stable between renders and differ between definitions.
+	require.Equal(t, l.ID, NewStartListener("ns", "/wf.ts", config).ID)
+	require.NotEqual(t, l.ID, NewStartListener("ns", "/other.ts", config).ID)
+
+	require.Nil(t, NewStartListener("ns", "/wf.ts", core.FlowConfig{Type: "default"}))
+}
+
+func TestNewWaitListener(t *testing.T) {
+	waitID, instID := uuid.New(), uuid.New()
+
+	l := NewWaitListener(waitID, "ns", instID, []string{"a"}, map[string]string{"hello": "world"}, false)
+	require.Equal(t, waitID, l.ID)
+	require.Equal(t, datastore.WaitSimple, l.TriggerType)
+	require.Equal(t, instID.String(), l.TriggerInstance)
+	require.Len(t, l.EventContextFilters, 1)
+
+	require.Equal(t, datastore.WaitOR, NewWaitListener(waitID, "ns", instID, []string{"a", "b"}, nil, false).TriggerType)
+	require.Equal(t, datastore.WaitAnd, NewWaitListener(waitID, "ns", instID, []string{"a", "b"}, nil, true).TriggerType)
+}
+
+func TestWaitOutput(t *testing.T) {
+	b, err := WaitOutput(&datastore.EventListener{TriggerType: datastore.WaitOR}, []*datastore.Event{newEvent("a", "src", nil)})
+	require.NoError(t, err)
+
+	var ev map[string]any
+	require.NoError(t, json.Unmarshal(b, &ev))
+	require.Equal(t, "a-id", ev["id"])
+
+	b, err = WaitOutput(&datastore.EventListener{TriggerType: datastore.WaitAnd}, []*datastore.Event{newEvent("a", "src", nil)})
+	require.NoError(t, err)
+
+	var input map[string]map[string]any
+	require.NoError(t, json.Unmarshal(b, &input))
+	require.Equal(t, "a-id", input["a"]["id"])
+}
+
+// fakeListeners is an in-memory datastore.EventListenerStore of one listener. onUpdate runs
+// before each compare-and-update, like the update of another replica would.
+type fakeListeners struct {
+	datastore.EventListenerStore
+	l        datastore.EventListener
+	onUpdate func()
+}
+
+func (f *fakeListeners) GetByID(_ context.Context, _ uuid.UUID) (*datastore.EventListener, error) {
+	cp := f.l
+	cp.ReceivedEventsForAndTrigger = slices.Clone(f.l.ReceivedEventsForAndTrigger)
+
+	return &cp, nil
+}
+
+func (f *fakeListeners) CompareAndUpdate(_ context.Context, l *datastore.EventListener) error {
+	if f.onUpdate != nil {
+		update := f.onUpdate
+		f.onUpdate = nil
+		update()
+	}
+	if l.Version != f.l.Version {
+		return datastore.ErrVersionConflict
+	}
+	f.l.ReceivedEventsForAndTrigger = l.ReceivedEventsForAndTrigger
+	f.l.Version++
+
+	return nil
+}
+
+type fakeListenersStore struct {
+	datastore.Store
+	listeners *fakeListeners
+}
+
+func (s *fakeListenersStore) EventListener() datastore.EventListenerStore {
+	return s.listeners
+}
+
+func TestAccumulateConcurrentUpdate(t *testing.T) {
+	listeners := &fakeListeners{l: datastore.EventListener{
+		ID:                     uuid.New(),
+		Namespace:              "ns",
+		ListeningForEventTypes: []string{"a", "b"},
+		TriggerType:            datastore.StartAnd,
+		TriggerWorkflow:        "/wf.ts",
+		Version:                1,
+	}}
+	var started []string
+	p := NewProcessor(&fakeListenersStore{listeners: listeners},
+		func(ctx context.Context, namespace string, path string, input []byte) error {
+			started = append(started, string(input))
+			return nil
+		}, nil)
+
+	// another replica stores event a while this one stores event b.
+	listeners.onUpdate = func() {
+		require.NoError(t, p.accumulate(t.Context(), &listeners.l, newEvent("a", "src", nil)))
+	}
+	require.NoError(t, p.accumulate(t.Context(), &listeners.l, newEvent("b", "src", nil)))
+
+	require.Len(t, started, 1)
+	var input map[string]any
+	require.NoError(t, json.Unmarshal([]byte(started[0]), &input))
+	require.Contains(t, input, "a")
+	require.Contains(t, input, "b")
+	require.Empty(t, listeners.l.ReceivedEventsForAndTrigger)
+}
+
+// fakeStaging is an in-memory datastore.StagingEventStore.
+type fakeStaging struct {
+	datastore.StagingEventStore
+	staged []*datastore.StagingEvent
+}
+
+func (f *fakeStaging) GetDelayedEvents(_ context.Context, currentTime time.Time, limit int, offset int) ([]*datastore.StagingEvent, int, error) {
+	var due []*datastore.StagingEvent
+	for _, ev := range f.staged {
+		if ev.DelayedUntil.Before(currentTime) {
+			due = append(due, ev)
+		}
+	}
+
+	return due[offset:min(offset+limit, len(due))], len(due), nil
+}
+
+func (f *fakeStaging) DeleteByDatabaseIDs(_ context.Context, ids ...uuid.UUID) error {
+	f.staged = slices.DeleteFunc(f.staged, func(ev *datastore.StagingEvent) bool {
+		return slices.Contains(ids, ev.DatabaseID)
+	})
+
+	return nil
+}
+
+// fakeHistory is an in-memory datastore.EventHistoryStore.
+type fakeHistory struct {
+	datastore.EventHistoryStore
+	events []*datastore.Event
+}
+
+func (f *fakeHistory) Append(_ context.Context, events []*datastore.Event) ([]*datastore.Event, []error) {
+	errs := make([]error, len(events))
+	for i, ev := range events {
+		for _, had := range f.events {
+			if had.Event.ID() == ev.Event.ID() {
+				errs[i] = datastore.ErrDuplication
+			}
+		}
+		if errs[i] == nil {
+			f.events = append(f.events, ev)
+		}
+	}
+
+	return events, errs
+}
+
+type fakeTopics struct {
+	datastore.EventTopicsStore
+}
+
+func (f *fakeTopics) GetListeners(_ context.Context, _ string) ([]*datastore.EventListener, error) {
+	return nil, nil
+}
+
+func (f *fakeTopics) Append(_ context.Context, _ uuid.UUID, _ string, _ uuid.UUID, _ string, _ string) error {
+	return nil
+}
+
+type fakeDeliveryStore struct {
+	datastore.Store
+	staging *fakeStaging
+	history *fakeHistory
+}
+
+func (s *fakeDeliveryStore) StagingEvents() datastore.StagingEventStore {
+	return s.staging
+}
+
+func (s *fakeDeliveryStore) EventHistory() datastore.EventHistoryStore {
+	return s.history
+}
+
+func (s *fakeDeliveryStore) EventListenerTopics() datastore.EventTopicsStore {
+	return &fakeTopics{}
+}
+
+func TestDeliverDelayed(t *testing.T) {
+	emitted := time.Now().UTC().Add(-time.Hour)
+	due := newEvent("due", "src", nil)
+	due.ReceivedAt = emitted
+	later := newEvent("later", "src", nil)
+	store := &fakeDeliveryStore{
+		staging: &fakeStaging{staged: []*datastore.StagingEvent{
+			{Event: due, DatabaseID: uuid.New(), DelayedUntil: emitted.Add(time.Minute)},
+			{Event: later, DatabaseID: uuid.New(), DelayedUntil: time.Now().Add(time.Hour)},
+		}},
+		history: &fakeHistory{},
+	}
+	p := NewProcessor(store, nil, nil)
+
+	now := time.Now().UTC()
+	require.NoError(t, p.deliverDelayed(t.Context(), now))
+
+	// delayed events count as received when they are delivered.
+	require.Len(t, store.history.events, 1)
+	require.Equal(t, "due", store.history.events[0].Event.Type())
+	require.Equal(t, now, store.history.events[0].ReceivedAt)
+	require.Len(t, store.staging.staged, 1)
+}
+
+func TestBroadcastDuplicates(t *testing.T) {
+	store := &fakeDeliveryStore{history: &fakeHistory{}}
+	p := NewProcessor(store, nil, nil)
+
+	first := newEvent("first", "src", nil)
+	require.NoError(t, p.Broadcast(t.Context(), "ns", []*datastore.Event{first}))
+
+	// a retried batch delivers the events missing from the history.
+	second := newEvent("second", "src", nil)
+	require.NoError(t, p.Broadcast(t.Context(), "ns", []*datastore.Event{first, second}))
+	require.Len(t, store.history.events, 2)
+	require.Equal(t, "second", store.history.events[1].Event.Type())
+}
+
+// fakeSyncListeners is an in-memory datastore.EventListenerStore of the listeners of all namespaces.
+type fakeSyncListeners struct {
+	datastore.EventListenerStore
+	listeners []*datastore.EventListener
+}
+
+func (f *fakeSyncListeners) Get(_ context.Context, namespace string, _, _ int) ([]*datastore.EventListener, int, error) {
+	var list []*datastore.EventListener
+	for _, l := range f.listeners {
+		if l.Namespace == namespace {
+			list = append(list, l)
+		}
+	}
+
+	return list, len(list), nil
+}
+
+func (f *fakeSyncListeners) Append(_ context.Context, l *datastore.EventListener) error {
+	f.listeners = append(f.listeners, l)
+	return nil
+}
+
+func (f *fakeSyncListeners) DeleteByID(_ context.Context, id uuid.UUID) error {
+	f.listeners = slices.DeleteFunc(f.listeners, func(l *datastore.EventListener) bool {
+		return l.ID == id
+	})
+
+	return nil
+}
+
+type fakeSyncStore struct {
+	datastore.Store
+	listeners *fakeSyncListeners
+}
+
+func (s *fakeSyncStore) EventListener() datastore.EventListenerStore {
+	return s.listeners
+}
+
+func (s *fakeSyncStore) EventListenerTopics() datastore.EventTopicsStore {
+	return &fakeTopics{}
+}
+
+func TestSyncListeners(t *testing.T) {
+	listener := func(namespace string, path string) *datastore.EventListener {
+		l := &datastore.EventListener{
+			Namespace:              namespace,
+			TriggerWorkflow:        path,
+			ListeningForEventTypes: []string{"order"},
+		}
+		l.ID = listenerID(l)
+
+		return l
+	}
+	kept := listener("ns", "/kept.wf.ts")
+	stale := listener("ns", "/stale.wf.ts")
+	other := listener("other", "/stale.wf.ts")
+	listeners := &fakeSyncListeners{listeners: []*datastore.EventListener{kept, stale, other}}
+	store := &fakeSyncStore{listeners: listeners}
+
+	added := listener("ns", "/added.wf.ts")
+	require.NoError(t, SyncListeners(t.Context(), store, "ns", []*datastore.EventListener{kept, added}))
+
+	// the listeners of other namespaces are not touched.
+	require.Equal(t, []*datastore.EventListener{kept, other, added}, listeners.listeners)
+}
@@ -0,0 +1,168 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
+	"github.com/google/uuid"
+)
+
+var flowTriggerTypes = map[string]datastore.TriggerType{
+	"event":     datastore.StartSimple,
+	"eventsOr":  datastore.StartOR,
+	"eventsAnd": datastore.StartAnd,
+}
+
+// NewStartListener builds the listener starting the event-triggered workflow at path. It returns
+// nil if the flow is not triggered by events.
+func NewStartListener(namespace string, path string, config core.FlowConfig) *datastore.EventListener {
+	triggerType, ok := flowTriggerTypes[config.Type]
+	if !ok || len(config.Events) == 0 {
+		return nil
+	}
+
+	types := make([]string, 0, len(config.Events))
+	filters := make([]datastore.EventContextFilter, 0)
+	for _, ev := range config.Events {
+		types = append(types, ev.Type)
+		if len(ev.Context) == 0 {
+			continue
+		}
+		f := datastore.EventContextFilter{
+			Type:    ev.Type,
+			Context: make(map[string]string, len(ev.Context)),
+		}
+		for k, v := range ev.Context {
+			f.Context[k] = fmt.Sprintf("%v", v)
+		}
+		filters = append(filters, f)
+	}
+
+	now := time.Now().UTC()
+	l := &datastore.EventListener{
+		CreatedAt:                   now,
+		UpdatedAt:                   now,
+		Namespace:                   namespace,
+		ListeningForEventTypes:      types,
+		ReceivedEventsForAndTrigger: make([]*datastore.Event, 0),
+		TriggerType:                 triggerType,
+		TriggerWorkflow:             path,
+		EventContextFilters:         filters,
+	}
+	l.ID = listenerID(l)
+
+	return l
+}
+
+// NewWaitListener builds the listener continuing the instance instanceID once events of the
+// given types arrive. The listener id is the id of the wait, so the engine can delete it when
+// the wait ends otherwise.
+func NewWaitListener(waitID uuid.UUID, namespace string, instanceID uuid.UUID, types []string,
+	eventContext map[string]string, all bool,
+) *datastore.EventListener {
+	triggerType := datastore.WaitOR
+	if len(types) == 1 {
+		triggerType = datastore.WaitSimple
+	} else if all {
+		triggerType = datastore.WaitAnd
+	}
+
+	filters := make([]datastore.EventContextFilter, 0)
+	if len(eventContext) > 0 {
+		for _, t := range types {
+			filters = append(filters, datastore.EventContextFilter{
+				Type:    t,
+				Context: eventContext,
+			})
+		}
+	}
+
+	now := time.Now().UTC()
+
+	return &datastore.EventListener{
+		ID:                          waitID,
+		CreatedAt:                   now,
+		UpdatedAt:                   now,
+		Namespace:                   namespace,
+		ListeningForEventTypes:      types,
+		ReceivedEventsForAndTrigger: make([]*datastore.Event, 0),
+		TriggerType:                 triggerType,
+		TriggerInstance:             instanceID.String(),
+		EventContextFilters:         filters,
+	}
+}
+
+// AddListener registers l and the topics of its event types.
+func AddListener(ctx context.Context, store datastore.Store, l *datastore.EventListener) error {
+	err := store.EventListener().Append(ctx, l)
+	if err != nil {
+		return fmt.Errorf("append listener %s: %w", l.ID, err)
+	}
+
+	var errs []error
+	for _, t := range l.ListeningForEventTypes {
+		err = store.EventListenerTopics().Append(ctx, l.NamespaceID, l.Namespace, l.ID, Topic(l.Namespace, t), "")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("append topic %s: %w", t, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// listenerID derives the id from the listener definition, so all replicas rendering the same
+// workflow files agree on the listeners.
+func listenerID(l *datastore.EventListener) uuid.UUID {
+	b, _ := json.Marshal([]any{l.Namespace, l.TriggerWorkflow, l.TriggerType, l.ListeningForEventTypes, l.EventContextFilters})
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, b)
+}
+
+// SyncListeners makes the start listeners of namespace match the given listeners. Listeners
+// that are already registered are kept, so and-triggers keep their received events.
+func SyncListeners(ctx context.Context, store datastore.Store, namespace string, listeners []*datastore.EventListener) error {
+	list, _, err := store.EventListener().Get(ctx, namespace, 0, 0)
+	if err != nil {
+		return fmt.Errorf("list listeners: %w", err)
+	}
+
+	existing := make(map[uuid.UUID]bool)
+	for _, l := range list {
+		if l.TriggerWorkflow == "" || l.TriggerInstance != "" {
+			continue
+		}
+		existing[l.ID] = true
+	}
+
+	var errs []error
+	for _, l := range listeners {
+		if existing[l.ID] {
+			delete(existing, l.ID)
+			continue
+		}
+
+		err = AddListener(ctx, store, l)
+		if errors.Is(err, datastore.ErrDuplication) {
+			// another replica registered it concurrently.
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("add listener for %s: %w", l.TriggerWorkflow, err))
+		}
+	}
+
+	// whatever is left is not backed by a workflow anymore.
+	for id := range existing {
+		err = store.EventListener().DeleteByID(ctx, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delete listener %s: %w", id, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
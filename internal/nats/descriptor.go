@@ -1,6 +1,7 @@
 package nats
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -11,6 +12,8 @@ type Descriptor struct {
 	name           string
 	streamConfig   *nats.StreamConfig
 	consumerConfig *nats.ConsumerConfig
+	// namespaced descriptors have a consumer per namespace instead of a shared one.
+	namespaced bool
 }
 
 func newDescriptor(name string, streamConfig *nats.StreamConfig, consumerConfig *nats.ConsumerConfig) *Descriptor {
@@ -34,6 +37,15 @@ func newDescriptor(name string, streamConfig *nats.StreamConfig, consumerConfig
 	return dp
 }
 
+// newNamespacedDescriptor returns a descriptor with a durable consumer per namespace, see
+// PullSubscribeNamespace. consumerConfig is the template of the consumers.
+func newNamespacedDescriptor(name string, streamConfig *nats.StreamConfig, consumerConfig *nats.ConsumerConfig) *Descriptor {
+	dp := newDescriptor(name, streamConfig, consumerConfig)
+	dp.namespaced = true
+
+	return dp
+}
+
 func (n Descriptor) Subject(namespace string, id string) string {
 	// replace dots with dashes as NATS does not allow dots in subjects.
 	namespace = strings.ReplaceAll(namespace, ".", "-")
@@ -53,3 +65,27 @@ func (n Descriptor) PullSubscribe(js nats.JetStreamContext, opts ...nats.SubOpt)
 
 	return sub, nil
 }
+
+// PullSubscribeNamespace binds to the durable consumer of the messages of namespace, the
+// consumer is created if it does not exist yet.
+func (n Descriptor) PullSubscribeNamespace(js nats.JetStreamContext, namespace string, opts ...nats.SubOpt) (*nats.Subscription, error) {
+	if !n.namespaced {
+		return nil, fmt.Errorf("stream %s has no namespace consumers", n)
+	}
+
+	cfg := *n.consumerConfig
+	cfg.Durable = n.String() + "-" + strings.ReplaceAll(namespace, ".", "-")
+	cfg.FilterSubject = n.Subject(namespace, "*")
+
+	_, err := js.ConsumerInfo(n.String(), cfg.Durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(n.String(), &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("nats ensure consumer %s: %w", cfg.Durable, err)
+	}
+
+	opts = append(opts, nats.Bind(n.String(), cfg.Durable))
+
+	return js.PullSubscribe(cfg.FilterSubject, cfg.Durable, opts...)
+}
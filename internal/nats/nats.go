@@ -62,19 +62,32 @@ var (
 			MaxMsgsPerSubject: 1,
 		}, nil)
 
-	StreamEngineQueue = newDescriptor("engine.queue",
+	// StreamEngineQueue has a consumer per namespace, a namespace flooding the queue does
+	// not hold back the messages of the others.
+	StreamEngineQueue = newNamespacedDescriptor("engine.queue",
 		&nats.StreamConfig{
 			Storage:    nats.FileStorage,
 			Retention:  nats.WorkQueuePolicy,
 			Duplicates: 1 * time.Hour,
 		}, &nats.ConsumerConfig{
-			AckPolicy:         nats.AckExplicitPolicy,
-			AckWait:           5 * time.Minute,
-			MaxDeliver:        10,
-			DeliverPolicy:     nats.DeliverAllPolicy,
-			ReplayPolicy:      nats.ReplayInstantPolicy,
-			InactiveThreshold: 0, // means never auto-delete
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       5 * time.Minute,
+			MaxDeliver:    10,
+			DeliverPolicy: nats.DeliverAllPolicy,
+			ReplayPolicy:  nats.ReplayInstantPolicy,
+			// the consumers of deleted namespaces are removed.
+			InactiveThreshold: 72 * time.Hour,
 		})
+
+	StreamEngineLease = newDescriptor("engine.lease",
+		&nats.StreamConfig{
+			Storage:   nats.FileStorage,
+			Retention: nats.LimitsPolicy,
+			MaxAge:    24 * time.Hour,
+			Discard:   nats.DiscardOld,
+			// important: keep only 1 message per subject (latest heartbeat)
+			MaxMsgsPerSubject: 1,
+		}, nil)
 )
 
 var allDescriptors = []*Descriptor{
@@ -83,6 +96,7 @@ var allDescriptors = []*Descriptor{
 	StreamSchedRule,
 	StreamSchedTask,
 	StreamEngineQueue,
+	StreamEngineLease,
 }
 
 type Conn = nats.Conn
@@ -157,6 +171,16 @@ func SetupJetStream(ctx context.Context, nc *nats.Conn) (nats.JetStreamContext,
 		if dp.consumerConfig == nil {
 			continue
 		}
+		if dp.namespaced {
+			// work queue streams reject overlapping consumers, drop the shared consumer of
+			// older versions. Its unacknowledged messages are delivered again.
+			err = js.DeleteConsumer(dp.String(), dp.String(), nats.Context(ctx))
+			if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+				return nil, fmt.Errorf("nats delete consumer %s: %w", dp, err)
+			}
+
+			continue
+		}
 		err = ensureConsumer(ctx, js, dp.consumerConfig)
 		if err != nil {
 			return nil, fmt.Errorf("nats ensure consumer %s: %w", dp, err)
@@ -9,7 +9,9 @@ import (
 	"github.com/direktiv/direktiv/internal/cluster/cache"
 	"github.com/direktiv/direktiv/internal/compiler"
 	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/datastore"
 	"github.com/direktiv/direktiv/internal/datastore/datasql"
+	"github.com/direktiv/direktiv/internal/events"
 	"github.com/direktiv/direktiv/internal/sched"
 	"github.com/direktiv/direktiv/pkg/filestore"
 	"github.com/direktiv/direktiv/pkg/filestore/filesql"
@@ -221,6 +223,7 @@ func renderWorkflowFiles(db *gorm.DB, scheduler *sched.Scheduler, cacheManager c
 			continue
 		}
 
+		listeners := make([]*datastore.EventListener, 0)
 		for a := range files {
 			f := files[a]
 			// only workflows
@@ -235,6 +238,9 @@ func renderWorkflowFiles(db *gorm.DB, scheduler *sched.Scheduler, cacheManager c
 
 				continue
 			}
+			if l := events.NewStartListener(ns.Name, f.Path, s.Config); l != nil {
+				listeners = append(listeners, l)
+			}
 			if s.Config.Cron == "" {
 				continue
 			}
@@ -251,6 +257,12 @@ func renderWorkflowFiles(db *gorm.DB, scheduler *sched.Scheduler, cacheManager c
 					slog.String("path", f.Path), slog.Any("error", err))
 			}
 		}
+
+		err = events.SyncListeners(ctx, dStore, ns.Name, listeners)
+		if err != nil {
+			slog.Error("cannot sync event listeners",
+				slog.String("namespace", ns.Name), slog.Any("error", err))
+		}
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +22,7 @@ import (
 	"github.com/direktiv/direktiv/internal/datastore/datasql"
 	"github.com/direktiv/direktiv/internal/engine"
 	"github.com/direktiv/direktiv/internal/engine/databus"
+	"github.com/direktiv/direktiv/internal/events"
 	"github.com/direktiv/direktiv/internal/extensions"
 	"github.com/direktiv/direktiv/internal/gateway"
 	"github.com/direktiv/direktiv/internal/mirroring"
@@ -31,7 +33,9 @@ import (
 	"github.com/direktiv/direktiv/internal/service/registry"
 	"github.com/direktiv/direktiv/internal/telemetry"
 	"github.com/direktiv/direktiv/pkg/database"
+	"github.com/direktiv/direktiv/pkg/filestore/filesql"
 	"github.com/direktiv/direktiv/pkg/lifecycle"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/nats-io/nats.go"
 	"gorm.io/driver/postgres"
@@ -232,10 +236,27 @@ func Start(lc *lifecycle.Manager) error {
 			comp,
 			js,
 			store,
+			filesql.NewStore(app.DB),
+			config,
 		)
 		if err != nil {
 			return fmt.Errorf("create engine, err: %w", err)
 		}
+
+		slog.Info("initializing events processor")
+		app.Events = events.NewProcessor(store, func(ctx context.Context, namespace string, path string, input []byte) error {
+			_, _, err := app.Engine.StartWorkflow(ctx, uuid.New(), namespace, path, string(input), map[string]string{
+				engine.LabelWithNotify:   strconv.FormatBool(false),
+				engine.LabelWithSyncExec: strconv.FormatBool(false),
+				engine.LabelInvokerType:  "event",
+				engine.LabelWithScope:    "main",
+			})
+
+			return err
+		}, app.Engine.WakeInstance)
+		app.Engine.SetEventEmitter(app.Events.Emit)
+		app.Events.Start(lc)
+
 		err = app.Engine.Start(lc)
 		if err != nil {
 			return fmt.Errorf("start engine, err: %w", err)
@@ -126,6 +126,12 @@ func newExternalServer(rm *requestMap) *externalServer {
 			code := resp.Header.Get(core.EngineHeaderErrorCode)
 			msg := resp.Header.Get(core.EngineHeaderErrorMessage)
 
+			// the status is replaced, the code keeps it for the runtime.
+			if code == "" {
+				resp.Header.Set(core.EngineHeaderErrorCode,
+					fmt.Sprintf("%s.%d", core.ErrorCodeHTTP, resp.StatusCode))
+			}
+
 			telemetry.LogInstance(resp.Request.Context(), telemetry.LogLevelError,
 				fmt.Sprintf("action request failed with status code %d", resp.StatusCode))
 
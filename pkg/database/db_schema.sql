@@ -104,6 +104,22 @@ CREATE TABLE IF NOT EXISTS "runtime_variables" (
     UNIQUE NULLS NOT DISTINCT (namespace, name, workflow_path, instance_id)
 );
 DROP INDEX IF EXISTS "runtime_variables_unique";
+ALTER TABLE "runtime_variables" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS "runtime_leases" (
+    "namespace" text NOT NULL,
+    "key" text NOT NULL,
+    "holder" text NOT NULL,
+    "instance_id" uuid NOT NULL,
+    "lease_limit" integer NOT NULL,
+    "expires_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY ("namespace", "key", "holder"),
+
+    CONSTRAINT "fk_namespaces_runtime_leases"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
 
 CREATE TABLE IF NOT EXISTS "engine_messages" (
     "id" uuid,
@@ -124,3 +140,78 @@ CREATE TABLE IF NOT EXISTS "traces" (
     "end_time" timestamptz,
     "metadata" JSONB
 );
+
+CREATE TABLE IF NOT EXISTS "events_history" (
+    "serial_id" serial,
+    "id" text NOT NULL,
+    "type" text NOT NULL,
+    "source" text NOT NULL,
+    "cloudevent" text NOT NULL,
+    "namespace_id" uuid,
+    "namespace" text NOT NULL,
+    "received_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("namespace", "id"),
+    CONSTRAINT "fk_namespaces_events_history"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "events_history_namespace_received_at" ON "events_history" ("namespace", "received_at");
+-- event ids are unique per namespace, tables created with the id as key are migrated.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_constraint
+               WHERE conname = 'events_history_pkey' AND conrelid = '"events_history"'::regclass
+               AND array_length(conkey, 1) = 1) THEN
+        ALTER TABLE "events_history" DROP CONSTRAINT "events_history_pkey";
+        ALTER TABLE "events_history" ADD PRIMARY KEY ("namespace", "id");
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS "event_listeners" (
+    "id" uuid,
+    "namespace_id" uuid,
+    "namespace" text NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "deleted" boolean NOT NULL DEFAULT FALSE,
+    "received_events" bytea,
+    "trigger_type" integer NOT NULL,
+    "events_lifespan" integer NOT NULL DEFAULT 0,
+    "event_types" text NOT NULL,
+    "trigger_info" text NOT NULL,
+    "metadata" text,
+    "event_context_filters" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_namespaces_event_listeners"
+    FOREIGN KEY ("namespace") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
+ALTER TABLE "event_listeners" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS "event_topics" (
+    "id" uuid,
+    "event_listener_id" uuid NOT NULL,
+    "namespace_id" uuid,
+    "namespace" text NOT NULL,
+    "topic" text NOT NULL,
+    "filter" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_event_listeners_event_topics"
+    FOREIGN KEY ("event_listener_id") REFERENCES "event_listeners"("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "event_topics_topic" ON "event_topics" USING hash("topic");
+
+CREATE TABLE IF NOT EXISTS "staging_events" (
+    "id" uuid,
+    "event_id" text NOT NULL,
+    "source" text NOT NULL,
+    "type" text NOT NULL,
+    "cloudevent" text NOT NULL,
+    "namespace_id" uuid,
+    "namespace_name" text NOT NULL,
+    "received_at" timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "delayed_until" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_namespaces_staging_events"
+    FOREIGN KEY ("namespace_name") REFERENCES "namespaces"("name") ON DELETE CASCADE ON UPDATE CASCADE
+);
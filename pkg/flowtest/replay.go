@@ -0,0 +1,63 @@
+package flowtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+)
+
+// Replay executes the instance with the history again, the non-deterministic calls return
+// the results recorded by the engine instead of calling external systems. The result fails
+// if the replay does not end like the instance did.
+func Replay(ctx context.Context, history []*engine.InstanceEvent) (*Result, error) {
+	if len(history) == 0 {
+		return nil, errors.New("history is empty")
+	}
+	first := history[0]
+	last := history[len(history)-1]
+	if !last.IsEndStatus() {
+		return nil, fmt.Errorf("instance %s did not end", first.InstanceID)
+	}
+
+	expect := Expect{}
+	switch last.State {
+	case engine.StateCodeComplete:
+		if len(last.Output) > 0 {
+			err := json.Unmarshal(last.Output, &expect.Output)
+			if err != nil {
+				return nil, fmt.Errorf("unmarshal output: %w", err)
+			}
+		}
+	case engine.StateCodeFailed:
+		expect.Error = &ErrorSpec{Code: last.ErrorCode, Message: last.Error}
+	case engine.StateCodeCancelled:
+		return nil, fmt.Errorf("instance %s was cancelled", first.InstanceID)
+	}
+
+	r := &caseRun{
+		tc: &Case{
+			Name:   first.InstanceID.String(),
+			Expect: expect,
+		},
+		variables: make(map[string]*runtime.Variable),
+		calls:     make(map[string]int),
+		replay:    runtime.NewReplay(engine.Recording(history)),
+	}
+
+	inst := first.Clone()
+	inst.State = engine.StateCodeRunning
+	inst.Output = nil
+	inst.Journal = nil
+	inst.Suspension = nil
+	inst.Attempt = 0
+
+	start := time.Now()
+	err := r.exec(ctx, inst)
+
+	return r.result(err, time.Since(start)), nil
+}
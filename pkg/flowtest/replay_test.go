@@ -0,0 +1,62 @@
+package flowtest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	id := uuid.New()
+	first := &engine.InstanceEvent{
+		State:      engine.StateCodePending,
+		InstanceID: id,
+		Metadata:   map[string]string{engine.LabelWithScope: "main"},
+		Script: `
+		function stateOne(input) {
+			const resp = fetchSync("http://replay.invalid/api")
+			return transition(stateTwo, {status: resp.status, name: input.name})
+		}
+		function stateTwo(data) {
+			const ev = waitForEvent({types: ["approved"]})
+			return finish({status: data.status, name: data.name, by: ev.by})
+		}`,
+		Fn:    "stateOne",
+		Input: json.RawMessage(`{"name":"test"}`),
+	}
+	transition := &engine.InstanceEvent{
+		State:      engine.StateCodeRunning,
+		InstanceID: id,
+		Fn:         "stateTwo",
+		Records: []*runtime.Record{
+			{Kind: runtime.RecordKindFetch, Key: "http://replay.invalid/api", Output: json.RawMessage(`{"status":503,"ok":false}`)},
+		},
+	}
+	end := &engine.InstanceEvent{
+		State:      engine.StateCodeComplete,
+		InstanceID: id,
+		Output:     json.RawMessage(`{"status":503,"name":"test","by":"admin"}`),
+		Records: []*runtime.Record{
+			{Kind: runtime.RecordKindWait, Key: string(runtime.SuspensionKindEvent), Output: json.RawMessage(`{"by":"admin"}`)},
+		},
+	}
+
+	res, err := Replay(context.Background(), []*engine.InstanceEvent{first, transition, end})
+	require.NoError(t, err)
+	require.True(t, res.Passed, res.Failures)
+	require.Equal(t, []string{"stateOne", "stateTwo"}, res.Transitions)
+
+	// a replay ending differently than the instance fails.
+	end.Output = json.RawMessage(`{"status":200}`)
+	res, err = Replay(context.Background(), []*engine.InstanceEvent{first, transition, end})
+	require.NoError(t, err)
+	require.False(t, res.Passed)
+
+	_, err = Replay(context.Background(), []*engine.InstanceEvent{first})
+	require.ErrorContains(t, err, "did not end")
+}
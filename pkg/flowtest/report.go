@@ -0,0 +1,110 @@
+package flowtest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of a test case.
+type Result struct {
+	Suite    string   `json:"suite"`
+	Name     string   `json:"name"`
+	Passed   bool     `json:"passed"`
+	Failures []string `json:"failures,omitempty"`
+
+	Transitions []string        `json:"transitions"`
+	Output      json.RawMessage `json:"output,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	ErrorCode   string          `json:"errorCode,omitempty"`
+	Duration    time.Duration   `json:"duration"`
+}
+
+// WriteJSON writes the results as JSON report.
+func WriteJSON(w io.Writer, results []*Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(results)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as JUnit XML report, a test suite per suite file.
+func WriteJUnit(w io.Writer, results []*Result) error {
+	report := &junitTestSuites{}
+	suites := make(map[string]*junitTestSuite)
+	durations := make(map[string]time.Duration)
+
+	for _, res := range results {
+		suite, ok := suites[res.Suite]
+		if !ok {
+			suite = &junitTestSuite{Name: res.Suite}
+			suites[res.Suite] = suite
+			report.Suites = append(report.Suites, suite)
+		}
+
+		tc := &junitTestCase{
+			Name:      res.Name,
+			Classname: res.Suite,
+			Time:      seconds(res.Duration),
+		}
+		if !res.Passed {
+			tc.Failure = &junitFailure{
+				Message: res.Failures[0],
+				Text:    strings.Join(res.Failures, "\n"),
+			}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		durations[res.Suite] += res.Duration
+	}
+	for name, suite := range suites {
+		suite.Time = seconds(durations[name])
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,830 @@
+package flowtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/direktiv/direktiv/internal/engine"
+	"github.com/direktiv/direktiv/internal/engine/runtime"
+	"github.com/direktiv/direktiv/pkg/filestore"
+	"github.com/google/uuid"
+	"github.com/sosodev/duration"
+)
+
+// namespace is the namespace flows under test run in.
+const namespace = "flowtest"
+
+// Run compiles the flow of the suite and runs its test cases.
+func (s *Suite) Run(ctx context.Context) ([]*Result, error) {
+	flowPath := filepath.Join(filepath.Dir(s.Path), s.Flow)
+	flow, err := Compile(flowPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Result, 0, len(s.Tests))
+	for _, tc := range s.Tests {
+		res := RunCase(ctx, flow, path.Join("/", filepath.ToSlash(s.Flow)), tc)
+		res.Suite = s.Path
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// RunCase runs the compiled flow at path with the mocks of tc and checks its expectations.
+// Suspending calls resolve immediately: sleeps end, waitForEvent receives the next mocked
+// event and failed states are retried without backoff.
+func RunCase(ctx context.Context, flow core.TypescriptFlow, path string, tc *Case) *Result {
+	r := &caseRun{
+		tc:        tc,
+		variables: make(map[string]*runtime.Variable),
+		calls:     make(map[string]int),
+		locks:     make(map[string]int),
+	}
+	for _, v := range tc.Variables {
+		mimeType := v.MimeType
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		r.variables[variableKey(v.Scope, v.Name)] = &runtime.Variable{
+			Name:     v.Name,
+			MimeType: mimeType,
+			Size:     len(v.Value),
+			Version:  1,
+			Data:     []byte(v.Value),
+		}
+	}
+
+	start := time.Now()
+	err := r.run(ctx, flow, path)
+
+	return r.result(err, time.Since(start))
+}
+
+// result returns the result of the run that ended with err.
+func (r *caseRun) result(err error, d time.Duration) *Result {
+	res := &Result{
+		Name:        r.tc.Name,
+		Transitions: r.transitions,
+		Output:      r.output,
+		Duration:    d,
+	}
+	if err != nil {
+		res.Error = err.Error()
+		res.ErrorCode = runtime.ErrorCode(err)
+	}
+	res.Failures = append(r.unmocked, r.check(err)...)
+	res.Passed = len(res.Failures) == 0
+
+	return res
+}
+
+// caseRun is the state of a run of a test case, asynchronous calls of the flow access it
+// concurrently.
+type caseRun struct {
+	tc *Case
+
+	mu          sync.Mutex
+	transitions []string
+	output      json.RawMessage
+	variables   map[string]*runtime.Variable
+	// calls counts the calls answered by the mocks by their key.
+	calls    map[string]int
+	events   int
+	signals  int
+	unmocked []string
+	// emitted holds the events emitted by the flow.
+	emitted []*cloudevents.Event
+	// locks counts the held slots of the locks and semaphores by their key.
+	locks   map[string]int
+	holders int
+
+	// replay answers the calls of the flow instead of the mocks, see Replay.
+	replay *runtime.Replay
+}
+
+// run executes the flow like the engine does, it returns the error the flow failed with.
+func (r *caseRun) run(ctx context.Context, flow core.TypescriptFlow, path string) error {
+	input := []byte("{}")
+	if r.tc.Input != nil {
+		var err error
+		input, err = json.Marshal(r.tc.Input)
+		if err != nil {
+			return fmt.Errorf("marshal input: %w", err)
+		}
+	}
+
+	if flow.Config.Input != nil {
+		err := core.ValidateSchema(flow.Config.Input.Schema, input)
+		if err != nil {
+			return fmt.Errorf("%w: %w", engine.ErrInvalidInput, err)
+		}
+	}
+
+	metadata, err := r.metadata(flow, path)
+	if err != nil {
+		return err
+	}
+
+	if flow.Config.Timeout != "" {
+		d, err := duration.Parse(flow.Config.Timeout)
+		if err != nil {
+			return fmt.Errorf("parse flow timeout: %w", err)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.ToTimeDuration())
+		defer cancel()
+	}
+
+	inst := &engine.InstanceEvent{
+		State:      engine.StateCodeRunning,
+		InstanceID: uuid.New(),
+		Namespace:  namespace,
+		Metadata:   metadata,
+		Script:     flow.Script,
+		Mappings:   flow.Mapping,
+		Fn:         flow.Config.State,
+		Input:      input,
+	}
+
+	return r.exec(ctx, inst)
+}
+
+// exec executes the instance from inst until it ends.
+func (r *caseRun) exec(ctx context.Context, inst *engine.InstanceEvent) error {
+	var err error
+	for inst != nil {
+		inst, err = r.runState(ctx, inst)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *caseRun) metadata(flow core.TypescriptFlow, path string) (map[string]string, error) {
+	secrets := make(map[string]string, len(r.tc.Secrets))
+	for k, v := range r.tc.Secrets {
+		secrets[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("marshal secrets: %w", err)
+	}
+
+	metadata := map[string]string{
+		core.EngineMappingNamespace: namespace,
+		core.EngineMappingPath:      path,
+		core.EngineMappingSecrets:   string(b),
+		engine.LabelWithScope:       "main",
+	}
+	if len(flow.Config.States) > 0 {
+		states, err := json.Marshal(flow.Config.States)
+		if err != nil {
+			return nil, fmt.Errorf("marshal state policies: %w", err)
+		}
+		metadata[core.EngineMappingStates] = string(states)
+	}
+	if flow.Config.Output != nil {
+		metadata[core.EngineMappingOutputSchema] = string(flow.Config.Output.Schema)
+	}
+
+	return metadata, nil
+}
+
+// runState executes inst from its state function. It returns the event continuing the
+// instance if the state suspended or its error policy applies.
+func (r *caseRun) runState(ctx context.Context, inst *engine.InstanceEvent) (*engine.InstanceEvent, error) {
+	if len(inst.Journal) == 0 {
+		r.record(inst.Fn)
+	}
+
+	current := inst
+	sc := &runtime.Script{
+		InstID:      inst.InstanceID,
+		Text:        inst.Script,
+		Mappings:    inst.Mappings,
+		Fn:          inst.Fn,
+		Input:       string(inst.StateInput()),
+		Metadata:    inst.Metadata,
+		Journal:     inst.Journal,
+		Resume:      inst.Resume,
+		Transitions: inst.Transitions,
+		Attempt:     inst.Attempt,
+		Suspendable: true,
+		Replay:      r.replay,
+	}
+
+	// the records of the state, a resumed state does not make its calls again.
+	var records []*runtime.Record
+	var onRecord runtime.OnRecordHook = func(rec *runtime.Record) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		records = append(records, rec)
+	}
+
+	var onFinish runtime.OnFinishHook = func(output []byte) error {
+		err := engine.ValidateOutput(inst.Metadata, output)
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.output = output
+
+		return nil
+	}
+	var onTransition runtime.OnTransitionHook = func(memory []byte, fn string) error {
+		next := current.Clone()
+		next.Output = memory
+		next.Fn = fn
+		next.Journal = nil
+		next.Resume = nil
+		next.Attempt = 0
+		next.Transitions++
+		current = next
+		r.record(fn)
+
+		r.mu.Lock()
+		records = nil
+		r.mu.Unlock()
+
+		return nil
+	}
+
+	err := runtime.ExecScript(ctx, sc, onFinish, onTransition, r.onCallAction(), r.onFetch(),
+		r.onSubflow(), r.onSetVariable(), r.onGetVariable(), r.onReadVariable(), r.onListVariables(),
+		r.onWriteVariable(), r.onDeleteVariable(), r.onEmitEvent(), r.onGetFile(), r.onListFiles(),
+		r.onAcquireLock(), r.onReleaseLock(), onRecord)
+	var susp *runtime.Suspension
+	if errors.As(err, &susp) {
+		entry, err := r.resolve(susp)
+		if err != nil {
+			return nil, err
+		}
+		next := current.Clone()
+		next.Journal = append(next.Journal, entry)
+		r.mu.Lock()
+		next.Resume = append(next.Resume, records...)
+		r.mu.Unlock()
+
+		return next, nil
+	}
+	if err == nil {
+		return nil, nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, &runtime.Error{
+			Code:    core.ErrorCodeTimeout,
+			Message: "timeout for flow exceeded",
+		}
+	}
+
+	next, _ := engine.RecoverState(current, err)
+	if next == nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// resolve returns the result of the suspending call s.
+func (r *caseRun) resolve(s *runtime.Suspension) (*runtime.JournalEntry, error) {
+	switch s.Kind {
+	case runtime.SuspensionKindSleep:
+		return &runtime.JournalEntry{Step: s.Step}, nil
+	case runtime.SuspensionKindEvent:
+		var cfg runtime.WaitForEventConfig
+		err := json.Unmarshal(s.Params, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal wait config: %w", err)
+		}
+		output, err := r.receive(&cfg)
+		if err != nil {
+			return nil, err
+		}
+		if output == nil {
+			return &runtime.JournalEntry{Step: s.Step, Error: runtime.ErrWaitTimeout}, nil
+		}
+
+		return &runtime.JournalEntry{Step: s.Step, Output: output}, nil
+	case runtime.SuspensionKindSignal:
+		var cfg runtime.WaitForSignalConfig
+		err := json.Unmarshal(s.Params, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal wait config: %w", err)
+		}
+		if r.signals < len(r.tc.Mocks.Signals) && r.tc.Mocks.Signals[r.signals].Name == cfg.Name {
+			payload, err := json.Marshal(r.tc.Mocks.Signals[r.signals].Payload)
+			if err != nil {
+				return nil, fmt.Errorf("marshal signal payload: %w", err)
+			}
+			r.signals++
+
+			return &runtime.JournalEntry{Step: s.Step, Output: payload}, nil
+		}
+		if cfg.Timeout != "" {
+			return &runtime.JournalEntry{Step: s.Step, Error: runtime.ErrWaitTimeout}, nil
+		}
+
+		return nil, fmt.Errorf("waiting for signal '%s' without mocked signal", cfg.Name)
+	default:
+		return nil, fmt.Errorf("unknown suspension kind '%s'", s.Kind)
+	}
+}
+
+// receive returns the next mocked events waited for with cfg, nil if the wait times out.
+// Waiting for all types returns the events keyed by their type.
+func (r *caseRun) receive(cfg *runtime.WaitForEventConfig) ([]byte, error) {
+	received := make(map[string]any)
+	for r.events < len(r.tc.Mocks.Events) {
+		ev := r.tc.Mocks.Events[r.events]
+		typ, _ := ev["type"].(string)
+		if !slices.Contains(cfg.Types, typ) {
+			break
+		}
+		r.events++
+		if !cfg.All {
+			return json.Marshal(ev)
+		}
+		received[typ] = ev
+		if len(received) == len(cfg.Types) {
+			return json.Marshal(received)
+		}
+	}
+	if cfg.Timeout != "" {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("waiting for events '%s' without mocked event", strings.Join(cfg.Types, ", "))
+}
+
+func (r *caseRun) record(fn string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions = append(r.transitions, fn)
+}
+
+// answer returns the index of the mock answering the next call of key out of the
+// matches mocks, an error if there are none.
+func (r *caseRun) answer(key string, matches int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if matches == 0 {
+		err := fmt.Errorf("no mock for %s", key)
+		r.unmocked = append(r.unmocked, err.Error())
+
+		return 0, err
+	}
+	n := r.calls[key]
+	r.calls[key]++
+
+	return min(n, matches-1), nil
+}
+
+// result returns the output or error of the mocked call answered with resp.
+func (resp *Response) result() (any, error) {
+	if resp.Error != nil {
+		return nil, &runtime.Error{
+			Code:    resp.Error.Code,
+			Message: resp.Error.Message,
+		}
+	}
+
+	return resp.Output, nil
+}
+
+func (r *caseRun) onCallAction() runtime.OnCallActionHook {
+	return func(ctx context.Context, sd *core.ServiceFileData, payload any) (any, error) {
+		var key string
+		var matches []*Response
+		if sd.Typ == core.FlowActionScopeWorkflow {
+			key = "action " + sd.Image
+			for _, m := range r.tc.Mocks.Actions {
+				if m.Image == sd.Image {
+					matches = append(matches, &m.Response)
+				}
+			}
+		} else {
+			scope := core.FlowActionScopeNamespace
+			if sd.Namespace == core.FlowActionScopeSystem {
+				scope = core.FlowActionScopeSystem
+			}
+			key = fmt.Sprintf("service %s in scope %s", sd.FilePath, scope)
+			for _, m := range r.tc.Mocks.Services {
+				if m.Scope == scope && m.Path == sd.FilePath {
+					matches = append(matches, &m.Response)
+				}
+			}
+		}
+
+		i, err := r.answer(key, len(matches))
+		if err != nil {
+			return nil, err
+		}
+
+		return matches[i].result()
+	}
+}
+
+func (r *caseRun) onSubflow() runtime.OnSubflowHook {
+	return func(ctx context.Context, path string, input []byte) ([]byte, error) {
+		var matches []*Response
+		for _, m := range r.tc.Mocks.Subflows {
+			if m.Path == path {
+				matches = append(matches, &m.Response)
+			}
+		}
+
+		i, err := r.answer("subflow "+path, len(matches))
+		if err != nil {
+			return nil, err
+		}
+		output, err := matches[i].result()
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(output)
+	}
+}
+
+func (r *caseRun) onFetch() runtime.OnFetchHook {
+	return func(req *http.Request) (*http.Response, error) {
+		var matches []*FetchMock
+		for _, m := range r.tc.Mocks.Fetch {
+			if m.URL == req.URL.String() && (m.Method == "" || strings.EqualFold(m.Method, req.Method)) {
+				matches = append(matches, m)
+			}
+		}
+
+		i, err := r.answer(fmt.Sprintf("fetch %s %s", req.Method, req.URL.String()), len(matches))
+		if err != nil {
+			return nil, err
+		}
+
+		return matches[i].response(req)
+	}
+}
+
+// response returns the mocked response to req.
+func (m *FetchMock) response(req *http.Request) (*http.Response, error) {
+	var body []byte
+	switch b := m.Body.(type) {
+	case nil:
+	case string:
+		body = []byte(b)
+	default:
+		var err error
+		body, err = json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("marshal mocked body: %w", err)
+		}
+	}
+
+	status := m.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := http.Header{}
+	for k, v := range m.Headers {
+		header.Set(k, v)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func variableKey(scope, name string) string {
+	return scope + "/" + name
+}
+
+// setVariable stores v if the stored variable has version, see runtime.OnWriteVariableHook.
+// r.mu must be held.
+func (r *caseRun) setVariable(scope string, v *runtime.Variable, version int64) *runtime.Variable {
+	key := variableKey(scope, v.Name)
+	var current int64
+	if stored, ok := r.variables[key]; ok {
+		current = stored.Version
+	}
+	if version != runtime.AnyVersion && version != current {
+		return nil
+	}
+
+	stored := *v
+	stored.Size = len(v.Data)
+	stored.Version = current + 1
+	r.variables[key] = &stored
+
+	return &stored
+}
+
+func (r *caseRun) onSetVariable() runtime.OnSetVariableHook {
+	return func(ctx context.Context, scope string, name string, data []byte) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.setVariable(scope, &runtime.Variable{
+			Name:     name,
+			MimeType: "application/octet-stream",
+			Data:     data,
+		}, runtime.AnyVersion)
+
+		return nil
+	}
+}
+
+func (r *caseRun) onGetVariable() runtime.OnGetVariableHook {
+	return func(ctx context.Context, scope string, name string) ([]byte, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if v, ok := r.variables[variableKey(scope, name)]; ok {
+			return v.Data, nil
+		}
+
+		return nil, nil
+	}
+}
+
+func (r *caseRun) onReadVariable() runtime.OnReadVariableHook {
+	return func(ctx context.Context, scope string, name string) (*runtime.Variable, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		v, ok := r.variables[variableKey(scope, name)]
+		if !ok {
+			return nil, nil
+		}
+		read := *v
+
+		return &read, nil
+	}
+}
+
+func (r *caseRun) onListVariables() runtime.OnListVariablesHook {
+	return func(ctx context.Context, scope string) ([]*runtime.Variable, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		var list []*runtime.Variable
+		for key, v := range r.variables {
+			if key == variableKey(scope, v.Name) {
+				list = append(list, &runtime.Variable{
+					Name:     v.Name,
+					MimeType: v.MimeType,
+					Size:     v.Size,
+					Version:  v.Version,
+				})
+			}
+		}
+		slices.SortFunc(list, func(a, b *runtime.Variable) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		return list, nil
+	}
+}
+
+func (r *caseRun) onWriteVariable() runtime.OnWriteVariableHook {
+	return func(ctx context.Context, scope string, v *runtime.Variable, version int64) (*runtime.Variable, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		return r.setVariable(scope, v, version), nil
+	}
+}
+
+func (r *caseRun) onDeleteVariable() runtime.OnDeleteVariableHook {
+	return func(ctx context.Context, scope string, name string) (bool, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		key := variableKey(scope, name)
+		_, ok := r.variables[key]
+		delete(r.variables, key)
+
+		return ok, nil
+	}
+}
+
+// onEmitEvent collects the emitted events, delays are ignored.
+func (r *caseRun) onEmitEvent() runtime.OnEmitEventHook {
+	return func(ctx context.Context, ev *cloudevents.Event, delay time.Duration) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.emitted = append(r.emitted, ev)
+
+		return nil
+	}
+}
+
+func fileNotFound(path string) error {
+	return &runtime.Error{
+		Code:    core.ErrorCodeFileNotFound,
+		Message: fmt.Sprintf("file '%s' not found", path),
+	}
+}
+
+// onGetFile reads the files of the test case.
+func (r *caseRun) onGetFile() runtime.OnGetFileHook {
+	return func(ctx context.Context, path string) ([]byte, error) {
+		data, ok := r.tc.Files[path]
+		if !ok {
+			return nil, fileNotFound(path)
+		}
+
+		return []byte(data), nil
+	}
+}
+
+// onListFiles lists the files of the test case, the directories are the parents of the files.
+func (r *caseRun) onListFiles() runtime.OnListFilesHook {
+	return func(ctx context.Context, dir string) ([]*runtime.File, error) {
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		entries := make(map[string]*runtime.File)
+		for p, data := range r.tc.Files {
+			rest, ok := strings.CutPrefix(p, prefix)
+			if !ok || rest == "" {
+				continue
+			}
+			name, _, isDir := strings.Cut(rest, "/")
+			f := &runtime.File{Path: prefix + name, Name: name, Type: string(filestore.FileTypeFile), Size: len(data)}
+			if isDir {
+				f.Type = string(filestore.FileTypeDirectory)
+				f.Size = 0
+			}
+			entries[f.Path] = f
+		}
+		if len(entries) == 0 && dir != "/" {
+			return nil, fileNotFound(dir)
+		}
+
+		list := make([]*runtime.File, 0, len(entries))
+		for _, f := range entries {
+			list = append(list, f)
+		}
+		slices.SortFunc(list, func(a, b *runtime.File) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+
+		return list, nil
+	}
+}
+
+// onAcquireLock takes a slot of the lock, the run is the only holder so held locks time
+// out without waiting.
+func (r *caseRun) onAcquireLock() runtime.OnAcquireLockHook {
+	return func(ctx context.Context, lock *runtime.Lock) (string, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.locks[lock.Key] >= lock.Limit {
+			return "", &runtime.Error{
+				Code:    core.ErrorCodeLockTimeout,
+				Message: fmt.Sprintf("lock '%s' not acquired within %s", lock.Key, lock.Wait),
+			}
+		}
+		r.locks[lock.Key]++
+		r.holders++
+
+		return fmt.Sprintf("%s/%d", lock.Key, r.holders), nil
+	}
+}
+
+func (r *caseRun) onReleaseLock() runtime.OnReleaseLockHook {
+	return func(ctx context.Context, key string, holder string) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.locks[key] > 0 {
+			r.locks[key]--
+		}
+
+		return nil
+	}
+}
+
+// check returns the expectations of the test case the run does not meet, err is the
+// error the flow failed with.
+func (r *caseRun) check(err error) []string {
+	expect := r.tc.Expect
+	var failures []string
+
+	if expect.Transitions != nil && !reflect.DeepEqual(expect.Transitions, r.transitions) {
+		failures = append(failures, fmt.Sprintf("expected transitions %v, got %v",
+			expect.Transitions, r.transitions))
+	}
+
+	switch {
+	case expect.Error != nil && err == nil:
+		failures = append(failures, "expected error, flow completed")
+	case expect.Error != nil:
+		code := runtime.ErrorCode(err)
+		if expect.Error.Code != "" && expect.Error.Code != code {
+			failures = append(failures, fmt.Sprintf("expected error code '%s', got '%s'", expect.Error.Code, code))
+		}
+		if !strings.Contains(err.Error(), expect.Error.Message) {
+			failures = append(failures, fmt.Sprintf("expected error message '%s', got '%s'",
+				expect.Error.Message, err.Error()))
+		}
+	case err != nil:
+		failures = append(failures, fmt.Sprintf("flow failed: %s", err.Error()))
+	}
+
+	if expect.Output != nil {
+		equal, cErr := jsonEqual(expect.Output, r.output)
+		if cErr != nil {
+			failures = append(failures, cErr.Error())
+		} else if !equal {
+			failures = append(failures, fmt.Sprintf("expected output %s, got %s",
+				mustMarshal(expect.Output), string(r.output)))
+		}
+	}
+
+	for _, v := range expect.Variables {
+		var got []byte
+		stored, ok := r.variables[variableKey(v.Scope, v.Name)]
+		if ok {
+			got = stored.Data
+		}
+		if !ok || string(got) != v.Value {
+			failures = append(failures, fmt.Sprintf("expected variable %s with value '%s', got '%s'",
+				variableKey(v.Scope, v.Name), v.Value, string(got)))
+		}
+	}
+
+	for i, want := range expect.Events {
+		if i >= len(r.emitted) {
+			failures = append(failures, fmt.Sprintf("expected event %s, got %d events", want.Type, len(r.emitted)))
+			break
+		}
+		failures = append(failures, checkEvent(want, r.emitted[i])...)
+	}
+
+	return failures
+}
+
+// checkEvent returns the fields of the emitted event ev not matching want.
+func checkEvent(want *EventSpec, ev *cloudevents.Event) []string {
+	var failures []string
+	if want.Type != "" && want.Type != ev.Type() {
+		failures = append(failures, fmt.Sprintf("expected event type %s, got %s", want.Type, ev.Type()))
+	}
+	if want.Source != "" && want.Source != ev.Source() {
+		failures = append(failures, fmt.Sprintf("expected event source %s, got %s", want.Source, ev.Source()))
+	}
+	if want.Data != nil {
+		equal, err := jsonEqual(want.Data, ev.Data())
+		if err != nil {
+			failures = append(failures, err.Error())
+		} else if !equal {
+			failures = append(failures, fmt.Sprintf("expected event data %s, got %s",
+				mustMarshal(want.Data), string(ev.Data())))
+		}
+	}
+
+	return failures
+}
+
+// jsonEqual reports if the expected value and the JSON output are the same JSON value.
+func jsonEqual(expected any, output json.RawMessage) (bool, error) {
+	if output == nil {
+		return false, nil
+	}
+
+	var want, got any
+	err := json.Unmarshal(mustMarshal(expected), &want)
+	if err != nil {
+		return false, fmt.Errorf("unmarshal expected output: %w", err)
+	}
+	err = json.Unmarshal(output, &got)
+	if err != nil {
+		return false, fmt.Errorf("unmarshal output: %w", err)
+	}
+
+	return reflect.DeepEqual(want, got), nil
+}
+
+func mustMarshal(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
@@ -0,0 +1,394 @@
+package flowtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/direktiv/direktiv/internal/core"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func testFlow(script string, states map[string]*core.StatePolicy) core.TypescriptFlow {
+	return core.TypescriptFlow{
+		Script: script,
+		Config: core.FlowConfig{
+			State:  "stateOne",
+			States: states,
+		},
+	}
+}
+
+func testCase(t *testing.T, spec string) *Case {
+	t.Helper()
+
+	tc := &Case{}
+	require.NoError(t, yaml.Unmarshal([]byte(spec), tc))
+
+	return tc
+}
+
+func TestRunCaseMocks(t *testing.T) {
+	flow := testFlow(`
+	function stateOne(input) {
+		const action = generateAction({image: "direktiv/echo"})
+		const a = action({name: input.name})
+		const s = execService({scope: "namespace", path: "/svc.yaml", payload: {}})
+		return transition(stateTwo, {a: a, s: s})
+	}
+	function stateTwo(data) {
+		const sub = execSubflow("/sub.wf.ts", data)
+		const resp = fetchSync("https://example.com/api", {method: "POST"})
+		setVariable("instance", "out", getVariable("namespace", "in"))
+		return finish({sub: sub, status: resp.status, body: resp.json(), secret: getSecret("token")})
+	}`, nil)
+
+	tc := testCase(t, `
+name: mocked
+input: {name: test}
+secrets: {token: abc}
+variables:
+  - {scope: namespace, name: in, value: hello}
+mocks:
+  actions:
+    - {image: direktiv/echo, output: echoed}
+  services:
+    - {scope: namespace, path: /svc.yaml, output: 1}
+  subflows:
+    - {path: /sub.wf.ts, output: {done: true}}
+  fetch:
+    - {method: POST, url: "https://example.com/api", status: 201, body: {id: 7}}
+expect:
+  transitions: [stateOne, stateTwo]
+  output: {sub: {done: true}, status: 201, body: {id: 7}, secret: abc}
+  variables:
+    - {scope: instance, name: out, value: hello}
+`)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", tc)
+	require.True(t, res.Passed, res.Failures)
+	require.Equal(t, []string{"stateOne", "stateTwo"}, res.Transitions)
+}
+
+func TestRunCaseFailures(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		const action = generateAction({image: "direktiv/unmocked"})
+		return finish(action({}))
+	}`, nil)
+
+	tc := testCase(t, `
+name: unmocked
+expect:
+  output: done
+`)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", tc)
+	require.False(t, res.Passed)
+	require.Contains(t, res.Failures, "no mock for action direktiv/unmocked")
+	require.Contains(t, res.Error, "no mock for action direktiv/unmocked")
+}
+
+func TestRunCaseErrorPolicy(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		const action = generateAction({image: "direktiv/flaky"})
+		return finish(action({}))
+	}
+	function stateFailed(e) {
+		throw new Error("giving up: " + e.code)
+	}`, map[string]*core.StatePolicy{
+		"stateOne": {
+			Retry: []core.RetryPolicy{{Codes: []string{"http.503"}, MaxAttempts: 2, Backoff: "PT1H"}},
+			Catch: []core.CatchPolicy{{State: "stateFailed"}},
+		},
+	})
+
+	tc := testCase(t, `
+name: retried and caught
+mocks:
+  actions:
+    - {image: direktiv/flaky, error: {code: http.503, message: unavailable}}
+    - {image: direktiv/flaky, error: {code: http.500, message: broken}}
+expect:
+  transitions: [stateOne, stateOne, stateFailed]
+  error: {message: "giving up: http.500"}
+`)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", tc)
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestRunCaseSuspensions(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		sleep(3600)
+		const ev = waitForEvent({types: ["greeting"]})
+		let timedOut = false
+		try {
+			waitForEvent({types: ["other"], timeout: "PT1M"})
+		} catch (e) {
+			timedOut = true
+		}
+		return finish({name: ev.data.name, timedOut: timedOut})
+	}`, nil)
+
+	tc := testCase(t, `
+name: waits
+mocks:
+  events:
+    - {type: greeting, data: {name: world}}
+expect:
+  transitions: [stateOne]
+  output: {name: world, timedOut: true}
+`)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", tc)
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestRunCaseResume(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		const action = generateAction({image: "direktiv/counter"})
+		const a = action({})
+		sleep(3600)
+		const b = action({})
+		waitForEvent({types: ["greeting"]})
+		const c = action({})
+		return finish([a, b, c])
+	}`, nil)
+
+	// the resumed state does not call the action again for the calls before its waits.
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: resumed
+mocks:
+  actions:
+    - {image: direktiv/counter, output: 1}
+    - {image: direktiv/counter, output: 2}
+    - {image: direktiv/counter, output: 3}
+    - {image: direktiv/counter, output: 4}
+  events:
+    - {type: greeting}
+expect:
+  output: [1, 2, 3]
+`))
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestRunCaseEvents(t *testing.T) {
+	flow := testFlow(`
+	function stateOne(input) {
+		emitEvent({type: "order.created", data: {id: input.id}})
+		return finish(true)
+	}`, nil)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: emitted
+input: {id: 7}
+expect:
+  events:
+    - {type: order.created, source: /flow.wf.ts, data: {id: 7}}
+`))
+	require.True(t, res.Passed, res.Failures)
+
+	res = RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: not emitted
+input: {id: 8}
+expect:
+  events:
+    - {type: order.created, data: {id: 7}}
+    - {type: order.shipped}
+`))
+	require.False(t, res.Passed)
+	require.Equal(t, []string{`expected event data {"id":7}, got {"id":8}`, "expected event order.shipped, got 1 events"}, res.Failures)
+}
+
+func TestRunCaseSignals(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		const approval = waitForSignal("approve")
+		let reminded = true
+		try {
+			waitForSignal("remind", {timeout: "PT1H"})
+		} catch (e) {
+			reminded = false
+		}
+		return finish({by: approval.by, reminded: reminded})
+	}`, nil)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: approved
+mocks:
+  signals:
+    - {name: approve, payload: {by: jane}}
+expect:
+  output: {by: jane, reminded: false}
+`))
+	require.True(t, res.Passed, res.Failures)
+
+	res = RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: not approved
+expect:
+  output: {}
+`))
+	require.False(t, res.Passed)
+	require.Contains(t, res.Error, "waiting for signal 'approve' without mocked signal")
+}
+
+func TestRunCaseFiles(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		return finish({
+			config: getFile("/config/app.json", {as: "json"}),
+			root: listFiles("/").map(f => f.name + ":" + f.type),
+		})
+	}`, nil)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: files
+files:
+  /config/app.json: '{"retries": 3}'
+  /readme.md: hello
+expect:
+  output: {config: {retries: 3}, root: ["config:directory", "readme.md:file"]}
+`))
+	require.True(t, res.Passed, res.Failures)
+
+	res = RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: missing file
+expect:
+  error: {code: io.direktiv.error.file.notfound}
+`))
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestRunCaseVariables(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		const seen = !compareAndSetVariable("workflow", "order-7", 0, {at: 1})
+		const count = incrementVariable("namespace", "orders")
+		const limit = readVariable("namespace", "limit")
+		return finish({seen: seen, count: count, limit: limit.value, vars: listVariables("namespace").length})
+	}`, nil)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: new order
+variables:
+  - {scope: namespace, name: orders, value: "41", mimeType: application/json}
+  - {scope: namespace, name: limit, value: "{\"max\": 50}", mimeType: application/json}
+expect:
+  output: {seen: false, count: 42, limit: {max: 50}, vars: 2}
+  variables:
+    - {scope: namespace, name: orders, value: "42"}
+    - {scope: workflow, name: order-7, value: '{"at":1}'}
+`))
+	require.True(t, res.Passed, res.Failures)
+
+	res = RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: seen order
+variables:
+  - {scope: workflow, name: order-7, value: "{}", mimeType: application/json}
+  - {scope: namespace, name: limit, value: "{}", mimeType: application/json}
+expect:
+  output: {seen: true, count: 1, limit: {}, vars: 2}
+`))
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestRunCaseLocks(t *testing.T) {
+	flow := testFlow(`
+	function stateOne() {
+		const order = withLock("order-7", {ttl: "PT10S"}, () => {
+			sleep(3600)
+			return withSemaphore("erp", 1, () => "updated")
+		})
+		let nested = ""
+		try {
+			withLock("order-7", () => withLock("order-7", () => "twice"))
+		} catch (e) {
+			nested = e.code
+		}
+		return finish({order: order, nested: nested})
+	}`, nil)
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: locks
+expect:
+  output: {order: updated, nested: io.direktiv.error.lock.timeout}
+`))
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestRunCaseSchemas(t *testing.T) {
+	flow := testFlow(`
+	function stateOne(input) {
+		return finish({name: input.name})
+	}`, nil)
+	flow.Config.Input = &core.FlowSchema{Schema: json.RawMessage(`{"type": "object", "required": ["name"]}`)}
+	flow.Config.Output = &core.FlowSchema{Schema: json.RawMessage(`{"properties": {"name": {"type": "string"}}}`)}
+
+	res := RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: valid
+input: {name: test}
+expect:
+  output: {name: test}
+`))
+	require.True(t, res.Passed, res.Failures)
+
+	res = RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: invalid input
+input: {}
+expect:
+  error: {message: 'property "name" is missing'}
+`))
+	require.True(t, res.Passed, res.Failures)
+
+	res = RunCase(context.Background(), flow, "/flow.wf.ts", testCase(t, `
+name: invalid output
+input: {name: 1}
+expect:
+  error: {code: io.direktiv.error.output.invalid}
+`))
+	require.True(t, res.Passed, res.Failures)
+}
+
+func TestFindSuites(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.test.yaml", "flow.wf.ts", "sub/b.test.yaml"} {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte("flow: flow.wf.ts"), 0o600))
+	}
+
+	suites, err := FindSuites(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "a.test.yaml"), filepath.Join(dir, "sub/b.test.yaml")}, suites)
+
+	s, err := LoadSuite(suites[0])
+	require.NoError(t, err)
+	require.Equal(t, "flow.wf.ts", s.Flow)
+}
+
+func TestWriteReports(t *testing.T) {
+	results := []*Result{
+		{Suite: "a.test.yaml", Name: "ok", Passed: true, Transitions: []string{"stateOne"}, Output: json.RawMessage(`1`)},
+		{Suite: "a.test.yaml", Name: "broken", Failures: []string{"expected output 1, got 2"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteJUnit(&buf, results))
+	require.Contains(t, buf.String(), `<testsuite name="a.test.yaml" tests="2" failures="1"`)
+	require.Contains(t, buf.String(), `<failure message="expected output 1, got 2">`)
+
+	buf.Reset()
+	require.NoError(t, WriteJSON(&buf, results))
+	var decoded []*Result
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Len(t, decoded, 2)
+	require.False(t, decoded[1].Passed)
+}
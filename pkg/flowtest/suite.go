@@ -0,0 +1,232 @@
+// Package flowtest runs flows offline with mocked actions, services, subflows, fetch
+// requests, events, secrets, variables and namespace files. Test suites are YAML files declaring the test
+// cases of a flow, the direktiv test command and RunSuite in go tests execute them.
+package flowtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/direktiv/direktiv/internal/compiler"
+	"github.com/direktiv/direktiv/internal/core"
+	"gopkg.in/yaml.v3"
+)
+
+// SuiteSuffix is the file name suffix of test suites.
+const SuiteSuffix = ".test.yaml"
+
+// Suite holds the test cases of a flow.
+type Suite struct {
+	// Path is the file the suite was loaded from.
+	Path string `yaml:"-"`
+	// Flow is the path of the flow file, relative to the suite file.
+	Flow  string  `yaml:"flow"`
+	Tests []*Case `yaml:"tests"`
+}
+
+// Case is a run of the flow with its mocks and the expected result.
+type Case struct {
+	Name  string `yaml:"name"`
+	Input any    `yaml:"input"`
+	// Secrets holds the plain values of the secrets by name.
+	Secrets   map[string]string `yaml:"secrets"`
+	Variables []*Variable       `yaml:"variables"`
+	// Files holds the content of the namespace files by absolute path.
+	Files  map[string]string `yaml:"files"`
+	Mocks  Mocks             `yaml:"mocks"`
+	Expect Expect            `yaml:"expect"`
+}
+
+// Variable is a variable of the namespace, workflow or instance scope.
+type Variable struct {
+	Scope string `yaml:"scope"`
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	// MimeType defaults to application/octet-stream, JSON values use application/json.
+	MimeType string `yaml:"mimeType"`
+}
+
+// Mocks answer the calls of the flow. Calls matching several mocks are answered by them
+// in order, the last one answers all further calls.
+type Mocks struct {
+	Actions  []*ActionMock  `yaml:"actions"`
+	Services []*ServiceMock `yaml:"services"`
+	Subflows []*SubflowMock `yaml:"subflows"`
+	Fetch    []*FetchMock   `yaml:"fetch"`
+	// Events are the events waitForEvent receives, in order.
+	Events []map[string]any `yaml:"events"`
+	// Signals are the signals waitForSignal receives, in order.
+	Signals []*SignalMock `yaml:"signals"`
+}
+
+// SignalMock is a signal sent to the flow with its payload.
+type SignalMock struct {
+	Name    string `yaml:"name"`
+	Payload any    `yaml:"payload"`
+}
+
+// Response is the result of a mocked call, the output or an error.
+type Response struct {
+	Output any        `yaml:"output"`
+	Error  *ErrorSpec `yaml:"error"`
+}
+
+// ErrorSpec is an error with code thrown to the flow. As expectation, empty fields
+// match any value and the message matches if it is contained in the error.
+type ErrorSpec struct {
+	Code    string `yaml:"code" json:"code"`
+	Message string `yaml:"message" json:"message"`
+}
+
+// ActionMock answers the actions of the flow generated with the image.
+type ActionMock struct {
+	Image    string `yaml:"image"`
+	Response `yaml:",inline"`
+}
+
+// ServiceMock answers the calls of the service file at path, scope is namespace or system.
+type ServiceMock struct {
+	Scope    string `yaml:"scope"`
+	Path     string `yaml:"path"`
+	Response `yaml:",inline"`
+}
+
+// SubflowMock answers the calls of the subflow at path.
+type SubflowMock struct {
+	Path     string `yaml:"path"`
+	Response `yaml:",inline"`
+}
+
+// FetchMock answers the requests to url, an empty method matches all methods. Body is
+// returned as is if it is a string, as JSON otherwise.
+type FetchMock struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers"`
+	Body    any               `yaml:"body"`
+}
+
+// Expect is the result a case asserts, unset fields are not checked.
+type Expect struct {
+	// Transitions are the state functions executed in order, starting with the start
+	// state. A retried state is listed once per attempt.
+	Transitions []string   `yaml:"transitions"`
+	Output      any        `yaml:"output"`
+	Error       *ErrorSpec `yaml:"error"`
+	// Variables are the values the flow must have set.
+	Variables []*Variable `yaml:"variables"`
+	// Events are the events the flow must have emitted, in order.
+	Events []*EventSpec `yaml:"events"`
+}
+
+// EventSpec is an event emitted by the flow, unset fields match any value.
+type EventSpec struct {
+	Type   string `yaml:"type"`
+	Source string `yaml:"source"`
+	Data   any    `yaml:"data"`
+}
+
+// LoadSuite reads the test suite at path.
+func LoadSuite(path string) (*Suite, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Suite{}
+	err = yaml.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("parse suite %s: %w", path, err)
+	}
+	if s.Flow == "" {
+		return nil, fmt.Errorf("suite %s has no flow", path)
+	}
+	s.Path = path
+
+	return s, nil
+}
+
+// FindSuites returns the test suites in the files and directories of paths.
+func FindSuites(paths ...string) ([]string, error) {
+	var suites []string
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && (path == p || strings.HasSuffix(path, SuiteSuffix)) {
+				suites = append(suites, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return suites, nil
+}
+
+// Compile transpiles and validates the flow file at path.
+func Compile(path string) (core.TypescriptFlow, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return core.TypescriptFlow{}, err
+	}
+
+	// imports are resolved from the directory of the flow.
+	ci := compiler.NewCompileItem(b, filepath.ToSlash(path)).WithModules(func(file string) ([]byte, error) {
+		return os.ReadFile(filepath.FromSlash(file))
+	})
+	err = ci.TranspileAndValidate()
+	if err != nil {
+		return core.TypescriptFlow{}, fmt.Errorf("compile flow %s: %w", path, err)
+	}
+	if len(ci.ValidationErrors) > 0 {
+		errList := make([]string, len(ci.ValidationErrors))
+		for i := range ci.ValidationErrors {
+			errList[i] = ci.ValidationErrors[i].Error()
+		}
+
+		return core.TypescriptFlow{}, fmt.Errorf("invalid flow %s: %s", path, strings.Join(errList, ", "))
+	}
+
+	flow := ci.Config()
+	for _, schema := range []*core.FlowSchema{flow.Config.Input, flow.Config.Output} {
+		err = loadSchema(filepath.Dir(path), schema)
+		if err != nil {
+			return core.TypescriptFlow{}, err
+		}
+	}
+
+	return flow, nil
+}
+
+// loadSchema loads the schema file of a flow in dir into schema. Files are read relative to
+// the directory of the flow, absolute paths from the working directory.
+func loadSchema(dir string, schema *core.FlowSchema) error {
+	if schema == nil || schema.File == "" {
+		return nil
+	}
+
+	file := filepath.FromSlash(schema.File)
+	if !strings.HasPrefix(schema.File, "/") {
+		file = filepath.Join(dir, file)
+	} else {
+		file = filepath.Join(".", file)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("load schema %s: %w", schema.File, err)
+	}
+	if _, err := core.ParseSchema(b); err != nil {
+		return fmt.Errorf("load schema %s: %w", schema.File, err)
+	}
+	schema.Schema = b
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package flowtest
+
+import (
+	"strings"
+	"testing"
+)
+
+// RunSuite runs the test suite at path in a go test, each test case as subtest.
+func RunSuite(t *testing.T, path string) {
+	t.Helper()
+
+	s, err := LoadSuite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.Run(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, res := range results {
+		t.Run(res.Name, func(t *testing.T) {
+			if !res.Passed {
+				t.Error(strings.Join(res.Failures, "\n"))
+			}
+		})
+	}
+}
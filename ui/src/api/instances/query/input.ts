@@ -16,7 +16,7 @@ export const getInstanceInput = apiFactory({
     namespace: string;
     instanceId: string;
   }) =>
-    `${baseUrl ?? ""}/api/v2/namespaces/${namespace}/instances/${instanceId}`,
+    `${baseUrl ?? ""}/api/v2/namespaces/${namespace}/instances/${instanceId}/input`,
   method: "GET",
   schema: InstanceInputResponseSchema,
 });
@@ -1,30 +1,214 @@
+/**
+ * Error thrown by failed actions and services. The code identifies
+ * the error, e.g. "io.direktiv.error.http.404" or the
+ * Direktiv-ErrorCode header of the response. Thrown objects with a
+ * code property fail the instance with that code.
+ */
+declare type DirektivError = Error & { code: string };
+
+/**
+ * Retries a failed state function.
+ * - maxAttempts: required, executions of the state in total
+ * - backoff: optional, ISO8601 duration to wait before the first
+ *   retry, e.g. "PT10S". It doubles with every attempt.
+ * - codes: optional, glob patterns of the error codes to retry,
+ *   defaults to all errors.
+ */
+declare type RetryPolicy = {
+  maxAttempts: number;
+  backoff?: string;
+  codes?: string[];
+};
+
+/**
+ * Transitions to another state function if a state fails. The state
+ * is called with { code, message, state, input } of the error.
+ * - state: required, name of the state function
+ * - codes: optional, glob patterns of the error codes to catch,
+ *   defaults to all errors.
+ */
+declare type CatchPolicy = {
+  state: string;
+  codes?: string[];
+};
+
+/**
+ * Error policies of a state function. Retries are tried before the
+ * catch handlers.
+ * - timeout: optional, ISO8601 duration a single execution of the
+ *   state may take. It fails with code "io.direktiv.error.timeout",
+ *   which can be retried or caught.
+ */
+declare type StatePolicy = {
+  retry?: RetryPolicy | RetryPolicy[];
+  catch?: CatchPolicy | CatchPolicy[];
+  timeout?: string;
+};
+
+/**
+ * Limits the instances of a flow running at the same time in the
+ * cluster, e.g. 1 to never overlap.
+ * - limit: required, number of running instances
+ * - overflow: optional, "queue" keeps instances over the limit
+ *   pending until a running one ends, "skip" fails them with code
+ *   "io.direktiv.error.concurrency". Defaults to "queue". Subflows
+ *   started with execSubflow are never queued, their parent waits
+ *   for them, they fail with the same code.
+ */
+declare type ConcurrencyConfig = {
+  limit: number;
+  overflow?: "queue" | "skip";
+};
+
+/**
+ * Definition of the flow.
+ * - timeout: ISO8601 duration the instance may take. Instances
+ *   exceeding it fail with code "io.direktiv.error.timeout", this is
+ *   not retried or caught.
+ * - concurrency: optional, a limit or a ConcurrencyConfig.
+ * - input: optional, JSON schema the input of new instances must
+ *   match, inline or the path of a json file in the namespace.
+ *   Instances with invalid input are not created.
+ * - output: optional, JSON schema the value passed to finish() must
+ *   match, otherwise the instance fails with code
+ *   "io.direktiv.error.output.invalid".
+ * Schemas follow the OpenAPI 3.0 dialect of JSON schema, e.g.
+ * nullable: true instead of the "null" type.
+ */
 type FlowDefinition = {
   type: "default";
   timeout: string;
   state: string;
+  states?: Record<string, StatePolicy>;
+  concurrency?: number | ConcurrencyConfig;
+  input?: string | Record<string, unknown>;
+  output?: string | Record<string, unknown>;
 };
 
 type StateFunction<T> = (params: T) => void;
 
 /**
- * Will transition to the next workflow state.
+ * Will transition to the next workflow state. Params exceeding the
+ * size limit of the engine throw with code "io.direktiv.error.limit.size".
+ * State functions using more CPU time than the engine allows fail with
+ * code "io.direktiv.error.limit.cpu", state functions creating more
+ * data than the engine allows fail with code "io.direktiv.error.limit.memory".
  * @param stateFn state function to run next, e.g., stateSecond.
  * @param stateFnParams params passed into the next state function.
  */
 declare function transition<T>(stateFn: StateFunction<T>, stateFnParams: T);
 
 /**
- * Will complete the workflow, returning the result.
+ * Will complete the workflow, returning the result. Results exceeding the
+ * size limit of the engine throw with code "io.direktiv.error.limit.size".
  * @param data end result output by the workflow, usually a JSON object.
  */
 declare function finish<T>(data: T);
 
 /**
- * Waits for a number of seconds.
+ * Waits for a number of seconds. Long sleeps suspend the instance,
+ * the state function is called again with the same params when the
+ * sleep ends. Calls before the sleep, e.g. actions or fetch, return
+ * their recorded results instead of being made again, locks are
+ * acquired again.
  * @param seconds time to wait.
  */
 declare function sleep(seconds: number): void;
 
+/**
+ * Config for waitForEvent
+ */
+declare type WaitForEventConfig = {
+  types: string[];
+  context?: Record<string, string>;
+  all?: boolean;
+  timeout?: string;
+};
+
+/**
+ * Suspends the instance until a matching cloud event is received.
+ * The state function is called again with the same params once the
+ * event arrives. Calls before the wait return their recorded results
+ * instead of being made again.
+ *
+ * @param WaitForEventConfig configuration object
+ * - types: required, event types to wait for
+ * - context: optional, context attributes the events have to match
+ * - all: optional, wait for events of all types instead of any
+ * - timeout: optional, ISO8601 duration, e.g. "PT1H". Throws
+ *   "wait timed out" when it expires.
+ * @returns the event, or the events keyed by type if all is set.
+ */
+declare function waitForEvent(config: WaitForEventConfig): unknown;
+
+/**
+ * Options for waitForSignal
+ */
+declare type WaitForSignalOptions = {
+  timeout?: string;
+};
+
+/**
+ * Suspends the instance until the signal is sent to it with
+ * POST /api/v2/namespaces/{namespace}/instances/{id}/signals/{name}.
+ * The state function is called again with the same params once the
+ * signal arrives. Calls before the wait return their recorded results
+ * instead of being made again. Subflows called with execSubflow are
+ * suspended together with the instances waiting for them.
+ *
+ * @param name name of the signal
+ * @param WaitForSignalOptions options object
+ * - timeout: optional, ISO8601 duration, e.g. "P1D". Throws
+ *   "wait timed out" when it expires.
+ * @returns the JSON payload of the signal.
+ */
+declare function waitForSignal(
+  name: string,
+  options?: WaitForSignalOptions
+): unknown;
+
+/**
+ * Config for emitEvent
+ */
+declare type EmitEventConfig = {
+  type: string;
+  source?: string;
+  data?: unknown;
+  extensions?: Record<string, string | number | boolean>;
+  delay?: string;
+};
+
+/**
+ * Publishes a cloud event in the namespace of the instance. It is
+ * delivered like a broadcast event and starts or wakes the flows
+ * listening for it.
+ *
+ * @param EmitEventConfig configuration object
+ * - type: required, type of the event
+ * - source: optional, defaults to the path of the flow
+ * - data: optional, JSON data of the event
+ * - extensions: optional, extension context attributes
+ * - delay: optional, ISO8601 duration to delay the delivery by
+ * @returns the id of the event.
+ */
+declare function emitEvent(config: EmitEventConfig): string;
+
+/**
+ * Runs another workflow as subflow and returns its result. The
+ * subflow is an instance of its own, linked to this instance.
+ * @param path path of the workflow file, e.g. "/child.wf.ts".
+ * @param input input of the subflow.
+ */
+declare function execSubflow<T>(path: string, input: T): unknown;
+
+/**
+ * Runs a subflow like execSubflow, but returns a promise of its
+ * result, e.g. to run several subflows concurrently with Promise.all.
+ * @param path path of the workflow file, e.g. "/child.wf.ts".
+ * @param input input of the subflow.
+ */
+declare function execSubflowAsync<T>(path: string, input: T): Promise<unknown>;
+
 /**
  * Returns the instance id of the workflow
  */
@@ -79,9 +263,14 @@ declare type ActionConfig = {
  * - cmd: optional, command to run in container
  * - envs: optional, { name: string, value: string }[].
  */
-declare function generateAction(
-  config: ActionConfig
-): (payload?: unknown) => void;
+declare function generateAction(config: ActionConfig): {
+  (payload?: unknown, timeout?: string): unknown;
+  /**
+   * Calls the action asynchronously, e.g. to run several actions
+   * concurrently with Promise.all.
+   */
+  async: (payload?: unknown, timeout?: string) => Promise<unknown>;
+};
 
 /**
  * Describes a file passed into a service
@@ -114,6 +303,14 @@ declare type ServiceConfig = {
  */
 declare function execService(config: ServiceConfig): () => void;
 
+/**
+ * Executes a service like execService, but returns a promise of the
+ * result, e.g. to run several services concurrently with Promise.all.
+ *
+ * @param ServiceConfig configuration object, see execService.
+ */
+declare function execServiceAsync(config: ServiceConfig): Promise<unknown>;
+
 /**
  * Returns a map where the key is the secret name and the value is the value of the secret
  * @param secrets the array of secrets names
@@ -125,3 +322,173 @@ declare function getSecrets(secrets: string[]): Record<string, string>;
  * @param secret the name of one secret
  */
 declare function getSecret(secret: string): string;
+
+/**
+ * Options for getFile
+ */
+declare type GetFileOptions = {
+  as?: "text" | "json" | "base64";
+};
+
+/**
+ * Reads a file of the namespace of the instance. Missing files throw
+ * with code "io.direktiv.error.file.notfound".
+ * @param path absolute path of the file, e.g. "/templates/mail.html".
+ * @param GetFileOptions options object
+ * - as: optional, returns the data as "text" (default), parsed "json"
+ *   or "base64".
+ * @returns the data of the file.
+ */
+declare function getFile(path: string, options?: GetFileOptions): unknown;
+
+/**
+ * File or directory of the namespace
+ */
+declare type NamespaceFile = {
+  path: string;
+  name: string;
+  type: string;
+  mimeType?: string;
+  size: number;
+};
+
+/**
+ * Lists the files and directories of a directory of the namespace of
+ * the instance. Missing directories throw with code
+ * "io.direktiv.error.file.notfound".
+ * @param path absolute path of the directory, e.g. "/".
+ */
+declare function listFiles(path: string): NamespaceFile[];
+
+/**
+ * Scope of a runtime variable
+ */
+declare type VariableScope = "namespace" | "workflow" | "instance";
+
+/**
+ * Runtime variable, value is only set by readVariable. JSON variables
+ * are parsed, text variables are strings and other types are base64
+ * encoded.
+ */
+declare type Variable = {
+  name: string;
+  mimeType: string;
+  size: number;
+  version: number;
+  value?: unknown;
+};
+
+/**
+ * Options for writeVariable and compareAndSetVariable
+ * - mimeType: optional, defaults to "application/json" and stores the
+ *   value as JSON. Text types store the string value, other types the
+ *   base64 decoded string value.
+ */
+declare type WriteVariableOptions = {
+  mimeType?: string;
+};
+
+/**
+ * Reads a runtime variable.
+ * @returns the variable with its value, null if it does not exist.
+ */
+declare function readVariable(
+  scope: VariableScope,
+  name: string
+): Variable | null;
+
+/**
+ * Writes a runtime variable, regardless of concurrent writes.
+ * @returns the new version of the variable.
+ */
+declare function writeVariable(
+  scope: VariableScope,
+  name: string,
+  value: unknown,
+  options?: WriteVariableOptions
+): number;
+
+/**
+ * Writes a runtime variable if it still has the version, e.g. the
+ * version returned by readVariable. Version 0 only creates the
+ * variable if it does not exist, e.g. for idempotency keys.
+ * @returns true if the variable was written.
+ */
+declare function compareAndSetVariable(
+  scope: VariableScope,
+  name: string,
+  version: number,
+  value: unknown,
+  options?: WriteVariableOptions
+): boolean;
+
+/**
+ * Atomically adds delta, default 1, to a JSON number variable. Missing
+ * variables start at 0. Throws with code
+ * "io.direktiv.error.variable.conflict" if concurrent writes keep
+ * conflicting.
+ * @returns the new value.
+ */
+declare function incrementVariable(
+  scope: VariableScope,
+  name: string,
+  delta?: number
+): number;
+
+/**
+ * Deletes a runtime variable.
+ * @returns true if the variable existed.
+ */
+declare function deleteVariable(scope: VariableScope, name: string): boolean;
+
+/**
+ * Lists the runtime variables of the scope, without values.
+ */
+declare function listVariables(scope: VariableScope): Variable[];
+
+/**
+ * Options for withLock and withSemaphore
+ */
+declare type LockOptions = {
+  ttl?: string;
+  wait?: string;
+};
+
+/**
+ * Runs fn while holding the lock key of the namespace, across all
+ * instances and nodes. The lock is released when fn returns or throws,
+ * and when the instance suspends in fn, e.g. in a long sleep. Held locks
+ * are listed with GET /api/v2/namespaces/{namespace}/locks.
+ *
+ * @param key name of the lock, keys starting with "direktiv." are reserved
+ * @param LockOptions options object
+ * - ttl: optional, ISO8601 duration, default "PT1M". The lock is renewed
+ *   while fn runs and freed after it if the holder crashed. A lock that
+ *   could not be renewed stops the state with code
+ *   "io.direktiv.error.lock.timeout".
+ * - wait: optional, ISO8601 duration, defaults to the ttl. Throws with
+ *   code "io.direktiv.error.lock.timeout" if the lock is not acquired
+ *   within it.
+ * @param fn synchronous function to run
+ * @returns the result of fn.
+ */
+declare function withLock<T>(key: string, options: LockOptions, fn: () => T): T;
+declare function withLock<T>(key: string, fn: () => T): T;
+
+/**
+ * Runs fn while holding one of limit slots of the semaphore key of the
+ * namespace, like withLock.
+ *
+ * @param key name of the semaphore
+ * @param limit number of slots, at least 1
+ * @param LockOptions options object, see withLock
+ * @param fn synchronous function to run
+ * @returns the result of fn.
+ */
+declare function withSemaphore<T>(
+  key: string,
+  limit: number,
+  options: LockOptions,
+  fn: () => T
+): T;
+declare function withSemaphore<T>(key: string, limit: number, fn: () => T): T;